@@ -0,0 +1,178 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/polarismesh/polaris-go/api"
+)
+
+// batchConcurrency bounds the registrations in flight of one RegisterBatch or DeregisterBatch call.
+const batchConcurrency = 8
+
+// BatchResult is the outcome of RegisterBatch and DeregisterBatch, Errors is indexed like the infos
+// and holds nil for the infos that succeeded.
+type BatchResult struct {
+	Succeeded int
+	Errors    []error
+}
+
+// BatchRegistry is the extension interface of the registries registering many instances at once, like
+// an agent registering the processes of its node, the Registries of this package implement it.
+type BatchRegistry interface {
+	// RegisterBatch registers infos concurrently, e.g. for an agent registering the processes of its node.
+	// The heartbeats of the batch share one scheduler staggering them over the heartbeat interval instead
+	// of one goroutine per instance. The result holds the error of every info, with a BatchError when one failed.
+	RegisterBatch(infos []*registry.Info) (BatchResult, error)
+
+	// DeregisterBatch deregisters infos concurrently and reports them like RegisterBatch.
+	DeregisterBatch(infos []*registry.Info) (BatchResult, error)
+
+	// DeregisterAllMatching deregisters every instance of the service listed by the consumer API for which
+	// predicate returns true, nil matches all, like the instances of one test run. The deregistrations run
+	// concurrently, the count of deregistered instances is returned with a DeregisterAllError for the others.
+	DeregisterAllMatching(ctx context.Context, namespace, service string, predicate func(InstanceInfo) bool) (int, error)
+}
+
+var _ BatchRegistry = (*polarisRegistry)(nil)
+
+// RegisterBatch implements the BatchRegistry interface.
+func (svr *polarisRegistry) RegisterBatch(infos []*registry.Info) (BatchResult, error) {
+	return svr.batch("register", infos, func(info *registry.Info) (err error) {
+		if before := svr.opts.beforeRegister; before != nil {
+			runHook("before register", func() { before(info) })
+		}
+		if after := svr.opts.afterRegister; after != nil {
+			defer func() {
+				runHook("after register", func() { after(info, err) })
+			}()
+		}
+		svr.beginRegister()
+		defer svr.endRegister()
+		return svr.register(info, true)
+	})
+}
+
+// DeregisterBatch implements the BatchRegistry interface.
+func (svr *polarisRegistry) DeregisterBatch(infos []*registry.Info) (BatchResult, error) {
+	return svr.batch("deregister", infos, svr.Deregister)
+}
+
+// batch runs op on every info with bounded concurrency.
+func (svr *polarisRegistry) batch(name string, infos []*registry.Info, op func(info *registry.Info) error) (BatchResult, error) {
+	result := BatchResult{Errors: make([]error, len(infos))}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, batchConcurrency)
+	for i, info := range infos {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, info *registry.Info) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			result.Errors[i] = op(info)
+		}(i, info)
+	}
+	wg.Wait()
+	for _, err := range result.Errors {
+		if err == nil {
+			result.Succeeded++
+		}
+	}
+	if result.Succeeded < len(infos) {
+		return result, &BatchError{Op: name, Result: result}
+	}
+	return result, nil
+}
+
+// heartbeatScheduler beats the instances registered by RegisterBatch from one goroutine. The heartbeat
+// interval is split in one slot per instance and the instances beat in turn, so that they do not all beat
+// at once. The goroutine runs while the scheduler has instances.
+type heartbeatScheduler struct {
+	lock    sync.Mutex
+	entries []*scheduledHeartbeat
+	next    int
+	running bool
+}
+
+type scheduledHeartbeat struct {
+	heartbeat *api.InstanceHeartbeatRequest
+	failures  int // only accessed by the scheduler goroutine
+}
+
+// add schedules heartbeat, the returned function removes it.
+func (s *heartbeatScheduler) add(svr *polarisRegistry, heartbeat *api.InstanceHeartbeatRequest) context.CancelFunc {
+	entry := &scheduledHeartbeat{heartbeat: heartbeat}
+	s.lock.Lock()
+	s.entries = append(s.entries, entry)
+	if !s.running {
+		s.running = true
+		go s.run(svr)
+	}
+	s.lock.Unlock()
+	return func() { s.remove(entry) }
+}
+
+func (s *heartbeatScheduler) remove(entry *scheduledHeartbeat) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, e := range s.entries {
+		if e == entry {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			if s.next > i {
+				s.next--
+			}
+			return
+		}
+	}
+}
+
+func (s *heartbeatScheduler) run(svr *polarisRegistry) {
+	after := svr.heartbeatAfter
+	if after == nil {
+		after = time.After
+	}
+	for {
+		s.lock.Lock()
+		if len(s.entries) == 0 {
+			s.running = false
+			s.lock.Unlock()
+			return
+		}
+		slot := svr.effectiveHeartbeatInterval() / time.Duration(len(s.entries))
+		s.lock.Unlock()
+		<-after(slot)
+
+		s.lock.Lock()
+		if len(s.entries) == 0 {
+			s.lock.Unlock()
+			continue
+		}
+		if s.next >= len(s.entries) {
+			s.next = 0
+		}
+		entry := s.entries[s.next]
+		s.next++
+		s.lock.Unlock()
+		entry.failures = svr.beat(entry.heartbeat, entry.failures)
+	}
+}
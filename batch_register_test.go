@@ -0,0 +1,123 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// failingRegisterProvider rejects the registrations of one host.
+type failingRegisterProvider struct {
+	*polaristest.Backend
+	host string
+}
+
+func (p *failingRegisterProvider) Register(req *api.InstanceRegisterRequest) (*model.InstanceRegisterResponse, error) {
+	if req.Host == p.host {
+		return nil, errors.New("polaris rejected the instance")
+	}
+	return p.Backend.Register(req)
+}
+
+func TestRegisterBatchPartialFailure(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	rg.provider = &failingRegisterProvider{Backend: backend, host: "127.0.0.2"}
+	infos := []*registry.Info{
+		newTestInfo("127.0.0.1:6666", nil),
+		nil,
+		newTestInfo("127.0.0.2:6666", nil),
+		newTestInfo("127.0.0.3:6666", nil),
+	}
+
+	result, err := rg.RegisterBatch(infos)
+	var batchErr *BatchError
+	require.True(t, errors.As(err, &batchErr), err)
+	require.Equal(t, "register", batchErr.Op)
+	require.Equal(t, 2, result.Succeeded)
+	require.Nil(t, result.Errors[0])
+	require.NotNil(t, result.Errors[1])
+	require.EqualError(t, result.Errors[2], "polaris rejected the instance")
+	require.Nil(t, result.Errors[3])
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 2)
+
+	result, err = rg.DeregisterBatch(infos)
+	require.True(t, errors.As(err, &batchErr), err)
+	require.Equal(t, "deregister", batchErr.Op)
+	// the rejected instance never registered, its Deregister is a no-op.
+	require.Equal(t, 3, result.Succeeded)
+	require.Nil(t, result.Errors[0])
+	require.NotNil(t, result.Errors[1])
+	require.Nil(t, result.Errors[2])
+	require.Nil(t, result.Errors[3])
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+
+	result, err = rg.RegisterBatch(infos[3:])
+	require.Nil(t, err)
+	require.Equal(t, BatchResult{Succeeded: 1, Errors: []error{nil}}, result)
+	_, err = rg.DeregisterBatch(infos[3:])
+	require.Nil(t, err)
+}
+
+func TestRegisterBatchSharedHeartbeats(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithHeartbeatJitter(0.2))
+	rg.heartbeatInterval = time.Second
+	clock := newFakeHeartbeatClock(rg)
+	infos := []*registry.Info{
+		newTestInfo("127.0.0.1:6666", nil),
+		newTestInfo("127.0.0.2:6666", nil),
+		newTestInfo("127.0.0.3:6666", nil),
+		newTestInfo("127.0.0.4:6666", nil),
+	}
+	_, err := rg.RegisterBatch(infos)
+	require.Nil(t, err)
+	// the first slot may have been scheduled before the whole batch was registered.
+	clock.beat(t)
+
+	beaten := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		require.Equal(t, 250*time.Millisecond, clock.beat(t))
+	}
+	<-clock.intervals
+	heartbeats := backend.Heartbeats()
+	require.Len(t, heartbeats, 9)
+	for _, heartbeat := range heartbeats[1:] {
+		beaten[heartbeat.Host]++
+	}
+	require.Equal(t, map[string]int{"127.0.0.1": 2, "127.0.0.2": 2, "127.0.0.3": 2, "127.0.0.4": 2}, beaten)
+
+	// a deregistered instance leaves the schedule, the others share the interval.
+	require.Nil(t, rg.Deregister(infos[0]))
+	clock.fire <- time.Now()
+	for i := 0; i < 3; i++ {
+		require.Equal(t, time.Second/3, clock.beat(t))
+	}
+	for _, heartbeat := range backend.Heartbeats()[9:] {
+		require.NotEqual(t, "127.0.0.1", heartbeat.Host)
+	}
+	_, err = rg.DeregisterBatch(infos[1:])
+	require.Nil(t, err)
+}
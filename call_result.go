@@ -0,0 +1,429 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	defaultCallResultFlushInterval   = time.Second
+	defaultCallResultMaxBuckets      = 10000
+	defaultCallResultMaxReportsFlush = 1000
+)
+
+// CallResultResolver is the extension interface of the resolvers reporting the results of the calls to
+// polaris, the Resolvers of this package implement it, see NewCallResultMiddleware.
+type CallResultResolver interface {
+	// ReportCallResult hands the result of a call to the aggregated reporter, it never calls polaris.
+	ReportCallResult(result CallResult)
+
+	// ClassifyCall returns the Status of a call for ReportCallResult, see WithCallClassifier.
+	ClassifyCall(err error, ri rpcinfo.RPCInfo) CallStatus
+
+	// ClassifyCallResult returns the raw polaris-go status of ClassifyCall.
+	//
+	// Deprecated: use ClassifyCall.
+	ClassifyCallResult(err error, ri rpcinfo.RPCInfo) model.RetStatus
+}
+
+var _ CallResultResolver = (*polarisResolver)(nil)
+
+// CallResult is the outcome of one call to a polaris instance, the InstanceID is the TagHashKey
+// of the resolved instance. The results of every Locality are aggregated apart and their reports
+// labeled with it, see WithOnCallResultReport.
+type CallResult struct {
+	Namespace  string
+	Service    string
+	InstanceID string
+	// Status is the status of the call, the RetStatus is used when it is not set.
+	Status CallStatus
+	// Deprecated: RetStatus is the raw polaris-go status of the call, set Status instead.
+	RetStatus model.RetStatus
+	RetCode   int32
+	Delay     time.Duration
+	Locality  Locality
+}
+
+// status returns the Status of a result, or the CallStatus of its RetStatus without it.
+func (result CallResult) status() CallStatus {
+	if result.Status != 0 {
+		return result.Status
+	}
+	return CallStatusFromRaw(result.RetStatus)
+}
+
+// CallResultReport is a bucket of aggregated call results reported to polaris at a flush, Calls results
+// of one instance, status, code and locality with their mean Delay. Labels carries the MetadataRegion,
+// MetadataZone and MetadataCampus levels of the locality the calls saw, it is nil for unlabeled results.
+type CallResultReport struct {
+	Namespace  string
+	Service    string
+	InstanceID string
+	Status     CallStatus
+	// Deprecated: RetStatus is the raw polaris-go status reported, use Status.
+	RetStatus model.RetStatus
+	RetCode   int32
+	Calls     int
+	Delay     time.Duration
+	Labels    map[string]string
+}
+
+// localityLabels returns the labels of the levels of locality that are set, nil when none is.
+func localityLabels(locality Locality) map[string]string {
+	if locality == (Locality{}) {
+		return nil
+	}
+	labels := make(map[string]string, 3)
+	for key, value := range map[string]string{
+		MetadataRegion: locality.Region, MetadataZone: locality.Zone, MetadataCampus: locality.SubZone,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// CallStatus is the status of a call reported to polaris. It stands for the model.RetStatus of polaris-go
+// so that the classifiers and the reports do not depend on the SDK, RawRetStatus and CallStatusFromRaw
+// convert between them.
+type CallStatus int
+
+const (
+	// CallSuccess is a call the instance answered.
+	CallSuccess CallStatus = iota + 1
+	// CallFail is a call the instance failed, like by a timeout or a transport error.
+	CallFail
+	// CallFlowControl is a call rejected before it reached the instance, like by a limiter or a circuit
+	// breaker. polaris-go v1.0.1 only knows successes and failures, such results are not reported.
+	CallFlowControl
+)
+
+// String returns the name of a status.
+func (s CallStatus) String() string {
+	switch s {
+	case CallSuccess:
+		return "success"
+	case CallFail:
+		return "fail"
+	case CallFlowControl:
+		return "flow control"
+	}
+	return "CallStatus(" + strconv.Itoa(int(s)) + ")"
+}
+
+// RawRetStatus returns the polaris-go status of s, RetFlowControl for CallFlowControl and unknown statuses.
+func (s CallStatus) RawRetStatus() model.RetStatus {
+	switch s {
+	case CallSuccess:
+		return model.RetSuccess
+	case CallFail:
+		return model.RetFail
+	}
+	return RetFlowControl
+}
+
+// CallStatusFromRaw returns the CallStatus of a polaris-go status, CallFlowControl for the statuses
+// other than model.RetSuccess and model.RetFail.
+func CallStatusFromRaw(status model.RetStatus) CallStatus {
+	switch status {
+	case model.RetSuccess:
+		return CallSuccess
+	case model.RetFail:
+		return CallFail
+	}
+	return CallFlowControl
+}
+
+// RetFlowControl is the raw polaris-go status of CallFlowControl.
+//
+// Deprecated: use CallFlowControl.
+const RetFlowControl model.RetStatus = 0
+
+// CallClassifier maps the error of a call to the status reported to polaris.
+type CallClassifier func(err error, ri rpcinfo.RPCInfo) CallStatus
+
+// DefaultCallClassifier is the classifier used without WithCallClassifier. Business errors, kerrors.ErrBiz
+// and the exceptions declared in the IDL which are not Kitex errors, are successes since the instance
+// answered. Limiter, circuit breaker and ACL rejections are CallFlowControl, the other Kitex errors,
+// like timeouts and transport errors, are failures.
+func DefaultCallClassifier(err error, ri rpcinfo.RPCInfo) CallStatus {
+	switch {
+	case err == nil:
+		return CallSuccess
+	case errors.Is(err, kerrors.ErrBiz):
+		return CallSuccess
+	case errors.Is(err, kerrors.ErrOverlimit), errors.Is(err, kerrors.ErrCircuitBreak), errors.Is(err, kerrors.ErrACL):
+		return CallFlowControl
+	case kerrors.IsTimeoutError(err), kerrors.IsKitexError(err):
+		return CallFail
+	default:
+		return CallSuccess
+	}
+}
+
+// CallResultClassifier maps the error of a call to the raw polaris-go status reported.
+//
+// Deprecated: use CallClassifier.
+type CallResultClassifier func(err error, ri rpcinfo.RPCInfo) model.RetStatus
+
+// DefaultCallResultClassifier returns the raw polaris-go status of DefaultCallClassifier.
+//
+// Deprecated: use DefaultCallClassifier.
+func DefaultCallResultClassifier(err error, ri rpcinfo.RPCInfo) model.RetStatus {
+	return DefaultCallClassifier(err, ri).RawRetStatus()
+}
+
+type callResultKey struct {
+	service    model.ServiceKey
+	instanceID string
+	status     CallStatus
+	retCode    int32
+	locality   Locality
+}
+
+type callResultBucket struct {
+	count    int
+	delaySum time.Duration
+}
+
+// callCounts counts the calls of an instance for the adaptive scoring.
+type callCounts struct {
+	total  int
+	failed int
+}
+
+// callResultReporter aggregates call results per instance and return code and reports them to the
+// polaris SDK from a single goroutine, so that the request path never calls the SDK.
+type callResultReporter struct {
+	dropped    uint64 // accessed atomically, keep it first for 64-bit alignment
+	consumer   api.ConsumerAPI
+	interval   time.Duration
+	maxBuckets int
+	maxReports int
+	onReport   func(report CallResultReport)
+	lock       sync.Mutex
+	buckets    map[callResultKey]*callResultBucket
+	// recent and previous count the results by instance ID of the current and the last flush windows.
+	recent    map[string]callCounts
+	previous  map[string]callCounts
+	closed    bool
+	startOnce sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+func newCallResultReporter(consumer api.ConsumerAPI, o *options) *callResultReporter {
+	r := &callResultReporter{
+		consumer:   consumer,
+		interval:   o.callResultFlushInterval,
+		maxBuckets: o.callResultMaxBuckets,
+		maxReports: defaultCallResultMaxReportsFlush,
+		onReport:   o.onCallResultReport,
+		buckets:    make(map[callResultKey]*callResultBucket),
+		recent:     make(map[string]callCounts),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if r.interval <= 0 {
+		r.interval = defaultCallResultFlushInterval
+	}
+	if r.maxBuckets <= 0 {
+		r.maxBuckets = defaultCallResultMaxBuckets
+	}
+	return r
+}
+
+// report adds a result to its bucket, it is dropped when the buffer already holds maxBuckets buckets.
+// CallFlowControl results are ignored.
+func (r *callResultReporter) report(result CallResult) {
+	status := result.status()
+	if status == CallFlowControl {
+		return
+	}
+	r.startOnce.Do(func() { go r.run() })
+	key := callResultKey{
+		service:    model.ServiceKey{Namespace: result.Namespace, Service: result.Service},
+		instanceID: result.InstanceID,
+		status:     status,
+		retCode:    result.RetCode,
+		locality:   result.Locality,
+	}
+	r.lock.Lock()
+	if !r.closed {
+		counts := r.recent[result.InstanceID]
+		counts.total++
+		if status != CallSuccess {
+			counts.failed++
+		}
+		r.recent[result.InstanceID] = counts
+	}
+	bucket, ok := r.buckets[key]
+	if !ok {
+		if r.closed || len(r.buckets) >= r.maxBuckets {
+			r.lock.Unlock()
+			atomic.AddUint64(&r.dropped, 1)
+			return
+		}
+		bucket = &callResultBucket{}
+		r.buckets[key] = bucket
+	}
+	bucket.count++
+	bucket.delaySum += result.Delay
+	r.lock.Unlock()
+}
+
+func (r *callResultReporter) run() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			r.flush()
+			return
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+// flush reports the aggregated buckets with their mean delay. Above maxReports results per flush the
+// number of reports of every bucket is scaled down proportionally, which keeps the error ratios.
+func (r *callResultReporter) flush() {
+	r.lock.Lock()
+	consumer := r.consumer
+	buckets := r.buckets
+	r.buckets = make(map[callResultKey]*callResultBucket, len(buckets))
+	r.previous, r.recent = r.recent, make(map[string]callCounts, len(r.recent))
+	r.lock.Unlock()
+	if len(buckets) == 0 {
+		return
+	}
+	total := 0
+	for _, bucket := range buckets {
+		total += bucket.count
+	}
+	scale := 1.0
+	if total > r.maxReports {
+		scale = float64(r.maxReports) / float64(total)
+	}
+	instances := make(map[model.ServiceKey]map[string]model.Instance)
+	for key, bucket := range buckets {
+		instance := r.instance(consumer, instances, key)
+		if instance == nil {
+			atomic.AddUint64(&r.dropped, uint64(bucket.count))
+			continue
+		}
+		result := &api.ServiceCallResult{}
+		result.SetCalledInstance(instance)
+		result.SetRetStatus(key.status.RawRetStatus())
+		result.SetRetCode(key.retCode)
+		delay := bucket.delaySum / time.Duration(bucket.count)
+		result.SetDelay(delay)
+		reports := int(math.Max(1, math.Round(float64(bucket.count)*scale)))
+		for i := 0; i < reports; i++ {
+			if err := consumer.UpdateServiceCallResult(result); err != nil {
+				log.GetBaseLogger().Warnf("[Polaris resolver] report call result of %s failed: %v", key.instanceID, err)
+				break
+			}
+		}
+		if onReport := r.onReport; onReport != nil {
+			report := CallResultReport{
+				Namespace:  key.service.Namespace,
+				Service:    key.service.Service,
+				InstanceID: key.instanceID,
+				Status:     key.status,
+				RetStatus:  key.status.RawRetStatus(),
+				RetCode:    key.retCode,
+				Calls:      bucket.count,
+				Delay:      delay,
+				Labels:     localityLabels(key.locality),
+			}
+			runHook("call result report", func() { onReport(report) })
+		}
+	}
+}
+
+// instance finds the SDK instance of a bucket, the call result statistics live on it.
+func (r *callResultReporter) instance(consumer api.ConsumerAPI, instances map[model.ServiceKey]map[string]model.Instance,
+	key callResultKey) model.Instance {
+	byID, ok := instances[key.service]
+	if !ok {
+		byID = make(map[string]model.Instance)
+		req := &api.GetAllInstancesRequest{}
+		req.Namespace = key.service.Namespace
+		req.Service = key.service.Service
+		if resp, err := consumer.GetAllInstances(req); err == nil {
+			for _, instance := range resp.GetInstances() {
+				byID[instance.GetId()] = instance
+			}
+		}
+		instances[key.service] = byID
+	}
+	return byID[key.instanceID]
+}
+
+// close flushes the pending results and stops the flush goroutine, later results are dropped.
+// setConsumer makes the next flushes report to consumer, see UpdateEndpoints.
+func (r *callResultReporter) setConsumer(consumer api.ConsumerAPI) {
+	r.lock.Lock()
+	r.consumer = consumer
+	r.lock.Unlock()
+}
+
+func (r *callResultReporter) close() {
+	r.closeOnce.Do(func() {
+		// nothing was ever reported when the goroutine does not run.
+		r.startOnce.Do(func() { close(r.stopped) })
+		r.lock.Lock()
+		r.closed = true
+		r.lock.Unlock()
+		close(r.done)
+		<-r.stopped
+	})
+}
+
+// failureRatio returns the ratio of failed calls of an instance over the current and the last flush
+// windows with the number of calls it is computed from.
+func (r *callResultReporter) failureRatio(instanceID string) (float64, int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	recent, previous := r.recent[instanceID], r.previous[instanceID]
+	total := recent.total + previous.total
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(recent.failed+previous.failed) / float64(total), total
+}
+
+func (r *callResultReporter) droppedResults() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
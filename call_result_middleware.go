@@ -0,0 +1,73 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+)
+
+// NewCallResultMiddleware returns a client middleware reporting the result of every call to the picked
+// instance with CallResultResolver.ReportCallResult, classified by CallResultResolver.ClassifyCall, nothing
+// is reported for a resolver which is not a CallResultResolver. The results carry
+// the locality tags of the instance, set by the conversion, an instance without them is reported
+// unlabeled. The RetCode is 0 for a success and -1 for a failure. The calls that picked no instance
+// resolved by polaris, without TagHashKey, are not reported.
+//
+//	client.WithMiddleware(polaris.NewCallResultMiddleware(resolver))
+func NewCallResultMiddleware(resolver Resolver) endpoint.Middleware {
+	reporter := extend(resolver)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request, response interface{}) error {
+			start := time.Now()
+			err := next(ctx, request, response)
+			ri := rpcinfo.GetRPCInfo(ctx)
+			if ri == nil || ri.To() == nil {
+				return err
+			}
+			// the instance is picked by the Kitex middlewares running after the user ones.
+			if result, ok := callResultOf(ri.To(), reporter.ClassifyCall(err, ri), time.Since(start)); ok {
+				reporter.ReportCallResult(result)
+			}
+			return err
+		}
+	}
+}
+
+// callResultOf builds the CallResult of a call to the endpoint to.
+func callResultOf(to rpcinfo.EndpointInfo, status CallStatus, delay time.Duration) (CallResult, bool) {
+	id, ok := to.Tag(TagHashKey)
+	if !ok || id == "" {
+		return CallResult{}, false
+	}
+	namespace, _ := to.Tag(namespaceTagKey)
+	result := CallResult{
+		Namespace:  namespace,
+		Service:    to.ServiceName(),
+		InstanceID: id,
+		Status:     status,
+		Delay:      delay,
+		Locality:   tagLocality(to),
+	}
+	if status != CallSuccess {
+		result.RetCode = -1
+	}
+	return result, true
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/cloudwego/kitex/pkg/rpcinfo/remoteinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// reportRecorder records the reports of WithOnCallResultReport by instance ID.
+type reportRecorder struct {
+	lock    sync.Mutex
+	reports map[string]CallResultReport
+}
+
+func (r *reportRecorder) record(report CallResultReport) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.reports == nil {
+		r.reports = make(map[string]CallResultReport)
+	}
+	r.reports[report.InstanceID] = report
+}
+
+// pickedCall runs a call through the middleware, next stands in for the Kitex load balancer picking ins.
+func pickedCall(t *testing.T, rs Resolver, ins discovery.Instance, callErr error) {
+	to := remoteinfo.NewRemoteInfo(&rpcinfo.EndpointBasicInfo{ServiceName: serviceName, Method: "echo"}, "echo")
+	ri := rpcinfo.NewRPCInfo(nil, to, rpcinfo.NewInvocation(serviceName, "echo"), rpcinfo.NewRPCConfig(), rpcinfo.NewRPCStats())
+	next := func(ctx context.Context, request, response interface{}) error {
+		to.SetInstance(ins)
+		return callErr
+	}
+	err := NewCallResultMiddleware(rs)(next)(rpcinfo.NewCtxWithRPCInfo(context.Background(), ri), nil, nil)
+	require.Equal(t, callErr, err)
+}
+
+func TestCallResultMiddlewareLabels(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{ID: "ins-1", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{MetadataRegion: "south", MetadataZone: "sz-1"}},
+		&polaristest.Instance{ID: "ins-2", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6667},
+	)
+	recorder := &reportRecorder{}
+	rs := newTestResolver(backend, WithCallResultFlushInterval(time.Hour), WithOnCallResultReport(recorder.record))
+	result, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 2)
+
+	for _, ins := range result.Instances {
+		pickedCall(t, rs, ins, nil)
+		pickedCall(t, rs, ins, kerrors.ErrRPCTimeout)
+	}
+	// an instance not resolved by polaris has no ID to report.
+	pickedCall(t, rs, discovery.NewInstance("tcp", "127.0.0.1:7000", 10, nil), nil)
+	rs.reporter.flush()
+
+	require.Len(t, backend.CallResults(), 4)
+	require.Len(t, recorder.reports, 2)
+	labeled := recorder.reports["ins-1"]
+	require.Equal(t, map[string]string{MetadataRegion: "south", MetadataZone: "sz-1"}, labeled.Labels)
+	require.Equal(t, polarisDefaultNamespace, labeled.Namespace)
+	require.Equal(t, serviceName, labeled.Service)
+	require.Equal(t, 1, labeled.Calls)
+	// the untagged instance degrades to unlabeled reports.
+	require.Nil(t, recorder.reports["ins-2"].Labels)
+}
+
+func TestCallResultOf(t *testing.T) {
+	ins := discovery.NewInstance("tcp", "127.0.0.1:6666", 10, map[string]string{
+		TagHashKey: "ins-1", namespaceTagKey: "Production", TagLocalityZone: "sz-1",
+	})
+	to := remoteinfo.NewRemoteInfo(&rpcinfo.EndpointBasicInfo{ServiceName: serviceName}, "echo")
+	to.SetInstance(ins)
+
+	result, ok := callResultOf(to, CallFail, time.Millisecond)
+	require.True(t, ok)
+	require.Equal(t, CallResult{Namespace: "Production", Service: serviceName, InstanceID: "ins-1", Status: CallFail,
+		RetCode: -1, Delay: time.Millisecond, Locality: Locality{Zone: "sz-1"}}, result)
+
+	_, ok = callResultOf(rpcinfo.NewEndpointInfo(serviceName, "echo", nil, nil), CallSuccess, time.Millisecond)
+	require.False(t, ok)
+}
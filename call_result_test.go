@@ -0,0 +1,248 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func newCallResultBackend() *polaristest.Backend {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{ID: "ins-1", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666},
+		&polaristest.Instance{ID: "ins-2", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6667},
+	)
+	return backend
+}
+
+func callResult(id string, status CallStatus, code int32, delay time.Duration) CallResult {
+	return CallResult{Namespace: polarisDefaultNamespace, Service: serviceName, InstanceID: id,
+		Status: status, RetCode: code, Delay: delay}
+}
+
+func TestCallResultAggregation(t *testing.T) {
+	backend := newCallResultBackend()
+	r := newCallResultReporter(backend, newOptions([]Option{WithCallResultFlushInterval(time.Hour)}))
+	defer r.close()
+
+	r.report(callResult("ins-1", CallSuccess, 0, 10*time.Millisecond))
+	r.report(callResult("ins-1", CallSuccess, 0, 30*time.Millisecond))
+	r.report(callResult("ins-1", CallFail, 500, 5*time.Millisecond))
+	r.report(callResult("ins-2", CallSuccess, 0, time.Millisecond))
+	r.report(callResult("ins-3", CallSuccess, 0, time.Millisecond))
+	r.flush()
+
+	results := backend.CallResults()
+	require.Len(t, results, 4)
+	type key struct {
+		id     string
+		status model.RetStatus
+	}
+	delays := make(map[key]time.Duration)
+	for _, result := range results {
+		delays[key{result.GetCalledInstance().GetId(), result.GetRetStatus()}] = *result.GetDelay()
+	}
+	require.Equal(t, map[key]time.Duration{
+		{"ins-1", model.RetSuccess}: 20 * time.Millisecond,
+		{"ins-1", model.RetFail}:    5 * time.Millisecond,
+		{"ins-2", model.RetSuccess}: time.Millisecond,
+	}, delays)
+	// ins-3 is unknown to polaris.
+	require.Equal(t, uint64(1), r.droppedResults())
+}
+
+func TestCallResultScaledReports(t *testing.T) {
+	backend := newCallResultBackend()
+	r := newCallResultReporter(backend, newOptions([]Option{WithCallResultFlushInterval(time.Hour)}))
+	defer r.close()
+	r.maxReports = 10
+
+	for i := 0; i < 80; i++ {
+		r.report(callResult("ins-1", CallSuccess, 0, time.Millisecond))
+	}
+	for i := 0; i < 20; i++ {
+		r.report(callResult("ins-1", CallFail, 500, time.Millisecond))
+	}
+	r.flush()
+
+	success, fail := 0, 0
+	for _, result := range backend.CallResults() {
+		if result.GetRetStatus() == model.RetSuccess {
+			success++
+		} else {
+			fail++
+		}
+	}
+	require.Equal(t, 8, success)
+	require.Equal(t, 2, fail)
+}
+
+func TestCallResultBurstDropAccounting(t *testing.T) {
+	backend := newCallResultBackend()
+	r := newCallResultReporter(backend, newOptions([]Option{
+		WithCallResultFlushInterval(time.Hour),
+		WithCallResultBufferSize(4),
+	}))
+	defer r.close()
+
+	const burst = 100000
+	for i := 0; i < burst; i++ {
+		r.report(callResult("ins-1", CallFail, int32(i%8), time.Millisecond))
+	}
+	aggregated := 0
+	r.lock.Lock()
+	require.Len(t, r.buckets, 4)
+	for _, bucket := range r.buckets {
+		aggregated += bucket.count
+	}
+	r.lock.Unlock()
+	require.Equal(t, uint64(burst), r.droppedResults()+uint64(aggregated))
+	require.Equal(t, uint64(burst/2), r.droppedResults())
+}
+
+func TestCallResultCloseFlushes(t *testing.T) {
+	backend := newCallResultBackend()
+	rs := newTestResolver(backend, WithCallResultFlushInterval(time.Hour))
+
+	rs.ReportCallResult(callResult("ins-1", CallSuccess, 0, time.Millisecond))
+	require.Equal(t, 0, backend.Calls(polaristest.OpUpdateCallResult))
+	require.NoError(t, rs.Close())
+	require.Equal(t, 1, backend.Calls(polaristest.OpUpdateCallResult))
+
+	rs.ReportCallResult(callResult("ins-1", CallSuccess, 0, time.Millisecond))
+	require.Equal(t, uint64(1), rs.DroppedCallResults())
+	require.NoError(t, rs.Close())
+}
+
+func TestCallResultPeriodicFlush(t *testing.T) {
+	backend := newCallResultBackend()
+	rs := newTestResolver(backend, WithCallResultFlushInterval(10*time.Millisecond))
+	defer rs.Close()
+
+	rs.ReportCallResult(callResult("ins-2", CallSuccess, 0, time.Millisecond))
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpUpdateCallResult) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestDefaultCallClassifier(t *testing.T) {
+	userNotFound := errors.New("user not found")
+	for _, c := range []struct {
+		err    error
+		status CallStatus
+	}{
+		{nil, CallSuccess},
+		{userNotFound, CallSuccess},
+		{kerrors.ErrBiz.WithCause(userNotFound), CallSuccess},
+		{fmt.Errorf("wrapped: %w", kerrors.ErrBiz.WithCause(userNotFound)), CallSuccess},
+		{kerrors.ErrRPCTimeout, CallFail},
+		{kerrors.ErrRPCTimeout.WithCause(errors.New("deadline")), CallFail},
+		{kerrors.ErrRemoteOrNetwork.WithCause(errors.New("connection reset")), CallFail},
+		{kerrors.ErrGetConnection.WithCause(errors.New("dial timeout")), CallFail},
+		{kerrors.ErrInternalException, CallFail},
+		{kerrors.ErrQPSOverLimit, CallFlowControl},
+		{kerrors.ErrInstanceCircuitBreak, CallFlowControl},
+		{kerrors.ErrACL.WithCause(errors.New("denied")), CallFlowControl},
+	} {
+		require.Equal(t, c.status, DefaultCallClassifier(c.err, nil), "%v", c.err)
+		require.Equal(t, c.status.RawRetStatus(), DefaultCallResultClassifier(c.err, nil), "%v", c.err)
+	}
+}
+
+func TestCallClassifier(t *testing.T) {
+	backend := newCallResultBackend()
+	notFound := errors.New("not found")
+	rs := newTestResolver(backend, WithCallClassifier(func(err error, ri rpcinfo.RPCInfo) CallStatus {
+		if err == notFound || err == nil {
+			return CallSuccess
+		}
+		return CallFail
+	}))
+	require.Equal(t, CallSuccess, rs.ClassifyCall(notFound, nil))
+	require.Equal(t, CallFail, rs.ClassifyCall(errors.New("user not found"), nil))
+	require.Equal(t, CallFail, rs.ClassifyCall(kerrors.ErrBiz, nil))
+
+	lazy := NewLazyResolver(nil)
+	require.Equal(t, CallSuccess, lazy.(CallResultResolver).ClassifyCall(kerrors.ErrBiz, nil))
+}
+
+func TestCallStatusRaw(t *testing.T) {
+	for status, raw := range map[CallStatus]model.RetStatus{
+		CallSuccess: model.RetSuccess, CallFail: model.RetFail, CallFlowControl: RetFlowControl,
+	} {
+		require.Equal(t, raw, status.RawRetStatus(), status.String())
+		require.Equal(t, status, CallStatusFromRaw(raw), status.String())
+	}
+	require.Equal(t, RetFlowControl, CallStatus(7).RawRetStatus())
+	require.Equal(t, "CallStatus(7)", CallStatus(7).String())
+}
+
+// TestDeprecatedCallResultClassifier checks the raw polaris-go statuses of the old classifier API still
+// classify and report the calls.
+func TestDeprecatedCallResultClassifier(t *testing.T) {
+	backend := newCallResultBackend()
+	notFound := errors.New("not found")
+	rs := newTestResolver(backend, WithCallResultFlushInterval(time.Hour),
+		WithCallResultClassifier(func(err error, ri rpcinfo.RPCInfo) model.RetStatus {
+			if err == notFound {
+				return model.RetSuccess
+			}
+			return model.RetFail
+		}))
+	require.Equal(t, model.RetSuccess, rs.ClassifyCallResult(notFound, nil))
+	require.Equal(t, CallSuccess, rs.ClassifyCall(notFound, nil))
+	require.Equal(t, model.RetFail, rs.ClassifyCallResult(kerrors.ErrBiz, nil))
+	require.Equal(t, CallFail, rs.ClassifyCall(kerrors.ErrBiz, nil))
+	require.Equal(t, model.RetSuccess, NewLazyResolver(nil).(CallResultResolver).ClassifyCallResult(kerrors.ErrBiz, nil))
+
+	var reports []CallResultReport
+	r := newCallResultReporter(backend, newOptions([]Option{WithOnCallResultReport(func(report CallResultReport) {
+		reports = append(reports, report)
+	})}))
+	defer r.close()
+	r.report(CallResult{Namespace: polarisDefaultNamespace, Service: serviceName, InstanceID: "ins-1",
+		RetStatus: model.RetFail, RetCode: 500, Delay: time.Millisecond})
+	r.report(CallResult{Namespace: polarisDefaultNamespace, Service: serviceName, InstanceID: "ins-1",
+		RetStatus: RetFlowControl, Delay: time.Millisecond})
+	r.flush()
+	require.Len(t, backend.CallResults(), 1)
+	require.Equal(t, model.RetFail, backend.CallResults()[0].GetRetStatus())
+	require.Len(t, reports, 1)
+	require.Equal(t, CallFail, reports[0].Status)
+	require.Equal(t, model.RetFail, reports[0].RetStatus)
+}
+
+func TestCallResultFlowControlNotReported(t *testing.T) {
+	backend := newCallResultBackend()
+	r := newCallResultReporter(backend, newOptions([]Option{WithCallResultFlushInterval(time.Hour)}))
+	defer r.close()
+
+	r.report(callResult("ins-1", CallFlowControl, 0, time.Millisecond))
+	r.report(callResult("ins-1", CallFail, 0, time.Millisecond))
+	r.flush()
+	require.Len(t, backend.CallResults(), 1)
+	require.Equal(t, uint64(0), r.droppedResults())
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import "context"
+
+// canaryTagKey is the description tag carrying the canary of a call.
+const canaryTagKey = "polaris.canary"
+
+type canaryKey struct{}
+
+// CtxWithCanary returns a context setting the canary of the calls made with it, overriding WithCanary.
+// An empty value queries polaris without canary. Kitex keeps one balancer per description, so Target
+// appends the canary to the description of the call.
+func CtxWithCanary(ctx context.Context, value string) context.Context {
+	return context.WithValue(ctx, canaryKey{}, value)
+}
+
+// canary returns the canary of a call, the one of CtxWithCanary or else the one of WithCanary.
+func (polaris *polarisResolver) canary(ctx context.Context) string {
+	if ctx != nil {
+		if value, ok := ctx.Value(canaryKey{}).(string); ok {
+			return value
+		}
+	}
+	return polaris.opts.canary
+}
+
+// canaryTags returns the canary of a call as a description tag.
+func (polaris *polarisResolver) canaryTags(ctx context.Context) []TargetTag {
+	if canary := polaris.canary(ctx); canary != "" {
+		return []TargetTag{{Key: canaryTagKey, Value: canary}}
+	}
+	return nil
+}
+
+// splitCanary splits the canary appended by Target off the description tags.
+func splitCanary(tags []TargetTag) (rest []TargetTag, canary string) {
+	for _, tag := range tags {
+		if tag.Key == canaryTagKey {
+			canary = tag.Value
+			continue
+		}
+		rest = append(rest, tag)
+	}
+	return rest, canary
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanary(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	consumer := &recordingConsumer{Backend: backend}
+	rs := newTestResolver(backend, WithCanary("1.2.0"))
+	rs.consumer = consumer
+	to := rpcinfo.NewEndpointInfo(serviceName, "echo", nil, nil)
+
+	desc := rs.Target(context.Background(), to)
+	require.Equal(t, "default:registry-test?polaris.canary=1.2.0", desc)
+	// the canary is not a metadata filter.
+	result, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, desc, result.CacheKey)
+	require.Equal(t, "1.2.0", consumer.requests[0].Canary)
+
+	ctx := CtxWithCanary(context.Background(), "1.3.0")
+	canaryDesc := rs.Target(ctx, to)
+	require.Equal(t, "default:registry-test?polaris.canary=1.3.0", canaryDesc)
+	result, err = rs.Resolve(ctx, canaryDesc)
+	require.Nil(t, err)
+	require.Equal(t, canaryDesc, result.CacheKey)
+	require.Equal(t, "1.3.0", consumer.requests[1].Canary)
+
+	// an empty canary disables the one of the resolver.
+	ctx = CtxWithCanary(context.Background(), "")
+	plainDesc := rs.Target(ctx, to)
+	require.Equal(t, "default:registry-test", plainDesc)
+	_, err = rs.Resolve(ctx, plainDesc)
+	require.Nil(t, err)
+	require.Equal(t, "", consumer.requests[2].Canary)
+}
+
+func TestCanaryDiscover(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	consumer := &recordingConsumer{Backend: backend}
+	rs := newTestResolver(backend)
+	rs.consumer = consumer
+
+	_, err := rs.discover(CtxWithCanary(context.Background(), "1.3.0"), polarisDefaultNamespace, serviceName)
+	require.Nil(t, err)
+	require.Equal(t, "1.3.0", consumer.requests[0].Canary)
+}
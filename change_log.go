@@ -0,0 +1,100 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// ChangeStats counts the Changes built from the events of the watched services and their instances,
+// Summarized the Changes whose log was capped by WithChangeLogCap.
+type ChangeStats struct {
+	Changes    uint64 `json:"changes"`
+	Added      uint64 `json:"added"`
+	Updated    uint64 `json:"updated"`
+	Removed    uint64 `json:"removed"`
+	Summarized uint64 `json:"summarized"`
+}
+
+// changeAddresses returns the addresses of instances, capped to the first n ones when n is positive.
+func changeAddresses(instances []discovery.Instance, n int) []string {
+	if n > 0 && len(instances) > n {
+		instances = instances[:n]
+	}
+	addrs := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		if addr := ins.Address(); addr != nil {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return addrs
+}
+
+// summarizeChangeList describes the instances of one list of a Change like "2 [a b]", the addresses
+// past the first n are counted only.
+func summarizeChangeList(instances []discovery.Instance, n int) string {
+	addrs := changeAddresses(instances, n)
+	s := strconv.Itoa(len(instances)) + " [" + strings.Join(addrs, " ")
+	if more := len(instances) - len(addrs); more > 0 {
+		s += " ... " + strconv.Itoa(more) + " more"
+	}
+	return s + "]"
+}
+
+// observeChange counts a Change delivered for desc and logs it at debug level, with at most the
+// number of addresses of WithChangeLogCap per list. The Change itself is not altered.
+func (polaris *polarisResolver) observeChange(ctx context.Context, desc string, change discovery.Change) {
+	added, updated, removed := len(change.Added), len(change.Updated), len(change.Removed)
+	if added+updated+removed == 0 {
+		return
+	}
+	n := polaris.opts.changeLogCap
+	summarized := n > 0 && (added > n || updated > n || removed > n)
+	atomic.AddUint64(&polaris.changeCount, 1)
+	atomic.AddUint64(&polaris.changeAdded, uint64(added))
+	atomic.AddUint64(&polaris.changeUpdated, uint64(updated))
+	atomic.AddUint64(&polaris.changeRemoved, uint64(removed))
+	if summarized {
+		atomic.AddUint64(&polaris.summarizedChanges, 1)
+	}
+	if logger := polaris.opts.structuredLogger; logger != nil {
+		logger.DebugContext(ctx, "polaris change", polaris.logFields(ctx, "description", desc,
+			"added_count", added, "updated_count", updated, "removed_count", removed,
+			"added", changeAddresses(change.Added, n), "updated", changeAddresses(change.Updated, n),
+			"removed", changeAddresses(change.Removed, n), "summarized", summarized)...)
+		return
+	}
+	log.GetBaseLogger().Debugf("[Polaris resolver] change of %s: added %s, updated %s, removed %s", desc,
+		summarizeChangeList(change.Added, n), summarizeChangeList(change.Updated, n), summarizeChangeList(change.Removed, n))
+}
+
+// ChangeStats implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) ChangeStats() ChangeStats {
+	return ChangeStats{
+		Changes:    atomic.LoadUint64(&polaris.changeCount),
+		Added:      atomic.LoadUint64(&polaris.changeAdded),
+		Updated:    atomic.LoadUint64(&polaris.changeUpdated),
+		Removed:    atomic.LoadUint64(&polaris.changeRemoved),
+		Summarized: atomic.LoadUint64(&polaris.summarizedChanges),
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOversizedChangeLog(t *testing.T) {
+	const size, capped = 1000, 3
+	backend := polaristest.NewBackend()
+	old := make([]*polaristest.Instance, 0, size)
+	for i := 0; i < size; i++ {
+		old = append(old, newTestListenerInstance(uint32(10000+i)))
+	}
+	backend.AddInstances(old...)
+	logger := &structuredLogger{}
+	rs := newTestResolver(backend, WithChangeLogCap(capped), WithStructuredLogger(logger))
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	changes := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	requireInitialChange(t, changes, size)
+
+	// one event replaces every instance, like a namespace wide mistake.
+	removed := make([]model.Instance, 0, size)
+	for _, ins := range backend.Instances(polarisDefaultNamespace, serviceName) {
+		removed = append(removed, ins)
+	}
+	added := make([]model.Instance, 0, size)
+	for i := 0; i < size; i++ {
+		added = append(added, &polaristest.Instance{ID: fmt.Sprintf("new-%d", i), Namespace: polarisDefaultNamespace,
+			Service: serviceName, Host: "127.0.0.2", Port: uint32(10000 + i), Revision: fmt.Sprintf("new-%d", i)})
+	}
+	backend.Publish(polarisDefaultNamespace, serviceName, &model.InstanceEvent{
+		AddEvent:    &model.InstanceAddEvent{Instances: added},
+		DeleteEvent: &model.InstanceDeleteEvent{Instances: removed},
+	})
+	select {
+	case change := <-changes:
+		// the Change handed to the listeners is complete.
+		require.Len(t, change.Added, size)
+		require.Len(t, change.Removed, size)
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+
+	require.Eventually(t, func() bool { return rs.ChangeStats().Changes == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, ChangeStats{Changes: 1, Added: size, Removed: size, Summarized: 1}, rs.ChangeStats())
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	var logged []structuredRecord
+	for _, record := range logger.records {
+		if record.msg == "polaris change" {
+			logged = append(logged, record)
+		}
+	}
+	require.Len(t, logged, 1)
+	fields := logged[0].fields
+	require.Equal(t, "debug", logged[0].level)
+	require.Equal(t, size, fields["added_count"])
+	require.Equal(t, size, fields["removed_count"])
+	require.Equal(t, []string{"127.0.0.2:10000", "127.0.0.2:10001", "127.0.0.2:10002"}, fields["added"])
+	require.Len(t, fields["removed"], capped)
+	require.Equal(t, true, fields["summarized"])
+}
+
+func TestSummarizeChangeList(t *testing.T) {
+	instances := []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:6666", 10, nil),
+		discovery.NewInstance("tcp", "127.0.0.1:6667", 10, nil),
+		discovery.NewInstance("tcp", "127.0.0.1:6668", 10, nil),
+	}
+	require.Equal(t, "3 [127.0.0.1:6666 ... 2 more]", summarizeChangeList(instances, 1))
+	require.Equal(t, "3 [127.0.0.1:6666 127.0.0.1:6667 127.0.0.1:6668]", summarizeChangeList(instances, 0))
+	require.Equal(t, "3 [127.0.0.1:6666 127.0.0.1:6667 127.0.0.1:6668]", summarizeChangeList(instances, 3))
+	require.Equal(t, "0 []", summarizeChangeList(nil, 1))
+
+	// the Changes below the cap are logged but not summarized.
+	rs := newTestResolver(polaristest.NewBackend(), WithChangeLogCap(3))
+	rs.observeChange(context.Background(), "desc", discovery.Change{Added: instances, Removed: instances[:1]})
+	require.Equal(t, ChangeStats{Changes: 1, Added: 3, Removed: 1}, rs.ChangeStats())
+	rs.observeChange(context.Background(), "desc", discovery.Change{})
+	require.Equal(t, uint64(1), rs.ChangeStats().Changes)
+}
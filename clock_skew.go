@@ -0,0 +1,86 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+const defaultClockSkewThreshold = time.Second
+
+// sampleClockSkew measures the offset of the server clock against the local one with the probe set by
+// WithServerTimeProbe, the server time is compared with the middle of the probe round trip.
+func (svr *polarisRegistry) sampleClockSkew() {
+	probe := svr.opts.serverTimeProbe
+	if probe == nil {
+		return
+	}
+	now := svr.now
+	if now == nil {
+		now = time.Now
+	}
+	send := now()
+	serverTime, err := probe()
+	if err != nil {
+		log.GetBaseLogger().Warnf("[Polaris registry] probe server time: %v", err)
+		return
+	}
+	recv := now()
+	skew := serverTime.Sub(send.Add(recv.Sub(send) / 2))
+	atomic.StoreInt64(&svr.clockSkew, int64(skew))
+
+	skewed := svr.clockSkewed(skew)
+	switch {
+	case skewed && atomic.SwapInt32(&svr.skewed, 1) == 0:
+		log.GetBaseLogger().Warnf("[Polaris registry] clock skew with polaris server is %v, heartbeat interval shortened to %v",
+			skew, svr.effectiveHeartbeatInterval())
+	case !skewed && atomic.SwapInt32(&svr.skewed, 0) == 1:
+		log.GetBaseLogger().Infof("[Polaris registry] clock skew with polaris server back to %v", skew)
+	}
+}
+
+func (svr *polarisRegistry) clockSkewed(skew time.Duration) bool {
+	threshold := svr.opts.clockSkewThreshold
+	if threshold <= 0 {
+		threshold = defaultClockSkewThreshold
+	}
+	return skew >= threshold || -skew >= threshold
+}
+
+// effectiveHeartbeatInterval returns the configured heartbeat interval, shortened to a third of the
+// instance TTL while the clock skew exceeds its threshold so that late heartbeats still meet the TTL.
+func (svr *polarisRegistry) effectiveHeartbeatInterval() time.Duration {
+	interval := svr.heartbeatInterval
+	if interval <= 0 {
+		interval = heartbeatTime
+	}
+	if !svr.clockSkewed(svr.ClockSkew()) {
+		return interval
+	}
+	if short := time.Duration(defaultHeartbeatIntervalSec) * time.Second / 3; short < interval {
+		return short
+	}
+	return interval
+}
+
+// ClockSkew implements the RegistryDiagnostics interface.
+func (svr *polarisRegistry) ClockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&svr.clockSkew))
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockSkewAdjustsInterval(t *testing.T) {
+	local := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	ttl := time.Duration(defaultHeartbeatIntervalSec) * time.Second
+	for _, c := range []struct {
+		skew     time.Duration
+		interval time.Duration
+	}{
+		{skew: 0, interval: heartbeatTime},
+		{skew: 200 * time.Millisecond, interval: heartbeatTime},
+		{skew: 8 * time.Second, interval: ttl / 3},
+		{skew: -8 * time.Second, interval: ttl / 3},
+	} {
+		skew := c.skew
+		rg := newTestRegistry(polaristest.NewBackend(), WithServerTimeProbe(func() (time.Time, error) {
+			return local.Add(skew), nil
+		}))
+		rg.heartbeatInterval = heartbeatTime
+		rg.now = func() time.Time { return local }
+
+		rg.sampleClockSkew()
+		require.Equal(t, skew, rg.ClockSkew())
+		require.Equal(t, c.interval, rg.effectiveHeartbeatInterval())
+	}
+}
+
+func TestClockSkewRoundTrip(t *testing.T) {
+	local := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	rg := newTestRegistry(polaristest.NewBackend(), WithServerTimeProbe(func() (time.Time, error) {
+		return local.Add(3 * time.Second), nil
+	}))
+	calls := 0
+	rg.now = func() time.Time {
+		calls++
+		if calls == 1 {
+			return local
+		}
+		// the probe took 2s, the server answered in the middle of it.
+		return local.Add(2 * time.Second)
+	}
+
+	rg.sampleClockSkew()
+	require.Equal(t, 2*time.Second, rg.ClockSkew())
+}
+
+func TestClockSkewProbeError(t *testing.T) {
+	rg := newTestRegistry(polaristest.NewBackend(), WithServerTimeProbe(func() (time.Time, error) {
+		return time.Time{}, errors.New("unreachable")
+	}))
+	rg.sampleClockSkew()
+	require.Equal(t, time.Duration(0), rg.ClockSkew())
+}
+
+func TestHeartbeatShortenedOnClockSkew(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithHeartbeatJitter(0), WithClockSkewThreshold(2*time.Second),
+		WithServerTimeProbe(func() (time.Time, error) { return time.Now().Add(8 * time.Second), nil }))
+	rg.heartbeatInterval = heartbeatTime
+	clock := newFakeHeartbeatClock(rg)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	require.Equal(t, heartbeatTime, clock.beat(t))
+	ttl := time.Duration(defaultHeartbeatIntervalSec) * time.Second
+	require.Equal(t, ttl/3, clock.beat(t))
+	require.InDelta(t, float64(8*time.Second), float64(rg.ClockSkew()), float64(time.Second))
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// inflightCall is a GetInstances call shared by the concurrent resolutions of a description.
+type inflightCall struct {
+	done chan struct{}
+	resp *model.InstancesResponse
+	err  error
+}
+
+// inflightCalls coalesces the concurrent GetInstances calls of a description like singleflight: the calls
+// made while one is in flight wait for it and share its response or error. Nothing is kept once it returns,
+// so the next call queries polaris again.
+type inflightCalls struct {
+	lock  sync.Mutex
+	calls map[string]*inflightCall
+}
+
+// startAlone runs fn in a goroutine without sharing it with the concurrent calls, see CtxWithFreshResolve.
+func startAlone(fn func() (*model.InstancesResponse, error)) *inflightCall {
+	call := &inflightCall{done: make(chan struct{})}
+	go func() {
+		call.resp, call.err = fn()
+		close(call.done)
+	}()
+	return call
+}
+
+// start returns the call in flight for key, or starts fn in a goroutine when there is none.
+func (c *inflightCalls) start(key string, fn func() (*model.InstancesResponse, error)) *inflightCall {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call
+	}
+	if c.calls == nil {
+		c.calls = make(map[string]*inflightCall)
+	}
+	call := &inflightCall{done: make(chan struct{})}
+	c.calls[key] = call
+	go func() {
+		call.resp, call.err = fn()
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		close(call.done)
+	}()
+	return call
+}
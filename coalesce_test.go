@@ -0,0 +1,106 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// resolveConcurrently resolves desc from n goroutines released at once.
+func resolveConcurrently(rs *polarisResolver, desc string, n int) ([]discovery.Result, []error) {
+	results, errs := make([]discovery.Result, n), make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			results[i], errs[i] = rs.Resolve(context.Background(), desc)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return results, errs
+}
+
+func TestResolveCoalescing(t *testing.T) {
+	const goroutines = 200
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6666})
+	backend.SetLatency(polaristest.OpGetInstances, 200*time.Millisecond)
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	results, errs := resolveConcurrently(rs, desc, goroutines)
+	require.Equal(t, 1, backend.Calls(polaristest.OpGetInstances))
+	for i := range results {
+		require.Nil(t, errs[i])
+		require.Equal(t, []string{"127.0.0.1:6666"}, addrs(results[i].Instances))
+	}
+
+	// nothing is cached once the call returned.
+	_, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, 2, backend.Calls(polaristest.OpGetInstances))
+}
+
+func TestResolveCoalescingError(t *testing.T) {
+	const goroutines = 200
+	backend := polaristest.NewBackend()
+	backend.SetLatency(polaristest.OpGetInstances, 200*time.Millisecond)
+	backend.SetFailureRate(polaristest.OpGetInstances, 1)
+	rs := newTestResolver(backend)
+
+	_, errs := resolveConcurrently(rs, polarisDefaultNamespace+":"+serviceName, goroutines)
+	require.Equal(t, 1, backend.Calls(polaristest.OpGetInstances))
+	for _, err := range errs {
+		require.NotNil(t, err)
+		require.Equal(t, errs[0].Error(), err.Error())
+	}
+}
+
+func TestResolveCoalescingContext(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6666})
+	backend.SetLatency(polaristest.OpGetInstances, 200*time.Millisecond)
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	// a waiter giving up does not fail the others sharing the call.
+	done := make(chan error, 1)
+	go func() {
+		_, err := rs.Resolve(context.Background(), desc)
+		done <- err
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := rs.Resolve(ctx, desc)
+	_, ok := err.(*ResolveContextError)
+	require.True(t, ok, "%v", err)
+	require.Nil(t, <-done)
+	require.Equal(t, 1, backend.Calls(polaristest.OpGetInstances))
+}
@@ -19,6 +19,7 @@ package polaris
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -26,26 +27,32 @@ import (
 	perrors "github.com/pkg/errors"
 	"github.com/polarismesh/polaris-go/api"
 	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/polarismesh/polaris-go/pkg/log"
 	"github.com/polarismesh/polaris-go/pkg/model"
 )
 
+// RawSDKContext returns a polaris-go SDK context of endpoints configured by opts, for the SDK APIs
+// this package does not wrap. The caller destroys it.
+func RawSDKContext(endpoints []string, opts ...Option) (api.SDKContext, error) {
+	return newSDKContext(endpoints, newOptions(opts))
+}
+
 // GetPolarisConfig get polaris config from endpoints.
-func GetPolarisConfig(endpoints []string) (api.SDKContext, error) {
-	if len(endpoints) == 0 {
-		return nil, perrors.New("endpoints is empty!")
-	}
+//
+// Deprecated: use RawSDKContext.
+func GetPolarisConfig(endpoints []string, opts ...Option) (api.SDKContext, error) {
+	return RawSDKContext(endpoints, opts...)
+}
 
-	serverConfigs := make([]string, 0, len(endpoints))
-	for _, addr := range endpoints {
-		ip, portStr, err := net.SplitHostPort(addr)
-		if err != nil {
-			return nil, perrors.WithMessagef(err, "split [%s] ", addr)
-		}
-		port, _ := strconv.Atoi(portStr)
-		serverConfigs = append(serverConfigs, fmt.Sprintf("%s:%d", ip, uint64(port)))
+// newSDKContext builds the SDK context of endpoints configured by o.
+func newSDKContext(endpoints []string, o *options) (api.SDKContext, error) {
+	polarisConf, err := newPolarisConfiguration(endpoints, o)
+	if err != nil {
+		return nil, err
+	}
+	if err := configureSDKLoggers(o); err != nil {
+		return nil, err
 	}
-
-	polarisConf := config.NewDefaultConfiguration(serverConfigs)
 
 	sdkCtx, err := api.InitContextByConfig(polarisConf)
 	if err != nil {
@@ -54,24 +61,187 @@ func GetPolarisConfig(endpoints []string) (api.SDKContext, error) {
 	return sdkCtx, nil
 }
 
+// newPolarisConfiguration builds the polaris SDK configuration from endpoints and options.
+func newPolarisConfiguration(endpoints []string, o *options) (config.Configuration, error) {
+	if len(endpoints) == 0 {
+		return nil, perrors.New("endpoints is empty!")
+	}
+
+	protocol, serverConfigs, err := parseEndpoints(endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	polarisConf := config.NewDefaultConfiguration(serverConfigs)
+	polarisConf.GetGlobal().GetServerConnector().SetProtocol(protocol)
+	if o.disableStatReporter {
+		polarisConf.GetGlobal().GetStatReporter().SetEnable(false)
+	}
+	if len(o.routerChain) > 0 {
+		chain, err := o.serviceRouterChain()
+		if err != nil {
+			return nil, err
+		}
+		polarisConf.GetConsumer().GetServiceRouter().SetChain(chain)
+	}
+	if o.disableLocationProvider {
+		serviceRouter := polarisConf.GetConsumer().GetServiceRouter()
+		chain := make([]string, 0, len(serviceRouter.GetChain()))
+		for _, router := range serviceRouter.GetChain() {
+			if router != config.DefaultServiceRouterNearbyBased {
+				chain = append(chain, router)
+			}
+		}
+		serviceRouter.SetChain(chain)
+	}
+	return polarisConf, nil
+}
+
 // SplitDescription splits description to namespace and serviceName.
+// Only the first separator is significant, a description without separator is treated as
+// a fully qualified service name. The target tags appended by Target are ignored.
 func SplitDescription(description string) (string, string) {
-	str := strings.Split(description, ":")
+	description, _ = cutDescriptionTags(description)
+	str := strings.SplitN(description, descriptionSeparator, 2)
+	if len(str) == 1 {
+		namespace, serviceName, ok := splitQualifiedServiceName(description)
+		if !ok {
+			return polarisDefaultNamespace, description
+		}
+		return namespace, serviceName
+	}
 	namespace, serviceName := str[0], str[1]
 	return namespace, serviceName
 }
 
-// ChangePolarisInstanceToKitex transforms polaris instance to Kitex instance.
+// joinDescriptionTags appends tags to a "namespace:service" description as "?env=prod&idc=sh".
+func joinDescriptionTags(description string, tags []TargetTag) string {
+	if len(tags) == 0 {
+		return description
+	}
+	var sb strings.Builder
+	sb.WriteString(description)
+	for i, tag := range tags {
+		if i == 0 {
+			sb.WriteString(tagsSeparator)
+		} else {
+			sb.WriteString(tagSeparator)
+		}
+		sb.WriteString(url.QueryEscape(tag.Key))
+		sb.WriteString("=")
+		sb.WriteString(url.QueryEscape(tag.Value))
+	}
+	return sb.String()
+}
+
+// cutDescriptionTags splits the tags appended by joinDescriptionTags off a description,
+// the tags keep their order and malformed pairs are ignored.
+func cutDescriptionTags(description string) (string, []TargetTag) {
+	i := strings.Index(description, tagsSeparator)
+	if i < 0 {
+		return description, nil
+	}
+	var tags []TargetTag
+	for _, pair := range strings.Split(description[i+1:], tagSeparator) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			continue
+		}
+		value, err := url.QueryUnescape(kv[1])
+		if err != nil {
+			continue
+		}
+		tags = append(tags, TargetTag{Key: key, Value: value})
+	}
+	return description[:i], tags
+}
+
+// splitQualifiedServiceName splits a fully qualified service name like "Production/user.api",
+// ok is false when name is not exactly one non-empty namespace and one non-empty service.
+func splitQualifiedServiceName(name string) (namespace, serviceName string, ok bool) {
+	str := strings.Split(name, qualifiedNameSeparator)
+	if len(str) != 2 || str[0] == "" || str[1] == "" {
+		return "", name, false
+	}
+	return str[0], str[1], true
+}
+
+// ChangePolarisInstanceToKitex transforms polaris instance to Kitex instance,
+// the instance metadata is carried as tags.
+//
+// Deprecated: use ConvertInstance.
 func ChangePolarisInstanceToKitex(PolarisInstance model.Instance) discovery.Instance {
-	weight := PolarisInstance.GetWeight()
-	if weight <= 0 {
-		weight = defaultWeight
+	return ConvertInstance(PolarisInstance)
+}
+
+// instanceConversion sets how polaris instances are converted. keep returns whether a metadata key is
+// copied into the tags, nil keeps all, setKeys are the metadata keys of the set name tried first.
+// local tells whether a host is the local one, the instances are converted to their MetadataUDSPath
+// on it, or on every host with preferUDS. Without local every instance is converted to its TCP address.
+// status adds the health and isolation status tags.
+type instanceConversion struct {
+	keep          func(key string) bool
+	setKeys       []string
+	defaultWeight int
+	local         func(host string) bool
+	preferUDS     bool
+	status        bool
+}
+
+// convert transforms a polaris instance to a Kitex instance as set by conv.
+func (conv instanceConversion) convert(PolarisInstance Instance) discovery.Instance {
+	tags := make(map[string]string, len(PolarisInstance.GetMetadata())+4)
+	tags["namespace"] = PolarisInstance.GetNamespace()
+	if conv.status {
+		tags[TagHealthy] = strconv.FormatBool(PolarisInstance.IsHealthy())
+		tags[TagIsolated] = strconv.FormatBool(PolarisInstance.IsIsolated())
 	}
-	addr := PolarisInstance.GetHost() + ":" + strconv.Itoa(int(PolarisInstance.GetPort()))
+	return newKitexInstance(PolarisInstance, tags, conv)
+}
 
-	tags := map[string]string{
-		"namespace": PolarisInstance.GetNamespace(),
+// newKitexInstance converts an instance, the given tags take precedence over the instance metadata.
+func newKitexInstance(PolarisInstance Instance, tags map[string]string, conv instanceConversion) discovery.Instance {
+	if id := PolarisInstance.GetId(); id != "" {
+		tags[TagHashKey] = id
+	}
+	if set := instanceSetName(PolarisInstance.GetMetadata(), PolarisInstance.GetLogicSet(), conv.setKeys); set != "" {
+		tags[TagSetName] = set
+	}
+	if revision := PolarisInstance.GetRevision(); revision != "" {
+		tags[TagRevision] = revision
 	}
+	setLocalityTags(tags, PolarisInstance)
+	created, modified := instanceTimes(PolarisInstance)
+	if created != "" {
+		tags[TagCreateTime] = created
+	}
+	if modified != "" {
+		tags[TagModifyTime] = modified
+	}
+	keep := conv.keep
+	for k, v := range PolarisInstance.GetMetadata() {
+		if keep != nil && !keep(k) {
+			continue
+		}
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	weight := polarisWeight(PolarisInstance.GetWeight(), PolarisInstance.GetMetadata(), conv.defaultWeight)
+	if path, ok := conv.udsPath(PolarisInstance); ok {
+		// the network no longer carries a protocol declared by the polaris protocol field.
+		if _, network := networks[strings.ToLower(PolarisInstance.GetProtocol())]; !network {
+			if _, ok := tags[TagProtocol]; !ok {
+				tags[TagProtocol] = PolarisInstance.GetProtocol()
+			}
+		}
+		return discovery.NewInstance("unix", path, weight, tags)
+	}
+	addr := PolarisInstance.GetHost() + ":" + strconv.Itoa(int(PolarisInstance.GetPort()))
 
 	KitexInstance := discovery.NewInstance(PolarisInstance.GetProtocol(), addr, weight, tags)
 	// In KitexInstance , tags can be used as IDC、Cluster、Env 、namespace、and so on.
@@ -133,3 +303,13 @@ func GetInstanceKey(namespace, serviceName, host, port string) string {
 	instanceKey.WriteString(port)
 	return instanceKey.String()
 }
+
+// runHook runs a user hook, a panic in the hook is recovered and logged.
+func runHook(name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.GetBaseLogger().Errorf("[Polaris] %s hook panic: %v", name, r)
+		}
+	}()
+	hook()
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitDescription(t *testing.T) {
+	cases := []struct {
+		desc, namespace, service string
+	}{
+		{"Production:user.api", "Production", "user.api"},
+		{"Production/user.api", "Production", "user.api"},
+		{"user.api", polarisDefaultNamespace, "user.api"},
+		{"default:/user.api", polarisDefaultNamespace, "/user.api"},
+	}
+	for _, c := range cases {
+		namespace, service := SplitDescription(c.desc)
+		require.Equal(t, c.namespace, namespace, c.desc)
+		require.Equal(t, c.service, service, c.desc)
+	}
+}
+
+func TestPolarisConfigurationToggles(t *testing.T) {
+	endpoints := []string{"127.0.0.1:8091"}
+	conf, err := newPolarisConfiguration(endpoints, newOptions(nil))
+	require.Nil(t, err)
+	require.Contains(t, conf.GetConsumer().GetServiceRouter().GetChain(), config.DefaultServiceRouterNearbyBased)
+
+	conf, err = newPolarisConfiguration(endpoints, newOptions([]Option{
+		WithDisableStatReporter(true),
+		WithDisableLocationProvider(true),
+	}))
+	require.Nil(t, err)
+	require.False(t, conf.GetGlobal().GetStatReporter().IsEnable())
+	require.NotContains(t, conf.GetConsumer().GetServiceRouter().GetChain(), config.DefaultServiceRouterNearbyBased)
+	require.NotEmpty(t, conf.GetConsumer().GetServiceRouter().GetChain())
+}
+
+func TestRawSDKContextWithDisabledPlugins(t *testing.T) {
+	sdkCtx, err := RawSDKContext([]string{"127.0.0.1:8091"},
+		WithDisableStatReporter(true), WithDisableLocationProvider(true))
+	require.Nil(t, err)
+	sdkCtx.Destroy()
+}
+
+// TestDeprecatedGetPolarisConfig checks the old name of RawSDKContext still builds the SDK context.
+func TestDeprecatedGetPolarisConfig(t *testing.T) {
+	sdkCtx, err := GetPolarisConfig([]string{"127.0.0.1:8091"}, WithDisableStatReporter(true))
+	require.Nil(t, err)
+	require.False(t, sdkCtx.GetConfig().GetGlobal().GetStatReporter().IsEnable())
+	sdkCtx.Destroy()
+
+	_, err = GetPolarisConfig([]string{"127.0.0.1:8091"}, WithSDKLogLevel("loud"))
+	require.NotNil(t, err)
+}
+
+func TestMetadataTagPrefixPassthrough(t *testing.T) {
+	metadata := map[string]string{
+		"conn.max-conns": "50",
+		"conn.tls":       "true",
+		"env":            "prod",
+		"owner":          "team-a",
+		TagProtocol:      "grpc",
+	}
+	desc := polarisDefaultNamespace + ":" + serviceName
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{"default copies all", nil, []string{"conn.max-conns", "conn.tls", "env", "owner", TagProtocol}},
+		{"prefix", []Option{WithMetadataTagPrefixPassthrough([]string{MetadataConnPrefix})},
+			[]string{"conn.max-conns", "conn.tls", TagProtocol}},
+		{"filtered keys kept", []Option{WithMetadataTagPrefixPassthrough([]string{MetadataConnPrefix}), WithTargetTagKeys("env")},
+			[]string{"conn.max-conns", "conn.tls", "env", TagProtocol}},
+		{"empty copies none", []Option{WithMetadataTagPrefixPassthrough(nil)}, []string{TagProtocol}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			backend := polaristest.NewBackend()
+			backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName,
+				Host: "127.0.0.1", Port: 6666, Metadata: metadata})
+			rs := newTestResolver(backend, c.opts...)
+			for _, resolve := range []func(context.Context, string) (discovery.Result, error){rs.Resolve, rs.ResolveAll} {
+				result, err := resolve(context.TODO(), desc)
+				require.Nil(t, err)
+				for key := range metadata {
+					_, ok := result.Instances[0].Tag(key)
+					require.Equal(t, contains(c.expected, key), ok, key)
+				}
+				namespace, _ := result.Instances[0].Tag("namespace")
+				require.Equal(t, polarisDefaultNamespace, namespace)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestResolverConcurrentUse shares one resolver between 50 goroutines like as many Kitex clients,
+// it is meant to run with -race while the backend keeps changing the instances.
+func TestResolverConcurrentUse(t *testing.T) {
+	const goroutines = 50
+	backend := polaristest.NewBackend()
+	services := []string{serviceName, serviceName + "-2", serviceName + "-3"}
+	for _, service := range services {
+		backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: service, Host: "127.0.0.1", Port: 6666})
+	}
+	rs := newTestResolver(backend, WithTargetTagKeys("env"), WithRouteDebug(true), WithInstanceLogSampling(7),
+		WithCallResultFlushInterval(time.Millisecond))
+
+	done := make(chan struct{})
+	var churn sync.WaitGroup
+	churn.Add(1)
+	go func() {
+		defer churn.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			service := services[i%len(services)]
+			ins := &polaristest.Instance{Namespace: polarisDefaultNamespace, Service: service, Host: "127.0.0.2", Port: uint32(7000 + i%20)}
+			backend.AddInstances(ins)
+			backend.RemoveInstances(polarisDefaultNamespace, service, backend.Instances(polarisDefaultNamespace, service)[1].ID)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			service := services[g%len(services)]
+			for i := 0; i < 20; i++ {
+				desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(service, "", nil, map[string]string{"env": "prod"}))
+				plain := polarisDefaultNamespace + ":" + service
+				rs.Resolve(context.TODO(), plain)
+				rs.ResolveAll(context.TODO(), desc)
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+				rs.Watcher(ctx, plain)
+				cancel()
+				unsubscribe, err := rs.Subscribe(plain+"?g="+strconv.Itoa(g%5), func(discovery.Change) {})
+				if err == nil {
+					unsubscribe()
+				}
+				rs.ServiceMetadata(context.TODO(), plain)
+				rs.ReportCallResult(CallResult{Namespace: polarisDefaultNamespace, Service: service,
+					InstanceID: "id", Status: CallSuccess, Delay: time.Millisecond})
+				rs.LastRouteTrace(plain)
+				rs.LastRevision(plain)
+				rs.EffectiveOptions()
+				rs.DroppedListenerChanges()
+				rs.DroppedCallResults()
+				rs.SkippedEvents()
+				rs.StaticFallbacks()
+			}
+		}(g)
+	}
+	// Close races with the end of the calls, the calls after it must not race either.
+	time.Sleep(10 * time.Millisecond)
+	rs.Close()
+	wg.Wait()
+	close(done)
+	churn.Wait()
+}
+
+func TestResolverClosed(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend())
+	desc := polarisDefaultNamespace + ":" + serviceName
+	require.Nil(t, rs.Close())
+
+	_, err := rs.Watcher(context.TODO(), desc)
+	require.Equal(t, ErrResolverClosed, err)
+	_, err = rs.Subscribe(desc, func(discovery.Change) {})
+	require.Equal(t, ErrResolverClosed, err)
+}
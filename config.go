@@ -0,0 +1,118 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"time"
+
+	perrors "github.com/pkg/errors"
+)
+
+// Config is a declarative alternative to the options, for configuration loaded from files.
+// Zero values keep the defaults of the corresponding options.
+type Config struct {
+	Endpoints               []string      `json:"endpoints" yaml:"endpoints"`
+	Namespace               string        `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Token                   string        `json:"token,omitempty" yaml:"token,omitempty"`
+	HealthyOnly             bool          `json:"healthy_only,omitempty" yaml:"healthy_only,omitempty"`
+	HeartbeatInterval       time.Duration `json:"heartbeat_interval,omitempty" yaml:"heartbeat_interval,omitempty"`
+	ResolveTimeout          time.Duration `json:"resolve_timeout,omitempty" yaml:"resolve_timeout,omitempty"`
+	DeregisterTimeout       time.Duration `json:"deregister_timeout,omitempty" yaml:"deregister_timeout,omitempty"`
+	WatchTimeout            time.Duration `json:"watch_timeout,omitempty" yaml:"watch_timeout,omitempty"`
+	DisableLocationProvider bool          `json:"disable_location_provider,omitempty" yaml:"disable_location_provider,omitempty"`
+	DisableStatReporter     bool          `json:"disable_stat_reporter,omitempty" yaml:"disable_stat_reporter,omitempty"`
+}
+
+// Validate checks the configuration without connecting to polaris.
+func (c Config) Validate() error {
+	if len(c.Endpoints) == 0 {
+		return perrors.New("polaris config: endpoints is empty")
+	}
+	if _, _, err := parseEndpoints(c.Endpoints); err != nil {
+		return perrors.WithMessage(err, "polaris config: endpoints")
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"heartbeat_interval", c.HeartbeatInterval},
+		{"resolve_timeout", c.ResolveTimeout},
+		{"deregister_timeout", c.DeregisterTimeout},
+		{"watch_timeout", c.WatchTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return perrors.Errorf("polaris config: negative %s %v", d.name, d.value)
+		}
+	}
+	if ttl := time.Duration(defaultHeartbeatIntervalSec) * time.Second; c.HeartbeatInterval >= ttl {
+		return perrors.Errorf("polaris config: heartbeat_interval %v must be shorter than the instance TTL %v",
+			c.HeartbeatInterval, ttl)
+	}
+	return nil
+}
+
+// Options translates the configuration into the equivalent options.
+func (c Config) Options() []Option {
+	var opts []Option
+	if c.Namespace != "" {
+		opts = append(opts, WithNamespace(c.Namespace))
+	}
+	if c.Token != "" {
+		opts = append(opts, WithServiceToken(c.Token))
+	}
+	if c.HealthyOnly {
+		opts = append(opts, WithHealthyOnly(true))
+	}
+	if c.HeartbeatInterval > 0 {
+		opts = append(opts, WithHeartbeatInterval(c.HeartbeatInterval))
+	}
+	if c.ResolveTimeout > 0 {
+		opts = append(opts, WithResolveTimeout(c.ResolveTimeout))
+	}
+	if c.DeregisterTimeout > 0 {
+		opts = append(opts, WithDeregisterTimeout(c.DeregisterTimeout))
+	}
+	if c.WatchTimeout > 0 {
+		opts = append(opts, WithWatchTimeout(c.WatchTimeout))
+	}
+	if c.DisableLocationProvider {
+		opts = append(opts, WithDisableLocationProvider(true))
+	}
+	if c.DisableStatReporter {
+		opts = append(opts, WithDisableStatReporter(true))
+	}
+	return opts
+}
+
+// NewResolverFromConfig validates conf and creates a resolver from it, opts are applied after the
+// options translated from conf.
+func NewResolverFromConfig(conf Config, opts ...Option) (Resolver, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewPolarisResolver(conf.Endpoints, append(conf.Options(), opts...)...)
+}
+
+// NewRegistryFromConfig validates conf and creates a registry from it, opts are applied after the
+// options translated from conf.
+func NewRegistryFromConfig(conf Config, opts ...Option) (Registry, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewPolarisRegistry(conf.Endpoints, append(conf.Options(), opts...)...)
+}
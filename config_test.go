@@ -0,0 +1,131 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Endpoints: []string{"127.0.0.1:8091"}}
+	require.Nil(t, valid.Validate())
+
+	for name, conf := range map[string]Config{
+		"no endpoints":       {},
+		"endpoint port":      {Endpoints: []string{"127.0.0.1"}},
+		"negative timeout":   {Endpoints: []string{"127.0.0.1:8091"}, ResolveTimeout: -time.Second},
+		"heartbeat over ttl": {Endpoints: []string{"127.0.0.1:8091"}, HeartbeatInterval: 5 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NotNil(t, conf.Validate())
+			_, err := NewResolverFromConfig(conf)
+			require.NotNil(t, err)
+			_, err = NewRegistryFromConfig(conf)
+			require.NotNil(t, err)
+		})
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	var conf Config
+	require.Nil(t, json.Unmarshal([]byte(`{
+		"endpoints": ["127.0.0.1:8091"],
+		"namespace": "Production",
+		"token": "secret",
+		"healthy_only": true,
+		"heartbeat_interval": 2000000000
+	}`), &conf))
+	require.Equal(t, Config{
+		Endpoints:         []string{"127.0.0.1:8091"},
+		Namespace:         "Production",
+		Token:             "secret",
+		HealthyOnly:       true,
+		HeartbeatInterval: 2 * time.Second,
+	}, conf)
+}
+
+func TestConfigOptionsEquivalence(t *testing.T) {
+	conf := Config{
+		Endpoints:               []string{"127.0.0.1:8091"},
+		Namespace:               "Production",
+		Token:                   "secret",
+		HealthyOnly:             true,
+		HeartbeatInterval:       2 * time.Second,
+		ResolveTimeout:          time.Second,
+		DeregisterTimeout:       3 * time.Second,
+		WatchTimeout:            4 * time.Second,
+		DisableLocationProvider: true,
+		DisableStatReporter:     true,
+	}
+	require.Equal(t, newOptions([]Option{
+		WithNamespace("Production"),
+		WithServiceToken("secret"),
+		WithHealthyOnly(true),
+		WithHeartbeatInterval(2 * time.Second),
+		WithResolveTimeout(time.Second),
+		WithDeregisterTimeout(3 * time.Second),
+		WithWatchTimeout(4 * time.Second),
+		WithDisableLocationProvider(true),
+		WithDisableStatReporter(true),
+	}), newOptions(conf.Options()))
+	require.Equal(t, newOptions(nil), newOptions(Config{Endpoints: conf.Endpoints}.Options()))
+}
+
+// recoverAllConsumer returns the unhealthy instances too, like the SDK does when all instances are unhealthy.
+type recoverAllConsumer struct {
+	*polaristest.Backend
+}
+
+func (c *recoverAllConsumer) GetInstances(req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	req.IncludeUnhealthyInstances = true
+	return c.Backend.GetInstances(req)
+}
+
+func TestConfigBehavior(t *testing.T) {
+	conf := Config{Endpoints: []string{"127.0.0.1:8091"}, Namespace: "Production", Token: "secret", HealthyOnly: true}
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 6666},
+		&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 7777, Unhealthy: true},
+	)
+
+	rg := newTestRegistry(backend, conf.Options()...)
+	info := newTestInfo("127.0.0.1:8888", nil)
+	require.Nil(t, rg.Register(info))
+	require.Len(t, backend.Instances("Production", serviceName), 3)
+	require.Eventually(t, func() bool { return len(backend.Heartbeats()) > 0 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, "secret", backend.Heartbeats()[0].ServiceToken)
+	require.Nil(t, rg.Deregister(info))
+
+	rs := newTestResolver(backend, conf.Options()...)
+	rs.consumer = &recoverAllConsumer{Backend: backend}
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil))
+	require.Equal(t, "Production:"+serviceName, desc)
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, "127.0.0.1:6666", result.Instances[0].Address().String())
+}
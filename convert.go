@@ -0,0 +1,141 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// ConvertOption sets how ConvertInstance and ConvertInstanceList convert polaris instances, like the
+// conversion options of the resolver, so that tools list the instances the way the clients see them.
+type ConvertOption func(conv *instanceConversion)
+
+// WithConvertResolverOptions converts the instances exactly like a resolver created with opts,
+// the next ConvertOptions override it.
+func WithConvertResolverOptions(opts ...Option) ConvertOption {
+	return withInstanceConversion(newOptions(opts).instanceConversion())
+}
+
+// WithConvertDefaultWeight sets the weight of the instances registered without one, see WithDefaultWeight.
+func WithConvertDefaultWeight(weight int) ConvertOption {
+	return func(conv *instanceConversion) {
+		conv.defaultWeight = weight
+	}
+}
+
+// WithConvertTagPrefixes copies only the metadata keys starting with one of prefixes into the tags,
+// see WithMetadataTagPrefixPassthrough.
+func WithConvertTagPrefixes(prefixes ...string) ConvertOption {
+	return func(conv *instanceConversion) {
+		conv.keep = prefixTagFilter(prefixes, conv.setKeys)
+	}
+}
+
+// WithConvertSetMetadataKeys sets the metadata keys of the set name of the instances, see WithSetMetadataKeys.
+func WithConvertSetMetadataKeys(keys ...string) ConvertOption {
+	return func(conv *instanceConversion) {
+		conv.setKeys = keys
+	}
+}
+
+// WithConvertStatus adds the TagHealthy and TagIsolated tags, like ResolveAll.
+func WithConvertStatus(status bool) ConvertOption {
+	return func(conv *instanceConversion) {
+		conv.status = status
+	}
+}
+
+// withInstanceConversion converts as set by conv.
+func withInstanceConversion(conv instanceConversion) ConvertOption {
+	return func(c *instanceConversion) {
+		*c = conv
+	}
+}
+
+func newInstanceConversion(opts []ConvertOption) instanceConversion {
+	var conv instanceConversion
+	for _, opt := range opts {
+		opt(&conv)
+	}
+	return conv
+}
+
+// Instance is the polaris instance the conversions read. Every model.Instance of polaris-go is one,
+// the interface keeps the converters and the tools building instances apart from the SDK model package.
+type Instance interface {
+	GetNamespace() string
+	GetService() string
+	GetId() string
+	GetHost() string
+	GetPort() uint32
+	GetProtocol() string
+	GetWeight() int
+	GetMetadata() map[string]string
+	GetLogicSet() string
+	GetRegion() string
+	GetZone() string
+	GetCampus() string
+	GetRevision() string
+	IsHealthy() bool
+	IsIsolated() bool
+}
+
+// FromRawInstances returns the Instances of polaris-go instances, like those of an SDK response.
+func FromRawInstances(instances []model.Instance) []Instance {
+	if instances == nil {
+		return nil
+	}
+	converted := make([]Instance, len(instances))
+	for i, instance := range instances {
+		converted[i] = instance
+	}
+	return converted
+}
+
+// ConvertInstance converts a polaris instance to the Kitex instance clients resolve, the metadata
+// is carried as tags.
+func ConvertInstance(instance Instance, opts ...ConvertOption) discovery.Instance {
+	return newInstanceConversion(opts).convert(instance)
+}
+
+// ConvertInstanceList converts polaris instances like ConvertInstance, for the provider view of tools
+// listing the registered instances of a service.
+func ConvertInstanceList(instances []Instance, opts ...ConvertOption) []discovery.Instance {
+	conv := newInstanceConversion(opts)
+	eps := make([]discovery.Instance, 0, len(instances))
+	for _, instance := range instances {
+		eps = append(eps, conv.convert(instance))
+	}
+	return eps
+}
+
+// ConvertInstances converts polaris-go instances like ConvertInstanceList.
+//
+// Deprecated: use ConvertInstanceList with FromRawInstances.
+func ConvertInstances(instances []model.Instance, opts ...ConvertOption) []discovery.Instance {
+	return newInstanceConversion(opts).convertRaw(instances)
+}
+
+// convertRaw converts the polaris-go instances of an SDK response.
+func (conv instanceConversion) convertRaw(instances []model.Instance) []discovery.Instance {
+	eps := make([]discovery.Instance, 0, len(instances))
+	for _, instance := range instances {
+		eps = append(eps, conv.convert(instance))
+	}
+	return eps
+}
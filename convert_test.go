@@ -0,0 +1,108 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertInstance(t *testing.T) {
+	instance := &polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1",
+		Port: 6666, Metadata: map[string]string{"conn.tls": "true", "env": "prod", "cell": "c1"}}
+
+	ins := ConvertInstance(instance)
+	require.Equal(t, defaultWeight, ins.Weight())
+	require.Equal(t, "127.0.0.1:6666", ins.Address().String())
+	for key, want := range map[string]string{"namespace": polarisDefaultNamespace, "env": "prod", "conn.tls": "true"} {
+		value, _ := ins.Tag(key)
+		require.Equal(t, want, value, key)
+	}
+	_, ok := ins.Tag(TagHealthy)
+	require.False(t, ok)
+
+	require.Equal(t, 100, ConvertInstance(instance, WithConvertDefaultWeight(100)).Weight())
+
+	ins = ConvertInstance(instance, WithConvertTagPrefixes(MetadataConnPrefix))
+	_, ok = ins.Tag("env")
+	require.False(t, ok)
+	value, _ := ins.Tag("conn.tls")
+	require.Equal(t, "true", value)
+
+	ins = ConvertInstance(instance, WithConvertSetMetadataKeys("cell"))
+	value, _ = ins.Tag(TagSetName)
+	require.Equal(t, "c1", value)
+
+	ins = ConvertInstance(instance, WithConvertStatus(true))
+	value, _ = ins.Tag(TagHealthy)
+	require.Equal(t, "true", value)
+	value, _ = ins.Tag(TagIsolated)
+	require.Equal(t, "false", value)
+
+	// the later options override the resolver ones.
+	ins = ConvertInstance(instance, WithConvertResolverOptions(WithDefaultWeight(20), WithSetMetadataKeys("cell")),
+		WithConvertDefaultWeight(30))
+	require.Equal(t, 30, ins.Weight())
+	value, _ = ins.Tag(TagSetName)
+	require.Equal(t, "c1", value)
+}
+
+// TestConvertInstancesLikeResolve lists the instances of a service the way the resolver resolves them.
+func TestConvertInstancesLikeResolve(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{"conn.max-conns": "50", "owner": "a"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6667,
+			Weight: 50},
+	)
+	opts := []Option{WithDefaultWeight(80), WithMetadataTagPrefixPassthrough([]string{MetadataConnPrefix})}
+	result, err := newTestResolver(backend, opts...).Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+
+	var instances []Instance
+	for _, ins := range backend.Instances(polarisDefaultNamespace, serviceName) {
+		instances = append(instances, ins)
+	}
+	converted := ConvertInstanceList(instances, WithConvertResolverOptions(opts...))
+	require.Len(t, converted, 2)
+	require.ElementsMatch(t, result.Instances, converted)
+	require.Empty(t, ConvertInstanceList(nil))
+}
+
+// TestDeprecatedConvertInstances checks the conversions of polaris-go instances still convert like
+// ConvertInstanceList.
+func TestDeprecatedConvertInstances(t *testing.T) {
+	raw := []model.Instance{
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{"env": "prod"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6667,
+			Weight: 50},
+	}
+	instances := FromRawInstances(raw)
+	require.Len(t, instances, 2)
+	require.Nil(t, FromRawInstances(nil))
+
+	converted := ConvertInstanceList(instances, WithConvertStatus(true))
+	require.Equal(t, converted, ConvertInstances(raw, WithConvertStatus(true)))
+	require.Empty(t, ConvertInstances(nil))
+	require.Equal(t, ConvertInstance(raw[1]), ChangePolarisInstanceToKitex(raw[1]))
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// deregisterAllConcurrency bounds the deregistrations in flight of one DeregisterAllMatching call.
+const deregisterAllConcurrency = 8
+
+// DeregisterAllMatching implements the BatchRegistry interface.
+func (svr *polarisRegistry) DeregisterAllMatching(ctx context.Context, namespace, service string,
+	predicate func(InstanceInfo) bool) (int, error) {
+	if svr.consumerAPI() == nil {
+		return 0, perrors.New("DeregisterAllMatching needs a consumer API, set WithConsumerAPI")
+	}
+	if namespace == "" {
+		namespace = svr.opts.defaultNamespace()
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	req := &api.GetAllInstancesRequest{}
+	req.Namespace = namespace
+	req.Service = service
+	resp, err := svr.consumerAPI().GetAllInstances(req)
+	if err != nil {
+		return 0, perrors.WithMessagef(err, "get all instances of %s:%s", namespace, service)
+	}
+
+	var (
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		count   int
+		failed  = make(map[string]error)
+		slots   = make(chan struct{}, deregisterAllConcurrency)
+		matched []model.Instance
+	)
+	for _, instance := range resp.GetInstances() {
+		if predicate == nil || predicate(newInstanceInfo(instance)) {
+			matched = append(matched, instance)
+		}
+	}
+	for i, instance := range matched {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			lock.Lock()
+			for _, skipped := range matched[i:] {
+				failed[skipped.GetId()] = err
+			}
+			lock.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(instance model.Instance) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			err := svr.deregisterInstance(namespace, service, instance)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				failed[instance.GetId()] = err
+				return
+			}
+			count++
+		}(instance)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return count, &DeregisterAllError{Namespace: namespace, Service: service, Deregistered: count, Failed: failed}
+	}
+	return count, nil
+}
+
+// deregisterInstance deregisters an instance listed from polaris, the heartbeats are stopped when it was
+// registered by this registry.
+func (svr *polarisRegistry) deregisterInstance(namespace, service string, instance model.Instance) error {
+	request := &api.InstanceDeRegisterRequest{
+		InstanceDeRegisterRequest: model.InstanceDeRegisterRequest{
+			Service:      service,
+			ServiceToken: svr.opts.serviceToken,
+			Namespace:    namespace,
+			InstanceID:   instance.GetId(),
+			Host:         instance.GetHost(),
+			Port:         int(instance.GetPort()),
+		},
+	}
+	if err := svr.deregisterWithTimeout(request); err != nil {
+		return err
+	}
+	instanceKey := GetInstanceKey(namespace, service, instance.GetHost(), strconv.Itoa(int(instance.GetPort())))
+	svr.lock.RLock()
+	insHeartbeat, ok := svr.registryIns[instanceKey]
+	svr.lock.RUnlock()
+	if ok {
+		svr.forget(instanceKey, insHeartbeat)
+	}
+	return nil
+}
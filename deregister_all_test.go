@@ -0,0 +1,142 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/stretchr/testify/require"
+)
+
+// deregisterFaults fails the deregistration of some instances and tracks the calls in flight.
+type deregisterFaults struct {
+	*polaristest.Backend
+	fail        map[string]bool
+	delay       time.Duration
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *deregisterFaults) Deregister(req *api.InstanceDeRegisterRequest) error {
+	f.lock.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		f.inFlight--
+		f.lock.Unlock()
+	}()
+	time.Sleep(f.delay)
+	if f.fail[req.InstanceID] {
+		return errors.New("polaris unavailable")
+	}
+	return f.Backend.Deregister(req)
+}
+
+// addTestRunInstances adds n instances tagged with the test run id.
+func addTestRunInstances(backend *polaristest.Backend, runID string, port, n int) {
+	for i := 0; i < n; i++ {
+		backend.AddInstances(&polaristest.Instance{
+			ID: fmt.Sprintf("%s-%d", runID, i), Namespace: polarisDefaultNamespace, Service: serviceName,
+			Host: "127.0.0.1", Port: uint32(port + i), Metadata: map[string]string{"test-run-id": runID},
+		})
+	}
+}
+
+func testRun(runID string) func(InstanceInfo) bool {
+	return func(info InstanceInfo) bool { return info.Metadata["test-run-id"] == runID }
+}
+
+func TestDeregisterAllMatching(t *testing.T) {
+	backend := polaristest.NewBackend()
+	addTestRunInstances(backend, "ours", 7000, 20)
+	addTestRunInstances(backend, "theirs", 8000, 5)
+	faults := &deregisterFaults{Backend: backend, delay: 5 * time.Millisecond}
+	svr := newTestRegistry(backend)
+	svr.provider = faults
+
+	count, err := svr.DeregisterAllMatching(context.TODO(), "", serviceName, testRun("ours"))
+	require.Nil(t, err)
+	require.Equal(t, 20, count)
+	remaining := backend.Instances(polarisDefaultNamespace, serviceName)
+	require.Len(t, remaining, 5)
+	for _, ins := range remaining {
+		require.Equal(t, "theirs", ins.Metadata["test-run-id"])
+	}
+	require.True(t, faults.maxInFlight > 1)
+	require.True(t, faults.maxInFlight <= deregisterAllConcurrency)
+}
+
+func TestDeregisterAllMatchingPartialFailure(t *testing.T) {
+	backend := polaristest.NewBackend()
+	addTestRunInstances(backend, "ours", 7000, 4)
+	svr := newTestRegistry(backend)
+	svr.provider = &deregisterFaults{Backend: backend, fail: map[string]bool{"ours-1": true, "ours-3": true}}
+
+	count, err := svr.DeregisterAllMatching(context.TODO(), polarisDefaultNamespace, serviceName, testRun("ours"))
+	require.Equal(t, 2, count)
+	var allErr *DeregisterAllError
+	require.True(t, errors.As(err, &allErr))
+	require.Equal(t, 2, allErr.Deregistered)
+	require.Len(t, allErr.Failed, 2)
+	require.Contains(t, allErr.Failed, "ours-1")
+	require.Contains(t, allErr.Failed, "ours-3")
+	require.Contains(t, err.Error(), "2 deregistered, 2 failed [ours-1: polaris unavailable; ours-3: polaris unavailable]")
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 2)
+}
+
+func TestDeregisterAllMatchingContext(t *testing.T) {
+	backend := polaristest.NewBackend()
+	addTestRunInstances(backend, "ours", 7000, 3)
+	svr := newTestRegistry(backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	count, err := svr.DeregisterAllMatching(ctx, "", serviceName, nil)
+	require.Equal(t, 0, count)
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 3)
+
+	svr.consumer = nil
+	_, err = svr.DeregisterAllMatching(context.TODO(), "", serviceName, nil)
+	require.NotNil(t, err)
+}
+
+func TestDeregisterAllMatchingStopsHeartbeats(t *testing.T) {
+	backend := polaristest.NewBackend()
+	svr := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", map[string]string{"test-run-id": "ours"})
+	require.Nil(t, svr.Register(info))
+
+	count, err := svr.DeregisterAllMatching(context.TODO(), "", serviceName, testRun("ours"))
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+	svr.lock.RLock()
+	require.Empty(t, svr.registryIns)
+	svr.lock.RUnlock()
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"net"
+	"strconv"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// deregistration is an in-flight Deregister, the concurrent Deregisters of the same instance share its result.
+type deregistration struct {
+	done chan struct{}
+	err  error
+}
+
+// startDeregistration returns the registration of an instance, nil when the registry does not know it,
+// and its in-flight deregistration. first reports whether the caller started the deregistration and must
+// finish it, the other callers wait for it.
+func (svr *polarisRegistry) startDeregistration(instanceKey string) (insHeartbeat *polarisHeartbeat,
+	pending *deregistration, first bool) {
+	svr.lock.Lock()
+	defer svr.lock.Unlock()
+	insHeartbeat, ok := svr.registryIns[instanceKey]
+	if !ok {
+		return nil, nil, false
+	}
+	if pending, ok := svr.deregistrations[instanceKey]; ok {
+		return insHeartbeat, pending, false
+	}
+	if svr.deregistrations == nil {
+		svr.deregistrations = make(map[string]*deregistration)
+	}
+	pending = &deregistration{done: make(chan struct{})}
+	svr.deregistrations[instanceKey] = pending
+	return insHeartbeat, pending, true
+}
+
+// finishDeregistration records the result of a deregistration and releases its waiters.
+func (svr *polarisRegistry) finishDeregistration(instanceKey string, pending *deregistration, err error) {
+	svr.lock.Lock()
+	delete(svr.deregistrations, instanceKey)
+	svr.lock.Unlock()
+	pending.err = err
+	close(pending.done)
+}
+
+// deregisterUnknown handles the Deregister of an instance the registry has no registration of, like the
+// second Deregister of the Kitex shutdown paths or the one of a server which never registered. It is a
+// no-op unless WithStrictDeregister is set, which deregisters the instance from polaris anyway and
+// fails unless polaris confirms that the instance is gone.
+func (svr *polarisRegistry) deregisterUnknown(request *api.InstanceDeRegisterRequest, instanceKey string) error {
+	if !svr.opts.strictDeregister {
+		log.GetBaseLogger().Debugf("[Polaris registry] instance{%s} is not registered, skipping its deregistration", instanceKey)
+		return nil
+	}
+	err := svr.deregisterWithTimeout(request)
+	instance := net.JoinHostPort(request.Host, strconv.Itoa(request.Port))
+	err = deregisterInstanceError(err, request.Namespace, request.Service, instance)
+	if err == nil || perrors.Is(err, ErrInstanceNotFound) {
+		return nil
+	}
+	return perrors.WithMessagef(err, "instance{%s} deregister", instanceKey)
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// deregisterConcurrently runs deregister n times at once and returns the errors.
+func deregisterConcurrently(n int, deregister func() error) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = deregister()
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestDeregisterTwice(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+
+	require.Nil(t, rg.Deregister(info))
+	require.Nil(t, rg.Deregister(info))
+	require.Equal(t, 1, backend.Calls(polaristest.OpDeregister))
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+
+	// the heartbeats stopped with the first Deregister.
+	beats := len(backend.Heartbeats())
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, beats, len(backend.Heartbeats()))
+}
+
+func TestDeregisterBeforeRegister(t *testing.T) {
+	backend := polaristest.NewBackend()
+	require.Nil(t, newTestRegistry(backend).Deregister(newTestInfo("127.0.0.1:6666", nil)))
+	require.Equal(t, 0, backend.Calls(polaristest.OpDeregister))
+
+	// the strict registry asks polaris, which does not know the instance either.
+	strict := newTestRegistry(backend, WithStrictDeregister(true))
+	require.Nil(t, strict.Deregister(newTestInfo("127.0.0.1:6666", nil)))
+	require.Equal(t, 1, backend.Calls(polaristest.OpDeregister))
+
+	// a registration left by a previous process is deregistered.
+	backend.AddInstances(newTestListenerInstance(6667))
+	require.Nil(t, strict.Deregister(newTestInfo("127.0.0.1:6667", nil)))
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+
+	backend.SetFailureRate(polaristest.OpDeregister, 1)
+	require.NotNil(t, strict.Deregister(newTestInfo("127.0.0.1:6666", nil)))
+	require.Nil(t, newTestRegistry(backend).Deregister(newTestInfo("127.0.0.1:6666", nil)))
+}
+
+func TestDeregisterConcurrent(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetLatency(polaristest.OpDeregister, 20*time.Millisecond)
+	rg := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+
+	for _, err := range deregisterConcurrently(8, func() error { return rg.Deregister(info) }) {
+		require.Nil(t, err)
+	}
+	require.Equal(t, 1, backend.Calls(polaristest.OpDeregister))
+	rg.lock.RLock()
+	require.Empty(t, rg.registryIns)
+	require.Empty(t, rg.deregistrations)
+	rg.lock.RUnlock()
+
+	// the concurrent Deregisters of an instance which never registered are no-ops too.
+	never := newTestInfo("127.0.0.1:6667", nil)
+	for _, err := range deregisterConcurrently(8, func() error { return rg.Deregister(never) }) {
+		require.Nil(t, err)
+	}
+	require.Equal(t, 1, backend.Calls(polaristest.OpDeregister))
+}
+
+func TestDeregisterConcurrentFailure(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	// the waiters share the failure of the deregistration, which keeps the registration.
+	backend.SetLatency(polaristest.OpDeregister, 20*time.Millisecond)
+	backend.SetFailureRate(polaristest.OpDeregister, 1)
+	for _, err := range deregisterConcurrently(4, func() error { return rg.Deregister(info) }) {
+		require.NotNil(t, err)
+	}
+	rg.lock.RLock()
+	require.Len(t, rg.registryIns, 1)
+	rg.lock.RUnlock()
+
+	backend.SetFailureRate(polaristest.OpDeregister, 0)
+	require.Nil(t, rg.Deregister(info))
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+}
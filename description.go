@@ -0,0 +1,108 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"strings"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// TargetTag is a Kitex endpoint tag encoded into the description by Target,
+// it filters the resolved instances on their metadata.
+type TargetTag struct {
+	Key   string
+	Value string
+}
+
+// TargetInfo is what a description identifies: a polaris service and the ordered target tags.
+type TargetInfo struct {
+	Namespace string
+	Service   string
+	Tags      []TargetTag
+}
+
+// DescriptionCodec converts between TargetInfo and the description string that Kitex uses as the
+// cache key of a target. Decode must accept every string returned by Encode, and Encode must return
+// the same description for the same TargetInfo since Target caches them.
+type DescriptionCodec interface {
+	Encode(info TargetInfo) string
+	Decode(description string) (TargetInfo, error)
+}
+
+// DefaultDescriptionCodec returns the codec of the "namespace:service?env=prod&idc=sh" format.
+func DefaultDescriptionCodec() DescriptionCodec {
+	return defaultDescriptionCodec{}
+}
+
+type defaultDescriptionCodec struct{}
+
+// Encode implements the DescriptionCodec interface.
+func (defaultDescriptionCodec) Encode(info TargetInfo) string {
+	return joinDescriptionTags(info.Namespace+descriptionSeparator+info.Service, info.Tags)
+}
+
+// Decode implements the DescriptionCodec interface, it never fails.
+func (defaultDescriptionCodec) Decode(description string) (TargetInfo, error) {
+	_, tags := cutDescriptionTags(description)
+	namespace, service := SplitDescription(description)
+	return TargetInfo{Namespace: namespace, Service: service, Tags: tags}, nil
+}
+
+// descriptionCodec returns the codec set by WithDescriptionCodec or the default one.
+func (o *options) descriptionCodec() DescriptionCodec {
+	if o.codec != nil {
+		return o.codec
+	}
+	return defaultDescriptionCodec{}
+}
+
+// invalidTargetPrefix starts the descriptions Target returns for targets it cannot resolve,
+// no codec produces it since it is not a valid namespace.
+const invalidTargetPrefix = "!invalid-target:"
+
+// invalidTarget returns the sentinel description of an invalid target, the resolver calls fail with
+// ErrInvalidTarget and reason.
+func invalidTarget(reason string) string {
+	log.GetBaseLogger().Warnf("[Polaris resolver] invalid target: %s", reason)
+	return invalidTargetPrefix + reason
+}
+
+// decodeDescription decodes desc with the configured codec, failures are returned as DescriptionError.
+// The sentinel descriptions of invalid targets fail with ErrInvalidTarget. With WithStrictDescriptions
+// the decoded desc is encoded again and must give desc back.
+func (polaris *polarisResolver) decodeDescription(desc string) (TargetInfo, error) {
+	if strings.HasPrefix(desc, invalidTargetPrefix) {
+		return TargetInfo{}, perrors.WithMessage(ErrInvalidTarget, strings.TrimPrefix(desc, invalidTargetPrefix))
+	}
+	codec := polaris.opts.descriptionCodec()
+	info, err := codec.Decode(desc)
+	if err != nil {
+		return TargetInfo{}, &DescriptionError{Description: desc, Err: err}
+	}
+	if polaris.opts.strictDescriptions {
+		if encoded := codec.Encode(info); encoded != desc {
+			log.GetBaseLogger().Errorf("[Polaris resolver] description %q is encoded back as %q", desc, encoded)
+			return TargetInfo{}, &DescriptionError{
+				Description: desc,
+				Err:         perrors.WithMessagef(ErrDescriptionMismatch, "encoded back as %q", encoded),
+			}
+		}
+	}
+	return info, nil
+}
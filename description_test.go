@@ -0,0 +1,177 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultDescriptionCodecRoundTrip(t *testing.T) {
+	codec := DefaultDescriptionCodec()
+	for _, info := range []TargetInfo{
+		{Namespace: polarisDefaultNamespace, Service: serviceName},
+		{Namespace: "Production", Service: "user.api", Tags: []TargetTag{{Key: "env", Value: "prod"}, {Key: "idc", Value: "sh&gz"}}},
+	} {
+		got, err := codec.Decode(codec.Encode(info))
+		require.Nil(t, err)
+		require.Equal(t, info, got)
+	}
+}
+
+func TestDefaultDescriptionCodecCompatibility(t *testing.T) {
+	codec := DefaultDescriptionCodec()
+	require.Equal(t, "Production:user.api?env=prod&idc=sh", codec.Encode(TargetInfo{
+		Namespace: "Production", Service: "user.api",
+		Tags: []TargetTag{{Key: "env", Value: "prod"}, {Key: "idc", Value: "sh"}},
+	}))
+	for desc, want := range map[string]TargetInfo{
+		"Production:user.api": {Namespace: "Production", Service: "user.api"},
+		"Production/user.api": {Namespace: "Production", Service: "user.api"},
+		"user.api":            {Namespace: polarisDefaultNamespace, Service: "user.api"},
+	} {
+		got, err := codec.Decode(desc)
+		require.Nil(t, err)
+		require.Equal(t, want, got)
+	}
+}
+
+// tenantCodec encodes a tenant carried as a target tag in a "tenant@namespace.service" description.
+type tenantCodec struct{}
+
+func (tenantCodec) Encode(info TargetInfo) string {
+	for _, tag := range info.Tags {
+		if tag.Key == "tenant" {
+			return tag.Value + "@" + info.Namespace + "." + info.Service
+		}
+	}
+	return info.Namespace + "." + info.Service
+}
+
+func (tenantCodec) Decode(description string) (TargetInfo, error) {
+	var info TargetInfo
+	if i := strings.Index(description, "@"); i >= 0 {
+		info.Tags = []TargetTag{{Key: "tenant", Value: description[:i]}}
+		description = description[i+1:]
+	}
+	parts := strings.SplitN(description, ".", 2)
+	if len(parts) != 2 {
+		return TargetInfo{}, errors.New("missing namespace")
+	}
+	info.Namespace, info.Service = parts[0], parts[1]
+	return info, nil
+}
+
+func TestCustomDescriptionCodec(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{"tenant": "t1"}},
+		&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 7777,
+			Metadata: map[string]string{"tenant": "t2"}},
+	)
+	rs := newTestResolver(backend, WithDescriptionCodec(tenantCodec{}), WithTargetTagKeys(namespaceTagKey, "tenant"))
+
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil,
+		map[string]string{namespaceTagKey: "Production", "tenant": "t1"}))
+	require.Equal(t, "t1@Production."+serviceName, desc)
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, "127.0.0.1:6666", result.Instances[0].Address().String())
+}
+
+func TestDescriptionDecodeError(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend(), WithDescriptionCodec(tenantCodec{}))
+
+	var descErr *DescriptionError
+	_, err := rs.Resolve(context.TODO(), "t1@broken")
+	require.True(t, errors.As(err, &descErr))
+	require.Equal(t, "t1@broken", descErr.Description)
+	_, err = rs.Watcher(context.TODO(), "t1@broken")
+	require.True(t, errors.As(err, &descErr))
+	_, err = rs.Subscribe("t1@broken", func(change discovery.Change) {})
+	require.True(t, errors.As(err, &descErr))
+	_, err = rs.ServiceMetadata(context.TODO(), "t1@broken")
+	require.True(t, errors.As(err, &descErr))
+}
+
+// driftingCodec decodes the descriptions of the default codec but encodes them with another separator.
+type driftingCodec struct{ defaultDescriptionCodec }
+
+func (driftingCodec) Encode(info TargetInfo) string {
+	return info.Namespace + "/" + info.Service
+}
+
+func TestStrictDescriptions(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 6666,
+		Metadata: map[string]string{"env": "prod"}})
+	rs := newTestResolver(backend, WithStrictDescriptions(true), WithTargetTagKeys(namespaceTagKey, "env"))
+
+	desc := rs.Target(CtxWithCanary(context.TODO(), "1.2.0"), rpcinfo.NewEndpointInfo(serviceName, "", nil,
+		map[string]string{namespaceTagKey: "Production", "env": "prod"}))
+	for _, desc := range []string{desc, "Production:" + serviceName, "Production:" + serviceName + "?env=prod"} {
+		result, err := rs.Resolve(context.TODO(), desc)
+		require.Nil(t, err, desc)
+		require.Len(t, result.Instances, 1, desc)
+	}
+
+	for desc, encoded := range map[string]string{
+		serviceName:                              polarisDefaultNamespace + ":" + serviceName,
+		"Production/" + serviceName:              "Production:" + serviceName,
+		"Production:" + serviceName + "?env":     "Production:" + serviceName,
+		"Production:" + serviceName + "?env=a b": "Production:" + serviceName + "?env=a+b",
+	} {
+		var descErr *DescriptionError
+		_, err := rs.Resolve(context.TODO(), desc)
+		require.True(t, errors.As(err, &descErr), desc)
+		require.True(t, errors.Is(err, ErrDescriptionMismatch), desc)
+		require.Equal(t, desc, descErr.Description)
+		require.Contains(t, err.Error(), desc)
+		require.Contains(t, err.Error(), encoded)
+		_, err = rs.Watcher(context.TODO(), desc)
+		require.True(t, errors.Is(err, ErrDescriptionMismatch), desc)
+	}
+
+	// without the check the other forms are decoded as before.
+	rs = newTestResolver(backend, WithStrictDescriptions(false))
+	_, err := rs.Resolve(context.TODO(), "Production/"+serviceName)
+	require.Nil(t, err)
+}
+
+func TestStrictDescriptionsCodecDrift(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	rs := newTestResolver(backend, WithStrictDescriptions(true), WithDescriptionCodec(driftingCodec{}))
+
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil,
+		map[string]string{namespaceTagKey: "Production"}))
+	_, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	_, err = rs.Resolve(context.TODO(), "Production:"+serviceName)
+	require.True(t, errors.Is(err, ErrDescriptionMismatch))
+	require.Contains(t, err.Error(), `"Production/`+serviceName+`"`)
+}
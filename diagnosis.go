@@ -0,0 +1,173 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/cloudwego/kitex/pkg/diagnosis"
+	"github.com/cloudwego/kitex/pkg/registry"
+)
+
+const (
+	// ResolverProbeName is the diagnosis probe dumping a ResolverDiagnosis.
+	ResolverProbeName diagnosis.ProbeName = "polaris_resolver"
+	// RegistryProbeName is the diagnosis probe dumping a RegistryDiagnosis.
+	RegistryProbeName diagnosis.ProbeName = "polaris_registry"
+)
+
+// ResolverDiagnosis is the state of a resolver dumped by its diagnosis probe.
+type ResolverDiagnosis struct {
+	WatchedServices        []WatchedService `json:"watched_services"`
+	DroppedListenerChanges uint64           `json:"dropped_listener_changes"`
+	DroppedCallResults     uint64           `json:"dropped_call_results"`
+	StaticFallbacks        uint64           `json:"static_fallbacks"`
+	ZeroWeightResults      uint64           `json:"zero_weight_results"`
+	ChangeStats            ChangeStats      `json:"change_stats"`
+	SkippedEvents          uint64           `json:"skipped_events"`
+	Options                OptionsSnapshot  `json:"options"`
+}
+
+// WatchedService is a service watched by a resolver, Instances counts the instances of the last
+// applied revision and Waiters the Watcher and Subscribe consumers of its events.
+type WatchedService struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Revision  string `json:"revision"`
+	Instances int    `json:"instances"`
+	Waiters   int    `json:"waiters"`
+}
+
+// RegistryDiagnosis is the state of a registry dumped by its diagnosis probe.
+type RegistryDiagnosis struct {
+	State          string               `json:"state"`
+	Registered     []RegisteredInstance `json:"registered"`
+	Healthy        bool                 `json:"healthy"`
+	HeartbeatsLost uint64               `json:"heartbeats_lost"`
+	ClockSkew      string               `json:"clock_skew"`
+	Options        OptionsSnapshot      `json:"options"`
+}
+
+// RegisteredInstance is an instance registered by a registry and kept alive by its heartbeats.
+type RegisteredInstance struct {
+	InstanceKey     string `json:"instance_key"`
+	InstanceID      string `json:"instance_id"`
+	SharedHeartbeat bool   `json:"shared_heartbeat"`
+}
+
+// RegisterDiagnosis registers the probes dumping the state of r and reg with d, e.g. the service given to
+// client.WithDiagnosisService or server.WithDiagnosisService. A nil r or reg is skipped, a registry not
+// created by NewPolarisRegistry dumps an empty RegistryDiagnosis.
+func RegisterDiagnosis(d diagnosis.Service, r Resolver, reg registry.Registry) {
+	if r != nil {
+		diagnosis.RegisterProbeFunc(d, ResolverProbeName, func() interface{} {
+			return diagnoseResolver(r)
+		})
+	}
+	if reg != nil {
+		diagnosis.RegisterProbeFunc(d, RegistryProbeName, func() interface{} {
+			return diagnoseRegistry(reg)
+		})
+	}
+}
+
+func diagnoseResolver(r Resolver) ResolverDiagnosis {
+	e := extend(r)
+	d := ResolverDiagnosis{
+		DroppedListenerChanges: e.DroppedListenerChanges(),
+		DroppedCallResults:     e.DroppedCallResults(),
+		StaticFallbacks:        e.StaticFallbacks(),
+		ZeroWeightResults:      e.ZeroWeightResults(),
+		ChangeStats:            e.ChangeStats(),
+		SkippedEvents:          e.SkippedEvents(),
+		Options:                e.EffectiveOptions(),
+	}
+	if w, ok := r.(interface{ watchedServices() []WatchedService }); ok {
+		d.WatchedServices = w.watchedServices()
+	}
+	return d
+}
+
+func diagnoseRegistry(reg registry.Registry) RegistryDiagnosis {
+	svr, ok := reg.(*polarisRegistry)
+	if !ok {
+		return RegistryDiagnosis{}
+	}
+	d := RegistryDiagnosis{
+		State:          svr.CurrentState().String(),
+		Healthy:        atomic.LoadInt32(&svr.unhealthy) == 0,
+		HeartbeatsLost: svr.HeartbeatsLost(),
+		ClockSkew:      svr.ClockSkew().String(),
+		Options:        svr.EffectiveOptions(),
+	}
+	svr.lock.RLock()
+	for instanceKey, ins := range svr.registryIns {
+		d.Registered = append(d.Registered, RegisteredInstance{
+			InstanceKey:     instanceKey,
+			InstanceID:      ins.heartbeat.InstanceID,
+			SharedHeartbeat: ins.shared,
+		})
+	}
+	svr.lock.RUnlock()
+	sort.Slice(d.Registered, func(i, j int) bool { return d.Registered[i].InstanceKey < d.Registered[j].InstanceKey })
+	return d
+}
+
+func (polaris *polarisResolver) watchedServices() []WatchedService {
+	return polaris.watcher.watchedServices()
+}
+
+func (l *lazyResolver) watchedServices() []WatchedService {
+	r, err := l.get()
+	if err != nil {
+		return nil
+	}
+	if w, ok := r.(interface{ watchedServices() []WatchedService }); ok {
+		return w.watchedServices()
+	}
+	return nil
+}
+
+// watchedServices returns the subscribed services sorted by namespace and name.
+func (m *watchManager) watchedServices() []WatchedService {
+	m.lock.Lock()
+	watches := make([]*serviceWatch, 0, len(m.watches))
+	for _, sw := range m.watches {
+		watches = append(watches, sw)
+	}
+	m.lock.Unlock()
+	services := make([]WatchedService, 0, len(watches))
+	for _, sw := range watches {
+		sw.lock.Lock()
+		services = append(services, WatchedService{
+			Namespace: sw.key.Namespace,
+			Service:   sw.key.Service,
+			Revision:  sw.revision,
+			Instances: len(sw.revisions),
+			Waiters:   len(sw.waiters),
+		})
+		sw.lock.Unlock()
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Service < services[j].Service
+	})
+	return services
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/diagnosis"
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDiagnosis captures the registered probes.
+type fakeDiagnosis map[diagnosis.ProbeName]diagnosis.ProbeFunc
+
+func (d fakeDiagnosis) RegisterProbeFunc(name diagnosis.ProbeName, probe diagnosis.ProbeFunc) {
+	d[name] = probe
+}
+
+func TestRegisterDiagnosis(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: "diagnosis-test", Host: "127.0.0.1", Port: 6666},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: "diagnosis-test", Host: "127.0.0.2", Port: 6666},
+	)
+	rs := newTestResolver(backend)
+	defer rs.Close()
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":diagnosis-test", func(discovery.Change) {})
+	require.Nil(t, err)
+	defer unsubscribe()
+	rg := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.3:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	batch := []*registry.Info{newTestInfo("127.0.0.4:6666", nil)}
+	_, err = rg.RegisterBatch(batch)
+	require.Nil(t, err)
+	defer rg.DeregisterBatch(batch)
+
+	d := fakeDiagnosis{}
+	RegisterDiagnosis(d, rs, rg)
+	require.Len(t, d, 2)
+
+	resolverDump, ok := d[ResolverProbeName]().(ResolverDiagnosis)
+	require.True(t, ok)
+	require.Equal(t, []WatchedService{{
+		Namespace: polarisDefaultNamespace,
+		Service:   "diagnosis-test",
+		Revision:  resolverDump.WatchedServices[0].Revision,
+		Instances: 2,
+		Waiters:   1,
+	}}, resolverDump.WatchedServices)
+	require.Equal(t, rs.EffectiveOptions(), resolverDump.Options)
+
+	registryDump, ok := d[RegistryProbeName]().(RegistryDiagnosis)
+	require.True(t, ok)
+	require.True(t, registryDump.Healthy)
+	require.Equal(t, "registered", registryDump.State)
+	require.Equal(t, "0s", registryDump.ClockSkew)
+	require.Len(t, registryDump.Registered, 2)
+	require.False(t, registryDump.Registered[0].SharedHeartbeat)
+	require.True(t, registryDump.Registered[1].SharedHeartbeat)
+	require.NotEmpty(t, registryDump.Registered[0].InstanceID)
+	_, err = json.Marshal(registryDump)
+	require.Nil(t, err)
+
+	require.Nil(t, rg.SetHealthy(false))
+	registryDump = d[RegistryProbeName]().(RegistryDiagnosis)
+	require.False(t, registryDump.Healthy)
+}
+
+func TestRegisterDiagnosisOtherRegistry(t *testing.T) {
+	d := fakeDiagnosis{}
+	RegisterDiagnosis(d, nil, registry.NoopRegistry)
+	require.Len(t, d, 1)
+	require.Equal(t, RegistryDiagnosis{}, d[RegistryProbeName]())
+
+	RegisterDiagnosis(nil, nil, registry.NoopRegistry)
+}
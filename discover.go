@@ -0,0 +1,102 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// InstanceInfo is a plain description of a polaris instance returned by Discover.
+type InstanceInfo struct {
+	ID       string
+	Host     string
+	Port     int
+	Weight   int
+	Healthy  bool
+	Isolated bool
+	Metadata map[string]string
+}
+
+// newDiscoverConsumer creates the consumer used by one Discover call, replaced in tests.
+var newDiscoverConsumer = func(endpoints []string, opts ...Option) (api.ConsumerAPI, error) {
+	sdkCtx, err := RawSDKContext(endpoints, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewConsumerAPIByContext(sdkCtx), nil
+}
+
+// Discover queries the instances of a service once through a temporary polaris SDK context, without
+// any Kitex client. The instances go through the same routing and filters as Resolve.
+func Discover(ctx context.Context, endpoints []string, namespace, service string, opts ...Option) ([]InstanceInfo, error) {
+	consumer, err := newDiscoverConsumer(endpoints, opts...)
+	if err != nil {
+		return nil, perrors.WithMessage(err, "create polaris consumer failed")
+	}
+	defer consumer.Destroy()
+	rs := &polarisResolver{consumer: consumer, opts: newOptions(opts)}
+	return rs.discover(ctx, namespace, service)
+}
+
+func (polaris *polarisResolver) discover(ctx context.Context, namespace, service string) ([]InstanceInfo, error) {
+	req := &api.GetInstancesRequest{}
+	req.Namespace = namespace
+	req.Service = service
+	req.Canary = polaris.canary(ctx)
+	req.SkipRouteFilter = polaris.opts.systemServiceMode
+	// the canary of ctx is part of the coalescing key.
+	resp, err := polaris.getInstances(ctx, namespace+descriptionSeparator+service+"#"+req.Canary, req)
+	if _, ok := err.(*ResolveContextError); ok {
+		return nil, err
+	}
+	if err != nil {
+		err = notFoundError(err, namespace, service)
+		return nil, perrors.WithMessagef(err, "get instances of %s:%s", namespace, service)
+	}
+	eps := make([]discovery.Instance, 0, len(resp.GetInstances()))
+	sources := make(map[discovery.Instance]model.Instance, len(resp.GetInstances()))
+	conv := polaris.opts.instanceConversion()
+	for _, instance := range resp.GetInstances() {
+		ep := conv.convert(instance)
+		eps = append(eps, ep)
+		sources[ep] = instance
+	}
+	eps, _ = applyFilters(ctx, polaris.descriptionFilters(nil), eps, nil)
+	infos := make([]InstanceInfo, 0, len(eps))
+	for _, ep := range eps {
+		infos = append(infos, newInstanceInfo(sources[ep]))
+	}
+	return infos, nil
+}
+
+// newInstanceInfo describes a polaris instance, the metadata is copied.
+func newInstanceInfo(instance model.Instance) InstanceInfo {
+	return InstanceInfo{
+		ID:       instance.GetId(),
+		Host:     instance.GetHost(),
+		Port:     int(instance.GetPort()),
+		Weight:   instance.GetWeight(),
+		Healthy:  instance.IsHealthy(),
+		Isolated: instance.IsIsolated(),
+		Metadata: copyMetadata(instance.GetMetadata()),
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscover(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 6666, Weight: 50,
+			Metadata: map[string]string{"env": "prod"}},
+		&polaristest.Instance{Namespace: "Production", Service: serviceName, Host: "127.0.0.1", Port: 7777, Unhealthy: true},
+	)
+	origin := newDiscoverConsumer
+	defer func() { newDiscoverConsumer = origin }()
+	var endpoints []string
+	newDiscoverConsumer = func(eps []string, opts ...Option) (api.ConsumerAPI, error) {
+		endpoints = eps
+		return backend, nil
+	}
+
+	infos, err := Discover(context.TODO(), []string{"127.0.0.1:8091"}, "Production", serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:8091"}, endpoints)
+	require.Equal(t, []InstanceInfo{{
+		ID:       backend.Instances("Production", serviceName)[0].ID,
+		Host:     "127.0.0.1",
+		Port:     6666,
+		Weight:   50,
+		Healthy:  true,
+		Metadata: map[string]string{"env": "prod"},
+	}}, infos)
+	require.Equal(t, 1, backend.Destroyed(), "the temporary SDK context must be destroyed")
+
+	infos, err = Discover(context.TODO(), []string{"127.0.0.1:8091"}, "Production", "missing")
+	require.Nil(t, err)
+	require.Empty(t, infos)
+	require.Equal(t, 2, backend.Destroyed())
+}
+
+func TestDiscoverConstructionError(t *testing.T) {
+	_, err := Discover(context.TODO(), nil, polarisDefaultNamespace, serviceName)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	perrors "github.com/pkg/errors"
+)
+
+// Protocols of the polaris server selected by the scheme of the endpoints, like "http://polaris-gw:8080".
+// An endpoint without scheme uses ProtocolGRPC.
+const (
+	ProtocolGRPC  = "grpc"
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+)
+
+// ErrUnsupportedProtocol is returned when the endpoints select a protocol the polaris-go server connector
+// cannot speak. polaris-go v1.0.1 only has the gRPC connector, the HTTP bridge needs a newer SDK.
+var ErrUnsupportedProtocol = errors.New("the polaris-go server connector does not support the protocol")
+
+// connectorProtocols maps the supported endpoint schemes to the polaris-go server connector plugin.
+var connectorProtocols = map[string]string{
+	ProtocolGRPC: "grpc",
+}
+
+// parseEndpoints returns the server connector protocol and the "host:port" addresses of endpoints.
+// All endpoints must select the same protocol.
+func parseEndpoints(endpoints []string) (string, []string, error) {
+	protocol := ""
+	addrs := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		scheme, hostPort := ProtocolGRPC, endpoint
+		if strings.Contains(endpoint, "://") {
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return "", nil, perrors.WithMessagef(err, "parse [%s] ", redactEndpoint(endpoint))
+			}
+			scheme, hostPort = strings.ToLower(u.Scheme), u.Host
+		}
+		if protocol == "" {
+			protocol = scheme
+		} else if protocol != scheme {
+			return "", nil, perrors.Errorf("mixed polaris endpoint protocols %s and %s", protocol, scheme)
+		}
+		ip, portStr, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			return "", nil, perrors.WithMessagef(err, "split [%s] ", redactEndpoint(endpoint))
+		}
+		port, _ := strconv.Atoi(portStr)
+		addrs = append(addrs, fmt.Sprintf("%s:%d", ip, uint64(port)))
+	}
+	connector, ok := connectorProtocols[protocol]
+	if !ok {
+		return "", nil, perrors.WithMessagef(ErrUnsupportedProtocol, "protocol %s", protocol)
+	}
+	return connector, addrs, nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const defaultErrorLogInterval = time.Minute
+
+// Operations whose repeated failures are rate limited by errorLogLimiter.
+const (
+	errorLogResolve    = "resolve"
+	errorLogNoInstance = "no instance"
+	errorLogWatch      = "watch"
+	errorLogHeartbeat  = "heartbeat"
+	errorLogZeroWeight = "zero weight"
+)
+
+type errorLogKey struct {
+	op      string
+	service model.ServiceKey
+}
+
+// errorLogEntry is the state of a repeated failure, last is when it was logged last.
+type errorLogEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+// errorLogLimiter logs the repeated failures of an operation on a service once, then once per
+// WithErrorLogInterval with the number of the failures not logged since. The zero value is ready to use.
+type errorLogLimiter struct {
+	lock    sync.Mutex
+	entries map[errorLogKey]*errorLogEntry
+	now     func() time.Time // time.Now, replaced in tests
+}
+
+// allow tells whether a failure of op on service is logged, suppressed is the number of the failures
+// not logged since the last one that was. A negative interval logs every failure.
+func (l *errorLogLimiter) allow(op string, service model.ServiceKey, interval time.Duration) (ok bool, suppressed int) {
+	if interval < 0 {
+		return true, 0
+	}
+	if interval == 0 {
+		interval = defaultErrorLogInterval
+	}
+	now := time.Now
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.now != nil {
+		now = l.now
+	}
+	key := errorLogKey{op: op, service: service}
+	entry, found := l.entries[key]
+	if !found {
+		if l.entries == nil {
+			l.entries = make(map[errorLogKey]*errorLogEntry)
+		}
+		l.entries[key] = &errorLogEntry{last: now()}
+		return true, 0
+	}
+	if t := now(); t.Sub(entry.last) >= interval {
+		suppressed = entry.suppressed
+		entry.last, entry.suppressed = t, 0
+		return true, suppressed
+	}
+	entry.suppressed++
+	return false, 0
+}
+
+// forget drops the state of the failures of service, like when it is not resolved anymore.
+func (l *errorLogLimiter) forget(service model.ServiceKey) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for key := range l.entries {
+		if key.service == service {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// len returns how many failures are tracked.
+func (l *errorLogLimiter) len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.entries)
+}
+
+// suppressedSuffix describes the failures not logged before a summary line.
+func suppressedSuffix(suppressed int) string {
+	if suppressed == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d more since the last log)", suppressed)
+}
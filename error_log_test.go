@@ -0,0 +1,207 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// manualClock is a clock moved by the tests.
+type manualClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	c.lock.Unlock()
+}
+
+// errorLogger records the error lines of the polaris logger.
+type errorLogger struct {
+	log.Logger
+	lock   sync.Mutex
+	errors []string
+}
+
+func (l *errorLogger) Errorf(format string, args ...interface{}) {
+	l.lock.Lock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+	l.lock.Unlock()
+}
+
+func (l *errorLogger) lines(prefix string) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var lines []string
+	for _, line := range l.errors {
+		if strings.HasPrefix(line, prefix) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func captureErrors(t *testing.T) *errorLogger {
+	previous := log.GetBaseLogger()
+	logger := &errorLogger{Logger: previous}
+	log.SetBaseLogger(logger)
+	t.Cleanup(func() { log.SetBaseLogger(previous) })
+	return logger
+}
+
+func TestErrorLogLimiter(t *testing.T) {
+	clock := &manualClock{now: time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC)}
+	limiter := &errorLogLimiter{now: clock.Now}
+	service := model.ServiceKey{Namespace: polarisDefaultNamespace, Service: serviceName}
+	allow := func() (bool, int) { return limiter.allow(errorLogResolve, service, 10*time.Second) }
+
+	ok, suppressed := allow()
+	require.True(t, ok)
+	require.Equal(t, 0, suppressed)
+	for i := 0; i < 3; i++ {
+		clock.advance(time.Second)
+		ok, _ = allow()
+		require.False(t, ok)
+	}
+	// the other operations and services are limited on their own.
+	ok, _ = limiter.allow(errorLogWatch, service, 10*time.Second)
+	require.True(t, ok)
+	ok, _ = limiter.allow(errorLogResolve, model.ServiceKey{Namespace: polarisDefaultNamespace, Service: "other"}, 10*time.Second)
+	require.True(t, ok)
+
+	clock.advance(7 * time.Second)
+	ok, suppressed = allow()
+	require.True(t, ok)
+	require.Equal(t, 3, suppressed)
+	ok, _ = allow()
+	require.False(t, ok)
+
+	limiter.forget(service)
+	require.Equal(t, 1, limiter.len())
+	ok, suppressed = allow()
+	require.True(t, ok)
+	require.Equal(t, 0, suppressed)
+
+	for i := 0; i < 3; i++ {
+		ok, _ = limiter.allow(errorLogResolve, service, -1)
+		require.True(t, ok)
+	}
+}
+
+func TestResolveErrorLogs(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetFailureRate(polaristest.OpGetInstances, 1)
+	backend.SetFailureRate(polaristest.OpWatchService, 1)
+	logger := &structuredLogger{}
+	rs := newTestResolver(backend, WithStructuredLogger(logger), WithErrorLogInterval(time.Minute))
+	clock := &manualClock{now: time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC)}
+	rs.errorLogs.now = clock.Now
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	records := func(msg string) []structuredRecord {
+		logger.lock.Lock()
+		defer logger.lock.Unlock()
+		var records []structuredRecord
+		for _, record := range logger.records {
+			if record.msg == msg {
+				records = append(records, record)
+			}
+		}
+		return records
+	}
+	for i := 0; i < 5; i++ {
+		_, err := rs.Resolve(context.Background(), desc)
+		require.NotNil(t, err)
+		_, err = rs.Watcher(context.Background(), desc)
+		require.NotNil(t, err)
+		clock.advance(10 * time.Second)
+	}
+	for _, msg := range []string{"polaris resolve failed", "polaris watch failed"} {
+		require.Len(t, records(msg), 1, msg)
+		require.Equal(t, "error", records(msg)[0].level)
+		require.Equal(t, 0, records(msg)[0].fields["suppressed"])
+	}
+
+	clock.advance(10 * time.Second)
+	_, err := rs.Resolve(context.Background(), desc)
+	require.NotNil(t, err)
+	_, err = rs.Watcher(context.Background(), desc)
+	require.NotNil(t, err)
+	for _, msg := range []string{"polaris resolve failed", "polaris watch failed"} {
+		require.Len(t, records(msg), 2, msg)
+		require.Equal(t, 4, records(msg)[1].fields["suppressed"])
+	}
+}
+
+func TestResolveErrorLogsEvicted(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetFailureRate(polaristest.OpGetInstances, 1)
+	rs := newTestResolver(backend, WithMaxTrackedServices(1))
+
+	_, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.NotNil(t, err)
+	require.Equal(t, 1, rs.errorLogs.len())
+	_, err = rs.Resolve(context.Background(), polarisDefaultNamespace+":other")
+	require.NotNil(t, err)
+	require.Equal(t, uint64(1), rs.EvictedServices())
+	require.Equal(t, 1, rs.errorLogs.len())
+	rs.errorLogs.forget(model.ServiceKey{Namespace: polarisDefaultNamespace, Service: "other"})
+	require.Equal(t, 0, rs.errorLogs.len())
+}
+
+func TestHeartbeatErrorLogs(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithErrorLogInterval(30*time.Second))
+	rg.provider = &failingHeartbeatProvider{Backend: backend, failing: 1}
+	clock := &manualClock{now: time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC)}
+	rg.errorLogs.now = clock.Now
+	logger := captureErrors(t)
+
+	heartbeat := &api.InstanceHeartbeatRequest{}
+	heartbeat.Namespace = polarisDefaultNamespace
+	heartbeat.Service = serviceName
+	heartbeat.InstanceID = "instance-1"
+	failures := 0
+	for i := 0; i < 6; i++ {
+		failures = rg.beat(heartbeat, failures)
+		clock.advance(10 * time.Second)
+	}
+	lines := logger.lines("[Polaris registry] heartbeat of instance-1 failed:")
+	require.Equal(t, []string{
+		"[Polaris registry] heartbeat of instance-1 failed: polaris unreachable",
+		"[Polaris registry] heartbeat of instance-1 failed: polaris unreachable (2 more since the last log)",
+	}, lines)
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrServiceMetadataUnsupported is returned for the service metadata of a Resolver which is not a
+// ServiceMetadataResolver.
+var ErrServiceMetadataUnsupported = errors.New("resolver does not read the service metadata")
+
+// ErrExtensionUnsupported is returned by the lazy and multi-cluster resolvers for an extension interface,
+// like ListenerResolver, the Resolver they wrap does not implement.
+var ErrExtensionUnsupported = errors.New("resolver does not implement the extension")
+
+// ErrResolverClosed is returned by the Watcher and Subscribe calls of a closed resolver.
+var ErrResolverClosed = errors.New("polaris resolver closed")
+
+// ErrInvalidTarget is returned by the resolver calls on the description of a target without service name
+// or with an invalid namespace, the message tells why.
+var ErrInvalidTarget = errors.New("invalid polaris target")
+
+// ErrNamespaceNotFound is matched with errors.Is by the errors of the calls on a namespace polaris does not know.
+var ErrNamespaceNotFound = errors.New("polaris namespace not found")
+
+// ErrServiceNotFound is matched with errors.Is by the errors of the calls on a service polaris does not know.
+var ErrServiceNotFound = errors.New("polaris service not found")
+
+// ErrInstanceNotFound is matched with errors.Is by the errors of DeregisterInstance on an instance polaris does not know.
+var ErrInstanceNotFound = errors.New("polaris instance not found")
+
+// ErrDescriptionMismatch is matched with errors.Is by the DescriptionError of a description that does not
+// encode back to itself, see WithStrictDescriptions.
+var ErrDescriptionMismatch = errors.New("description does not round-trip through the codec")
+
+// NotFoundError is returned by Resolve and Register when polaris does not know the namespace or the service,
+// it matches ErrNamespaceNotFound or ErrServiceNotFound with errors.Is and unwraps to the polaris error.
+// A service that exists without instances gives a NoInstanceError instead.
+type NotFoundError struct {
+	Namespace string
+	Service   string
+	// Instance is the host:port of the instance for ErrInstanceNotFound.
+	Instance string
+	// Kind is ErrNamespaceNotFound, ErrServiceNotFound or ErrInstanceNotFound.
+	Kind error
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *NotFoundError) Error() string {
+	switch e.Kind {
+	case ErrNamespaceNotFound:
+		return fmt.Sprintf("namespace %s of service %s not found: %v", e.Namespace, e.Service, e.Err)
+	case ErrInstanceNotFound:
+		return fmt.Sprintf("instance %s of service %s:%s not found: %v", e.Instance, e.Namespace, e.Service, e.Err)
+	}
+	return fmt.Sprintf("service %s:%s not found: %v", e.Namespace, e.Service, e.Err)
+}
+
+// Is tells whether target is the Kind of the error.
+func (e *NotFoundError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the polaris error.
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// UnauthorizedError is returned by DeregisterInstance when polaris rejects the service token.
+type UnauthorizedError struct {
+	Namespace string
+	Service   string
+	Err       error
+}
+
+// Error implements the error interface.
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("unauthorized on service %s:%s, check the service token: %v", e.Namespace, e.Service, e.Err)
+}
+
+// Unwrap returns the polaris error.
+func (e *UnauthorizedError) Unwrap() error {
+	return e.Err
+}
+
+// NoInstanceError is returned by Resolve when no instance remains for a service.
+// TotalFromPolaris tells whether polaris returned nothing at all (a registration problem)
+// or the client side filters removed everything.
+type NoInstanceError struct {
+	Namespace        string
+	Service          string
+	TotalFromPolaris int
+	AfterFilter      int
+	Filters          []string
+}
+
+// Error implements the error interface.
+func (e *NoInstanceError) Error() string {
+	if e.TotalFromPolaris == 0 {
+		return fmt.Sprintf("no instance remains for %s:%s, polaris returned no instance", e.Namespace, e.Service)
+	}
+	return fmt.Sprintf("no instance remains for %s:%s, %d instances from polaris, %d after filters [%s]",
+		e.Namespace, e.Service, e.TotalFromPolaris, e.AfterFilter, strings.Join(e.Filters, ","))
+}
+
+// ZeroWeightResultError is returned by Resolve under ZeroWeightError when every instance of a service has
+// a zero weight.
+type ZeroWeightResultError struct {
+	Namespace string
+	Service   string
+	Instances int
+}
+
+// Error implements the error interface.
+func (e *ZeroWeightResultError) Error() string {
+	return fmt.Sprintf("the %d instances of %s:%s all have a zero weight", e.Instances, e.Namespace, e.Service)
+}
+
+// ResolveContextError is returned by Resolve when its context is done before polaris answered,
+// it unwraps to the context error.
+type ResolveContextError struct {
+	Namespace string
+	Service   string
+	Err       error
+}
+
+// Error implements the error interface.
+func (e *ResolveContextError) Error() string {
+	return fmt.Sprintf("resolve %s:%s interrupted: %v", e.Namespace, e.Service, e.Err)
+}
+
+// Unwrap returns the context error.
+func (e *ResolveContextError) Unwrap() error {
+	return e.Err
+}
+
+// DeregisterTimeoutError is returned by Deregister when polaris did not answer within the
+// WithDeregisterTimeout budget, the heartbeats of the instance are stopped anyway.
+type DeregisterTimeoutError struct {
+	Namespace string
+	Service   string
+	Host      string
+	Port      int
+	Timeout   time.Duration
+}
+
+// Error implements the error interface.
+func (e *DeregisterTimeoutError) Error() string {
+	return fmt.Sprintf("deregister %s:%s %s:%d timed out after %v", e.Namespace, e.Service, e.Host, e.Port, e.Timeout)
+}
+
+// RegistrationNotVisibleError is returned by VerifyRegistration when the registered instance could not
+// be found by the consumer API in time, often because of a wrong namespace or token.
+type RegistrationNotVisibleError struct {
+	Namespace  string
+	Service    string
+	InstanceID string
+	Timeout    time.Duration
+	Err        error
+}
+
+// Error implements the error interface.
+func (e *RegistrationNotVisibleError) Error() string {
+	msg := fmt.Sprintf("instance %s of %s:%s not visible after %v", e.InstanceID, e.Namespace, e.Service, e.Timeout)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the last error of the consumer API or the context error.
+func (e *RegistrationNotVisibleError) Unwrap() error {
+	return e.Err
+}
+
+// DescriptionError is returned when the DescriptionCodec cannot decode a description.
+type DescriptionError struct {
+	Description string
+	Err         error
+}
+
+// Error implements the error interface.
+func (e *DescriptionError) Error() string {
+	return fmt.Sprintf("decode description %q: %v", e.Description, e.Err)
+}
+
+// Unwrap returns the error of the codec.
+func (e *DescriptionError) Unwrap() error {
+	return e.Err
+}
+
+// WatchTimeoutError is returned by Watcher and Subscribe when the subscription of a service could not
+// be created within WithWatchTimeout, the subscription keeps being retried in the background.
+type WatchTimeoutError struct {
+	Namespace string
+	Service   string
+	Timeout   time.Duration
+}
+
+// Error implements the error interface.
+func (e *WatchTimeoutError) Error() string {
+	return fmt.Sprintf("watch %s:%s timed out after %v", e.Namespace, e.Service, e.Timeout)
+}
+
+// DeregisterAllError is returned by DeregisterAllMatching when some matching instances were not deregistered,
+// Failed holds the error by instance ID, the context error for the instances never tried.
+type DeregisterAllError struct {
+	Namespace    string
+	Service      string
+	Deregistered int
+	Failed       map[string]error
+}
+
+// Error implements the error interface.
+func (e *DeregisterAllError) Error() string {
+	ids := make([]string, 0, len(e.Failed))
+	for id := range e.Failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	failures := make([]string, 0, len(ids))
+	for _, id := range ids {
+		failures = append(failures, id+": "+e.Failed[id].Error())
+	}
+	return fmt.Sprintf("deregister instances of %s:%s: %d deregistered, %d failed [%s]",
+		e.Namespace, e.Service, e.Deregistered, len(e.Failed), strings.Join(failures, "; "))
+}
+
+// NamespaceCreateError is returned by Register when WithAutoCreateNamespace could not create the missing
+// namespace, RegisterErr is the error of the registration and CreateErr the one of the creation.
+type NamespaceCreateError struct {
+	Namespace   string
+	RegisterErr error
+	CreateErr   error
+}
+
+// Error implements the error interface.
+func (e *NamespaceCreateError) Error() string {
+	return fmt.Sprintf("register in missing namespace %s: %v, create the namespace: %v",
+		e.Namespace, e.RegisterErr, e.CreateErr)
+}
+
+// Unwrap returns the registration error.
+func (e *NamespaceCreateError) Unwrap() error {
+	return e.RegisterErr
+}
+
+// BatchError is returned by RegisterBatch and DeregisterBatch when some infos failed, Op is register
+// or deregister and Result holds the error of every info.
+type BatchError struct {
+	Op     string
+	Result BatchResult
+}
+
+// Error implements the error interface.
+func (e *BatchError) Error() string {
+	failures := make([]string, 0, len(e.Result.Errors)-e.Result.Succeeded)
+	for i, err := range e.Result.Errors {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%d: %v", i, err))
+		}
+	}
+	return fmt.Sprintf("%s batch: %d succeeded, %d failed [%s]",
+		e.Op, e.Result.Succeeded, len(failures), strings.Join(failures, "; "))
+}
+
+// SnapshotVersionError is returned when loading a fallback snapshot of a version no SnapshotCodec decodes,
+// like a snapshot written by a newer release. Resolve then falls through to the static fallback.
+type SnapshotVersionError struct {
+	Path    string
+	Version int
+}
+
+// Error implements the error interface.
+func (e *SnapshotVersionError) Error() string {
+	return fmt.Sprintf("snapshot %s has the unknown version %d", e.Path, e.Version)
+}
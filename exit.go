@@ -0,0 +1,130 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// exitDeregisterTimeout bounds the deregistration of an exiting process.
+const exitDeregisterTimeout = 3 * time.Second
+
+// ExitGuard deregisters an instance when the process exits outside of the graceful shutdown of the Kitex
+// server, so that it does not receive failing traffic until polaris expires its TTL, see DeregisterOnExit.
+type ExitGuard struct {
+	reg     registry.Registry
+	info    *registry.Info
+	timeout time.Duration
+	once    sync.Once
+	signals chan os.Signal
+	done    chan struct{}
+	stop    sync.Once
+	raise   func(sig os.Signal) // sends sig to the process again, replaced in tests
+}
+
+// DeregisterOnExit installs a SIGTERM and SIGINT handler deregistering info from reg. The signal is
+// sent to the process again once the instance is deregistered, so that the other handlers, like the
+// graceful shutdown of the Kitex server, and the default termination still apply. Panics are covered by
+//
+//	defer guard.HandlePanicAndDeregister()
+//
+// at the top of the goroutines of the service.
+func DeregisterOnExit(reg registry.Registry, info *registry.Info) *ExitGuard {
+	g := newExitGuard(reg, info)
+	signal.Notify(g.signals, syscall.SIGTERM, syscall.SIGINT)
+	go g.run()
+	return g
+}
+
+func newExitGuard(reg registry.Registry, info *registry.Info) *ExitGuard {
+	return &ExitGuard{
+		reg:     reg,
+		info:    info,
+		timeout: exitDeregisterTimeout,
+		signals: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+		raise:   raiseSignal,
+	}
+}
+
+func (g *ExitGuard) run() {
+	select {
+	case <-g.done:
+	case sig := <-g.signals:
+		log.GetBaseLogger().Infof("[Polaris registry] %v received, deregistering %s", sig, g.info.ServiceName)
+		g.Deregister()
+		g.Stop()
+		g.raise(sig)
+	}
+}
+
+func raiseSignal(sig os.Signal) {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(sig)
+	}
+}
+
+// HandlePanicAndDeregister deregisters the instance when the goroutine panics and panics again with the
+// same value, it must be deferred directly. The stack of the panic is logged since the new panic loses it.
+func (g *ExitGuard) HandlePanicAndDeregister() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.GetBaseLogger().Errorf("[Polaris registry] panic, deregistering %s: %v\n%s", g.info.ServiceName, r, debug.Stack())
+	g.Deregister()
+	panic(r)
+}
+
+// Deregister deregisters the instance once, waiting at most a few seconds for polaris. Later calls, like the
+// one of the graceful shutdown after a signal, do nothing; a Deregister of the registry itself beforehand
+// only makes the one of the guard fail, which is logged.
+func (g *ExitGuard) Deregister() {
+	g.once.Do(func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- g.reg.Deregister(g.info)
+		}()
+		timer := time.NewTimer(g.timeout)
+		defer timer.Stop()
+		select {
+		case err := <-done:
+			if err != nil {
+				log.GetBaseLogger().Warnf("[Polaris registry] deregister %s on exit: %v", g.info.ServiceName, err)
+			}
+		case <-timer.C:
+			log.GetBaseLogger().Warnf("[Polaris registry] deregister %s on exit timed out after %v",
+				g.info.ServiceName, g.timeout)
+		}
+	})
+}
+
+// Stop removes the signal handler, the deferred HandlePanicAndDeregister calls keep working.
+func (g *ExitGuard) Stop() {
+	g.stop.Do(func() {
+		signal.Stop(g.signals)
+		close(g.done)
+	})
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRegistry counts the deregistrations, deregister blocks them when set.
+type countingRegistry struct {
+	lock         sync.Mutex
+	deregistered int
+	deregister   chan struct{}
+}
+
+func (r *countingRegistry) Register(info *registry.Info) error {
+	return nil
+}
+
+func (r *countingRegistry) Deregister(info *registry.Info) error {
+	if r.deregister != nil {
+		<-r.deregister
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.deregistered++
+	return nil
+}
+
+func (r *countingRegistry) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.deregistered
+}
+
+func TestHandlePanicAndDeregister(t *testing.T) {
+	reg := &countingRegistry{}
+	g := newExitGuard(reg, newTestInfo("127.0.0.1:6666", nil))
+
+	recovered := func() (r interface{}) {
+		defer func() { r = recover() }()
+		defer g.HandlePanicAndDeregister()
+		panic("boom")
+	}()
+	require.Equal(t, "boom", recovered)
+	require.Equal(t, 1, reg.count())
+
+	// no panic, no deregistration.
+	func() {
+		defer g.HandlePanicAndDeregister()
+	}()
+	// the guard deregisters once.
+	recovered = func() (r interface{}) {
+		defer func() { r = recover() }()
+		defer g.HandlePanicAndDeregister()
+		panic("again")
+	}()
+	require.Equal(t, "again", recovered)
+	g.Deregister()
+	require.Equal(t, 1, reg.count())
+}
+
+func TestExitGuardDeregisterTimeout(t *testing.T) {
+	reg := &countingRegistry{deregister: make(chan struct{})}
+	defer close(reg.deregister)
+	g := newExitGuard(reg, newTestInfo("127.0.0.1:6666", nil))
+	g.timeout = 10 * time.Millisecond
+	start := time.Now()
+	g.Deregister()
+	require.True(t, time.Since(start) < time.Second)
+	g.Deregister()
+}
+
+func TestExitGuardSignal(t *testing.T) {
+	reg := &countingRegistry{}
+	g := newExitGuard(reg, newTestInfo("127.0.0.1:6666", nil))
+	raised := make(chan os.Signal, 1)
+	g.raise = func(sig os.Signal) { raised <- sig }
+	go g.run()
+
+	g.signals <- syscall.SIGTERM
+	select {
+	case sig := <-raised:
+		require.Equal(t, syscall.SIGTERM, sig)
+	case <-time.After(time.Second):
+		t.Fatal("signal not raised again")
+	}
+	require.Equal(t, 1, reg.count())
+	// the graceful shutdown deregistering afterwards is fine.
+	g.Deregister()
+	g.Stop()
+	require.Equal(t, 1, reg.count())
+}
+
+func TestDeregisterOnExitStop(t *testing.T) {
+	reg := &countingRegistry{}
+	g := DeregisterOnExit(reg, newTestInfo("127.0.0.1:6666", nil))
+	g.Stop()
+	g.Stop()
+	require.Zero(t, reg.count())
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEmptyProtectionUnderFaults shows what a client sees while polaris degrades: failed lookups
+// are served by the static fallback list and a stale server keeps returning removed instances.
+func TestEmptyProtectionUnderFaults(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	desc := polarisDefaultNamespace + ":" + serviceName
+	rs := newTestResolver(backend, WithStaticFallback(desc, []string{"10.0.0.1:8888"}))
+
+	backend.SetFailureRate(polaristest.OpGetInstances, 1)
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.False(t, result.Cacheable)
+	require.Equal(t, "10.0.0.1:8888", result.Instances[0].Address().String())
+	require.Equal(t, uint64(1), rs.StaticFallbacks())
+
+	backend.SetFailureRate(polaristest.OpGetInstances, 0)
+	backend.SetStale(true)
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, backend.Instances(polarisDefaultNamespace, serviceName)[0].ID)
+	result, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.True(t, result.Cacheable)
+	require.Equal(t, "127.0.0.1:6666", result.Instances[0].Address().String())
+
+	backend.SetStale(false)
+	result, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.False(t, result.Cacheable)
+	require.Equal(t, uint64(2), rs.StaticFallbacks())
+}
+
+func TestResolveTimeoutUnderLatency(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	rs := newTestResolver(backend, WithResolveTimeout(10*time.Millisecond))
+
+	backend.SetLatency(polaristest.OpGetInstances, 100*time.Millisecond)
+	_, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	_, ok := err.(*ResolveContextError)
+	require.True(t, ok, "%v", err)
+}
+
+// TestHeartbeatReregisterUnderBlackhole shows an application registering again once its heartbeats
+// are lost, the new registration heartbeats when the server answers again.
+func TestHeartbeatReregisterUnderBlackhole(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetHeartbeatBlackhole(true)
+	lost := make(chan error, 1)
+	rg := newTestRegistry(backend, WithHeartbeatFailureBudget(3), WithOnHeartbeatLost(func(err error) {
+		select {
+		case lost <- err:
+		default:
+		}
+	}))
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	select {
+	case err := <-lost:
+		require.NotNil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("heartbeats not lost")
+	}
+	require.True(t, backend.Calls(polaristest.OpHeartbeat) >= 3)
+	require.Empty(t, backend.Heartbeats())
+
+	backend.SetHeartbeatBlackhole(false)
+	require.Nil(t, rg.Register(info))
+	require.Equal(t, 2, backend.Calls(polaristest.OpRegister))
+	require.Eventually(t, func() bool { return len(backend.Heartbeats()) > 0 }, time.Second, 5*time.Millisecond)
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 1)
+}
+
+// TestWatchRecoversFromClosedEventChannel shows Watcher and the Subscribe listeners getting the Changes
+// of a service again once its event channel is closed, the resolver subscribes it again.
+func TestWatchRecoversFromClosedEventChannel(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	rs := newTestResolver(backend)
+	defer rs.Close()
+	desc := polarisDefaultNamespace + ":" + serviceName
+	requireInitialWatch(t, rs, desc, 1)
+	changes := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	<-changes
+
+	backend.CloseEventChannel(polarisDefaultNamespace, serviceName)
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpWatchService) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	watched := make(chan discovery.Change, 1)
+	go func() {
+		change, err := rs.Watcher(context.TODO(), desc)
+		require.Nil(t, err)
+		watched <- change
+	}()
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpWatchService) == 3
+	}, time.Second, time.Millisecond)
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777})
+	for _, ch := range []chan discovery.Change{changes, watched} {
+		select {
+		case change := <-ch:
+			require.Len(t, change.Added, 1)
+			require.Equal(t, "127.0.0.1:7777", change.Added[0].Address().String())
+		case <-time.After(time.Second):
+			t.Fatal("change not delivered after the event channel was closed")
+		}
+	}
+}
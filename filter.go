@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
+
+// instanceFilter narrows the converted instances of a service on the client side.
+type instanceFilter struct {
+	name   string
+	filter func(ctx context.Context, instances []discovery.Instance) []discovery.Instance
+}
+
+// applyFilters runs the filters in order and returns the remaining instances with the names of the applied filters.
+// The counts of every filter are appended to trace when it is not nil.
+func applyFilters(ctx context.Context, filters []instanceFilter, instances []discovery.Instance,
+	trace *RouteTrace) ([]discovery.Instance, []string) {
+	applied := make([]string, 0, len(filters))
+	for _, f := range filters {
+		if len(instances) == 0 {
+			break
+		}
+		before := len(instances)
+		instances = f.filter(ctx, instances)
+		applied = append(applied, f.name)
+		if trace != nil {
+			trace.Stages = append(trace.Stages, RouteStage{Name: f.name, Before: before, After: len(instances)})
+		}
+	}
+	return instances, applied
+}
+
+// newTagFilter keeps the instances whose metadata carries the value of a target tag.
+func newTagFilter(tag TargetTag) instanceFilter {
+	return instanceFilter{
+		name: tag.Key + "=" + tag.Value,
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			matched := make([]discovery.Instance, 0, len(instances))
+			for _, ins := range instances {
+				if value, ok := ins.Tag(tag.Key); ok && value == tag.Value {
+					matched = append(matched, ins)
+				}
+			}
+			return matched
+		},
+	}
+}
+
+// filterChange runs a Change of Watcher or of the listeners through the filters of resolve. The Result,
+// the instances before the change, is filtered like the instances of resolve. The added and updated
+// instances are kept when the filters keep them among the instances after the change and the removed
+// ones when the filters keep them in the Result, so that the fallbacks of the filters, like the ones of
+// the shards or of the sets, decide of the deltas as well.
+func filterChange(ctx context.Context, filters []instanceFilter, change discovery.Change) discovery.Change {
+	if len(filters) == 0 {
+		return change
+	}
+	changed := make(map[string]struct{}, len(change.Added)+len(change.Removed))
+	for _, ins := range change.Added {
+		changed[ins.Address().String()] = struct{}{}
+	}
+	for _, ins := range change.Removed {
+		changed[ins.Address().String()] = struct{}{}
+	}
+	after := make([]discovery.Instance, 0, len(change.Result.Instances)+len(change.Added))
+	for _, ins := range change.Result.Instances {
+		if _, ok := changed[ins.Address().String()]; !ok {
+			after = append(after, ins)
+		}
+	}
+	after, _ = applyFilters(ctx, filters, append(after, change.Added...), nil)
+	change.Result.Instances, _ = applyFilters(ctx, filters, change.Result.Instances, nil)
+	change.Added = keepFiltered(change.Added, after)
+	change.Updated = keepFiltered(change.Updated, after)
+	change.Removed = keepFiltered(change.Removed, change.Result.Instances)
+	return change
+}
+
+// keepFiltered returns the instances of deltas whose address is among the filtered instances.
+func keepFiltered(deltas, filtered []discovery.Instance) []discovery.Instance {
+	if len(deltas) == 0 {
+		return deltas
+	}
+	addrs := make(map[string]struct{}, len(filtered))
+	for _, ins := range filtered {
+		addrs[ins.Address().String()] = struct{}{}
+	}
+	var kept []discovery.Instance
+	for _, ins := range deltas {
+		if _, ok := addrs[ins.Address().String()]; ok {
+			kept = append(kept, ins)
+		}
+	}
+	return kept
+}
+
+// filterResult runs the instances of a Result through the filters of resolve, for the Results compared
+// by a Diff.
+func filterResult(ctx context.Context, filters []instanceFilter, result discovery.Result) discovery.Result {
+	result.Instances, _ = applyFilters(ctx, filters, result.Instances, nil)
+	return result
+}
@@ -3,6 +3,7 @@ module github.com/kitex-contrib/registry-polaris
 go 1.16
 
 require (
+	github.com/bytedance/gopkg v0.0.0-20210910103821-e4efae9c17c3
 	github.com/cloudwego/kitex v0.1.3
 	github.com/cloudwego/kitex-examples v0.0.0-20211103034154-ddf5b924924e
 	github.com/pkg/errors v0.9.1
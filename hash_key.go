@@ -0,0 +1,125 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"net"
+
+	"github.com/cloudwego/kitex/client"
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/loadbalance"
+)
+
+// TagHashKey carries the polaris instance ID, a key that survives IP changes of the instance.
+const TagHashKey = "hash_key"
+
+// NewConsistentHashOption returns the client option of a Kitex consistent hash balancer whose ring
+// is built on the TagHashKey of the instances instead of their addresses.
+func NewConsistentHashOption(getKey loadbalance.KeyFunc) client.Option {
+	return client.WithLoadBalancer(NewConsistentHashBalancer(loadbalance.NewConsistentHashOption(getKey)))
+}
+
+// NewConsistentHashBalancer wraps the Kitex consistent hash balancer so that its ring is built on the
+// TagHashKey of the instances, instances without the tag keep using their address.
+func NewConsistentHashBalancer(opt loadbalance.ConsistentHashOption) loadbalance.Loadbalancer {
+	return &hashKeyBalancer{balancer: loadbalance.NewConsistBalancer(opt)}
+}
+
+// hashKeyAddr presents the hash key as the address seen by the consistent hash balancer.
+type hashKeyAddr struct {
+	network, key string
+}
+
+func (a hashKeyAddr) Network() string { return a.network }
+func (a hashKeyAddr) String() string  { return a.key }
+
+// hashKeyInstance is the view of an instance given to the wrapped balancer.
+type hashKeyInstance struct {
+	discovery.Instance
+	addr net.Addr
+}
+
+// Address implements the discovery.Instance interface.
+func (i *hashKeyInstance) Address() net.Addr {
+	return i.addr
+}
+
+type hashKeyBalancer struct {
+	balancer loadbalance.Loadbalancer
+}
+
+// GetPicker implements the loadbalance.Loadbalancer interface.
+func (b *hashKeyBalancer) GetPicker(e discovery.Result) loadbalance.Picker {
+	picker := b.balancer.GetPicker(wrapHashKeyResult(e))
+	return &loadbalance.SynthesizedPicker{NextFunc: func(ctx context.Context, request interface{}) discovery.Instance {
+		ins := picker.Next(ctx, request)
+		if wrapped, ok := ins.(*hashKeyInstance); ok {
+			return wrapped.Instance
+		}
+		return ins
+	}}
+}
+
+// Rebalance implements the loadbalance.Rebalancer interface.
+func (b *hashKeyBalancer) Rebalance(change discovery.Change) {
+	if rebalancer, ok := b.balancer.(loadbalance.Rebalancer); ok {
+		rebalancer.Rebalance(wrapHashKeyChange(change))
+	}
+}
+
+// Delete implements the loadbalance.Rebalancer interface.
+func (b *hashKeyBalancer) Delete(change discovery.Change) {
+	if rebalancer, ok := b.balancer.(loadbalance.Rebalancer); ok {
+		rebalancer.Delete(wrapHashKeyChange(change))
+	}
+}
+
+// Name implements the loadbalance.Loadbalancer interface.
+func (b *hashKeyBalancer) Name() string {
+	return "polaris_hash_key_" + b.balancer.Name()
+}
+
+func wrapHashKeyChange(change discovery.Change) discovery.Change {
+	return discovery.Change{
+		Result:  wrapHashKeyResult(change.Result),
+		Added:   wrapHashKeyInstances(change.Added),
+		Updated: wrapHashKeyInstances(change.Updated),
+		Removed: wrapHashKeyInstances(change.Removed),
+	}
+}
+
+func wrapHashKeyResult(e discovery.Result) discovery.Result {
+	e.Instances = wrapHashKeyInstances(e.Instances)
+	return e
+}
+
+func wrapHashKeyInstances(instances []discovery.Instance) []discovery.Instance {
+	if instances == nil {
+		return nil
+	}
+	wrapped := make([]discovery.Instance, len(instances))
+	for i, ins := range instances {
+		key, ok := ins.Tag(TagHashKey)
+		if !ok || key == "" {
+			wrapped[i] = ins
+			continue
+		}
+		wrapped[i] = &hashKeyInstance{Instance: ins, addr: hashKeyAddr{network: ins.Address().Network(), key: key}}
+	}
+	return wrapped
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/loadbalance"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newHashKeyResult(cacheKey string, hosts map[string]string) discovery.Result {
+	result := discovery.Result{Cacheable: true, CacheKey: cacheKey}
+	for id, host := range hosts {
+		result.Instances = append(result.Instances, ConvertInstance(&polaristest.Instance{
+			ID: id, Namespace: polarisDefaultNamespace, Service: serviceName, Host: host, Port: 6666, Protocol: "tcp",
+		}))
+	}
+	return result
+}
+
+func TestHashKeyTag(t *testing.T) {
+	ins := ConvertInstance(&polaristest.Instance{ID: "ins-1", Host: "127.0.0.1", Port: 6666})
+	key, ok := ins.Tag(TagHashKey)
+	require.True(t, ok)
+	require.Equal(t, "ins-1", key)
+}
+
+func TestConsistentHashStableAcrossIPChange(t *testing.T) {
+	getKey := func(ctx context.Context, request interface{}) string {
+		return request.(string)
+	}
+	balancer := NewConsistentHashBalancer(loadbalance.NewConsistentHashOption(getKey))
+	before := newHashKeyResult("before", map[string]string{
+		"ins-a": "10.0.0.1", "ins-b": "10.0.0.2", "ins-c": "10.0.0.3",
+	})
+	// ins-a moved to another pod IP.
+	after := newHashKeyResult("after", map[string]string{
+		"ins-a": "10.0.0.9", "ins-b": "10.0.0.2", "ins-c": "10.0.0.3",
+	})
+
+	for i := 0; i < 1000; i++ {
+		request := "key-" + strconv.Itoa(i)
+		// pickers serve a single call.
+		picked := balancer.GetPicker(before).Next(context.TODO(), request)
+		moved := balancer.GetPicker(after).Next(context.TODO(), request)
+		pickedID, _ := picked.Tag(TagHashKey)
+		movedID, _ := moved.Tag(TagHashKey)
+		require.Equal(t, pickedID, movedID, request)
+		if pickedID == "ins-a" {
+			require.Equal(t, "10.0.0.9:6666", moved.Address().String(), "the real address is dialed")
+		}
+	}
+}
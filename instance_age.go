@@ -0,0 +1,89 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/model/pb"
+)
+
+const (
+	// TagRevision is the tag carrying the polaris revision of an instance, it changes with every update.
+	TagRevision = "revision"
+	// TagCreateTime is the tag carrying the time polaris created the instance, in polarisTimeLayout.
+	TagCreateTime = "create_time"
+	// TagModifyTime is the tag carrying the time polaris last modified the instance, in polarisTimeLayout.
+	TagModifyTime = "modify_time"
+
+	// polarisTimeLayout is the layout of the instance times of the polaris server, in its local time.
+	polarisTimeLayout = "2006-01-02 15:04:05"
+)
+
+// instanceTimes returns the create and modify times of an instance when the SDK exposes them. The instances
+// of the test backend expose them with the same methods returning strings.
+func instanceTimes(instance Instance) (created, modified string) {
+	switch ins := instance.(type) {
+	case *pb.InstanceInProto:
+		return ins.GetCtime().GetValue(), ins.GetMtime().GetValue()
+	case interface {
+		GetCtime() string
+		GetMtime() string
+	}:
+		return ins.GetCtime(), ins.GetMtime()
+	}
+	return "", ""
+}
+
+// instanceCreatedAt returns when an instance was registered, from TagCreateTime or else the MetadataStartTime
+// registered with WithAutoMetadata.
+func instanceCreatedAt(ins discovery.Instance) (time.Time, bool) {
+	if created, ok := ins.Tag(TagCreateTime); ok && created != "" {
+		if at, err := time.ParseInLocation(polarisTimeLayout, created, time.Local); err == nil {
+			return at, true
+		}
+	}
+	if started, ok := ins.Tag(MetadataStartTime); ok && started != "" {
+		if at, err := time.Parse(time.RFC3339, started); err == nil {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// newMinAgeFilter drops the instances registered less than age ago, unless no instance would remain.
+// The instances of unknown age are kept.
+func newMinAgeFilter(age time.Duration, now func() time.Time) instanceFilter {
+	return instanceFilter{
+		name: "min age(" + age.String() + ")",
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			oldest := now().Add(-age)
+			var kept []discovery.Instance
+			for _, ins := range instances {
+				if at, ok := instanceCreatedAt(ins); !ok || !at.After(oldest) {
+					kept = append(kept, ins)
+				}
+			}
+			if len(kept) == 0 {
+				return instances
+			}
+			return kept
+		},
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceRevisionAndTimes(t *testing.T) {
+	instance := &polaristest.Instance{Host: "127.0.0.1", Port: 6666, Revision: "7",
+		Ctime: "2021-10-01 10:00:00", Mtime: "2021-10-02 11:00:00"}
+	ins := ConvertInstance(instance)
+	for tag, want := range map[string]string{TagRevision: "7", TagCreateTime: "2021-10-01 10:00:00",
+		TagModifyTime: "2021-10-02 11:00:00"} {
+		value, _ := ins.Tag(tag)
+		require.Equal(t, want, value, tag)
+	}
+
+	// the times are omitted when the model does not expose them.
+	ins = ConvertInstance(&polaristest.Instance{Host: "127.0.0.1", Port: 6666})
+	_, ok := ins.Tag(TagCreateTime)
+	require.False(t, ok)
+}
+
+func TestMinInstanceAge(t *testing.T) {
+	now := time.Now()
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Ctime: now.Add(-time.Hour).Format(polarisTimeLayout)},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.2", Port: 6666,
+			Ctime: now.Format(polarisTimeLayout)},
+		// the start time of the metadata is used without create time and unknown ages are kept.
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.3", Port: 6666,
+			Metadata: map[string]string{MetadataStartTime: now.Add(-time.Minute).Format(time.RFC3339)}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.4", Port: 6666,
+			Metadata: map[string]string{MetadataStartTime: now.Format(time.RFC3339)}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.5", Port: 6666},
+	)
+	rs := newTestResolver(backend, WithMinInstanceAge(30*time.Second), WithMetadataTagPrefixPassthrough(nil))
+	result, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666", "127.0.0.3:6666", "127.0.0.5:6666"}, addrs(result.Instances))
+}
+
+func TestMinInstanceAgeKeepsYoungInstances(t *testing.T) {
+	now := time.Now()
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Ctime: now.Format(polarisTimeLayout)},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.2", Port: 6666,
+			Metadata: map[string]string{MetadataStartTime: now.Format(time.RFC3339)}},
+	)
+	rs := newTestResolver(backend, WithMinInstanceAge(time.Minute))
+	result, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666", "127.0.0.2:6666"}, addrs(result.Instances))
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+type convertedInstance struct {
+	revision string
+	instance discovery.Instance
+}
+
+// instanceCache keeps the converted Kitex instances of one service, so that unchanged
+// polaris instances are not converted again on every event.
+type instanceCache struct {
+	lock      sync.Mutex
+	instances map[string]convertedInstance
+	conv      instanceConversion
+}
+
+// newInstanceCache creates an instanceCache converting the instances as set by conv.
+func newInstanceCache(conv instanceConversion) *instanceCache {
+	return &instanceCache{instances: make(map[string]convertedInstance), conv: conv}
+}
+
+// convert converts one instance, reusing the cached object when the revision is unchanged.
+func (c *instanceCache) convert(instance model.Instance) discovery.Instance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.convertLocked(instance)
+}
+
+// convertAll converts a full snapshot of the service and evicts instances no longer present.
+func (c *instanceCache) convertAll(instances []model.Instance) []discovery.Instance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	eps := make([]discovery.Instance, 0, len(instances))
+	seen := make(map[string]struct{}, len(instances))
+	for _, instance := range instances {
+		seen[instance.GetId()] = struct{}{}
+		eps = append(eps, c.convertLocked(instance))
+	}
+	for id := range c.instances {
+		if _, ok := seen[id]; !ok {
+			delete(c.instances, id)
+		}
+	}
+	return eps
+}
+
+// snapshot returns the converted instances currently cached, in no particular order.
+func (c *instanceCache) snapshot() []discovery.Instance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	eps := make([]discovery.Instance, 0, len(c.instances))
+	for _, cached := range c.instances {
+		eps = append(eps, cached.instance)
+	}
+	return eps
+}
+
+// remove converts removed instances, reusing the cached objects, and evicts them from the cache.
+func (c *instanceCache) remove(instances []model.Instance) []discovery.Instance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var eps []discovery.Instance
+	for _, instance := range instances {
+		if cached, ok := c.instances[instance.GetId()]; ok {
+			eps = append(eps, cached.instance)
+			delete(c.instances, instance.GetId())
+			continue
+		}
+		eps = append(eps, c.conv.convert(instance))
+	}
+	return eps
+}
+
+func (c *instanceCache) convertLocked(instance model.Instance) discovery.Instance {
+	id, revision := instance.GetId(), instance.GetRevision()
+	if id == "" || revision == "" {
+		return c.conv.convert(instance)
+	}
+	if cached, ok := c.instances[id]; ok && cached.revision == revision {
+		return cached.instance
+	}
+	converted := c.conv.convert(instance)
+	c.instances[id] = convertedInstance{revision: revision, instance: converted}
+	return converted
+}
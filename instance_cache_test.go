@@ -0,0 +1,120 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInstances(n int) []model.Instance {
+	instances := make([]model.Instance, 0, n)
+	for i := 0; i < n; i++ {
+		instances = append(instances, &polaristest.Instance{
+			ID:        "ins-" + strconv.Itoa(i),
+			Namespace: polarisDefaultNamespace,
+			Service:   serviceName,
+			Host:      "10.0.0.1",
+			Port:      uint32(10000 + i),
+			Weight:    100,
+			Revision:  "1",
+		})
+	}
+	return instances
+}
+
+// churn bumps the revision of every twentieth instance, i.e. 5% of the service.
+func churn(instances []model.Instance, round int) {
+	for i := round % 20; i < len(instances); i += 20 {
+		ins := instances[i].(*polaristest.Instance)
+		ins.Revision = strconv.Itoa(round + 2)
+	}
+}
+
+func TestInstanceCacheReuse(t *testing.T) {
+	cache := newInstanceCache(instanceConversion{})
+	instances := newTestInstances(3)
+	first := cache.convertAll(instances)
+
+	instances[1].(*polaristest.Instance).Revision = "2"
+	second := cache.convertAll(instances)
+	require.True(t, first[0] == second[0])
+	require.False(t, first[1] == second[1])
+	require.True(t, first[2] == second[2])
+
+	removed := cache.remove(instances[2:])
+	require.True(t, removed[0] == second[2])
+	require.Len(t, cache.instances, 2)
+
+	cache.convertAll(instances[:1])
+	require.Len(t, cache.instances, 1)
+}
+
+func TestInstanceCacheConcurrent(t *testing.T) {
+	cache := newInstanceCache(instanceConversion{})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			instances := newTestInstances(50)
+			for round := 0; round < 100; round++ {
+				switch round % 3 {
+				case 0:
+					cache.convertAll(instances)
+				case 1:
+					cache.convert(instances[round%50])
+				default:
+					cache.remove(instances[g : g+1])
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func benchmarkConvertEvents(b *testing.B, convert func([]model.Instance)) {
+	instances := newTestInstances(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for round := 0; round < 1000; round++ {
+			churn(instances, round)
+			convert(instances)
+		}
+	}
+}
+
+func BenchmarkConvertEventsWithCache(b *testing.B) {
+	cache := newInstanceCache(instanceConversion{})
+	benchmarkConvertEvents(b, func(instances []model.Instance) {
+		cache.convertAll(instances)
+	})
+}
+
+func BenchmarkConvertEventsWithoutCache(b *testing.B) {
+	benchmarkConvertEvents(b, func(instances []model.Instance) {
+		eps := make([]interface{}, 0, len(instances))
+		for _, instance := range instances {
+			eps = append(eps, ConvertInstance(instance))
+		}
+	})
+}
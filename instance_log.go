@@ -0,0 +1,90 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// Sources of the instances logged with WithStructuredLogger.
+const (
+	// logSourceRemote marks the instances queried through the SDK by Resolve.
+	logSourceRemote = "remote"
+	// logSourceCache marks the instances of the snapshot of the shared subscription of a service.
+	logSourceCache = "cache"
+)
+
+// StructuredLogger receives the records of Resolve and Watcher as alternating keys and values instead of
+// the format strings of the polaris-go logger, see WithStructuredLogger. A *slog.Logger implements it.
+type StructuredLogger interface {
+	DebugContext(ctx context.Context, msg string, args ...interface{})
+	InfoContext(ctx context.Context, msg string, args ...interface{})
+	WarnContext(ctx context.Context, msg string, args ...interface{})
+	ErrorContext(ctx context.Context, msg string, args ...interface{})
+}
+
+// instancesLog describes the instances got by one Resolve or Watcher call.
+type instancesLog struct {
+	op       string
+	desc     string
+	key      model.ServiceKey
+	revision string
+	source   string
+	elapsed  time.Duration
+}
+
+// logInstances logs one summary line for the instances of desc got by op, and with
+// WithInstanceLogSampling(n) every nth instance seen by the resolver at debug level.
+func (polaris *polarisResolver) logInstances(ctx context.Context, l instancesLog, instances []model.Instance) {
+	logger := polaris.opts.structuredLogger
+	if logger != nil {
+		logger.InfoContext(ctx, "polaris "+l.op, polaris.logFields(ctx, "namespace", l.key.Namespace,
+			"service", l.key.Service, "description", l.desc, "instance_count", len(instances),
+			"duration_ms", float64(l.elapsed)/float64(time.Millisecond), "revision", l.revision, "source", l.source)...)
+	} else {
+		log.GetBaseLogger().Infof("[Polaris resolver] %s of %s got %d instances in %v", l.op, l.desc, len(instances), l.elapsed)
+	}
+	n := uint64(polaris.opts.instanceLogSampling)
+	if n == 0 {
+		return
+	}
+	for _, instance := range instances {
+		if atomic.AddUint64(&polaris.loggedInstances, 1)%n != 0 {
+			continue
+		}
+		if logger != nil {
+			logger.DebugContext(ctx, "polaris "+l.op+" instance", polaris.logFields(ctx, "namespace", l.key.Namespace,
+				"service", l.key.Service, "host", instance.GetHost(), "port", instance.GetPort())...)
+		} else {
+			log.GetBaseLogger().Debugf("[Polaris resolver] %s of %s got instance %s:%d",
+				l.op, l.desc, instance.GetHost(), instance.GetPort())
+		}
+	}
+}
+
+// logFields appends the fields of the WithLogFields hook for ctx to fields.
+func (polaris *polarisResolver) logFields(ctx context.Context, fields ...interface{}) []interface{} {
+	if hook := polaris.opts.logFields; hook != nil {
+		runHook("log fields", func() { fields = append(fields, hook(ctx)...) })
+	}
+	return fields
+}
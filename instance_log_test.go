@@ -0,0 +1,213 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+// capturingLogger records the lines logged with the "[Polaris resolver]" prefix.
+type capturingLogger struct {
+	log.Logger
+	lock  sync.Mutex
+	infos []string
+	debug []string
+}
+
+func (l *capturingLogger) capture(lines *[]string, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if !strings.HasPrefix(line, "[Polaris resolver]") {
+		return
+	}
+	l.lock.Lock()
+	*lines = append(*lines, line)
+	l.lock.Unlock()
+}
+
+func (l *capturingLogger) Infof(format string, args ...interface{}) {
+	l.capture(&l.infos, format, args...)
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) {
+	l.capture(&l.debug, format, args...)
+}
+
+func (l *capturingLogger) Warnf(format string, args ...interface{}) {}
+
+func (l *capturingLogger) Errorf(format string, args ...interface{}) {}
+
+func captureLogs(t *testing.T) *capturingLogger {
+	previous := log.GetBaseLogger()
+	logger := &capturingLogger{Logger: previous}
+	log.SetBaseLogger(logger)
+	t.Cleanup(func() { log.SetBaseLogger(previous) })
+	return logger
+}
+
+func addTestInstances(backend *polaristest.Backend, n int) {
+	for i := 0; i < n; i++ {
+		backend.AddInstances(&polaristest.Instance{
+			Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: uint32(6000 + i),
+			Weight: defaultWeight,
+		})
+	}
+}
+
+func TestInstanceLogSummary(t *testing.T) {
+	backend := polaristest.NewBackend()
+	addTestInstances(backend, 50)
+	rs := newTestResolver(backend)
+	logger := captureLogs(t)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	_, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rs.Watcher(ctx, desc)
+	require.Nil(t, err)
+
+	require.Len(t, logger.infos, 2)
+	require.True(t, strings.HasPrefix(logger.infos[0], "[Polaris resolver] resolve of "+desc+" got 50 instances in "))
+	require.True(t, strings.HasPrefix(logger.infos[1], "[Polaris resolver] watch of "+desc+" got 50 instances in "))
+	require.Empty(t, logger.debug)
+}
+
+func TestInstanceLogSampling(t *testing.T) {
+	backend := polaristest.NewBackend()
+	addTestInstances(backend, 25)
+	rs := newTestResolver(backend, WithInstanceLogSampling(10))
+	logger := captureLogs(t)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	_, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, logger.debug, 2)
+	// the cadence carries over to the next resolve, 50 instances seen so far.
+	_, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, logger.debug, 5)
+	for _, line := range logger.debug {
+		require.True(t, strings.HasPrefix(line, "[Polaris resolver] resolve of "+desc+" got instance 127.0.0.1:"), line)
+	}
+}
+
+type structuredRecord struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// structuredLogger records the records of WithStructuredLogger.
+type structuredLogger struct {
+	lock    sync.Mutex
+	records []structuredRecord
+}
+
+func (l *structuredLogger) record(level, msg string, args []interface{}) {
+	fields := make(map[string]interface{}, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[args[i].(string)] = args[i+1]
+	}
+	l.lock.Lock()
+	l.records = append(l.records, structuredRecord{level: level, msg: msg, fields: fields})
+	l.lock.Unlock()
+}
+
+func (l *structuredLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("debug", msg, args)
+}
+
+func (l *structuredLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("info", msg, args)
+}
+
+func (l *structuredLogger) WarnContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("warn", msg, args)
+}
+
+func (l *structuredLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("error", msg, args)
+}
+
+type traceIDKey struct{}
+
+func TestStructuredLogger(t *testing.T) {
+	backend := polaristest.NewBackend()
+	addTestInstances(backend, 20)
+	logger := &structuredLogger{}
+	var hookCalls int
+	rs := newTestResolver(backend, WithStructuredLogger(logger), WithInstanceLogSampling(10),
+		WithLogFields(func(ctx context.Context) []interface{} {
+			hookCalls++
+			traceID, _ := ctx.Value(traceIDKey{}).(string)
+			return []interface{}{"trace_id", traceID}
+		}))
+	formatted := captureLogs(t)
+	desc := polarisDefaultNamespace + ":" + serviceName
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "trace-1")
+
+	_, err := rs.Resolve(ctx, desc)
+	require.Nil(t, err)
+	require.Len(t, logger.records, 3)
+	summary := logger.records[0]
+	require.Equal(t, "info", summary.level)
+	require.Equal(t, "polaris resolve", summary.msg)
+	require.Equal(t, polarisDefaultNamespace, summary.fields["namespace"])
+	require.Equal(t, serviceName, summary.fields["service"])
+	require.Equal(t, 20, summary.fields["instance_count"])
+	require.Equal(t, logSourceRemote, summary.fields["source"])
+	require.Equal(t, "trace-1", summary.fields["trace_id"])
+	require.Contains(t, summary.fields, "duration_ms")
+	require.Contains(t, summary.fields, "revision")
+	for _, sampled := range logger.records[1:] {
+		require.Equal(t, "debug", sampled.level)
+		require.Equal(t, "trace-1", sampled.fields["trace_id"])
+		require.Contains(t, sampled.fields, "host")
+	}
+	require.Equal(t, 3, hookCalls)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = rs.Watcher(watchCtx, desc)
+	require.Nil(t, err)
+	watch := logger.records[3]
+	require.Equal(t, "polaris watch", watch.msg)
+	require.Equal(t, logSourceCache, watch.fields["source"])
+	require.Equal(t, "trace-1", watch.fields["trace_id"])
+
+	// the failures are structured too.
+	_, err = rs.Resolve(ctx, polarisDefaultNamespace+":missing.api")
+	require.NotNil(t, err)
+	failure := logger.records[len(logger.records)-1]
+	require.Equal(t, "warn", failure.level)
+	require.Equal(t, "missing.api", failure.fields["service"])
+	require.Equal(t, 0, failure.fields["total_from_polaris"])
+	require.Equal(t, "trace-1", failure.fields["trace_id"])
+
+	// nothing goes to the format strings.
+	require.Empty(t, formatted.infos)
+	require.Empty(t, formatted.debug)
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// ConnCleaner is the part of the Kitex connection pools closing the connections to an address,
+// implemented by remote.LongConnPool like the default connpool.LongPool.
+type ConnCleaner interface {
+	Clean(network, address string)
+}
+
+// CleanConnections returns a WithOnInstancesRemoved function closing the connections of pool to the
+// removed instances, so that the calls fail over at once instead of after the pooled connections error.
+// The connections are keyed by the network and address of the instance, which the client dialed.
+// The pool is the one given to the client:
+//
+//	pool := connpool.NewLongPool(serviceName, idleConfig)
+//	resolver, err := polaris.NewPolarisResolver(endpoints, polaris.WithOnInstancesRemoved(polaris.CleanConnections(pool)))
+//	cli, err := echo.NewClient(serviceName, client.WithResolver(resolver), client.WithConnPool(pool))
+func CleanConnections(pool ConnCleaner) func(instances []discovery.Instance) {
+	return func(instances []discovery.Instance) {
+		for _, instance := range instances {
+			if addr := instance.Address(); addr != nil {
+				pool.Clean(addr.Network(), addr.String())
+			}
+		}
+	}
+}
+
+// instancesRemovedHook converts the deleted instances for the function set by WithOnInstancesRemoved.
+func (o *options) instancesRemovedHook() func(instances []model.Instance) {
+	removed := o.onInstancesRemoved
+	if removed == nil {
+		return nil
+	}
+	conv := o.instanceConversion()
+	return func(instances []model.Instance) {
+		eps := conv.convertRaw(instances)
+		runHook("instances removed", func() { removed(eps) })
+	}
+}
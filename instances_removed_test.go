@@ -0,0 +1,82 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConnPool records the cleaned addresses.
+type fakeConnPool struct {
+	lock    sync.Mutex
+	cleaned []string
+}
+
+func (p *fakeConnPool) Clean(network, address string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.cleaned = append(p.cleaned, network+"://"+address)
+}
+
+func (p *fakeConnPool) addresses() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.cleaned...)
+}
+
+func TestOnInstancesRemoved(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{ID: "a", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666, Protocol: "tcp"},
+		&polaristest.Instance{ID: "b", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.2", Port: 6666, Protocol: "tcp"},
+		&polaristest.Instance{ID: "c", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.3", Port: 7777, Protocol: "tcp"},
+	)
+	pool := &fakeConnPool{}
+	removed := make(chan []discovery.Instance, 1)
+	clean := CleanConnections(pool)
+	rs := newTestResolver(backend, WithOnInstancesRemoved(func(instances []discovery.Instance) {
+		clean(instances)
+		removed <- instances
+	}))
+	defer rs.Close()
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":"+serviceName, func(discovery.Change) {})
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, "a", "c")
+	select {
+	case instances := <-removed:
+		require.ElementsMatch(t, []string{"127.0.0.1:6666", "127.0.0.3:7777"}, addrs(instances))
+	case <-time.After(time.Second):
+		t.Fatal("removed instances not notified")
+	}
+	require.ElementsMatch(t, []string{"tcp://127.0.0.1:6666", "tcp://127.0.0.3:7777"}, pool.addresses())
+
+	// additions notify nothing.
+	backend.AddInstances(&polaristest.Instance{ID: "d", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.4", Port: 6666})
+	select {
+	case instances := <-removed:
+		t.Fatalf("unexpected removal %v", addrs(instances))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
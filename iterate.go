@@ -0,0 +1,74 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// iterateChunkSize is how many instances IterateInstances converts at once.
+const iterateChunkSize = 256
+
+// IterateInstances implements the AllInstancesResolver interface.
+func (polaris *polarisResolver) IterateInstances(ctx context.Context, desc string, fn func(InstanceInfo) bool) error {
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return err
+	}
+	req := &api.GetAllInstancesRequest{}
+	req.Namespace = info.Namespace
+	req.Service = info.Service
+	resp, err := polaris.consumerAPI().GetAllInstances(req)
+	if err != nil {
+		return perrors.WithMessagef(err, "get all instances of %s", desc)
+	}
+	convertChunks(resp.GetInstances(), iterateChunkSize, func(chunk []InstanceInfo) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		for _, instance := range chunk {
+			if !fn(instance) {
+				return false
+			}
+		}
+		return true
+	})
+	return err
+}
+
+// convertChunks converts instances size at a time into one reused buffer handed to yield, until yield
+// returns false, so that only one chunk of converted instances is alive at any time.
+func convertChunks(instances []model.Instance, size int, yield func(chunk []InstanceInfo) bool) {
+	chunk := make([]InstanceInfo, 0, size)
+	for start := 0; start < len(instances); start += size {
+		end := start + size
+		if end > len(instances) {
+			end = len(instances)
+		}
+		chunk = chunk[:0]
+		for _, instance := range instances[start:end] {
+			chunk = append(chunk, newInstanceInfo(instance))
+		}
+		if !yield(chunk) {
+			return
+		}
+	}
+}
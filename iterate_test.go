@@ -0,0 +1,127 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newLargeTestResolver(n int) *polarisResolver {
+	backend := polaristest.NewBackend()
+	instances := make([]*polaristest.Instance, 0, n)
+	for _, instance := range newTestInstances(n) {
+		ins := instance.(*polaristest.Instance)
+		ins.Metadata = map[string]string{"env": "prod", "idc": "sh"}
+		instances = append(instances, ins)
+	}
+	backend.AddInstances(instances...)
+	return newTestResolver(backend)
+}
+
+func TestIterateInstancesExactlyOnce(t *testing.T) {
+	const n = 3*iterateChunkSize + 17
+	rs := newLargeTestResolver(n)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	seen := make(map[string]int, n)
+	require.Nil(t, rs.IterateInstances(context.TODO(), desc, func(info InstanceInfo) bool {
+		seen[info.ID]++
+		require.Equal(t, "prod", info.Metadata["env"])
+		return true
+	}))
+	require.Len(t, seen, n)
+	for id, count := range seen {
+		require.Equal(t, 1, count, id)
+	}
+
+	visited := 0
+	require.Nil(t, rs.IterateInstances(context.TODO(), desc, func(info InstanceInfo) bool {
+		visited++
+		return visited < iterateChunkSize+10
+	}))
+	require.Equal(t, iterateChunkSize+10, visited)
+}
+
+func TestIterateInstancesContextDone(t *testing.T) {
+	rs := newLargeTestResolver(2 * iterateChunkSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	visited := 0
+	err := rs.IterateInstances(ctx, polarisDefaultNamespace+":"+serviceName, func(info InstanceInfo) bool {
+		visited++
+		cancel()
+		return true
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, iterateChunkSize, visited)
+}
+
+// benchmarkPeakHeap reports the peak heap growth seen by sample, which is called while the instances are held.
+func benchmarkPeakHeap(b *testing.B, run func(sample func())) {
+	var base, peak uint64
+	var stats runtime.MemStats
+	sample := func() {
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > base && stats.HeapAlloc-base > peak {
+			peak = stats.HeapAlloc - base
+		}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		base = stats.HeapAlloc
+		run(sample)
+	}
+	b.ReportMetric(float64(peak), "peak-heap-B")
+}
+
+func BenchmarkIterateInstances20k(b *testing.B) {
+	rs := newLargeTestResolver(20000)
+	desc := polarisDefaultNamespace + ":" + serviceName
+	b.ResetTimer()
+	benchmarkPeakHeap(b, func(sample func()) {
+		visited := 0
+		rs.IterateInstances(context.TODO(), desc, func(info InstanceInfo) bool {
+			if visited++; visited%iterateChunkSize == 0 {
+				sample()
+			}
+			return true
+		})
+	})
+}
+
+func BenchmarkMaterializeInstances20k(b *testing.B) {
+	rs := newLargeTestResolver(20000)
+	desc := polarisDefaultNamespace + ":" + serviceName
+	b.ResetTimer()
+	benchmarkPeakHeap(b, func(sample func()) {
+		var infos []InstanceInfo
+		rs.IterateInstances(context.TODO(), desc, func(info InstanceInfo) bool {
+			infos = append(infos, info)
+			if len(infos)%iterateChunkSize == 0 {
+				sample()
+			}
+			return true
+		})
+		sample()
+	})
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// MustNewPolarisResolver is like NewPolarisResolver but panics when the resolver cannot be created,
+// so that it can be used inline, e.g. client.WithResolver(polaris.MustNewPolarisResolver(endpoints)).
+func MustNewPolarisResolver(endpoints []string, opts ...Option) Resolver {
+	r, err := NewPolarisResolver(endpoints, opts...)
+	if err != nil {
+		panic(perrors.WithMessagef(err, "create polaris resolver with endpoints %v", endpoints))
+	}
+	return r
+}
+
+// NewLazyResolver returns a resolver that creates the polaris SDK on its first use instead of at startup.
+// A construction error is returned by every Resolve, Watcher, ResolveAll and Subscribe call.
+func NewLazyResolver(endpoints []string, opts ...Option) Resolver {
+	return &lazyResolver{
+		target: &polarisResolver{endpoints: append([]string(nil), endpoints...), opts: newOptions(opts)},
+		build: func() (extendedResolver, error) {
+			r, err := NewPolarisResolver(endpoints, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return extend(r), nil
+		},
+	}
+}
+
+// lazyResolver defers the construction of a polaris resolver to its first use.
+type lazyResolver struct {
+	// target computes descriptions without the SDK when the construction failed.
+	target   *polarisResolver
+	build    func() (extendedResolver, error)
+	once     sync.Once
+	resolver extendedResolver
+	err      error
+}
+
+func (l *lazyResolver) get() (extendedResolver, error) {
+	l.once.Do(func() {
+		l.resolver, l.err = l.build()
+		if l.err != nil {
+			l.err = perrors.WithMessage(l.err, "lazy polaris resolver construction failed")
+			log.GetBaseLogger().Errorf("[Polaris resolver] %v", l.err)
+		}
+	})
+	return l.resolver, l.err
+}
+
+// Target implements the Resolver interface.
+func (l *lazyResolver) Target(ctx context.Context, target rpcinfo.EndpointInfo) string {
+	r, err := l.get()
+	if err != nil {
+		return l.target.Target(ctx, target)
+	}
+	return r.Target(ctx, target)
+}
+
+// Resolve implements the Resolver interface.
+func (l *lazyResolver) Resolve(ctx context.Context, desc string) (discovery.Result, error) {
+	r, err := l.get()
+	if err != nil {
+		return discovery.Result{}, err
+	}
+	return r.Resolve(ctx, desc)
+}
+
+// Watcher implements the Resolver interface.
+func (l *lazyResolver) Watcher(ctx context.Context, desc string) (discovery.Change, error) {
+	r, err := l.get()
+	if err != nil {
+		return discovery.Change{}, err
+	}
+	return r.Watcher(ctx, desc)
+}
+
+// ResolveAll implements the AllInstancesResolver interface.
+func (l *lazyResolver) ResolveAll(ctx context.Context, desc string) (discovery.Result, error) {
+	r, err := l.get()
+	if err != nil {
+		return discovery.Result{}, err
+	}
+	return r.ResolveAll(ctx, desc)
+}
+
+// Subscribe implements the ListenerResolver interface.
+func (l *lazyResolver) Subscribe(desc string, listener func(discovery.Change)) (func(), error) {
+	r, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return r.Subscribe(desc, listener)
+}
+
+// SubscribeDeltas implements the ListenerResolver interface.
+func (l *lazyResolver) SubscribeDeltas(desc string,
+	listener func(added, updated, removed []discovery.Instance)) (func(), error) {
+	r, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return r.SubscribeDeltas(desc, listener)
+}
+
+// DroppedListenerChanges implements the ResolverDiagnostics interface.
+func (l *lazyResolver) DroppedListenerChanges() uint64 {
+	r, err := l.get()
+	if err != nil {
+		return 0
+	}
+	return r.DroppedListenerChanges()
+}
+
+// ServiceMetadata implements the ServiceMetadataResolver interface.
+func (l *lazyResolver) ServiceMetadata(ctx context.Context, desc string) (map[string]string, error) {
+	r, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return serviceMetadataOf(ctx, r, desc)
+}
+
+// LastRouteTrace implements the ResolverDiagnostics interface.
+func (l *lazyResolver) LastRouteTrace(desc string) (RouteTrace, bool) {
+	r, err := l.get()
+	if err != nil {
+		return RouteTrace{}, false
+	}
+	return r.LastRouteTrace(desc)
+}
+
+// ReportCallResult implements the CallResultResolver interface.
+func (l *lazyResolver) ReportCallResult(result CallResult) {
+	if r, err := l.get(); err == nil {
+		r.ReportCallResult(result)
+	}
+}
+
+// DroppedCallResults implements the ResolverDiagnostics interface.
+func (l *lazyResolver) DroppedCallResults() uint64 {
+	r, err := l.get()
+	if err != nil {
+		return 0
+	}
+	return r.DroppedCallResults()
+}
+
+// ClassifyCall implements the CallResultResolver interface, it does not need the SDK.
+func (l *lazyResolver) ClassifyCall(err error, ri rpcinfo.RPCInfo) CallStatus {
+	return l.target.ClassifyCall(err, ri)
+}
+
+// ClassifyCallResult implements the CallResultResolver interface, it does not need the SDK.
+func (l *lazyResolver) ClassifyCallResult(err error, ri rpcinfo.RPCInfo) model.RetStatus {
+	return l.target.ClassifyCallResult(err, ri)
+}
+
+// StaticFallbacks implements the ResolverDiagnostics interface.
+func (l *lazyResolver) StaticFallbacks() uint64 {
+	r, err := l.get()
+	if err != nil {
+		return 0
+	}
+	return r.StaticFallbacks()
+}
+
+// ChangeStats implements the ResolverDiagnostics interface.
+func (l *lazyResolver) ChangeStats() ChangeStats {
+	r, err := l.get()
+	if err != nil {
+		return ChangeStats{}
+	}
+	return r.ChangeStats()
+}
+
+// ZeroWeightResults implements the ResolverDiagnostics interface.
+func (l *lazyResolver) ZeroWeightResults() uint64 {
+	r, err := l.get()
+	if err != nil {
+		return 0
+	}
+	return r.ZeroWeightResults()
+}
+
+// LastRevision implements the ResolverDiagnostics interface.
+func (l *lazyResolver) LastRevision(desc string) (string, bool) {
+	r, err := l.get()
+	if err != nil {
+		return "", false
+	}
+	return r.LastRevision(desc)
+}
+
+// SkippedEvents implements the ResolverDiagnostics interface.
+func (l *lazyResolver) SkippedEvents() uint64 {
+	r, err := l.get()
+	if err != nil {
+		return 0
+	}
+	return r.SkippedEvents()
+}
+
+// EvictedServices implements the ResolverDiagnostics interface.
+func (l *lazyResolver) EvictedServices() uint64 {
+	r, err := l.get()
+	if err != nil {
+		return 0
+	}
+	return r.EvictedServices()
+}
+
+// IterateInstances implements the AllInstancesResolver interface.
+func (l *lazyResolver) IterateInstances(ctx context.Context, desc string, fn func(InstanceInfo) bool) error {
+	r, err := l.get()
+	if err != nil {
+		return err
+	}
+	return r.IterateInstances(ctx, desc, fn)
+}
+
+// Refresh implements the RefreshResolver interface.
+func (l *lazyResolver) Refresh(ctx context.Context, desc string) (discovery.Change, error) {
+	r, err := l.get()
+	if err != nil {
+		return discovery.Change{}, err
+	}
+	return r.Refresh(ctx, desc)
+}
+
+// UpdateEndpoints implements the EndpointsUpdater interface.
+func (l *lazyResolver) UpdateEndpoints(ctx context.Context, endpoints []string) error {
+	r, err := l.get()
+	if err != nil {
+		return err
+	}
+	return r.UpdateEndpoints(ctx, endpoints)
+}
+
+// WatchDeliveryLag implements the ResolverDiagnostics interface.
+func (l *lazyResolver) WatchDeliveryLag() LagHistogram {
+	r, err := l.get()
+	if err != nil {
+		return (&lagHistogram{}).snapshot()
+	}
+	return r.WatchDeliveryLag()
+}
+
+// ResolveHistory implements the ResolverDiagnostics interface.
+func (l *lazyResolver) ResolveHistory() []ResolveHistoryEntry {
+	r, err := l.get()
+	if err != nil {
+		return nil
+	}
+	return r.ResolveHistory()
+}
+
+// Close implements the io.Closer interface, a resolver that was never used is not created anymore.
+func (l *lazyResolver) Close() error {
+	l.once.Do(func() {
+		l.err = perrors.New("lazy polaris resolver closed before its first use")
+	})
+	if l.resolver == nil {
+		return nil
+	}
+	return l.resolver.Close()
+}
+
+// EffectiveOptions implements the OptionsReporter interface, it does not create the resolver.
+func (l *lazyResolver) EffectiveOptions() OptionsSnapshot {
+	return l.target.EffectiveOptions()
+}
+
+// Diff implements the Resolver interface.
+func (l *lazyResolver) Diff(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
+	return discovery.DefaultDiff(cacheKey, prev, next)
+}
+
+// Name implements the Resolver interface.
+func (l *lazyResolver) Name() string {
+	return "Polaris"
+}
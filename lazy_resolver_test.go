@@ -0,0 +1,67 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMustNewPolarisResolver(t *testing.T) {
+	require.Panics(t, func() {
+		MustNewPolarisResolver([]string{})
+	})
+}
+
+func TestLazyResolverDeferredFailure(t *testing.T) {
+	rs := NewLazyResolver([]string{}, WithTargetTagKeys("namespace", "env"))
+
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, map[string]string{"env": "prod"}))
+	require.Equal(t, polarisDefaultNamespace+":"+serviceName+"?env=prod", desc)
+	_, err := rs.Resolve(context.TODO(), desc)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "endpoints is empty")
+	_, err = rs.Watcher(context.TODO(), desc)
+	require.NotNil(t, err)
+	_, err = rs.(ListenerResolver).Subscribe(desc, nil)
+	require.NotNil(t, err)
+}
+
+func TestLazyResolverDeferredSuccess(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	builds := 0
+	rs := &lazyResolver{
+		target: &polarisResolver{opts: newOptions(nil)},
+		build: func() (extendedResolver, error) {
+			builds++
+			return newTestResolver(backend), nil
+		},
+	}
+	require.Equal(t, "Polaris", rs.Name())
+	require.Equal(t, 0, builds)
+
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil))
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, 1, builds)
+}
@@ -0,0 +1,413 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	defaultListenerQueueSize = 16
+	listenerWaiterSize       = 64
+)
+
+// ListenerResolver is the extension interface of the resolvers notifying the application code of the
+// instance changes, the Resolvers of this package implement it:
+//
+//	unsubscribe, err := resolver.(polaris.ListenerResolver).Subscribe(desc, listener)
+type ListenerResolver interface {
+	// Subscribe calls listener with every Change of the service until unsubscribe is called, the first
+	// Change carries only the current Result unless the service has no instance. The Changes go through
+	// the filters of Resolve for desc, like the shard, set and TLS ones.
+	// Each listener runs in its own goroutine, when it falls behind the oldest pending Changes are dropped.
+	Subscribe(desc string, listener func(discovery.Change)) (unsubscribe func(), err error)
+
+	// SubscribeDeltas is like Subscribe for listeners that never need the Result, which is then not built.
+	// The first call carries the current instances as added, the next ones follow the order of the events.
+	SubscribeDeltas(desc string, listener func(added, updated, removed []discovery.Instance)) (unsubscribe func(), err error)
+}
+
+var _ ListenerResolver = (*polarisResolver)(nil)
+
+// changeListener delivers Changes to one application listener from its own goroutine,
+// the queue is bounded and the oldest Change is dropped when the listener falls behind.
+type changeListener struct {
+	fn      func(discovery.Change)
+	deltas  bool // the listener ignores the Result, the hub does not build it for it
+	queue   chan discovery.Change
+	dropped *uint64
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newChangeListener(fn func(discovery.Change), size int, dropped *uint64) *changeListener {
+	if size <= 0 {
+		size = defaultListenerQueueSize
+	}
+	return &changeListener{
+		fn:      fn,
+		queue:   make(chan discovery.Change, size),
+		dropped: dropped,
+		done:    make(chan struct{}),
+	}
+}
+
+// push never blocks, it is called with the lock of the owning hub held.
+func (l *changeListener) push(change discovery.Change) {
+	for {
+		select {
+		case l.queue <- change:
+			return
+		default:
+		}
+		select {
+		case <-l.queue:
+			atomic.AddUint64(l.dropped, 1)
+		default:
+		}
+	}
+}
+
+func (l *changeListener) run() {
+	for {
+		select {
+		case <-l.done:
+			return
+		case change := <-l.queue:
+			runHook("listener", func() { l.fn(change) })
+		}
+	}
+}
+
+func (l *changeListener) stop() {
+	l.once.Do(func() { close(l.done) })
+}
+
+// listenerHub converts the events of one description once and fans the Changes out to its listeners.
+type listenerHub struct {
+	desc   string
+	sw     *serviceWatch
+	waiter chan model.SubScribeEvent
+	cache  *instanceCache
+	// lock guards instances and listeners, so that a new listener gets the snapshot before the next Change.
+	lock      sync.Mutex
+	instances []model.Instance
+	listeners map[*changeListener]struct{}
+	done      chan struct{}
+	// filters are the filters of resolve for the description, see changeFilters.
+	filters []instanceFilter
+	// materialize converts the snapshot of a Result, it is (*instanceCache).convertAll.
+	materialize func(cache *instanceCache, instances []model.Instance) []discovery.Instance
+	// delivered records the lag of the Changes pushed to the listeners, see WatchDeliveryLag.
+	delivered func(origin time.Time)
+	// observe counts and logs the Changes pushed to the listeners, see ChangeStats.
+	observe func(change discovery.Change)
+	// reload queries polaris for the instances of the service, see resync.
+	reload func() ([]model.Instance, error)
+	// stale is set while the hub missed events and could not reload, it is only used by run.
+	stale bool
+}
+
+func (h *listenerHub) run() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case event := <-h.waiter:
+			if h.stale || h.sw.takeMissed(h.waiter) {
+				// the snapshot the next events apply to is wrong, the fresh one covers this event too.
+				if h.stale = !h.resync(); !h.stale {
+					continue
+				}
+			}
+			insEvent, origin, ok := instanceEvent(event)
+			if !ok {
+				continue
+			}
+			h.dispatch(insEvent, origin)
+		}
+	}
+}
+
+// resync replaces the snapshot of the hub by the instances of polaris after its waiter missed events and
+// pushes the Change since the last one, if any, to every listener, with the added, updated and removed
+// instances of the missed events. The queued events, which the fresh
+// instances cover, are dropped. It reports whether polaris answered.
+func (h *listenerHub) resync() bool {
+	for drained := false; !drained; {
+		select {
+		case <-h.waiter:
+		default:
+			drained = true
+		}
+	}
+	if h.reload == nil {
+		return true
+	}
+	instances, err := h.reload()
+	if err != nil {
+		log.GetBaseLogger().Warnf("[Polaris resolver] resync %s after missed events: %v", h.desc, err)
+		return false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ctx := context.Background()
+	prev := discovery.Result{Cacheable: true, CacheKey: h.desc, Instances: h.materialize(h.cache, h.instances)}
+	next := discovery.Result{Cacheable: true, CacheKey: h.desc, Instances: h.materialize(h.cache, instances)}
+	change, changed := diffResults(h.desc, filterResult(ctx, h.filters, prev), filterResult(ctx, h.filters, next))
+	h.instances = append([]model.Instance(nil), instances...)
+	if !changed {
+		return true
+	}
+	for l := range h.listeners {
+		l.push(change)
+	}
+	if len(h.listeners) > 0 && h.observe != nil {
+		h.observe(change)
+	}
+	return true
+}
+
+// diffResults returns the Change from prev to next like discovery.DefaultDiff, with the updated instances
+// too, see diffInstances, and whether anything changed.
+func diffResults(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
+	added, updated, removed := diffInstances(prev.Instances, next.Instances)
+	change := discovery.Change{
+		Result:  discovery.Result{Cacheable: next.Cacheable, CacheKey: cacheKey, Instances: next.Instances},
+		Added:   added,
+		Updated: updated,
+		Removed: removed,
+	}
+	return change, len(added)+len(updated)+len(removed) > 0
+}
+
+// change builds the Change of an event the way Watcher does, the Result is the snapshot before the
+// event with the updates of the event applied. Without withResult only the deltas are converted, the
+// filters need the Result though.
+func (h *listenerHub) change(insEvent *model.InstanceEvent, withResult bool) discovery.Change {
+	result := discovery.Result{Cacheable: true, CacheKey: h.desc}
+	if withResult {
+		result.Instances = h.materialize(h.cache, applyUpdates(h.instances, insEvent))
+	}
+	add, update, remove := convertInstanceEvent(h.cache, insEvent)
+	h.apply(insEvent)
+	change := discovery.Change{Result: result, Added: add, Updated: update, Removed: remove}
+	return filterChange(context.Background(), h.filters, change)
+}
+
+// apply updates the polaris snapshot of the hub with an event, the instances added again after a resync
+// replace the ones of the snapshot.
+func (h *listenerHub) apply(insEvent *model.InstanceEvent) {
+	if insEvent.AddEvent != nil {
+		for _, instance := range insEvent.AddEvent.Instances {
+			h.instances = appendOrReplace(h.instances, instance)
+		}
+	}
+	h.instances = applyUpdates(h.instances, insEvent)
+	if insEvent.DeleteEvent != nil {
+		removed := make(map[string]struct{}, len(insEvent.DeleteEvent.Instances))
+		for _, instance := range insEvent.DeleteEvent.Instances {
+			removed[instance.GetId()] = struct{}{}
+		}
+		kept := h.instances[:0]
+		for _, instance := range h.instances {
+			if _, ok := removed[instance.GetId()]; !ok {
+				kept = append(kept, instance)
+			}
+		}
+		h.instances = kept
+	}
+}
+
+func appendOrReplace(instances []model.Instance, instance model.Instance) []model.Instance {
+	for i, existing := range instances {
+		if existing.GetId() == instance.GetId() {
+			instances[i] = instance
+			return instances
+		}
+	}
+	return append(instances, instance)
+}
+
+// dispatch builds the Change of an event and pushes it to every listener, the Result is built only
+// when a listener needs it.
+func (h *listenerHub) dispatch(insEvent *model.InstanceEvent, origin time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	withResult := len(h.filters) > 0
+	for l := range h.listeners {
+		withResult = withResult || !l.deltas
+	}
+	change := h.change(insEvent, withResult)
+	for l := range h.listeners {
+		l.push(change)
+	}
+	if len(h.listeners) > 0 && h.delivered != nil {
+		h.delivered(origin)
+	}
+	if len(h.listeners) > 0 && h.observe != nil {
+		h.observe(change)
+	}
+}
+
+// add registers a listener, its first Change carries only the current Result unless the service is empty,
+// so that the listeners of static services get the instances too. The first Change of a deltas listener
+// carries the current instances as added instead.
+func (h *listenerHub) add(l *changeListener) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.instances) > 0 && l.deltas {
+		added := make([]discovery.Instance, 0, len(h.instances))
+		for _, instance := range h.instances {
+			added = append(added, h.cache.convert(instance))
+		}
+		l.push(filterChange(context.Background(), h.filters, discovery.Change{Added: added}))
+	} else if len(h.instances) > 0 {
+		l.push(filterChange(context.Background(), h.filters, discovery.Change{Result: discovery.Result{
+			Cacheable: true,
+			CacheKey:  h.desc,
+			Instances: h.materialize(h.cache, h.instances),
+		}}))
+	}
+	h.listeners[l] = struct{}{}
+}
+
+// refresh replaces the snapshot and the conversion cache of the hub and pushes change to every listener.
+func (h *listenerHub) refresh(cache *instanceCache, instances []model.Instance, change discovery.Change) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.cache = cache
+	h.instances = append([]model.Instance(nil), instances...)
+	for l := range h.listeners {
+		l.push(change)
+	}
+}
+
+// Subscribe implements the ListenerResolver interface.
+func (polaris *polarisResolver) Subscribe(desc string, listener func(discovery.Change)) (func(), error) {
+	return polaris.subscribe(desc, listener, false)
+}
+
+// SubscribeDeltas implements the ListenerResolver interface.
+func (polaris *polarisResolver) SubscribeDeltas(desc string,
+	listener func(added, updated, removed []discovery.Instance)) (func(), error) {
+	return polaris.subscribe(desc, func(change discovery.Change) {
+		listener(change.Added, change.Updated, change.Removed)
+	}, true)
+}
+
+// subscribe adds a listener to the hub of desc, which is created with the first listener.
+func (polaris *polarisResolver) subscribe(desc string, listener func(discovery.Change), deltas bool) (func(), error) {
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return nil, err
+	}
+	key := model.ServiceKey{Namespace: info.Namespace, Service: info.Service}
+	polaris.track(key, desc)
+	polaris.listenerLock.Lock()
+	defer polaris.listenerLock.Unlock()
+	hub, ok := polaris.hubs[desc]
+	if !ok {
+		sw, waiter, snapshot, err := polaris.watcher.subscribe(key, listenerWaiterSize)
+		if err != nil {
+			return nil, err
+		}
+		hub = &listenerHub{
+			desc:        desc,
+			sw:          sw,
+			waiter:      waiter,
+			cache:       polaris.instanceCache(desc),
+			instances:   append([]model.Instance(nil), snapshot.GetInstances()...),
+			listeners:   make(map[*changeListener]struct{}),
+			done:        make(chan struct{}),
+			filters:     polaris.changeFilters(info.Tags),
+			materialize: (*instanceCache).convertAll,
+			delivered:   func(origin time.Time) { polaris.observeWatchLag(desc, origin) },
+			observe:     func(change discovery.Change) { polaris.observeChange(context.Background(), desc, change) },
+			reload: func() ([]model.Instance, error) {
+				resp, err := polaris.getAllInstances(context.Background(), key)
+				if err != nil {
+					return nil, err
+				}
+				return resp.GetInstances(), nil
+			},
+		}
+		sw.countDrops(waiter, &polaris.droppedChanges)
+		if polaris.hubs == nil {
+			polaris.hubs = make(map[string]*listenerHub)
+		}
+		polaris.hubs[desc] = hub
+		go hub.run()
+	}
+	l := newChangeListener(listener, polaris.opts.listenerQueueSize, &polaris.droppedChanges)
+	l.deltas = deltas
+	hub.add(l)
+	go l.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { polaris.unsubscribe(hub, l) })
+	}, nil
+}
+
+func (polaris *polarisResolver) unsubscribe(hub *listenerHub, l *changeListener) {
+	l.stop()
+	polaris.listenerLock.Lock()
+	defer polaris.listenerLock.Unlock()
+	if polaris.hubs[hub.desc] != hub {
+		// the resolver was closed.
+		return
+	}
+	hub.lock.Lock()
+	delete(hub.listeners, l)
+	empty := len(hub.listeners) == 0
+	hub.lock.Unlock()
+	if empty {
+		hub.sw.removeWaiter(hub.waiter)
+		close(hub.done)
+		delete(polaris.hubs, hub.desc)
+	}
+}
+
+// DroppedListenerChanges implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) DroppedListenerChanges() uint64 {
+	return atomic.LoadUint64(&polaris.droppedChanges)
+}
+
+// closeListeners stops every hub and listener.
+func (polaris *polarisResolver) closeListeners() {
+	polaris.listenerLock.Lock()
+	defer polaris.listenerLock.Unlock()
+	for desc, hub := range polaris.hubs {
+		hub.lock.Lock()
+		for l := range hub.listeners {
+			l.stop()
+		}
+		hub.listeners = make(map[*changeListener]struct{})
+		hub.lock.Unlock()
+		hub.sw.removeWaiter(hub.waiter)
+		close(hub.done)
+		delete(polaris.hubs, desc)
+	}
+}
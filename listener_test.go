@@ -0,0 +1,452 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestListenerInstance(port uint32) *polaristest.Instance {
+	return &polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: port}
+}
+
+// requireInitialChange requires the first Change of a listener to carry only the Result.
+func requireInitialChange(t *testing.T, changes <-chan discovery.Change, instances int) {
+	select {
+	case change := <-changes:
+		require.Len(t, change.Result.Instances, instances)
+		require.Empty(t, change.Added)
+		require.Empty(t, change.Updated)
+		require.Empty(t, change.Removed)
+	case <-time.After(time.Second):
+		t.Fatal("initial change not delivered")
+	}
+}
+
+func TestSubscribeMultipleListeners(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6666))
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	first, second := make(chan discovery.Change, 4), make(chan discovery.Change, 4)
+	unsubscribeFirst, err := rs.Subscribe(desc, func(change discovery.Change) { first <- change })
+	require.Nil(t, err)
+	unsubscribeSecond, err := rs.Subscribe(desc, func(change discovery.Change) { second <- change })
+	require.Nil(t, err)
+	defer unsubscribeSecond()
+	requireInitialChange(t, first, 1)
+	requireInitialChange(t, second, 1)
+
+	backend.AddInstances(newTestListenerInstance(7777))
+	for _, ch := range []chan discovery.Change{first, second} {
+		select {
+		case change := <-ch:
+			require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Added))
+			require.Empty(t, change.Updated)
+			require.Empty(t, change.Removed)
+			require.Len(t, change.Result.Instances, 1)
+		case <-time.After(time.Second):
+			t.Fatal("change not delivered")
+		}
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, backend.Instances(polarisDefaultNamespace, serviceName)[0].ID)
+	select {
+	case change := <-second:
+		require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Removed))
+		require.Empty(t, change.Added)
+		require.Empty(t, change.Updated)
+		require.Len(t, change.Result.Instances, 2)
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+	select {
+	case <-first:
+		t.Fatal("change delivered after unsubscribe")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeListenerPanic(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	delivered := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) {
+		delivered <- change
+		panic("listener")
+	})
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	backend.AddInstances(newTestListenerInstance(6666))
+	backend.AddInstances(newTestListenerInstance(7777))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(time.Second):
+			t.Fatal("change not delivered after listener panic")
+		}
+	}
+}
+
+func TestSubscribeSlowListener(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend, WithListenerQueueSize(2))
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	blocked, release := make(chan struct{}, 16), make(chan struct{})
+	delivered := make(chan discovery.Change, 16)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) {
+		blocked <- struct{}{}
+		<-release
+		delivered <- change
+	})
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	const events = 8
+	backend.AddInstances(newTestListenerInstance(6000))
+	<-blocked
+	for i := 1; i < events; i++ {
+		backend.AddInstances(newTestListenerInstance(uint32(6000 + i)))
+	}
+	require.Eventually(t, func() bool {
+		// one change is blocked in the listener and two are queued.
+		return rs.DroppedListenerChanges() == events-3
+	}, time.Second, 5*time.Millisecond)
+
+	close(release)
+	var last discovery.Change
+	for i := 0; i < 3; i++ {
+		select {
+		case last = <-delivered:
+		case <-time.After(time.Second):
+			t.Fatal("change not delivered")
+		}
+	}
+	require.Equal(t, "127.0.0.1:6007", last.Added[0].Address().String())
+}
+
+// TestSubscribeResyncAfterMissedEvents stalls the hub until its waiter misses events, which are counted,
+// then the hub resyncs with polaris so that the next Change applies to the right instances.
+func TestSubscribeResyncAfterMissedEvents(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6000))
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	changes := make(chan discovery.Change, 16)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	rs.listenerLock.Lock()
+	hub := rs.hubs[desc]
+	rs.listenerLock.Unlock()
+
+	hub.lock.Lock()
+	for port := uint32(6001); port < 7000 && rs.DroppedListenerChanges() == 0; port++ {
+		backend.AddInstances(newTestListenerInstance(port))
+		time.Sleep(100 * time.Microsecond)
+	}
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, backend.Instances(polarisDefaultNamespace, serviceName)[0].ID)
+	hub.lock.Unlock()
+	require.NotZero(t, rs.DroppedListenerChanges(), "no event missed")
+
+	addresses := func(instances []discovery.Instance) map[string]struct{} {
+		set := make(map[string]struct{}, len(instances))
+		for _, ins := range instances {
+			set[ins.Address().String()] = struct{}{}
+		}
+		return set
+	}
+	expected := make(map[string]struct{})
+	for _, ins := range backend.Instances(polarisDefaultNamespace, serviceName) {
+		expected[fmt.Sprintf("%s:%d", ins.Host, ins.Port)] = struct{}{}
+	}
+	// the resync pushes the Change to the instances of polaris, the next events apply to them.
+	timeout := time.After(time.Second)
+	for resynced := false; !resynced; {
+		select {
+		case change := <-changes:
+			resynced = len(change.Result.Instances) == len(expected)
+		case <-timeout:
+			t.Fatal("hub not resynced")
+		}
+	}
+
+	backend.AddInstances(newTestListenerInstance(7777))
+	for {
+		select {
+		case change := <-changes:
+			if len(change.Added) != 1 || change.Added[0].Address().String() != "127.0.0.1:7777" {
+				// an event the worker dispatched after the resync.
+				continue
+			}
+			require.Empty(t, change.Removed)
+			require.Equal(t, expected, addresses(change.Result.Instances))
+			return
+		case <-timeout:
+			t.Fatal("change not delivered after the resync")
+		}
+	}
+}
+
+// TestSubscribeResyncDeltas resyncs a hub whose missed events added, updated and removed instances, the
+// Change of the resync carries them.
+func TestSubscribeResyncDeltas(t *testing.T) {
+	kept := &polaristest.Instance{ID: "a", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6666, Weight: 100, Revision: "1"}
+	updated := *kept
+	updated.Weight, updated.Revision = 50, "2"
+	removed := &polaristest.Instance{ID: "b", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 7777, Weight: 100, Revision: "1"}
+	added := &polaristest.Instance{ID: "c", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 8888, Weight: 100, Revision: "1"}
+	hub := &listenerHub{
+		desc:        polarisDefaultNamespace + ":" + serviceName,
+		cache:       newInstanceCache(newOptions(nil).instanceConversion()),
+		instances:   []model.Instance{kept, removed},
+		listeners:   make(map[*changeListener]struct{}),
+		materialize: (*instanceCache).convertAll,
+	}
+	reloaded := []model.Instance{&updated, added}
+	hub.reload = func() ([]model.Instance, error) { return reloaded, nil }
+	changes := make(chan discovery.Change, 1)
+	var dropped uint64
+	l := newChangeListener(func(change discovery.Change) { changes <- change }, 0, &dropped)
+	hub.listeners[l] = struct{}{}
+	go l.run()
+	defer l.stop()
+
+	require.True(t, hub.resync())
+	change := nextChange(t, changes)
+	require.Equal(t, []string{"127.0.0.1:8888"}, addrs(change.Added))
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Updated))
+	require.Equal(t, 50, change.Updated[0].Weight())
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Removed))
+	require.ElementsMatch(t, []string{"127.0.0.1:6666", "127.0.0.1:8888"}, addrs(change.Result.Instances))
+
+	// an update alone is a Change too.
+	again := updated
+	again.Weight, again.Revision = 80, "3"
+	reloaded = []model.Instance{&again, added}
+	require.True(t, hub.resync())
+	change = nextChange(t, changes)
+	require.Empty(t, change.Added)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Updated))
+	require.Equal(t, 80, change.Updated[0].Weight())
+	require.Empty(t, change.Removed)
+}
+
+func TestSubscribeUpdateOnlyResult(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6666), newTestListenerInstance(7777))
+	rs := newTestResolver(backend)
+	changes := make(chan discovery.Change, 1)
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":"+serviceName, func(change discovery.Change) {
+		changes <- change
+	})
+	require.Nil(t, err)
+	defer unsubscribe()
+	requireInitialChange(t, changes, 2)
+
+	ins := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+	ins.Weight = 50
+	ins.Metadata = map[string]string{"env": "prod"}
+	require.Nil(t, backend.UpdateInstance(ins))
+	select {
+	case change := <-changes:
+		require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Updated))
+		require.Equal(t, 50, change.Updated[0].Weight())
+		require.Empty(t, change.Added)
+		require.Empty(t, change.Removed)
+		require.Len(t, change.Result.Instances, 2)
+		for _, got := range change.Result.Instances {
+			if got.Address().String() != "127.0.0.1:6666" {
+				require.Equal(t, defaultWeight, got.Weight())
+				continue
+			}
+			require.Equal(t, 50, got.Weight())
+			env, _ := got.Tag("env")
+			require.Equal(t, "prod", env)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+}
+
+type delta struct {
+	added, updated, removed []discovery.Instance
+}
+
+func addrs(instances []discovery.Instance) []string {
+	list := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		list = append(list, ins.Address().String())
+	}
+	return list
+}
+
+func TestSubscribeDeltas(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6666), newTestListenerInstance(7777))
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+	deltas := make(chan delta, 8)
+	unsubscribe, err := rs.SubscribeDeltas(desc, func(added, updated, removed []discovery.Instance) {
+		deltas <- delta{added, updated, removed}
+	})
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	// count the Results materialized from now on.
+	var materialized int64
+	hub := rs.hubs[desc]
+	hub.lock.Lock()
+	hub.materialize = func(cache *instanceCache, instances []model.Instance) []discovery.Instance {
+		atomic.AddInt64(&materialized, 1)
+		return cache.convertAll(instances)
+	}
+	hub.lock.Unlock()
+
+	backend.AddInstances(newTestListenerInstance(8888))
+	ins := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+	ins.Weight = 50
+	require.Nil(t, backend.UpdateInstance(ins))
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, ins.ID)
+
+	var got []delta
+	for len(got) < 4 {
+		select {
+		case d := <-deltas:
+			got = append(got, d)
+		case <-time.After(time.Second):
+			t.Fatalf("%d deltas delivered", len(got))
+		}
+	}
+	require.ElementsMatch(t, []string{"127.0.0.1:6666", "127.0.0.1:7777"}, addrs(got[0].added))
+	require.Equal(t, []string{"127.0.0.1:8888"}, addrs(got[1].added))
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(got[2].updated))
+	require.Equal(t, 50, got[2].updated[0].Weight())
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(got[3].removed))
+	require.Zero(t, atomic.LoadInt64(&materialized))
+
+	// a full listener on the same service needs the Result again.
+	changes := make(chan discovery.Change, 4)
+	unsubscribeFull, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribeFull()
+	requireInitialChange(t, changes, 2)
+	backend.AddInstances(newTestListenerInstance(9999))
+	select {
+	case change := <-changes:
+		require.Len(t, change.Added, 1)
+		require.NotEmpty(t, change.Result.Instances)
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+	select {
+	case d := <-deltas:
+		require.Equal(t, []string{"127.0.0.1:9999"}, addrs(d.added))
+	case <-time.After(time.Second):
+		t.Fatal("delta not delivered")
+	}
+	require.Equal(t, int64(2), atomic.LoadInt64(&materialized))
+}
+
+func newFilteredListenerInstance(port uint32, shard string, tls bool) *polaristest.Instance {
+	instance := newTestListenerInstance(port)
+	instance.Weight = defaultWeight
+	instance.Metadata = map[string]string{DefaultShardMetadataKey: shard, MetadataSetName: "app.sz.1"}
+	if tls {
+		instance.Metadata[MetadataTLS] = "true"
+	}
+	return instance
+}
+
+// nextDelta requires deltas within a second.
+func nextDelta(t *testing.T, deltas <-chan delta) delta {
+	select {
+	case d := <-deltas:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no deltas received")
+		return delta{}
+	}
+}
+
+func TestSubscribeFilterChain(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		newFilteredListenerInstance(6666, "0", true),
+		newFilteredListenerInstance(6667, "1", true),
+		newFilteredListenerInstance(6668, "0", false),
+	)
+	rs := newTestResolver(backend, WithRequireTLSInstances(true), WithSetFilter("app.sz.1"))
+	desc := rs.Target(CtxWithShard(context.Background(), "0"), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil))
+	result, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+
+	changes := make(chan discovery.Change, 8)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	deltas := make(chan delta, 8)
+	unsubscribeDeltas, err := rs.SubscribeDeltas(desc, func(added, updated, removed []discovery.Instance) {
+		deltas <- delta{added, updated, removed}
+	})
+	require.Nil(t, err)
+	defer unsubscribeDeltas()
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(nextChange(t, changes).Result.Instances))
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(nextDelta(t, deltas).added))
+
+	// the listeners get the same instances as resolve.
+	backend.AddInstances(newFilteredListenerInstance(7777, "0", true))
+	change := nextChange(t, changes)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Result.Instances))
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Added))
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(nextDelta(t, deltas).added))
+
+	backend.AddInstances(newFilteredListenerInstance(8888, "1", true))
+	require.Empty(t, nextChange(t, changes).Added)
+	require.Empty(t, nextDelta(t, deltas).added)
+
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, backend.Instances(polarisDefaultNamespace, serviceName)[0].ID)
+	change = nextChange(t, changes)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Removed))
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(nextDelta(t, deltas).removed))
+
+	result, err = rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(result.Instances))
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
+
+// localityAny is the name of the last fallback step, which does not filter on locality.
+const localityAny = "locality(any)"
+
+// splitLocalityTags separates the target tags of the locality levels from the other ones.
+func splitLocalityTags(tags []TargetTag, levels []string) (rest, locality []TargetTag) {
+	if len(levels) == 0 {
+		return tags, nil
+	}
+	for _, tag := range tags {
+		if contains(levels, tag.Key) {
+			locality = append(locality, tag)
+		} else {
+			rest = append(rest, tag)
+		}
+	}
+	return rest, locality
+}
+
+// localityFallback keeps the instances matching the locality tags of every level from levels[i] on,
+// starting with i = 0 and moving to the next level while nothing matches. The instances are returned
+// unfiltered when no level matches. The names of the attempted steps are returned.
+func (polaris *polarisResolver) localityFallback(ctx context.Context, locality []TargetTag,
+	instances []discovery.Instance, trace *RouteTrace) ([]discovery.Instance, []string) {
+	levels := polaris.opts.localityLevels
+	var steps []string
+	prev := -1
+	for i := range levels {
+		var filters []instanceFilter
+		for _, level := range levels[i:] {
+			for _, tag := range locality {
+				if tag.Key == level {
+					filters = append(filters, newTagFilter(tag))
+				}
+			}
+		}
+		if len(filters) == 0 || len(filters) == prev {
+			// the client has no value for this level.
+			continue
+		}
+		prev = len(filters)
+		names := make([]string, 0, len(filters))
+		for _, f := range filters {
+			names = append(names, f.name)
+		}
+		step := "locality(" + strings.Join(names, ",") + ")"
+		matched, _ := applyFilters(ctx, filters, instances, nil)
+		steps = append(steps, step)
+		if trace != nil {
+			trace.Stages = append(trace.Stages, RouteStage{Name: step, Before: len(instances), After: len(matched)})
+		}
+		if len(matched) > 0 {
+			return matched, steps
+		}
+	}
+	if trace != nil {
+		trace.Stages = append(trace.Stages, RouteStage{Name: localityAny, Before: len(instances), After: len(instances)})
+	}
+	return instances, append(steps, localityAny)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
+
+// Tags carrying the locality of a resolved instance in the region, zone and sub-zone shape of the xDS
+// localities, Kitex has no locality type of its own. They are set from the MetadataRegion, MetadataZone
+// and MetadataCampus metadata, or from the location polaris knows of the instance.
+const (
+	TagLocalityRegion  = "locality.region"
+	TagLocalityZone    = "locality.zone"
+	TagLocalitySubZone = "locality.subzone"
+)
+
+// Locality is the locality of an instance, the zero Locality is the one of the instances without any.
+type Locality struct {
+	Region  string
+	Zone    string
+	SubZone string
+}
+
+// InstanceLocality returns the locality of a resolved instance, the missing levels are empty.
+func InstanceLocality(ins discovery.Instance) Locality {
+	return tagLocality(ins)
+}
+
+// tagged is a discovery.Instance or the rpcinfo.EndpointInfo of a call, whose tags fall back to the ones
+// of the picked instance.
+type tagged interface {
+	Tag(key string) (string, bool)
+}
+
+// tagLocality reads the locality tags of an instance or of the endpoint of a call.
+func tagLocality(tags tagged) Locality {
+	region, _ := tags.Tag(TagLocalityRegion)
+	zone, _ := tags.Tag(TagLocalityZone)
+	subZone, _ := tags.Tag(TagLocalitySubZone)
+	return Locality{Region: region, Zone: zone, SubZone: subZone}
+}
+
+// GroupByLocality groups the instances of a Result by locality for balancers picking a locality first,
+// the order of the instances of the Result is kept within each group.
+func GroupByLocality(result discovery.Result) map[Locality][]discovery.Instance {
+	groups := make(map[Locality][]discovery.Instance)
+	for _, ins := range result.Instances {
+		locality := InstanceLocality(ins)
+		groups[locality] = append(groups[locality], ins)
+	}
+	return groups
+}
+
+// setLocalityTags sets the locality tags of a converted instance, every level comes from the metadata
+// of the instance first.
+func setLocalityTags(tags map[string]string, instance Instance) {
+	metadata := instance.GetMetadata()
+	for _, level := range []struct {
+		tag, metadata, location string
+	}{
+		{TagLocalityRegion, metadata[MetadataRegion], instance.GetRegion()},
+		{TagLocalityZone, metadata[MetadataZone], instance.GetZone()},
+		{TagLocalitySubZone, metadata[MetadataCampus], instance.GetCampus()},
+	} {
+		if level.metadata != "" {
+			tags[level.tag] = level.metadata
+		} else if level.location != "" {
+			tags[level.tag] = level.location
+		}
+	}
+}
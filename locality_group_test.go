@@ -0,0 +1,77 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceLocality(t *testing.T) {
+	// the metadata wins over the location of polaris, level by level.
+	ins := ConvertInstance(&polaristest.Instance{Host: "127.0.0.1", Port: 6666,
+		Region: "south", Zone: "sz", Campus: "sz-1",
+		Metadata: map[string]string{MetadataRegion: "south-china", MetadataCampus: "sz-2"}})
+	require.Equal(t, Locality{Region: "south-china", Zone: "sz", SubZone: "sz-2"}, InstanceLocality(ins))
+	zone, ok := ins.Tag(TagLocalityZone)
+	require.True(t, ok)
+	require.Equal(t, "sz", zone)
+
+	ins = ConvertInstance(&polaristest.Instance{Host: "127.0.0.1", Port: 6666})
+	require.Equal(t, Locality{}, InstanceLocality(ins))
+	_, ok = ins.Tag(TagLocalityRegion)
+	require.False(t, ok)
+}
+
+func TestGroupByLocality(t *testing.T) {
+	backend := polaristest.NewBackend()
+	instance := func(port uint32, metadata map[string]string) *polaristest.Instance {
+		ins := newTestListenerInstance(port)
+		ins.Metadata = metadata
+		return ins
+	}
+	backend.AddInstances(
+		instance(6001, map[string]string{MetadataRegion: "south", MetadataZone: "sz", MetadataCampus: "sz-1"}),
+		instance(6002, map[string]string{MetadataRegion: "south", MetadataZone: "sz", MetadataCampus: "sz-1"}),
+		instance(6003, map[string]string{MetadataRegion: "south", MetadataZone: "gz"}),
+		instance(6004, map[string]string{MetadataRegion: "north"}),
+		instance(6005, nil),
+		instance(6006, map[string]string{MetadataZone: "sz"}),
+	)
+	rs := newTestResolver(backend, WithMetadataTagPrefixPassthrough([]string{MetadataConnPrefix}))
+	result, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+
+	groups := GroupByLocality(result)
+	got := make(map[Locality][]string, len(groups))
+	for locality, instances := range groups {
+		got[locality] = addrs(instances)
+	}
+	require.Equal(t, map[Locality][]string{
+		{Region: "south", Zone: "sz", SubZone: "sz-1"}: {"127.0.0.1:6001", "127.0.0.1:6002"},
+		{Region: "south", Zone: "gz"}:                  {"127.0.0.1:6003"},
+		{Region: "north"}:                              {"127.0.0.1:6004"},
+		{}:                                             {"127.0.0.1:6005"},
+		{Zone: "sz"}:                                   {"127.0.0.1:6006"},
+	}, got)
+
+	require.Empty(t, GroupByLocality(discovery.Result{}))
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalityBackend() *polaristest.Backend {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{"zone": "a", "region": "x", "env": "prod"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777,
+			Metadata: map[string]string{"zone": "c", "region": "y", "env": "prod"}},
+	)
+	return backend
+}
+
+func resolveLocality(backend *polaristest.Backend, tags map[string]string) ([]string, error) {
+	rs := newTestResolver(backend, WithTargetTagKeys("env", "zone", "region"), WithLocalityFallback("zone", "region"))
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, tags))
+	result, err := rs.Resolve(context.TODO(), desc)
+	return addresses(result.Instances), err
+}
+
+func TestLocalityFallback(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		tags map[string]string
+		want []string
+	}{
+		{name: "zone", tags: map[string]string{"zone": "a", "region": "x"}, want: []string{"127.0.0.1:6666"}},
+		{name: "region", tags: map[string]string{"zone": "b", "region": "x"}, want: []string{"127.0.0.1:6666"}},
+		{name: "region only", tags: map[string]string{"region": "y"}, want: []string{"127.0.0.1:7777"}},
+		{name: "global", tags: map[string]string{"zone": "b", "region": "z"}, want: []string{"127.0.0.1:6666", "127.0.0.1:7777"}},
+		{name: "no locality", tags: nil, want: []string{"127.0.0.1:6666", "127.0.0.1:7777"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			backend := newLocalityBackend()
+			got, err := resolveLocality(backend, c.tags)
+			require.Nil(t, err)
+			require.Equal(t, c.want, got)
+			// every step reuses the instances of a single query.
+			require.Equal(t, 1, backend.Calls(polaristest.OpGetInstances))
+		})
+	}
+}
+
+func TestLocalityFallbackAllEmpty(t *testing.T) {
+	_, err := resolveLocality(newLocalityBackend(), map[string]string{"env": "pre", "zone": "b", "region": "x"})
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance))
+	require.Equal(t, 2, noInstance.TotalFromPolaris)
+	require.Equal(t, []string{"env=pre", "locality(zone=b,region=x)", "locality(region=x)", localityAny}, noInstance.Filters)
+}
+
+func TestLocalityFallbackTrace(t *testing.T) {
+	rs := newTestResolver(newLocalityBackend(), WithRouteDebug(true),
+		WithTargetTagKeys("zone", "region"), WithLocalityFallback("zone", "region"))
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, map[string]string{"zone": "b", "region": "x"}))
+	_, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	trace, ok := rs.LastRouteTrace(desc)
+	require.True(t, ok)
+	require.Equal(t, []RouteStage{
+		{Name: routeStagePolaris, Before: 2, After: 2},
+		{Name: "locality(zone=b,region=x)", Before: 2, After: 0},
+		{Name: "locality(region=x)", Before: 2, After: 1},
+	}, trace.Stages)
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// Metadata keys carrying the location of a registered instance, the polaris-go register request has no
+// location fields. They match the level keys of WithLocalityFallback.
+const (
+	MetadataRegion = "region"
+	MetadataZone   = "zone"
+	MetadataCampus = "campus"
+)
+
+const defaultLocationTimeout = time.Second
+
+// LocationProvider returns the location of the server, it should give up once ctx is done.
+type LocationProvider func(ctx context.Context) (region, zone, campus string, err error)
+
+// Environment variables read by EnvLocationProvider, the first one set wins.
+var (
+	regionEnvs = []string{"POLARIS_REGION", "REGION", "AWS_REGION", "AWS_DEFAULT_REGION", "CLOUDSDK_COMPUTE_REGION"}
+	zoneEnvs   = []string{"POLARIS_ZONE", "ZONE", "CLOUDSDK_COMPUTE_ZONE"}
+	campusEnvs = []string{"POLARIS_CAMPUS", "CAMPUS"}
+)
+
+// Cloud metadata endpoints queried by CloudLocationProvider, replaced in tests.
+var (
+	ec2MetadataEndpoint = "http://169.254.169.254"
+	gceMetadataEndpoint = "http://metadata.google.internal"
+)
+
+// EnvLocationProvider reads the location from the POLARIS_REGION, POLARIS_ZONE and POLARIS_CAMPUS
+// environment variables, falling back to REGION, ZONE and CAMPUS and to the AWS and gcloud ones.
+func EnvLocationProvider() LocationProvider {
+	return func(ctx context.Context) (region, zone, campus string, err error) {
+		region, zone, campus = firstEnv(regionEnvs), firstEnv(zoneEnvs), firstEnv(campusEnvs)
+		if region == "" && zone == "" && campus == "" {
+			return "", "", "", perrors.New("no location environment variable set")
+		}
+		return region, zone, campus, nil
+	}
+}
+
+func firstEnv(names []string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// CloudLocationProvider reads the region and zone from the EC2 instance metadata service, then from the
+// GCE metadata server. The campus is left empty.
+func CloudLocationProvider() LocationProvider {
+	return func(ctx context.Context) (region, zone, campus string, err error) {
+		if region, zone, err = ec2Location(ctx); err == nil {
+			return region, zone, "", nil
+		}
+		ec2Err := err
+		if region, zone, err = gceLocation(ctx); err == nil {
+			return region, zone, "", nil
+		}
+		return "", "", "", perrors.Errorf("ec2: %v, gce: %v", ec2Err, err)
+	}
+}
+
+// chainLocationProviders returns the location of the first provider that succeeds.
+func chainLocationProviders(providers ...LocationProvider) LocationProvider {
+	return func(ctx context.Context) (region, zone, campus string, err error) {
+		var errs []string
+		for _, provider := range providers {
+			if region, zone, campus, err = provider(ctx); err == nil {
+				return region, zone, campus, nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return "", "", "", perrors.New(strings.Join(errs, "; "))
+	}
+}
+
+// ec2Location queries the EC2 instance metadata service with an IMDSv2 session token.
+func ec2Location(ctx context.Context) (region, zone string, err error) {
+	req, err := http.NewRequest(http.MethodPut, ec2MetadataEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := metadataGet(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+	get := func(path string) (string, error) {
+		req, err := http.NewRequest(http.MethodGet, ec2MetadataEndpoint+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return metadataGet(ctx, req)
+	}
+	if zone, err = get("/latest/meta-data/placement/availability-zone"); err != nil {
+		return "", "", err
+	}
+	if region, err = get("/latest/meta-data/placement/region"); err != nil {
+		return "", "", err
+	}
+	return region, zone, nil
+}
+
+// gceLocation queries the GCE metadata server, whose zone looks like "projects/123/zones/us-central1-a".
+func gceLocation(ctx context.Context) (region, zone string, err error) {
+	req, err := http.NewRequest(http.MethodGet, gceMetadataEndpoint+"/computeMetadata/v1/instance/zone", nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	value, err := metadataGet(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+	zone = value[strings.LastIndex(value, "/")+1:]
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return region, zone, nil
+}
+
+func metadataGet(ctx context.Context, req *http.Request) (string, error) {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", perrors.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// locationProvider returns the provider set by WithLocationProvider or WithCloudLocationDetection, nil for none.
+func (o *options) locationProvider() LocationProvider {
+	if o.location != nil {
+		return o.location
+	}
+	if o.cloudLocation {
+		return chainLocationProviders(EnvLocationProvider(), CloudLocationProvider())
+	}
+	return nil
+}
+
+// detectLocation calls the location provider within the location timeout. A failure leaves the
+// location empty with a warning, it never fails the registration. Successful results are kept.
+func (svr *polarisRegistry) detectLocation() (region, zone, campus string) {
+	provider := svr.opts.locationProvider()
+	if provider == nil {
+		return "", "", ""
+	}
+	svr.locationLock.Lock()
+	defer svr.locationLock.Unlock()
+	if svr.locationDetected {
+		return svr.region, svr.zone, svr.campus
+	}
+	timeout := orDefaultDuration(svr.opts.locationTimeout, defaultLocationTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	type location struct {
+		region, zone, campus string
+		err                  error
+	}
+	detected := make(chan location, 1)
+	go func() {
+		var l location
+		l.region, l.zone, l.campus, l.err = provider(ctx)
+		detected <- l
+	}()
+	select {
+	case <-ctx.Done():
+		log.GetBaseLogger().Warnf("[Polaris registry] location not detected within %v, registering without location", timeout)
+		return "", "", ""
+	case l := <-detected:
+		if l.err != nil {
+			log.GetBaseLogger().Warnf("[Polaris registry] detect location: %v, registering without location", l.err)
+			return "", "", ""
+		}
+		svr.region, svr.zone, svr.campus, svr.locationDetected = l.region, l.zone, l.campus, true
+		return l.region, l.zone, l.campus
+	}
+}
+
+// setLocation adds the detected location to the metadata, the keys set by the registry info tags win.
+func (svr *polarisRegistry) setLocation(metadata map[string]string, info *registry.Info) {
+	region, zone, campus := svr.detectLocation()
+	for key, value := range map[string]string{MetadataRegion: region, MetadataZone: zone, MetadataCampus: campus} {
+		if _, ok := info.Tags[key]; value != "" && !ok {
+			metadata[key] = value
+		}
+	}
+}
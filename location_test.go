@@ -0,0 +1,156 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func registeredMetadata(t *testing.T, backend *polaristest.Backend, port uint32) map[string]string {
+	for _, ins := range backend.Instances(polarisDefaultNamespace, serviceName) {
+		if ins.Port == port {
+			return ins.Metadata
+		}
+	}
+	t.Fatalf("instance on port %d not registered", port)
+	return nil
+}
+
+func TestLocationProvider(t *testing.T) {
+	backend := polaristest.NewBackend()
+	var calls int32
+	rg := newTestRegistry(backend, WithLocationProvider(func(ctx context.Context) (string, string, string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "ap-guangzhou", "ap-guangzhou-3", "", nil
+	}))
+	info := newTestInfo("127.0.0.1:6666", map[string]string{MetadataZone: "ap-guangzhou-4"})
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	other := newTestInfo("127.0.0.1:7777", nil)
+	require.Nil(t, rg.Register(other))
+	defer rg.Deregister(other)
+
+	metadata := registeredMetadata(t, backend, 6666)
+	require.Equal(t, "ap-guangzhou", metadata[MetadataRegion])
+	require.Equal(t, "ap-guangzhou-4", metadata[MetadataZone])
+	_, ok := metadata[MetadataCampus]
+	require.False(t, ok)
+	require.Equal(t, "ap-guangzhou-3", registeredMetadata(t, backend, 7777)[MetadataZone])
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestLocationProviderFailure(t *testing.T) {
+	backend := polaristest.NewBackend()
+	release := make(chan struct{})
+	defer close(release)
+	cases := map[string]LocationProvider{
+		"error": func(ctx context.Context) (string, string, string, error) {
+			return "", "", "", errors.New("metadata service unreachable")
+		},
+		"timeout": func(ctx context.Context) (string, string, string, error) {
+			<-release
+			return "ap-guangzhou", "", "", nil
+		},
+	}
+	port := uint32(6000)
+	for name, provider := range cases {
+		port++
+		t.Run(name, func(t *testing.T) {
+			rg := newTestRegistry(backend, WithLocationProvider(provider), WithLocationTimeout(20*time.Millisecond))
+			info := newTestInfo("127.0.0.1:"+strconv.Itoa(int(port)), nil)
+			begin := time.Now()
+			require.Nil(t, rg.Register(info))
+			defer rg.Deregister(info)
+			require.Less(t, int64(time.Since(begin)), int64(time.Second))
+			_, ok := registeredMetadata(t, backend, port)[MetadataRegion]
+			require.False(t, ok)
+		})
+	}
+}
+
+func TestEnvLocationProvider(t *testing.T) {
+	for _, name := range append(append(append([]string{}, regionEnvs...), zoneEnvs...), campusEnvs...) {
+		if value, ok := os.LookupEnv(name); ok {
+			os.Unsetenv(name)
+			defer os.Setenv(name, value)
+		}
+	}
+	_, _, _, err := EnvLocationProvider()(context.TODO())
+	require.NotNil(t, err)
+
+	os.Setenv("AWS_REGION", "us-east-1")
+	defer os.Unsetenv("AWS_REGION")
+	os.Setenv("POLARIS_ZONE", "us-east-1a")
+	defer os.Unsetenv("POLARIS_ZONE")
+	region, zone, campus, err := EnvLocationProvider()(context.TODO())
+	require.Nil(t, err)
+	require.Equal(t, []string{"us-east-1", "us-east-1a", ""}, []string{region, zone, campus})
+}
+
+func TestCloudLocationProvider(t *testing.T) {
+	ec2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/placement/availability-zone":
+			w.Write([]byte("eu-west-1b"))
+		case r.URL.Path == "/latest/meta-data/placement/region":
+			w.Write([]byte("eu-west-1"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ec2.Close()
+	gce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/zone" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("projects/123/zones/us-central1-a"))
+	}))
+	defer gce.Close()
+	defer func(ec2Endpoint, gceEndpoint string) {
+		ec2MetadataEndpoint, gceMetadataEndpoint = ec2Endpoint, gceEndpoint
+	}(ec2MetadataEndpoint, gceMetadataEndpoint)
+
+	ec2MetadataEndpoint, gceMetadataEndpoint = ec2.URL, gce.URL
+	region, zone, _, err := CloudLocationProvider()(context.TODO())
+	require.Nil(t, err)
+	require.Equal(t, []string{"eu-west-1", "eu-west-1b"}, []string{region, zone})
+
+	ec2MetadataEndpoint = gce.URL
+	region, zone, _, err = CloudLocationProvider()(context.TODO())
+	require.Nil(t, err)
+	require.Equal(t, []string{"us-central1", "us-central1-a"}, []string{region, zone})
+
+	gceMetadataEndpoint = ec2.URL
+	_, _, _, err = CloudLocationProvider()(context.TODO())
+	require.NotNil(t, err)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/kitex"
+	"github.com/cloudwego/kitex/pkg/registry"
+)
+
+// Metadata keys injected into every registered instance unless disabled by WithAutoMetadata(false).
+const (
+	MetadataStartTime              = "start-time"
+	MetadataKitexVersion           = "kitex-version"
+	MetadataRegistryPolarisVersion = "registry-polaris-version"
+)
+
+// MetadataConnPrefix is the documented prefix of the per-instance connection hints in the metadata,
+// like "conn.max-conns=50" or "conn.tls=true", see WithMetadataTagPrefixPassthrough.
+const MetadataConnPrefix = "conn."
+
+const modulePath = "github.com/kitex-contrib/registry-polaris"
+
+var processStartTime = time.Now()
+
+// moduleVersion returns the version of this module from the build info.
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// instanceMetadata builds the metadata registered for info, user tags override the synthesized keys.
+func instanceMetadata(info *registry.Info, o *options) map[string]string {
+	metadata := make(map[string]string, len(info.Tags)+3)
+	if o.autoMetadata {
+		startTime := info.StartTime
+		if startTime.IsZero() {
+			startTime = processStartTime
+		}
+		metadata[MetadataStartTime] = startTime.Format(time.RFC3339)
+		metadata[MetadataKitexVersion] = kitex.Version
+		metadata[MetadataRegistryPolarisVersion] = moduleVersion()
+	}
+	if info.Weight == 0 {
+		metadata[TagDrained] = "true"
+	}
+	if capable, ok := tlsCapable(info, o); ok && capable {
+		metadata[MetadataTLS] = "true"
+	}
+	o.setAdditionalPorts(metadata)
+	for k, v := range info.Tags {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+// instanceConversion returns how the resolver converts polaris instances.
+func (o *options) instanceConversion() instanceConversion {
+	return instanceConversion{keep: o.metadataTagFilter(), setKeys: o.setMetadataKeys, defaultWeight: o.instanceWeight(),
+		local: isLocalHost, preferUDS: o.preferUDS}
+}
+
+// metadataTagFilter returns whether a metadata key is copied into the instance tags, nil copies every key.
+// The keys the resolver filters instances on are always copied.
+func (o *options) metadataTagFilter() func(key string) bool {
+	if o.metadataTagPrefixes == nil {
+		return nil
+	}
+	required := append(append(append([]string{o.shardMetadataKey()}, o.targetTagKeys...), o.localityLevels...),
+		o.setMetadataKeys...)
+	if o.minInstanceAge > 0 {
+		required = append(required, MetadataStartTime)
+	}
+	return prefixTagFilter(o.metadataTagPrefixes, required)
+}
+
+// prefixTagFilter keeps the metadata keys starting with one of prefixes, the required keys and the keys
+// the resolver always filters instances on.
+func prefixTagFilter(prefixes, required []string) func(key string) bool {
+	keys := map[string]struct{}{TagProtocol: {}, TagDraining: {}, TagDrained: {}, MetadataTLS: {}}
+	for _, key := range required {
+		keys[key] = struct{}{}
+	}
+	return func(key string) bool {
+		if _, ok := keys[key]; ok {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
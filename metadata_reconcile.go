@@ -0,0 +1,126 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// reconcileMetadata reads the instance registered with desired back every interval and registers it
+// again when its metadata or weight drifted, see WithMetadataReconciliation. It stops with ctx, which
+// is canceled when the registration is replaced, by SetIsolated, a new Register or a correction.
+func (svr *polarisRegistry) reconcileMetadata(ctx context.Context, instanceKey string,
+	desired *api.InstanceRegisterRequest, instanceID string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lost := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lost = svr.reconcileOnce(instanceKey, desired, instanceID, lost)
+	}
+}
+
+// reconcileOnce corrects the instance when it drifted from desired, it returns whether the instance
+// was deregistered without being registered again, the next round then only registers it.
+func (svr *polarisRegistry) reconcileOnce(instanceKey string, desired *api.InstanceRegisterRequest,
+	instanceID string, lost bool) bool {
+	var drift []string
+	if !lost {
+		req := &api.GetAllInstancesRequest{}
+		req.Namespace = desired.Namespace
+		req.Service = desired.Service
+		resp, err := svr.consumerAPI().GetAllInstances(req)
+		if err != nil {
+			log.GetBaseLogger().Warnf("[Polaris registry] read back instance %s: %v", instanceID, err)
+			return false
+		}
+		for _, instance := range resp.GetInstances() {
+			if instance.GetId() == instanceID {
+				drift = registrationDrift(desired, instance)
+				break
+			}
+		}
+		if len(drift) == 0 {
+			// an instance not visible yet is left to the heartbeats.
+			return false
+		}
+	}
+
+	svr.updateLock.Lock()
+	defer svr.updateLock.Unlock()
+	svr.lock.RLock()
+	current, ok := svr.registryIns[instanceKey]
+	svr.lock.RUnlock()
+	if !ok || current.desired != desired {
+		// the registration was changed through the registry meanwhile.
+		return false
+	}
+	if !lost {
+		log.GetBaseLogger().Warnf("[Polaris registry] instance %s drifted from its registration [%s], registering it again",
+			instanceID, strings.Join(drift, ", "))
+		if err := svr.deregisterWithTimeout(createDeregisterParamOf(desired)); err != nil {
+			log.GetBaseLogger().Warnf("[Polaris registry] deregister drifted instance %s: %v", instanceID, err)
+			return false
+		}
+	}
+	resp, err := svr.providerAPI().Register(desired)
+	if err != nil {
+		log.GetBaseLogger().Warnf("[Polaris registry] register drifted instance %s again: %v", instanceID, err)
+		return true
+	}
+	atomic.AddUint64(&svr.corrections, 1)
+	log.GetBaseLogger().Infof("[Polaris registry] instance %s registered again with its desired metadata", instanceID)
+	svr.startHeartbeat(instanceKey, desired, resp, current.shared)
+	return false
+}
+
+// registrationDrift lists the differences of instance with the metadata and weight of desired. The metadata
+// keys which were not registered are ignored, as is the weight when none was registered.
+func registrationDrift(desired *api.InstanceRegisterRequest, instance model.Instance) []string {
+	var drift []string
+	metadata := instance.GetMetadata()
+	for key, value := range desired.Metadata {
+		if actual, ok := metadata[key]; !ok {
+			drift = append(drift, fmt.Sprintf("%s: %q missing", key, value))
+		} else if actual != value {
+			drift = append(drift, fmt.Sprintf("%s: %q instead of %q", key, actual, value))
+		}
+	}
+	sort.Strings(drift)
+	if desired.Weight != nil && instance.GetWeight() != *desired.Weight {
+		drift = append(drift, fmt.Sprintf("weight: %d instead of %d", instance.GetWeight(), *desired.Weight))
+	}
+	return drift
+}
+
+// MetadataCorrections implements the RegistryDiagnostics interface.
+func (svr *polarisRegistry) MetadataCorrections() uint64 {
+	return atomic.LoadUint64(&svr.corrections)
+}
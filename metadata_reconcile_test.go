@@ -0,0 +1,109 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// overwriteMetadata changes the metadata of the registered instance like a legacy updater.
+func overwriteMetadata(t *testing.T, backend *polaristest.Backend, key, value string) {
+	ins := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+	ins.Metadata[key] = value
+	ins.Weight = 1
+	require.Nil(t, backend.UpdateInstance(ins))
+}
+
+func registeredValue(backend *polaristest.Backend, key string) string {
+	instances := backend.Instances(polarisDefaultNamespace, serviceName)
+	if len(instances) != 1 {
+		return ""
+	}
+	return instances[0].Metadata[key]
+}
+
+func TestMetadataReconciliation(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithMetadataReconciliation(5*time.Millisecond))
+	info := newTestInfo("127.0.0.1:6666", map[string]string{"env": "prod"})
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	id := backend.Instances(polarisDefaultNamespace, serviceName)[0].ID
+
+	overwriteMetadata(t, backend, "env", "dev")
+	require.Eventually(t, func() bool {
+		return registeredValue(backend, "env") == "prod"
+	}, time.Second, time.Millisecond)
+	require.Equal(t, uint64(1), rg.MetadataCorrections())
+	instances := backend.Instances(polarisDefaultNamespace, serviceName)
+	require.Len(t, instances, 1)
+	require.Equal(t, id, instances[0].ID)
+
+	// the keys added by others are left alone.
+	ins := instances[0]
+	ins.Metadata["owner"] = "legacy"
+	require.Nil(t, backend.UpdateInstance(ins))
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, "legacy", registeredValue(backend, "owner"))
+	require.Equal(t, uint64(1), rg.MetadataCorrections())
+}
+
+func TestMetadataReconciliationFollowsUpdates(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithMetadataReconciliation(5*time.Millisecond))
+	require.Nil(t, rg.Register(newTestInfo("127.0.0.1:6666", map[string]string{"env": "prod"})))
+
+	// registering again changes the desired metadata.
+	info := newTestInfo("127.0.0.1:6666", map[string]string{"env": "staging"})
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, "staging", registeredValue(backend, "env"))
+	require.Zero(t, rg.MetadataCorrections())
+
+	// so does SetIsolated, the correction keeps the isolation.
+	require.Nil(t, rg.SetIsolated(info, true))
+	overwriteMetadata(t, backend, "env", "dev")
+	require.Eventually(t, func() bool {
+		return registeredValue(backend, "env") == "staging"
+	}, time.Second, time.Millisecond)
+	require.True(t, backend.Instances(polarisDefaultNamespace, serviceName)[0].Isolated)
+	require.Equal(t, uint64(1), rg.MetadataCorrections())
+}
+
+func TestMetadataReconciliationRetriesRegister(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithMetadataReconciliation(5*time.Millisecond))
+	info := newTestInfo("127.0.0.1:6666", map[string]string{"env": "prod"})
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	backend.SetFailureRate(polaristest.OpRegister, 1)
+	overwriteMetadata(t, backend, "env", "dev")
+	require.Eventually(t, func() bool {
+		return len(backend.Instances(polarisDefaultNamespace, serviceName)) == 0
+	}, time.Second, time.Millisecond)
+	backend.SetFailureRate(polaristest.OpRegister, 0)
+	require.Eventually(t, func() bool {
+		return registeredValue(backend, "env") == "prod"
+	}, time.Second, time.Millisecond)
+	require.Equal(t, uint64(1), rg.MetadataCorrections())
+}
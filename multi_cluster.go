@@ -0,0 +1,666 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// MergePolicy decides which instances of the two clusters of NewMultiClusterResolver are used.
+type MergePolicy int
+
+const (
+	// Union uses the instances of both clusters, the copy of the primary wins for an instance in both.
+	Union MergePolicy = iota
+	// PreferPrimary uses the instances of the primary cluster, those of the secondary one only when the
+	// primary has none or fails.
+	PreferPrimary
+	// FailoverOnly uses the instances of the primary cluster, those of the secondary one only when the
+	// primary fails. A primary without instances is not failed over.
+	FailoverOnly
+)
+
+// String returns the name of the policy.
+func (p MergePolicy) String() string {
+	switch p {
+	case Union:
+		return "Union"
+	case PreferPrimary:
+		return "PreferPrimary"
+	case FailoverOnly:
+		return "FailoverOnly"
+	}
+	return "MergePolicy(" + strconv.Itoa(int(p)) + ")"
+}
+
+// clusterResult is what one cluster returned, err is nil for a cluster without instances.
+type clusterResult struct {
+	instances []discovery.Instance
+	err       error
+}
+
+// merge returns the instances of the policy, the instances of both clusters are deduplicated by
+// instance ID and by address.
+func (p MergePolicy) merge(primary, secondary clusterResult) []discovery.Instance {
+	switch p {
+	case PreferPrimary:
+		if primary.err == nil && len(primary.instances) > 0 {
+			return primary.instances
+		}
+		return secondary.instances
+	case FailoverOnly:
+		if primary.err == nil {
+			return primary.instances
+		}
+		return secondary.instances
+	}
+	return mergeInstances(primary.instances, secondary.instances)
+}
+
+// mergeInstances returns the primary instances and the secondary ones with another ID and address.
+func mergeInstances(primary, secondary []discovery.Instance) []discovery.Instance {
+	if len(secondary) == 0 {
+		return primary
+	}
+	if len(primary) == 0 {
+		return secondary
+	}
+	merged := make([]discovery.Instance, 0, len(primary)+len(secondary))
+	ids := make(map[string]struct{}, len(primary)+len(secondary))
+	addrs := make(map[string]struct{}, len(primary)+len(secondary))
+	for _, instances := range [][]discovery.Instance{primary, secondary} {
+		for _, ins := range instances {
+			addr := ins.Address().String()
+			if _, ok := addrs[addr]; ok {
+				continue
+			}
+			id, _ := ins.Tag(TagHashKey)
+			if _, ok := ids[id]; ok && id != "" {
+				continue
+			}
+			addrs[addr] = struct{}{}
+			if id != "" {
+				ids[id] = struct{}{}
+			}
+			merged = append(merged, ins)
+		}
+	}
+	return merged
+}
+
+// mergeErrors returns the error of a call that failed on both clusters.
+func mergeErrors(primary, secondary error) error {
+	return perrors.WithMessagef(primary, "both clusters failed, secondary: %v, primary", secondary)
+}
+
+// diffInstances returns the instances of next not in prev by address as added, those of prev not in next
+// as removed, and those in both with another weight, ID or revision as updated.
+func diffInstances(prev, next []discovery.Instance) (added, updated, removed []discovery.Instance) {
+	prevByAddr := make(map[string]discovery.Instance, len(prev))
+	for _, ins := range prev {
+		prevByAddr[ins.Address().String()] = ins
+	}
+	nextAddrs := make(map[string]struct{}, len(next))
+	for _, ins := range next {
+		addr := ins.Address().String()
+		nextAddrs[addr] = struct{}{}
+		old, ok := prevByAddr[addr]
+		if !ok {
+			added = append(added, ins)
+		} else if old.Weight() != ins.Weight() || !sameTag(old, ins, TagHashKey) || !sameTag(old, ins, TagRevision) {
+			updated = append(updated, ins)
+		}
+	}
+	for _, ins := range prev {
+		if _, ok := nextAddrs[ins.Address().String()]; !ok {
+			removed = append(removed, ins)
+		}
+	}
+	return added, updated, removed
+}
+
+func sameTag(a, b discovery.Instance, key string) bool {
+	va, _ := a.Tag(key)
+	vb, _ := b.Tag(key)
+	return va == vb
+}
+
+// applyChange returns the instances of a cluster after change, a Change without deltas carries them all,
+// like the first Change of a listener.
+func applyChange(instances []discovery.Instance, change discovery.Change) []discovery.Instance {
+	if len(change.Added)+len(change.Updated)+len(change.Removed) == 0 {
+		return change.Result.Instances
+	}
+	removed := make(map[string]struct{}, len(change.Removed))
+	for _, ins := range change.Removed {
+		removed[ins.Address().String()] = struct{}{}
+	}
+	updated := make(map[string]discovery.Instance, len(change.Updated))
+	for _, ins := range change.Updated {
+		updated[ins.Address().String()] = ins
+	}
+	next := make([]discovery.Instance, 0, len(instances)+len(change.Added))
+	seen := make(map[string]struct{}, len(instances)+len(change.Added))
+	for _, ins := range instances {
+		addr := ins.Address().String()
+		if _, ok := removed[addr]; ok {
+			continue
+		}
+		if u, ok := updated[addr]; ok {
+			ins = u
+		}
+		seen[addr] = struct{}{}
+		next = append(next, ins)
+	}
+	for _, ins := range change.Added {
+		if _, ok := seen[ins.Address().String()]; !ok {
+			seen[ins.Address().String()] = struct{}{}
+			next = append(next, ins)
+		}
+	}
+	return next
+}
+
+// NewMultiClusterResolver returns a resolver over two polaris clusters, like during the migration of a
+// control plane, which merges the instances of both with merge. Both resolvers must understand the
+// descriptions of the primary one. Watcher and Subscribe follow both clusters, their Changes are the diffs
+// of the merged instances, and the first Changes carry the instances of each cluster as they arrive.
+// A cluster whose subscription fails is treated as down until the service is subscribed again.
+func NewMultiClusterResolver(primary, secondary Resolver, merge MergePolicy) Resolver {
+	return &multiClusterResolver{
+		primary:   extend(primary),
+		secondary: extend(secondary),
+		policy:    merge,
+		watches:   make(map[string]*clusterWatch),
+	}
+}
+
+// multiClusterResolver merges the instances of two resolvers.
+type multiClusterResolver struct {
+	droppedChanges uint64 // accessed atomically, keep it first for 64-bit alignment
+	primary        extendedResolver
+	secondary      extendedResolver
+	policy         MergePolicy
+	lock           sync.Mutex
+	watches        map[string]*clusterWatch
+	// watchers are the listeners of Watcher by description, they are kept until Close.
+	watchers map[string]*changeListener
+	closed   bool
+}
+
+// clusterWatch follows a description on both clusters for the listeners of Watcher and Subscribe.
+type clusterWatch struct {
+	desc   string
+	policy MergePolicy
+	// lock guards the instances of the clusters and the listeners, so that a new listener gets the merged
+	// instances before the next Change.
+	lock        sync.Mutex
+	clusters    [2]clusterResult
+	merged      []discovery.Instance
+	listeners   map[*changeListener]struct{}
+	unsubscribe [2]func()
+}
+
+// update applies the Change of one cluster and pushes the diff of the merged instances to the listeners.
+func (w *clusterWatch) update(cluster int, change discovery.Change) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.clusters[cluster].instances = applyChange(w.clusters[cluster].instances, change)
+	merged := w.policy.merge(w.clusters[0], w.clusters[1])
+	added, updated, removed := diffInstances(w.merged, merged)
+	w.merged = merged
+	if len(added)+len(updated)+len(removed) == 0 {
+		return
+	}
+	merge := discovery.Change{
+		Result:  discovery.Result{Cacheable: true, CacheKey: w.desc, Instances: merged},
+		Added:   added,
+		Updated: updated,
+		Removed: removed,
+	}
+	for l := range w.listeners {
+		l.push(merge)
+	}
+}
+
+// add registers a listener, its first Change carries the merged instances like the one of Subscribe.
+func (w *clusterWatch) add(l *changeListener) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.merged) > 0 && l.deltas {
+		l.push(discovery.Change{Added: w.merged})
+	} else if len(w.merged) > 0 {
+		l.push(discovery.Change{Result: discovery.Result{Cacheable: true, CacheKey: w.desc, Instances: w.merged}})
+	}
+	w.listeners[l] = struct{}{}
+}
+
+// remove unregisters a listener and tells whether none is left.
+func (w *clusterWatch) remove(l *changeListener) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.listeners, l)
+	return len(w.listeners) == 0
+}
+
+func (w *clusterWatch) stop() {
+	for _, unsubscribe := range w.unsubscribe {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+	}
+	w.lock.Lock()
+	for l := range w.listeners {
+		l.stop()
+	}
+	w.listeners = make(map[*changeListener]struct{})
+	w.lock.Unlock()
+}
+
+// watch returns the watch of desc created with l as its first listener, m.lock is held.
+func (m *multiClusterResolver) watch(desc string, l *changeListener) (*clusterWatch, error) {
+	if m.closed {
+		return nil, ErrResolverClosed
+	}
+	if w, ok := m.watches[desc]; ok {
+		w.add(l)
+		return w, nil
+	}
+	w := &clusterWatch{desc: desc, policy: m.policy, listeners: map[*changeListener]struct{}{l: {}}}
+	for i, r := range []extendedResolver{m.primary, m.secondary} {
+		cluster := i
+		unsubscribe, err := r.Subscribe(desc, func(change discovery.Change) { w.update(cluster, change) })
+		if err != nil {
+			log.GetBaseLogger().Warnf("[Polaris resolver] subscribe %s on the %s cluster failed: %v",
+				desc, clusterName(cluster), err)
+		}
+		w.lock.Lock()
+		w.clusters[cluster].err = err
+		w.unsubscribe[cluster] = unsubscribe
+		w.lock.Unlock()
+	}
+	if w.clusters[0].err != nil && w.clusters[1].err != nil {
+		return nil, mergeErrors(w.clusters[0].err, w.clusters[1].err)
+	}
+	m.watches[desc] = w
+	return w, nil
+}
+
+func clusterName(cluster int) string {
+	if cluster == 0 {
+		return "primary"
+	}
+	return "secondary"
+}
+
+// Target implements the Resolver interface, the descriptions are those of the primary resolver.
+func (m *multiClusterResolver) Target(ctx context.Context, target rpcinfo.EndpointInfo) string {
+	return m.primary.Target(ctx, target)
+}
+
+// resolveBoth calls resolve on both clusters concurrently and merges their instances, a NoInstanceError
+// is a cluster without instances.
+func (m *multiClusterResolver) resolveBoth(ctx context.Context, desc string,
+	resolve func(r extendedResolver) (discovery.Result, error)) (discovery.Result, error) {
+	var results [2]clusterResult
+	var wg sync.WaitGroup
+	for i, r := range []extendedResolver{m.primary, m.secondary} {
+		wg.Add(1)
+		go func(i int, r extendedResolver) {
+			defer wg.Done()
+			result, err := resolve(r)
+			results[i] = clusterResult{instances: result.Instances, err: err}
+		}(i, r)
+	}
+	wg.Wait()
+	var noInstance *NoInstanceError
+	empty := [2]error{}
+	for i := range results {
+		if errors.As(results[i].err, &noInstance) {
+			empty[i], results[i].err = results[i].err, nil
+		}
+	}
+	if results[0].err != nil && results[1].err != nil {
+		return discovery.Result{}, mergeErrors(results[0].err, results[1].err)
+	}
+	for i := range results {
+		if results[i].err != nil {
+			log.GetBaseLogger().Warnf("[Polaris resolver] resolve %s on the %s cluster failed: %v",
+				desc, clusterName(i), results[i].err)
+		}
+	}
+	instances := m.policy.merge(results[0], results[1])
+	if len(instances) == 0 {
+		for _, err := range []error{empty[0], empty[1], results[0].err, results[1].err} {
+			if err != nil {
+				return discovery.Result{}, err
+			}
+		}
+	}
+	return discovery.Result{Cacheable: true, CacheKey: desc, Instances: instances}, nil
+}
+
+// Resolve implements the Resolver interface.
+func (m *multiClusterResolver) Resolve(ctx context.Context, desc string) (discovery.Result, error) {
+	return m.resolveBoth(ctx, desc, func(r extendedResolver) (discovery.Result, error) { return r.Resolve(ctx, desc) })
+}
+
+// ResolveAll implements the AllInstancesResolver interface.
+func (m *multiClusterResolver) ResolveAll(ctx context.Context, desc string) (discovery.Result, error) {
+	result, err := m.resolveBoth(ctx, desc, func(r extendedResolver) (discovery.Result, error) { return r.ResolveAll(ctx, desc) })
+	result.Cacheable = false
+	return result, err
+}
+
+// Watcher implements the Resolver interface, the first call of a description waits for the instances
+// of a cluster when none is known yet.
+func (m *multiClusterResolver) Watcher(ctx context.Context, desc string) (discovery.Change, error) {
+	m.lock.Lock()
+	l, ok := m.watchers[desc]
+	if !ok {
+		l = newChangeListener(nil, listenerWaiterSize, &m.droppedChanges)
+		if _, err := m.watch(desc, l); err != nil {
+			m.lock.Unlock()
+			return discovery.Change{}, err
+		}
+		if m.watchers == nil {
+			m.watchers = make(map[string]*changeListener)
+		}
+		m.watchers[desc] = l
+	}
+	m.lock.Unlock()
+	select {
+	case change := <-l.queue:
+		return change, nil
+	case <-l.done:
+		return discovery.Change{}, ErrResolverClosed
+	case <-ctx.Done():
+		return discovery.Change{}, ctx.Err()
+	}
+}
+
+// Subscribe implements the ListenerResolver interface.
+func (m *multiClusterResolver) Subscribe(desc string, listener func(discovery.Change)) (func(), error) {
+	return m.subscribe(desc, listener, false)
+}
+
+// SubscribeDeltas implements the ListenerResolver interface.
+func (m *multiClusterResolver) SubscribeDeltas(desc string,
+	listener func(added, updated, removed []discovery.Instance)) (func(), error) {
+	return m.subscribe(desc, func(change discovery.Change) {
+		listener(change.Added, change.Updated, change.Removed)
+	}, true)
+}
+
+func (m *multiClusterResolver) subscribe(desc string, listener func(discovery.Change), deltas bool) (func(), error) {
+	l := newChangeListener(listener, defaultListenerQueueSize, &m.droppedChanges)
+	l.deltas = deltas
+	m.lock.Lock()
+	w, err := m.watch(desc, l)
+	m.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	go l.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { m.unsubscribe(w, l) })
+	}, nil
+}
+
+func (m *multiClusterResolver) unsubscribe(w *clusterWatch, l *changeListener) {
+	l.stop()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.watches[w.desc] != w || !w.remove(l) {
+		return
+	}
+	delete(m.watches, w.desc)
+	w.stop()
+}
+
+// DroppedListenerChanges implements the ResolverDiagnostics interface.
+func (m *multiClusterResolver) DroppedListenerChanges() uint64 {
+	return atomic.LoadUint64(&m.droppedChanges) + m.primary.DroppedListenerChanges() +
+		m.secondary.DroppedListenerChanges()
+}
+
+// ServiceMetadata implements the ServiceMetadataResolver interface, the metadata of the secondary cluster
+// is returned when the primary one fails.
+func (m *multiClusterResolver) ServiceMetadata(ctx context.Context, desc string) (map[string]string, error) {
+	metadata, err := serviceMetadataOf(ctx, m.primary, desc)
+	if err == nil {
+		return metadata, nil
+	}
+	metadata, secondaryErr := serviceMetadataOf(ctx, m.secondary, desc)
+	if secondaryErr != nil {
+		return nil, mergeErrors(err, secondaryErr)
+	}
+	return metadata, nil
+}
+
+// LastRouteTrace implements the ResolverDiagnostics interface, the trace of the primary cluster is returned first.
+func (m *multiClusterResolver) LastRouteTrace(desc string) (RouteTrace, bool) {
+	if trace, ok := m.primary.LastRouteTrace(desc); ok {
+		return trace, true
+	}
+	return m.secondary.LastRouteTrace(desc)
+}
+
+// ReportCallResult implements the CallResultResolver interface, the result is handed to both clusters since the
+// instance may be in any of them.
+func (m *multiClusterResolver) ReportCallResult(result CallResult) {
+	m.primary.ReportCallResult(result)
+	m.secondary.ReportCallResult(result)
+}
+
+// DroppedCallResults implements the ResolverDiagnostics interface.
+func (m *multiClusterResolver) DroppedCallResults() uint64 {
+	return m.primary.DroppedCallResults() + m.secondary.DroppedCallResults()
+}
+
+// ClassifyCall implements the CallResultResolver interface.
+func (m *multiClusterResolver) ClassifyCall(err error, ri rpcinfo.RPCInfo) CallStatus {
+	return m.primary.ClassifyCall(err, ri)
+}
+
+// ClassifyCallResult implements the CallResultResolver interface.
+func (m *multiClusterResolver) ClassifyCallResult(err error, ri rpcinfo.RPCInfo) model.RetStatus {
+	return m.primary.ClassifyCallResult(err, ri)
+}
+
+// StaticFallbacks implements the ResolverDiagnostics interface.
+func (m *multiClusterResolver) StaticFallbacks() uint64 {
+	return m.primary.StaticFallbacks() + m.secondary.StaticFallbacks()
+}
+
+// ChangeStats implements the ResolverDiagnostics interface.
+func (m *multiClusterResolver) ChangeStats() ChangeStats {
+	primary, secondary := m.primary.ChangeStats(), m.secondary.ChangeStats()
+	return ChangeStats{
+		Changes:    primary.Changes + secondary.Changes,
+		Added:      primary.Added + secondary.Added,
+		Updated:    primary.Updated + secondary.Updated,
+		Removed:    primary.Removed + secondary.Removed,
+		Summarized: primary.Summarized + secondary.Summarized,
+	}
+}
+
+// ZeroWeightResults implements the ResolverDiagnostics interface.
+func (m *multiClusterResolver) ZeroWeightResults() uint64 {
+	return m.primary.ZeroWeightResults() + m.secondary.ZeroWeightResults()
+}
+
+// LastRevision implements the ResolverDiagnostics interface, the revision of the primary cluster is returned first.
+func (m *multiClusterResolver) LastRevision(desc string) (string, bool) {
+	if revision, ok := m.primary.LastRevision(desc); ok {
+		return revision, true
+	}
+	return m.secondary.LastRevision(desc)
+}
+
+// SkippedEvents implements the ResolverDiagnostics interface.
+func (m *multiClusterResolver) SkippedEvents() uint64 {
+	return m.primary.SkippedEvents() + m.secondary.SkippedEvents()
+}
+
+// EvictedServices implements the ResolverDiagnostics interface.
+func (m *multiClusterResolver) EvictedServices() uint64 {
+	return m.primary.EvictedServices() + m.secondary.EvictedServices()
+}
+
+// IterateInstances implements the AllInstancesResolver interface, the instances of the clusters are iterated one
+// cluster after the other following the policy.
+func (m *multiClusterResolver) IterateInstances(ctx context.Context, desc string, fn func(InstanceInfo) bool) error {
+	ids := make(map[string]struct{})
+	addrs := make(map[string]struct{})
+	stopped := false
+	yielded := 0
+	err := m.primary.IterateInstances(ctx, desc, func(info InstanceInfo) bool {
+		yielded++
+		if m.policy == Union {
+			ids[info.ID] = struct{}{}
+			addrs[info.Host+":"+strconv.Itoa(info.Port)] = struct{}{}
+		}
+		stopped = !fn(info)
+		return !stopped
+	})
+	if stopped || (err == nil && m.policy == FailoverOnly) || (err == nil && m.policy == PreferPrimary && yielded > 0) {
+		return err
+	}
+	if err != nil && yielded > 0 {
+		// the instances of the primary cluster were partly iterated already.
+		return err
+	}
+	secondaryErr := m.secondary.IterateInstances(ctx, desc, func(info InstanceInfo) bool {
+		if _, ok := ids[info.ID]; ok && info.ID != "" {
+			return true
+		}
+		if _, ok := addrs[info.Host+":"+strconv.Itoa(info.Port)]; ok {
+			return true
+		}
+		return fn(info)
+	})
+	if err != nil && secondaryErr != nil {
+		return mergeErrors(err, secondaryErr)
+	}
+	return secondaryErr
+}
+
+// Refresh implements the RefreshResolver interface, both clusters are refreshed and the Change is the diff of the
+// merged instances with those of the watch of desc.
+func (m *multiClusterResolver) Refresh(ctx context.Context, desc string) (discovery.Change, error) {
+	var prev []discovery.Instance
+	m.lock.Lock()
+	if w, ok := m.watches[desc]; ok {
+		w.lock.Lock()
+		prev = w.merged
+		w.lock.Unlock()
+	}
+	m.lock.Unlock()
+	result, err := m.resolveBoth(ctx, desc, func(r extendedResolver) (discovery.Result, error) {
+		change, err := r.Refresh(ctx, desc)
+		return change.Result, err
+	})
+	if err != nil {
+		return discovery.Change{}, err
+	}
+	added, updated, removed := diffInstances(prev, result.Instances)
+	return discovery.Change{Result: result, Added: added, Updated: updated, Removed: removed}, nil
+}
+
+// UpdateEndpoints implements the EndpointsUpdater interface, it is ambiguous for two clusters and fails, the
+// primary and secondary resolvers are updated on their own instead.
+func (m *multiClusterResolver) UpdateEndpoints(ctx context.Context, endpoints []string) error {
+	return perrors.New("UpdateEndpoints of a multi-cluster resolver, update the primary or secondary resolver")
+}
+
+// WatchDeliveryLag implements the ResolverDiagnostics interface, the histograms of both clusters are added.
+func (m *multiClusterResolver) WatchDeliveryLag() LagHistogram {
+	h := m.primary.WatchDeliveryLag()
+	secondary := m.secondary.WatchDeliveryLag()
+	counts := make([]uint64, len(h.Counts))
+	for i := range counts {
+		counts[i] = h.Counts[i]
+		if i < len(secondary.Counts) {
+			counts[i] += secondary.Counts[i]
+		}
+	}
+	return LagHistogram{Buckets: h.Buckets, Counts: counts, Count: h.Count + secondary.Count, Sum: h.Sum + secondary.Sum}
+}
+
+// ResolveHistory implements the ResolverDiagnostics interface, the histories of both clusters are merged.
+func (m *multiClusterResolver) ResolveHistory() []ResolveHistoryEntry {
+	h := &resolveHistory{entries: make(map[string]time.Time)}
+	for _, entry := range append(m.primary.ResolveHistory(), m.secondary.ResolveHistory()...) {
+		if entry.LastUsed.After(h.entries[entry.Desc]) {
+			h.entries[entry.Desc] = entry.LastUsed
+		}
+	}
+	return h.sorted()
+}
+
+// Close implements the io.Closer interface, both resolvers are closed.
+func (m *multiClusterResolver) Close() error {
+	m.lock.Lock()
+	m.closed = true
+	watches := m.watches
+	m.watches = make(map[string]*clusterWatch)
+	for _, l := range m.watchers {
+		l.stop()
+	}
+	m.lock.Unlock()
+	for _, w := range watches {
+		w.stop()
+	}
+	err := m.primary.Close()
+	if secondaryErr := m.secondary.Close(); secondaryErr != nil {
+		if err != nil {
+			return mergeErrors(err, secondaryErr)
+		}
+		return secondaryErr
+	}
+	return err
+}
+
+// EffectiveOptions implements the OptionsReporter interface, the options are those of the primary resolver.
+func (m *multiClusterResolver) EffectiveOptions() OptionsSnapshot {
+	return m.primary.EffectiveOptions()
+}
+
+// Diff implements the Resolver interface.
+func (m *multiClusterResolver) Diff(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
+	return discovery.DefaultDiff(cacheKey, prev, next)
+}
+
+// Name implements the Resolver interface, it differs from the one of the clusters so that Kitex does not
+// share their caches.
+func (m *multiClusterResolver) Name() string {
+	return "PolarisMultiCluster"
+}
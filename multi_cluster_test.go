@@ -0,0 +1,243 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+const multiClusterDesc = polarisDefaultNamespace + ":" + serviceName
+
+// newTestClusters returns the backends and the resolvers of a primary and a secondary cluster.
+func newTestClusters(t *testing.T) (primary, secondary *polaristest.Backend, rs Resolver, policy func(MergePolicy) Resolver) {
+	primary, secondary = polaristest.NewBackend(), polaristest.NewBackend()
+	policy = func(merge MergePolicy) Resolver {
+		rs := NewMultiClusterResolver(newTestResolver(primary), newTestResolver(secondary), merge)
+		t.Cleanup(func() { rs.(io.Closer).Close() })
+		return rs
+	}
+	return primary, secondary, policy(Union), policy
+}
+
+// downCluster makes the calls of a cluster fail.
+func downCluster(backend *polaristest.Backend) {
+	for _, op := range []string{polaristest.OpGetInstances, polaristest.OpGetAllInstances, polaristest.OpWatchService} {
+		backend.SetFailureRate(op, 1)
+	}
+}
+
+func TestMultiClusterResolveUnion(t *testing.T) {
+	primary, secondary, rs, _ := newTestClusters(t)
+	shared := newTestListenerInstance(6666)
+	primary.AddInstances(shared, &polaristest.Instance{ID: "moved", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 7777})
+	// the same address and the same ID in the secondary cluster are deduplicated.
+	secondary.AddInstances(shared, &polaristest.Instance{ID: "moved", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.2", Port: 7777}, newTestListenerInstance(8888))
+
+	result, err := rs.Resolve(context.Background(), multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666", "127.0.0.1:7777", "127.0.0.1:8888"}, addrs(result.Instances))
+	require.True(t, result.Cacheable)
+	require.Equal(t, multiClusterDesc, result.CacheKey)
+}
+
+func TestMultiClusterResolvePreferPrimary(t *testing.T) {
+	primary, secondary, _, policy := newTestClusters(t)
+	rs := policy(PreferPrimary)
+	secondary.AddInstances(newTestListenerInstance(7777))
+
+	// the primary cluster has no instance.
+	result, err := rs.Resolve(context.Background(), multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(result.Instances))
+
+	primary.AddInstances(newTestListenerInstance(6666))
+	result, err = rs.Resolve(context.Background(), multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+
+	downCluster(primary)
+	result, err = rs.Resolve(context.Background(), multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(result.Instances))
+}
+
+func TestMultiClusterResolveFailoverOnly(t *testing.T) {
+	primary, secondary, _, policy := newTestClusters(t)
+	rs := policy(FailoverOnly)
+	secondary.AddInstances(newTestListenerInstance(7777))
+
+	// a primary cluster without instances is not failed over.
+	_, err := rs.Resolve(context.Background(), multiClusterDesc)
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance))
+
+	primary.AddInstances(newTestListenerInstance(6666))
+	result, err := rs.Resolve(context.Background(), multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+
+	downCluster(primary)
+	result, err = rs.Resolve(context.Background(), multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(result.Instances))
+}
+
+func TestMultiClusterResolveClusterDown(t *testing.T) {
+	primary, secondary, rs, _ := newTestClusters(t)
+	primary.AddInstances(newTestListenerInstance(6666))
+	secondary.AddInstances(newTestListenerInstance(7777))
+
+	downCluster(secondary)
+	result, err := rs.Resolve(context.Background(), multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+
+	downCluster(primary)
+	_, err = rs.Resolve(context.Background(), multiClusterDesc)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "both clusters failed")
+}
+
+// waitMerged returns the first Change whose Result has the addresses want.
+func waitMerged(t *testing.T, changes <-chan discovery.Change, want ...string) discovery.Change {
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case change := <-changes:
+			if got := addrs(change.Result.Instances); len(got) == len(want) {
+				require.ElementsMatch(t, want, got)
+				return change
+			}
+		case <-deadline:
+			t.Fatalf("merged instances %v not delivered", want)
+		}
+	}
+}
+
+func TestMultiClusterSubscribeUnion(t *testing.T) {
+	primary, secondary, rs, _ := newTestClusters(t)
+	ins := newTestListenerInstance(6666)
+	ins.Weight = 100
+	primary.AddInstances(ins)
+	ins.Weight = 50
+	secondary.AddInstances(ins, newTestListenerInstance(7777))
+
+	changes := make(chan discovery.Change, 8)
+	unsubscribe, err := rs.(ListenerResolver).Subscribe(multiClusterDesc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	// the copy of the secondary cluster is replaced once the instances of the primary cluster arrive.
+	for change := waitMerged(t, changes, "127.0.0.1:6666", "127.0.0.1:7777"); change.Result.Instances[0].Weight() != 100; {
+		change = waitMerged(t, changes, "127.0.0.1:6666", "127.0.0.1:7777")
+	}
+
+	// an instance of the secondary cluster already known from the primary one changes nothing.
+	require.Nil(t, secondary.UpdateInstance(secondary.Instances(polarisDefaultNamespace, serviceName)[0]))
+	secondary.AddInstances(newTestListenerInstance(8888))
+	change := nextChange(t, changes)
+	require.Equal(t, []string{"127.0.0.1:8888"}, addrs(change.Added))
+	require.Empty(t, change.Updated)
+	require.Empty(t, change.Removed)
+	require.Len(t, change.Result.Instances, 3)
+
+	// the copy of the secondary cluster replaces the removed one of the primary cluster.
+	primary.RemoveInstances(polarisDefaultNamespace, serviceName, primary.Instances(polarisDefaultNamespace, serviceName)[0].ID)
+	change = nextChange(t, changes)
+	require.Empty(t, change.Added)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Updated))
+	require.Equal(t, 50, change.Updated[0].Weight())
+	require.Empty(t, change.Removed)
+
+	secondary.RemoveInstances(polarisDefaultNamespace, serviceName, polarisDefaultNamespace+":"+serviceName+":127.0.0.1:7777")
+	change = nextChange(t, changes)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Removed))
+	require.ElementsMatch(t, []string{"127.0.0.1:6666", "127.0.0.1:8888"}, addrs(change.Result.Instances))
+}
+
+func TestMultiClusterSubscribePreferPrimary(t *testing.T) {
+	primary, secondary, _, policy := newTestClusters(t)
+	rs := policy(PreferPrimary)
+	secondary.AddInstances(newTestListenerInstance(7777))
+
+	changes := make(chan discovery.Change, 8)
+	unsubscribe, err := rs.(ListenerResolver).SubscribeDeltas(multiClusterDesc, func(added, updated, removed []discovery.Instance) {
+		changes <- discovery.Change{Added: added, Updated: updated, Removed: removed}
+	})
+	require.Nil(t, err)
+	defer unsubscribe()
+	change := nextChange(t, changes)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Added))
+
+	primary.AddInstances(newTestListenerInstance(6666))
+	change = nextChange(t, changes)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(change.Added))
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Removed))
+}
+
+func TestMultiClusterWatcherClusterDown(t *testing.T) {
+	primary, secondary, _, policy := newTestClusters(t)
+	rs := policy(FailoverOnly)
+	primary.AddInstances(newTestListenerInstance(6666))
+	secondary.AddInstances(newTestListenerInstance(7777))
+	downCluster(primary)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	change, err := rs.Watcher(ctx, multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Result.Instances))
+
+	secondary.AddInstances(newTestListenerInstance(8888))
+	change, err = rs.Watcher(ctx, multiClusterDesc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:8888"}, addrs(change.Added))
+
+	downCluster(secondary)
+	rs = policy(Union)
+	_, err = rs.Watcher(ctx, multiClusterDesc)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "both clusters failed")
+}
+
+func TestMultiClusterIterateInstances(t *testing.T) {
+	primary, secondary, rs, policy := newTestClusters(t)
+	primary.AddInstances(newTestListenerInstance(6666))
+	secondary.AddInstances(newTestListenerInstance(6666), newTestListenerInstance(7777))
+
+	iterate := func(rs Resolver) []int {
+		var ports []int
+		require.Nil(t, rs.(AllInstancesResolver).IterateInstances(context.Background(), multiClusterDesc, func(info InstanceInfo) bool {
+			ports = append(ports, info.Port)
+			return true
+		}))
+		return ports
+	}
+	require.Equal(t, []int{6666, 7777}, iterate(rs))
+	require.Equal(t, []int{6666}, iterate(policy(PreferPrimary)))
+	downCluster(primary)
+	require.Equal(t, []int{6666, 7777}, iterate(policy(FailoverOnly)))
+}
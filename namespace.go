@@ -0,0 +1,139 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+)
+
+const namespaceCreateTimeout = 5 * time.Second
+
+// isNamespaceNotFound tells whether err is the polaris error of a missing namespace.
+func isNamespaceNotFound(err error) bool {
+	var sdkErr model.SDKError
+	return perrors.As(err, &sdkErr) && sdkErr.ServerCode() == namingpb.NotFoundNamespace
+}
+
+// isServiceNotFound tells whether err is the polaris error of a missing service, the consumer API
+// reports it with an SDK code and the provider API with the code of the server.
+func isServiceNotFound(err error) bool {
+	var sdkErr model.SDKError
+	if !perrors.As(err, &sdkErr) {
+		return false
+	}
+	switch sdkErr.ServerCode() {
+	case namingpb.NotFoundService, namingpb.NotFoundResource:
+		return true
+	}
+	return sdkErr.ErrorCode() == model.ErrCodeServiceNotFound
+}
+
+// notFoundError turns the polaris errors of a missing namespace or service into a NotFoundError,
+// the other errors are returned as is.
+func notFoundError(err error, namespace, service string) error {
+	if _, ok := err.(*NotFoundError); ok {
+		return err
+	}
+	switch {
+	case isNamespaceNotFound(err):
+		return &NotFoundError{Namespace: namespace, Service: service, Kind: ErrNamespaceNotFound, Err: err}
+	case isServiceNotFound(err):
+		return &NotFoundError{Namespace: namespace, Service: service, Kind: ErrServiceNotFound, Err: err}
+	}
+	return err
+}
+
+// registerCreatingNamespace creates the namespace of a registration polaris rejected for it, see
+// WithAutoCreateNamespace, and registers once more.
+func (svr *polarisRegistry) registerCreatingNamespace(param *api.InstanceRegisterRequest,
+	registerErr error) (*model.InstanceRegisterResponse, error) {
+	createNamespace := svr.createNamespace
+	if createNamespace == nil {
+		createNamespace = svr.createNamespaceByHTTP
+	}
+	if err := createNamespace(param.Namespace); err != nil {
+		return nil, &NamespaceCreateError{Namespace: param.Namespace, RegisterErr: registerErr, CreateErr: err}
+	}
+	log.GetBaseLogger().Infof("[Polaris registry] created namespace %s", param.Namespace)
+	resp, err := svr.providerAPI().Register(param)
+	return resp, notFoundError(err, param.Namespace, param.Service)
+}
+
+// createNamespaceByHTTP creates namespace with the admin API of WithAdminAPI, an existing namespace is fine.
+func (svr *polarisRegistry) createNamespaceByHTTP(namespace string) error {
+	if svr.opts.adminAddress == "" || svr.opts.adminToken == "" {
+		return perrors.New("creating a namespace needs the admin API address and token of WithAdminAPI")
+	}
+	address := svr.opts.adminAddress
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	body, err := json.Marshal([]map[string]string{{"name": namespace}})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: namespaceCreateTimeout}
+	return postNamespace(client, strings.TrimSuffix(address, "/")+"/naming/v1/namespaces", svr.opts.adminToken, body)
+}
+
+// namespaceAPIError is the answer of the polaris server refusing to create a namespace.
+type namespaceAPIError struct {
+	Code uint32 `json:"code"`
+	Info string `json:"info"`
+}
+
+func (e *namespaceAPIError) Error() string {
+	return fmt.Sprintf("polaris server code %d: %s", e.Code, e.Info)
+}
+
+func postNamespace(client *http.Client, url, token string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Polaris-Token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	answer := &namespaceAPIError{}
+	if err := json.Unmarshal(data, answer); err != nil {
+		return perrors.Errorf("polaris server answered %s: %s", resp.Status, data)
+	}
+	if answer.Code == namingpb.ExecuteSuccess || answer.Code == namingpb.ExistedResource {
+		return nil
+	}
+	return answer
+}
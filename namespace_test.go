@@ -0,0 +1,138 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// namespaceProvider rejects the registrations in namespaces that were not created.
+type namespaceProvider struct {
+	*polaristest.Backend
+	lock       sync.Mutex
+	namespaces map[string]bool
+}
+
+func (p *namespaceProvider) Register(req *api.InstanceRegisterRequest) (*model.InstanceRegisterResponse, error) {
+	p.lock.Lock()
+	exists := p.namespaces[req.Namespace]
+	p.lock.Unlock()
+	if !exists {
+		return nil, model.NewServerSDKError(namingpb.NotFoundNamespace, "not found namespace", nil,
+			"fail to register instance")
+	}
+	return p.Backend.Register(req)
+}
+
+func (p *namespaceProvider) create(namespace string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.namespaces[namespace] = true
+	return nil
+}
+
+func TestAutoCreateNamespace(t *testing.T) {
+	backend := polaristest.NewBackend()
+	provider := &namespaceProvider{Backend: backend, namespaces: map[string]bool{}}
+	rg := newTestRegistry(backend, WithAutoCreateNamespace(true), WithNamespace("pr-1234"))
+	rg.provider = provider
+	var created []string
+	rg.createNamespace = func(namespace string) error {
+		created = append(created, namespace)
+		return provider.create(namespace)
+	}
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	require.Equal(t, []string{"pr-1234"}, created)
+	require.Len(t, backend.Instances("pr-1234", serviceName), 1)
+	require.Nil(t, rg.Deregister(info))
+
+	// the namespace now exists.
+	require.Nil(t, rg.Register(info))
+	require.Len(t, created, 1)
+	require.Nil(t, rg.Deregister(info))
+}
+
+func TestAutoCreateNamespaceForbidden(t *testing.T) {
+	backend := polaristest.NewBackend()
+	provider := &namespaceProvider{Backend: backend, namespaces: map[string]bool{}}
+	rg := newTestRegistry(backend, WithAutoCreateNamespace(true), WithNamespace("pr-1234"))
+	rg.provider = provider
+	rg.createNamespace = func(namespace string) error {
+		return &namespaceAPIError{Code: namingpb.Unauthorized, Info: "forbidden"}
+	}
+	err := rg.Register(newTestInfo("127.0.0.1:6666", nil))
+	var createErr *NamespaceCreateError
+	require.True(t, errors.As(err, &createErr), err)
+	require.Equal(t, "pr-1234", createErr.Namespace)
+	require.True(t, isNamespaceNotFound(createErr.RegisterErr))
+	require.Contains(t, err.Error(), "forbidden")
+	require.Empty(t, backend.Instances("pr-1234", serviceName))
+
+	// off by default.
+	rg = newTestRegistry(backend, WithNamespace("pr-1234"))
+	rg.provider = provider
+	rg.createNamespace = provider.create
+	err = rg.Register(newTestInfo("127.0.0.1:6666", nil))
+	require.True(t, isNamespaceNotFound(err), err)
+}
+
+func TestPostNamespace(t *testing.T) {
+	var token, path string
+	var body []map[string]string
+	answer := `{"code":200000}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Polaris-Token")
+		path = r.URL.Path
+		data, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		_, _ = w.Write([]byte(answer))
+	}))
+	defer server.Close()
+
+	require.Nil(t, postNamespace(server.Client(), server.URL, "t0ken", []byte(`[{"name":"pr-1234"}]`)))
+	require.Equal(t, "t0ken", token)
+	require.Equal(t, []map[string]string{{"name": "pr-1234"}}, body)
+
+	answer = `{"code":400201,"info":"existed resource"}`
+	require.Nil(t, postNamespace(server.Client(), server.URL, "t0ken", nil))
+	answer = `{"code":401000,"info":"unauthorized"}`
+	require.EqualError(t, postNamespace(server.Client(), server.URL, "t0ken", nil), "polaris server code 401000: unauthorized")
+
+	rg := newTestRegistry(polaristest.NewBackend(), WithServiceToken("s3cret"))
+	require.EqualError(t, rg.createNamespaceByHTTP("pr-1234"),
+		"creating a namespace needs the admin API address and token of WithAdminAPI")
+
+	answer = `{"code":200000}`
+	rg = newTestRegistry(polaristest.NewBackend(), WithAdminAPI(server.URL+"/", "adm1n"))
+	require.Nil(t, rg.createNamespaceByHTTP("pr-5678"))
+	require.Equal(t, "adm1n", token)
+	require.Equal(t, "/naming/v1/namespaces", path)
+	require.Equal(t, []map[string]string{{"name": "pr-5678"}}, body)
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// notFoundConsumer answers the queries of the unknown namespaces and services the way polaris-go does.
+type notFoundConsumer struct {
+	*polaristest.Backend
+	namespaces map[string]bool
+	services   map[string]bool
+}
+
+func (c *notFoundConsumer) GetInstances(req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	if !c.namespaces[req.Namespace] {
+		return nil, model.NewServerSDKError(namingpb.NotFoundNamespace, "not found namespace", nil,
+			"server error from 127.0.0.1:8091: not found namespace")
+	}
+	if !c.services[req.Service] {
+		return nil, model.NewSDKError(model.ErrCodeServiceNotFound, nil, "service %s not found", req.Service)
+	}
+	return c.Backend.GetInstances(req)
+}
+
+func TestResolveNotFound(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend)
+	rs.consumer = &notFoundConsumer{
+		Backend:    backend,
+		namespaces: map[string]bool{polarisDefaultNamespace: true},
+		services:   map[string]bool{serviceName: true},
+	}
+
+	desc := rs.Target(context.Background(), rpcinfo.NewEndpointInfo(serviceName, "", nil,
+		map[string]string{namespaceTagKey: "Production"}))
+	_, err := rs.Resolve(context.Background(), desc)
+	require.True(t, errors.Is(err, ErrNamespaceNotFound), err)
+	require.False(t, errors.Is(err, ErrServiceNotFound))
+	var notFound *NotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, "Production", notFound.Namespace)
+	require.Equal(t, serviceName, notFound.Service)
+	require.Contains(t, err.Error(), "namespace Production of service "+serviceName+" not found")
+
+	desc = rs.Target(context.Background(), rpcinfo.NewEndpointInfo("missing.api", "", nil, nil))
+	_, err = rs.Resolve(context.Background(), desc)
+	require.True(t, errors.Is(err, ErrServiceNotFound), err)
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, polarisDefaultNamespace, notFound.Namespace)
+	require.Equal(t, "missing.api", notFound.Service)
+	require.Contains(t, err.Error(), "service default:missing.api not found")
+
+	_, err = rs.discover(context.Background(), polarisDefaultNamespace, "missing.api")
+	require.True(t, errors.Is(err, ErrServiceNotFound), err)
+
+	// the service exists without instances.
+	desc = rs.Target(context.Background(), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil))
+	_, err = rs.Resolve(context.Background(), desc)
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance), err)
+	require.Equal(t, polarisDefaultNamespace, noInstance.Namespace)
+	require.Equal(t, serviceName, noInstance.Service)
+	require.False(t, errors.Is(err, ErrNamespaceNotFound))
+	require.False(t, errors.Is(err, ErrServiceNotFound))
+}
+
+// notFoundProvider rejects the registrations of the services polaris does not know.
+type notFoundProvider struct {
+	*polaristest.Backend
+}
+
+func (p *notFoundProvider) Register(req *api.InstanceRegisterRequest) (*model.InstanceRegisterResponse, error) {
+	return nil, model.NewServerSDKError(namingpb.NotFoundService, "not found service", nil,
+		"fail to register instance")
+}
+
+func TestRegisterNotFound(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithNamespace("pr-1234"))
+	rg.provider = &namespaceProvider{Backend: backend, namespaces: map[string]bool{}}
+	err := rg.Register(newTestInfo("127.0.0.1:6666", nil))
+	require.True(t, errors.Is(err, ErrNamespaceNotFound), err)
+	var notFound *NotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, "pr-1234", notFound.Namespace)
+	require.Equal(t, serviceName, notFound.Service)
+
+	rg = newTestRegistry(backend)
+	rg.provider = &notFoundProvider{Backend: backend}
+	err = rg.Register(newTestInfo("127.0.0.1:6666", nil))
+	require.True(t, errors.Is(err, ErrServiceNotFound), err)
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, polarisDefaultNamespace, notFound.Namespace)
+	require.Equal(t, serviceName, notFound.Service)
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// ErrConflictingOptions is matched with errors.Is by the ConflictingOptionsError of the constructors.
+var ErrConflictingOptions = errors.New("conflicting polaris options")
+
+// OptionConflict is a pair of options that cannot be combined, Reason tells what happens when they are.
+type OptionConflict struct {
+	First  string
+	Second string
+	Reason string
+}
+
+// String returns the pair and the reason of the conflict.
+func (c OptionConflict) String() string {
+	return c.First + " with " + c.Second + ": " + c.Reason
+}
+
+// ConflictingOptionsError is returned by NewPolarisResolver and NewPolarisRegistry given options that
+// cannot be combined, unless WithLenientOptions is set. It matches ErrConflictingOptions with errors.Is.
+type ConflictingOptionsError struct {
+	Conflicts []OptionConflict
+}
+
+// Error implements the error interface.
+func (e *ConflictingOptionsError) Error() string {
+	conflicts := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		conflicts = append(conflicts, c.String())
+	}
+	return fmt.Sprintf("%v: %s", ErrConflictingOptions, strings.Join(conflicts, "; "))
+}
+
+// Is tells whether target is ErrConflictingOptions.
+func (e *ConflictingOptionsError) Is(target error) bool {
+	return target == ErrConflictingOptions
+}
+
+// optionConflict is an entry of optionConflicts, conflict tells whether both options are set.
+type optionConflict struct {
+	OptionConflict
+	conflict func(o *options) bool
+}
+
+// givenAPIs is the first option of the conflicts with the options configuring the SDK context, which
+// is not built by this package when the APIs are given.
+const givenAPIs = "WithConsumerAPI or WithProviderAPI"
+
+const givenAPIsReason = "configure the given polaris-go APIs instead"
+
+func (o *options) givenAPIs() bool {
+	return o.consumerAPI != nil || o.providerAPI != nil
+}
+
+// optionConflicts is the table of the incompatible options, new entries go here.
+var optionConflicts = []optionConflict{
+	{
+		OptionConflict{givenAPIs, "WithDisableStatReporter", givenAPIsReason},
+		func(o *options) bool { return o.givenAPIs() && o.disableStatReporter },
+	},
+	{
+		OptionConflict{givenAPIs, "WithDisableLocationProvider", givenAPIsReason},
+		func(o *options) bool { return o.givenAPIs() && o.disableLocationProvider },
+	},
+	{
+		OptionConflict{givenAPIs, "WithRouterChain", givenAPIsReason},
+		func(o *options) bool { return o.givenAPIs() && len(o.routerChain) > 0 },
+	},
+	{
+		OptionConflict{givenAPIs, "WithDedicatedSDKContext", "the given APIs run on the SDK context of the caller"},
+		func(o *options) bool { return o.givenAPIs() && o.dedicatedSDKContext },
+	},
+	{
+		OptionConflict{givenAPIs, "WithSDKLogDir, WithSDKLogLevel or WithSDKLogDiscard",
+			"the loggers are configured when the SDK context is built, configure them before building the APIs"},
+		func(o *options) bool {
+			return o.givenAPIs() && (o.sdkLogDir != "" || o.sdkLogLevel != "" || o.sdkLogDiscard)
+		},
+	},
+	{
+		OptionConflict{"WithSDKLogDiscard", "WithSDKLogDir", "no log file is written"},
+		func(o *options) bool { return o.sdkLogDiscard && o.sdkLogDir != "" },
+	},
+	{
+		OptionConflict{"WithDisableLocationProvider", "WithRouterChain", "the nearby based router is removed from the chain"},
+		func(o *options) bool {
+			return o.disableLocationProvider && contains(o.routerChain, config.DefaultServiceRouterNearbyBased)
+		},
+	},
+	{
+		OptionConflict{"WithLocationProvider", "WithCloudLocationDetection", "the cloud detection never runs"},
+		func(o *options) bool { return o.location != nil && o.cloudLocation },
+	},
+}
+
+// conflicts returns the conflicts of the set options in the order of optionConflicts.
+func (o *options) conflicts() []OptionConflict {
+	var conflicts []OptionConflict
+	for _, c := range optionConflicts {
+		if c.conflict(o) {
+			conflicts = append(conflicts, c.OptionConflict)
+		}
+	}
+	return conflicts
+}
+
+// validate returns a ConflictingOptionsError for the conflicts of the set options, under
+// WithLenientOptions they are logged by component instead.
+func (o *options) validate(component string) error {
+	conflicts := o.conflicts()
+	if len(conflicts) == 0 {
+		return nil
+	}
+	if !o.lenientOptions {
+		return &ConflictingOptionsError{Conflicts: conflicts}
+	}
+	for _, c := range conflicts {
+		if logger := o.structuredLogger; logger != nil {
+			logger.WarnContext(context.Background(), "polaris options conflict", "component", component,
+				"first", c.First, "second", c.Second, "reason", c.Reason)
+		} else {
+			log.GetBaseLogger().Warnf("[Polaris %s] conflicting options %s", component, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionConflicts(t *testing.T) {
+	backend := polaristest.NewBackend()
+	cases := []struct {
+		opts   []Option
+		second string
+	}{
+		{[]Option{WithConsumerAPI(backend), WithDisableStatReporter(true)}, "WithDisableStatReporter"},
+		{[]Option{WithProviderAPI(backend), WithDisableLocationProvider(true)}, "WithDisableLocationProvider"},
+		{[]Option{WithConsumerAPI(backend), WithRouterChain([]string{"ruleBasedRouter"})}, "WithRouterChain"},
+		{[]Option{WithConsumerAPI(backend), WithDedicatedSDKContext()}, "WithDedicatedSDKContext"},
+		{[]Option{WithProviderAPI(backend), WithSDKLogLevel("warn")}, "WithSDKLogDir, WithSDKLogLevel or WithSDKLogDiscard"},
+		{[]Option{WithSDKLogDiscard(), WithSDKLogDir("/var/log/polaris")}, "WithSDKLogDir"},
+		{[]Option{WithDisableLocationProvider(true), WithRouterChain([]string{"nearbyBasedRouter"})}, "WithRouterChain"},
+		{[]Option{WithLocationProvider(EnvLocationProvider()), WithCloudLocationDetection(true)}, "WithCloudLocationDetection"},
+	}
+	require.Len(t, cases, len(optionConflicts), "a conflict of the table is not tested")
+	for i, c := range cases {
+		conflicts := newOptions(c.opts).conflicts()
+		require.Equal(t, []OptionConflict{optionConflicts[i].OptionConflict}, conflicts, c.second)
+		require.Equal(t, c.second, conflicts[0].Second)
+	}
+
+	// the options that are set alone do not conflict.
+	require.Empty(t, newOptions([]Option{WithConsumerAPI(backend), WithProviderAPI(backend)}).conflicts())
+	require.Empty(t, newOptions([]Option{WithDisableStatReporter(true), WithDedicatedSDKContext(),
+		WithSDKLogDiscard(), WithCloudLocationDetection(true)}).conflicts())
+}
+
+func TestConflictingOptionsError(t *testing.T) {
+	backend := polaristest.NewBackend()
+	_, err := NewPolarisResolver(nil, WithConsumerAPI(backend), WithDedicatedSDKContext(),
+		WithSDKLogDiscard(), WithSDKLogDir("/var/log/polaris"))
+	var conflictErr *ConflictingOptionsError
+	require.True(t, errors.As(err, &conflictErr), err)
+	require.True(t, errors.Is(err, ErrConflictingOptions))
+	require.Len(t, conflictErr.Conflicts, 3)
+	require.Equal(t, "conflicting polaris options: "+
+		"WithConsumerAPI or WithProviderAPI with WithDedicatedSDKContext: the given APIs run on the SDK context of the caller; "+
+		"WithConsumerAPI or WithProviderAPI with WithSDKLogDir, WithSDKLogLevel or WithSDKLogDiscard: "+
+		"the loggers are configured when the SDK context is built, configure them before building the APIs; "+
+		"WithSDKLogDiscard with WithSDKLogDir: no log file is written", err.Error())
+
+	_, err = NewPolarisRegistry(nil, WithLocationProvider(EnvLocationProvider()), WithCloudLocationDetection(true))
+	require.True(t, errors.Is(err, ErrConflictingOptions), err)
+}
+
+func TestLenientOptions(t *testing.T) {
+	backend := polaristest.NewBackend()
+	logger := &structuredLogger{}
+	rs, err := NewPolarisResolver(nil, WithConsumerAPI(backend), WithDisableStatReporter(true),
+		WithLenientOptions(), WithStructuredLogger(logger))
+	require.Nil(t, err)
+	defer rs.(io.Closer).Close()
+	require.Len(t, logger.records, 1)
+	require.Equal(t, structuredRecord{level: "warn", msg: "polaris options conflict", fields: map[string]interface{}{
+		"component": "resolver", "first": givenAPIs, "second": "WithDisableStatReporter", "reason": givenAPIsReason,
+	}}, logger.records[0])
+
+	rg, err := NewPolarisRegistry(nil, WithProviderAPI(backend), WithDisableStatReporter(true), WithLenientOptions())
+	require.Nil(t, err)
+	require.NotNil(t, rg)
+}
@@ -0,0 +1,972 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// Option is the option used to configure the polaris SDK config, registry and resolver.
+// Options that do not apply to a component are ignored by it.
+type Option func(o *options)
+
+type options struct {
+	disableStatReporter      bool
+	disableLocationProvider  bool
+	dedicatedSDKContext      bool
+	autoMetadata             bool
+	initialSyncTimeout       time.Duration
+	watchWorkerPoolSize      int
+	beforeResolve            func(ctx context.Context, desc string)
+	afterResolve             func(ctx context.Context, desc string, result discovery.Result, err error)
+	beforeRegister           func(info *registry.Info)
+	afterRegister            func(info *registry.Info, err error)
+	beforeDeregister         func(info *registry.Info)
+	afterDeregister          func(info *registry.Info, err error)
+	listenerQueueSize        int
+	targetTagKeys            []string
+	targetTagDefaults        map[string]string
+	serviceMetadataTTL       time.Duration
+	weightClamp              bool
+	weightMin                int
+	weightMax                int
+	weightTargetSum          int
+	routeDebug               bool
+	deregisterTimeout        time.Duration
+	callResultFlushInterval  time.Duration
+	callResultMaxBuckets     int
+	callClassifier           CallClassifier
+	adaptiveScoring          bool
+	protocolFilter           string
+	postRegisterVerification time.Duration
+	codec                    DescriptionCodec
+	strictDescriptions       bool
+	systemServiceMode        bool
+	preferUDS                bool
+	errorLogInterval         time.Duration
+	tlsCapable               *bool
+	requireTLS               bool
+	strictDeregister         bool
+	resolveHistoryPath       string
+	resolveHistoryEntries    int
+	resolveHistoryMaxAge     time.Duration
+	sdkLogDir                string
+	sdkLogLevel              string
+	sdkLogDiscard            bool
+	zeroWeightPolicy         ZeroWeightPolicy
+	onCallResultReport       func(report CallResultReport)
+	lenientOptions           bool
+	routerChain              []string
+	changeLogCap             int
+	additionalPorts          map[string]int
+	watchTimeout             time.Duration
+	watchLagThreshold        time.Duration
+	heartbeatJitter          float64
+	heartbeatFailureBudget   int
+	onHeartbeatLost          func(err error)
+	registerRetry            bool
+	registerRetryAttempts    int
+	registerRetryBackoff     Backoff
+	onRegistered             func()
+	onInstancesRemoved       func(instances []discovery.Instance)
+	autoCreateNamespace      bool
+	adminAddress             string
+	adminToken               string
+	canary                   string
+	removalGracePeriod       time.Duration
+	removalResidualWeight    int
+	weightUpdateThreshold    float64
+	weightSyncInterval       time.Duration
+	setName                  string
+	setStrict                bool
+	setMetadataKeys          []string
+	shardMetadata            string
+	shardStrict              bool
+	minInstanceAge           time.Duration
+	defaultInstanceWeight    int
+	snapshotDir              string
+	snapshotCodec            SnapshotCodec
+	rateLimitLabels          LabelExtractor
+	rateLimitMetaKeys        []string
+	rateLimitMaxLabels       int
+	maxTrackedServices       int
+	metadataReconciliation   time.Duration
+	selfWatch                bool
+	onExternallyDeregistered func()
+	namespace                string
+	serviceToken             string
+	healthyOnly              bool
+	heartbeatInterval        time.Duration
+	resolveTimeout           time.Duration
+	serverTimeProbe          func() (time.Time, error)
+	clockSkewThreshold       time.Duration
+	localityLevels           []string
+	instanceLogSampling      int
+	structuredLogger         StructuredLogger
+	logFields                func(ctx context.Context) []interface{}
+	consumerAPI              api.ConsumerAPI
+	providerAPI              api.ProviderAPI
+	staticFallbacks          map[string][]discovery.Instance
+	metadataTagPrefixes      []string
+	registerIsolated         bool
+	location                 LocationProvider
+	cloudLocation            bool
+	locationTimeout          time.Duration
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		autoMetadata:           true,
+		heartbeatJitter:        defaultHeartbeatJitter,
+		heartbeatFailureBudget: defaultHeartbeatFailureBudget,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// defaultNamespace returns the namespace set by WithNamespace or the polaris default namespace,
+// SystemNamespace with WithSystemServiceMode.
+func (o *options) defaultNamespace() string {
+	if o.namespace != "" {
+		return o.namespace
+	}
+	if o.systemServiceMode {
+		return SystemNamespace
+	}
+	return polarisDefaultNamespace
+}
+
+// WithDisableStatReporter turns off the stat reporter plugins of the polaris SDK.
+func WithDisableStatReporter(disable bool) Option {
+	return func(o *options) {
+		o.disableStatReporter = disable
+	}
+}
+
+// WithDisableLocationProvider stops the polaris SDK from depending on the client location,
+// the nearby based router that consumes it is removed from the router chain.
+func WithDisableLocationProvider(disable bool) Option {
+	return func(o *options) {
+		o.disableLocationProvider = disable
+	}
+}
+
+// WithDedicatedSDKContext gives the resolver or registry its own SDK context. By default the ones built
+// with the same endpoints and SDK options share one, destroyed when the last resolver or registry sharing it
+// is closed. The users of a shared context watching the same service each get every event of the service.
+func WithDedicatedSDKContext() Option {
+	return func(o *options) {
+		o.dedicatedSDKContext = true
+	}
+}
+
+// WithAutoMetadata controls whether the registry injects start-time, kitex-version and
+// registry-polaris-version metadata into registered instances, it is enabled by default.
+func WithAutoMetadata(enable bool) Option {
+	return func(o *options) {
+		o.autoMetadata = enable
+	}
+}
+
+// WithInitialSyncTimeout makes Watcher wait up to timeout for the first instances when the
+// service is still empty at watch time, instead of reporting zero instances right away.
+func WithInitialSyncTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.initialSyncTimeout = timeout
+	}
+}
+
+// WithWatchWorkerPool sets how many goroutines consume the polaris event channels of all
+// watched services, the default is 4 regardless of the number of services.
+func WithWatchWorkerPool(size int) Option {
+	return func(o *options) {
+		o.watchWorkerPoolSize = size
+	}
+}
+
+// WithResolveHooks sets functions invoked before and after every Resolve, after receives the final
+// filtered result. Either may be nil, panics in hooks are recovered and logged.
+func WithResolveHooks(before func(ctx context.Context, desc string),
+	after func(ctx context.Context, desc string, result discovery.Result, err error)) Option {
+	return func(o *options) {
+		o.beforeResolve = before
+		o.afterResolve = after
+	}
+}
+
+// WithRegisterHooks sets functions invoked before and after every Register.
+func WithRegisterHooks(before func(info *registry.Info), after func(info *registry.Info, err error)) Option {
+	return func(o *options) {
+		o.beforeRegister = before
+		o.afterRegister = after
+	}
+}
+
+// WithDeregisterHooks sets functions invoked before and after every Deregister.
+func WithDeregisterHooks(before func(info *registry.Info), after func(info *registry.Info, err error)) Option {
+	return func(o *options) {
+		o.beforeDeregister = before
+		o.afterDeregister = after
+	}
+}
+
+// WithListenerQueueSize sets how many Changes are queued for each listener added by Subscribe
+// before the oldest ones are dropped, the default is 16.
+func WithListenerQueueSize(size int) Option {
+	return func(o *options) {
+		o.listenerQueueSize = size
+	}
+}
+
+// WithTargetTagKeys sets the ordered Kitex endpoint tag keys that identify a target, the default is
+// only "namespace". Every key other than namespace becomes an instance metadata filter in Resolve.
+func WithTargetTagKeys(keys ...string) Option {
+	return func(o *options) {
+		o.targetTagKeys = keys
+	}
+}
+
+// WithTargetTagDefaults sets the values used for target tags missing on the client,
+// a tag without value nor default is not filtered on.
+func WithTargetTagDefaults(defaults map[string]string) Option {
+	return func(o *options) {
+		o.targetTagDefaults = defaults
+	}
+}
+
+// WithServiceMetadataTTL sets how long the results of ServiceMetadata are cached, the default is 30s.
+func WithServiceMetadataTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.serviceMetadataTTL = ttl
+	}
+}
+
+// WithDefaultWeight sets the weight registered for the registry.Info weights below zero and given to the
+// resolved instances without weight, the default is discovery.DefaultWeight. A zero registry.Info weight
+// registers a drained instance instead, see TagDrained.
+func WithDefaultWeight(weight int) Option {
+	return func(o *options) {
+		o.defaultInstanceWeight = weight
+	}
+}
+
+// WithWeightClamp bounds the weights of resolved instances to [min, max].
+func WithWeightClamp(min, max int) Option {
+	return func(o *options) {
+		o.weightClamp = true
+		o.weightMin = min
+		o.weightMax = max
+	}
+}
+
+// WithWeightNormalization rescales the weights of resolved instances proportionally so that they
+// sum up to about targetSum, instances share it equally when every weight is zero.
+func WithWeightNormalization(targetSum int) Option {
+	return func(o *options) {
+		o.weightTargetSum = targetSum
+	}
+}
+
+// WithRouteDebug records and logs how the instances of every Resolve were selected, which costs
+// an extra polaris query per Resolve. The last trace of a description is returned by LastRouteTrace.
+func WithRouteDebug(enable bool) Option {
+	return func(o *options) {
+		o.routeDebug = enable
+	}
+}
+
+// WithDeregisterTimeout bounds every Deregister call to polaris. On timeout Deregister returns a
+// DeregisterTimeoutError but still stops the heartbeats and forgets the instance locally.
+func WithDeregisterTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.deregisterTimeout = timeout
+	}
+}
+
+// WithCallResultFlushInterval sets how often the aggregated call results are reported to polaris,
+// the default is 1s.
+func WithCallResultFlushInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.callResultFlushInterval = interval
+	}
+}
+
+// WithCallResultBufferSize bounds the number of distinct instance and return code pairs aggregated
+// between two flushes, results of new pairs are dropped beyond it. The default is 10000.
+func WithCallResultBufferSize(size int) Option {
+	return func(o *options) {
+		o.callResultMaxBuckets = size
+	}
+}
+
+// WithCallClassifier sets how CallResultResolver.ClassifyCall maps the error of a call to the status reported
+// to polaris, the default is DefaultCallClassifier.
+func WithCallClassifier(classifier CallClassifier) Option {
+	return func(o *options) {
+		o.callClassifier = classifier
+	}
+}
+
+// WithCallResultClassifier sets a classifier returning raw polaris-go statuses, see WithCallClassifier.
+//
+// Deprecated: use WithCallClassifier.
+func WithCallResultClassifier(classifier func(err error, ri rpcinfo.RPCInfo) model.RetStatus) Option {
+	if classifier == nil {
+		return WithCallClassifier(nil)
+	}
+	return WithCallClassifier(func(err error, ri rpcinfo.RPCInfo) CallStatus {
+		return CallStatusFromRaw(classifier(err, ri))
+	})
+}
+
+// WithAdaptiveInstanceScoring makes Resolve skip the instances whose circuit breaker is open and score
+// the weight w of the others as round(w * health * (1 - failureRatio)), clamped into [max(1, w/10), w].
+// health is 1 for the instances healthy in polaris and 0.5 otherwise, failureRatio is the ratio of the
+// failed calls given to ReportCallResult over the last two flush intervals, zero below 10 calls.
+func WithAdaptiveInstanceScoring(enable bool) Option {
+	return func(o *options) {
+		o.adaptiveScoring = enable
+	}
+}
+
+// WithProtocolFilter makes Resolve and the watch Changes keep only the instances declaring the Kitex
+// transport protocol proto, like transport.GRPC.String(), in their TagProtocol metadata or polaris
+// protocol field. Nothing is filtered when no instance of the service declares a protocol.
+func WithProtocolFilter(proto string) Option {
+	return func(o *options) {
+		o.protocolFilter = proto
+	}
+}
+
+// WithPostRegisterVerification makes Register check with VerifyRegistration that the instance is
+// visible from the consumer API within timeout. Register deregisters the instance and fails otherwise.
+func WithPostRegisterVerification(timeout time.Duration) Option {
+	return func(o *options) {
+		o.postRegisterVerification = timeout
+	}
+}
+
+// WithDescriptionCodec replaces the format of the descriptions built by Target and parsed by the
+// resolver, the default is DefaultDescriptionCodec.
+func WithDescriptionCodec(codec DescriptionCodec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithStrictDescriptions makes the resolver check that every description it is given encodes back to itself
+// with the DescriptionCodec, the calls on a description that does not fail with an ErrDescriptionMismatch
+// DescriptionError. It catches a codec whose Encode and Decode disagree, and hand-written descriptions in
+// another form than the one of Target, like "user.api" for "default:user.api".
+func WithStrictDescriptions(strict bool) Option {
+	return func(o *options) {
+		o.strictDescriptions = strict
+	}
+}
+
+// WithSystemServiceMode lets a resolver resolve the services of a self-hosted polaris control plane, like
+// polaris.checker and polaris.config: the targets without namespace are in SystemNamespace and the polaris
+// queries of Resolve and Discover skip the routers of the SDK, rule and nearby routing included, that the
+// system services have no rules for. Without the routers the unhealthy instances are returned too unless
+// WithHealthyOnly is set.
+func WithSystemServiceMode(enable bool) Option {
+	return func(o *options) {
+		o.systemServiceMode = enable
+	}
+}
+
+// WithPreferUDS makes the resolver dial the MetadataUDSPath of the instances which have one on every host,
+// not only on the local host, for hosts sharing the socket directory with their co-located services.
+func WithPreferUDS(prefer bool) Option {
+	return func(o *options) {
+		o.preferUDS = prefer
+	}
+}
+
+// WithErrorLogInterval limits the logs of the repeated Resolve, Watcher and heartbeat failures of a service:
+// the first failure is logged, then one line per interval with the number of failures not logged since.
+// The default is 1m, a negative interval logs every failure.
+func WithErrorLogInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.errorLogInterval = interval
+	}
+}
+
+// WithTLSCapable overrides whether the registry registers its servers as TLS capable with the MetadataTLS
+// metadata, which is otherwise detected from the MetadataTLS or MetadataConnPrefix+"tls" tag of registry.Info.
+func WithTLSCapable(capable bool) Option {
+	return func(o *options) {
+		o.tlsCapable = &capable
+	}
+}
+
+// WithRequireTLSInstances makes the resolver keep only the instances registered as TLS capable, for the
+// clients enabling TLS while their servers roll it out. The first Resolve of a service whose instances are
+// all filtered out logs a warning.
+func WithRequireTLSInstances(require bool) Option {
+	return func(o *options) {
+		o.requireTLS = require
+	}
+}
+
+// WithStrictDeregister makes the Deregister of an instance the registry has no registration of, like a
+// second Deregister, deregister it from polaris anyway, it fails unless polaris confirms the instance is gone.
+// By default such a Deregister returns nil without calling polaris.
+func WithStrictDeregister(strict bool) Option {
+	return func(o *options) {
+		o.strictDeregister = strict
+	}
+}
+
+// WithResolveHistory persists the descriptions the resolver resolves with the time of their last Resolve
+// to the file at path, at most maxEntries of them, 1000 by default. The next resolver created with the same
+// path resolves them in the background at startup so that the first calls find the caches of the SDK warm.
+// A corrupt file is ignored, the descriptions unused for WithResolveHistoryMaxAge are dropped.
+func WithResolveHistory(path string, maxEntries int) Option {
+	return func(o *options) {
+		o.resolveHistoryPath = path
+		o.resolveHistoryEntries = maxEntries
+	}
+}
+
+// WithResolveHistoryMaxAge sets how long the descriptions of WithResolveHistory are kept without being
+// resolved, 7 days by default.
+func WithResolveHistoryMaxAge(age time.Duration) Option {
+	return func(o *options) {
+		o.resolveHistoryMaxAge = age
+	}
+}
+
+// WithSDKLogDir makes the polaris-go SDK write its log files under path instead of ./polaris/log.
+// The loggers of the SDK are global, the last SDK context created decides.
+func WithSDKLogDir(path string) Option {
+	return func(o *options) {
+		o.sdkLogDir = path
+	}
+}
+
+// WithSDKLogLevel sets the level of the logs of the polaris-go SDK, one of "trace", "debug", "info",
+// "warn", "error", "fatal" or "none". An unknown level fails the creation of the SDK context.
+func WithSDKLogLevel(level string) Option {
+	return func(o *options) {
+		o.sdkLogLevel = level
+	}
+}
+
+// WithSDKLogDiscard makes the polaris-go SDK write no log file, for read-only filesystems. Its logs and
+// the ones of this package are handed to the WithStructuredLogger logger, or dropped without one.
+func WithSDKLogDiscard() Option {
+	return func(o *options) {
+		o.sdkLogDiscard = true
+	}
+}
+
+// WithZeroWeightPolicy sets what Resolve does when every instance it returns has a zero weight in polaris,
+// like when their dynamic weights are still warming up. The drained instances are left out and keep their
+// zero weight, see TagDrained. ZeroWeightEqual, the default, gives the others the default weight so that the
+// load balancer keeps spreading the calls, ZeroWeightError fails Resolve and ZeroWeightPassthrough returns
+// their zero weights. Such results are logged and counted by ZeroWeightResults whatever the policy.
+func WithZeroWeightPolicy(policy ZeroWeightPolicy) Option {
+	return func(o *options) {
+		o.zeroWeightPolicy = policy
+	}
+}
+
+// WithOnCallResultReport sets a function called with every bucket of call results reported to polaris,
+// labeled with the locality of the called instance, e.g. to export per zone success rates. The
+// ServiceCallResult of polaris-go v1.0.1 has no labels, polaris itself aggregates by instance only.
+// It runs on the flush goroutine of the reporter and must not block.
+func WithOnCallResultReport(report func(report CallResultReport)) Option {
+	return func(o *options) {
+		o.onCallResultReport = report
+	}
+}
+
+// WithLenientOptions makes NewPolarisResolver and NewPolarisRegistry log the options that cannot be
+// combined instead of failing with a ConflictingOptionsError.
+func WithLenientOptions() Option {
+	return func(o *options) {
+		o.lenientOptions = true
+	}
+}
+
+// WithRouterChain replaces the service router chain of the polaris SDK, rule based then nearby based by
+// default, e.g. to run the nearby based router first. The names are the ones of the polaris-go plugins,
+// like "nearbyBasedRouter" and "ruleBasedRouter", an unknown name fails the construction. polaris-go v1.0.1
+// has no chain per request, the resolvers with another chain get an SDK context of their own.
+func WithRouterChain(chain []string) Option {
+	return func(o *options) {
+		o.routerChain = append([]string(nil), chain...)
+	}
+}
+
+// WithChangeLogCap caps to n the addresses logged per list of the Changes of the watched services, the
+// Changes handed to Kitex and the listeners stay complete. The logs of the Changes above the cap, like the
+// event of a namespace wide mistake replacing thousands of instances, carry the counts and the first n
+// addresses, they are counted by ChangeStats. The Changes are logged at debug level, without cap by default.
+func WithChangeLogCap(n int) Option {
+	return func(o *options) {
+		o.changeLogCap = n
+	}
+}
+
+// WithAdditionalPorts makes the registry register the other ports of a server, like a pprof or debug
+// HTTP port, as the metadata of its instance, "port.debug=6060" for {"debug": 6060}, instead of a second
+// registration. The names are made of letters, digits, '-' and '_' and the ports are in 1-65535,
+// NewPolarisRegistry fails otherwise. Clients read them back with InstancePort.
+func WithAdditionalPorts(ports map[string]int) Option {
+	return func(o *options) {
+		o.additionalPorts = make(map[string]int, len(ports))
+		for name, port := range ports {
+			o.additionalPorts[name] = port
+		}
+	}
+}
+
+// WithWatchTimeout bounds the creation of the polaris subscription of a service in Watcher and
+// Subscribe, which fail with a WatchTimeoutError when the control plane does not answer in time.
+func WithWatchTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.watchTimeout = timeout
+	}
+}
+
+// WithWatchLagThreshold sets the watch delivery lag above which the Change of a polaris event is logged,
+// the default is 5s, see ResolverDiagnostics.WatchDeliveryLag.
+func WithWatchLagThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.watchLagThreshold = threshold
+	}
+}
+
+// WithHeartbeatJitter shortens every heartbeat interval randomly by up to the fraction of it, the default
+// is 0.2 for up to 20% and 0 disables the jitter. The intervals are never lengthened, a beat later than the
+// interval could land past the TTL of the instance.
+func WithHeartbeatJitter(fraction float64) Option {
+	return func(o *options) {
+		o.heartbeatJitter = fraction
+	}
+}
+
+// WithHeartbeatFailureBudget sets after how many consecutive heartbeat failures an instance is
+// considered lost, the default is 3.
+func WithHeartbeatFailureBudget(failures int) Option {
+	return func(o *options) {
+		o.heartbeatFailureBudget = failures
+	}
+}
+
+// WithOnHeartbeatLost sets a function called once when the heartbeats of an instance exhaust their
+// failure budget, for instance to fail the readiness probe. It is called again after a recovery.
+func WithOnHeartbeatLost(lost func(err error)) Option {
+	return func(o *options) {
+		o.onHeartbeatLost = lost
+	}
+}
+
+// WithRegisterRetry makes Register retry in the background when its first attempt fails, e.g. when the
+// server starts before polaris is reachable, Register then returns nil instead of the error. maxAttempts
+// bounds the attempts including the first one, zero retries until Deregister, and backoff gives the
+// delays between them, nil uses ExponentialBackoff(time.Second, 30*time.Second).
+// Invalid registry infos are never retried.
+func WithRegisterRetry(maxAttempts int, backoff Backoff) Option {
+	return func(o *options) {
+		o.registerRetry = true
+		o.registerRetryAttempts = maxAttempts
+		o.registerRetryBackoff = backoff
+	}
+}
+
+// WithMetadataReconciliation makes the registry read its instances back with the consumer API every interval
+// and restore the metadata and weight they were registered with when other tooling changed them, logging
+// every correction, see RegistryDiagnostics.MetadataCorrections. The polaris-go provider API has no way
+// to update an instance, so a drifted instance is deregistered and registered again. SetIsolated and
+// Register change the restored registration. It is off by default.
+func WithMetadataReconciliation(interval time.Duration) Option {
+	return func(o *options) {
+		o.metadataReconciliation = interval
+	}
+}
+
+// WithSelfWatch makes the registry watch the services of its instances and register an instance again when
+// polaris deletes it without the registry deregistering it, e.g. from the polaris console, with the
+// WithRegisterRetry policy when the first attempt fails.
+func WithSelfWatch(watch bool) Option {
+	return func(o *options) {
+		o.selfWatch = watch
+	}
+}
+
+// WithOnExternallyDeregistered sets a function called each time WithSelfWatch finds a registered instance
+// deleted from polaris by someone else, before the instance is registered again.
+func WithOnExternallyDeregistered(deregistered func()) Option {
+	return func(o *options) {
+		o.onExternallyDeregistered = deregistered
+	}
+}
+
+// WithOnRegistered sets a function called each time Register registers an instance, by the first attempt
+// or by a retry of WithRegisterRetry, for instance to pass the readiness probe.
+func WithOnRegistered(registered func()) Option {
+	return func(o *options) {
+		o.onRegistered = registered
+	}
+}
+
+// WithOnInstancesRemoved sets a function called with the instances polaris deleted from a watched service,
+// a service becomes watched by Watcher or Subscribe. It runs on the goroutine of the watch and must not
+// block, e.g. CleanConnections closes the pooled connections to the removed addresses.
+func WithOnInstancesRemoved(removed func(instances []discovery.Instance)) Option {
+	return func(o *options) {
+		o.onInstancesRemoved = removed
+	}
+}
+
+// WithAutoCreateNamespace makes Register create the namespace polaris reports missing and register again,
+// for ephemeral dev namespaces. The namespace is created with the admin API of WithAdminAPI, without it
+// Register fails with a NamespaceCreateError. Keep it off in production, where a missing namespace is a
+// misconfiguration.
+func WithAutoCreateNamespace(enable bool) Option {
+	return func(o *options) {
+		o.autoCreateNamespace = enable
+	}
+}
+
+// WithAdminAPI sets the HTTP open API of the polaris server WithAutoCreateNamespace creates the namespaces
+// with, like http://polaris:8090, and the token of a polaris user allowed to create them. The service token
+// of WithServiceToken only authorizes the instances of a service.
+func WithAdminAPI(address, token string) Option {
+	return func(o *options) {
+		o.adminAddress = address
+		o.adminToken = token
+	}
+}
+
+// WithRemovalGracePeriod keeps the instances removed from polaris in the Changes of Watcher and Subscribe
+// for d at residualWeightPercent of their weight with the TagDraining tag, so that old instances get a trickle
+// of traffic during a rollout. A follow-up Change removes them once d expired, an instance added again
+// within d replaces its draining copy. Resolve returns the draining instances of the watched services too.
+func WithRemovalGracePeriod(d time.Duration, residualWeightPercent int) Option {
+	return func(o *options) {
+		if residualWeightPercent < 0 {
+			residualWeightPercent = 0
+		} else if residualWeightPercent > 100 {
+			residualWeightPercent = 100
+		}
+		o.removalGracePeriod = d
+		o.removalResidualWeight = residualWeightPercent
+	}
+}
+
+// WithWeightUpdateThreshold keeps back the polaris updates which change nothing but the weight of an instance
+// by at most percent of the weight propagated last, so that small weight adjustments do not cause a Change
+// in Watcher and Subscribe. The updates kept back are propagated by the next update above the threshold or
+// another change of the instance, and every WithWeightSyncInterval. Other changes propagate immediately.
+func WithWeightUpdateThreshold(percent float64) Option {
+	return func(o *options) {
+		o.weightUpdateThreshold = percent
+	}
+}
+
+// WithWeightSyncInterval sets how often the weight updates kept back by WithWeightUpdateThreshold are
+// propagated, the default is 30s.
+func WithWeightSyncInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.weightSyncInterval = interval
+	}
+}
+
+// WithCanary sets the canary of the polaris queries of the resolver, so that the canary routing of polaris
+// selects the instances of that canary. CtxWithCanary overrides it per call.
+func WithCanary(value string) Option {
+	return func(o *options) {
+		o.canary = value
+	}
+}
+
+// WithNamespace sets the namespace used by the registry and by Target when none is given by the
+// namespace tag or a fully qualified service name, the default is "default".
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithServiceToken sets the service token sent with register, deregister and heartbeat requests.
+func WithServiceToken(token string) Option {
+	return func(o *options) {
+		o.serviceToken = token
+	}
+}
+
+// WithHealthyOnly drops the unhealthy and isolated instances from Resolve, which polaris returns when
+// every instance of a service is unhealthy.
+func WithHealthyOnly(enable bool) Option {
+	return func(o *options) {
+		o.healthyOnly = enable
+	}
+}
+
+// WithHeartbeatInterval sets the interval of the heartbeats of registered instances, the default is 5s.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.heartbeatInterval = interval
+	}
+}
+
+// WithResolveTimeout bounds every Resolve, which fails with a ResolveContextError on timeout, and sets
+// the GetInstances timeout of the SDK. CtxWithResolveTimeout overrides it per call.
+func WithResolveTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.resolveTimeout = timeout
+	}
+}
+
+// WithServerTimeProbe sets a function returning the current time of the polaris server, like the Date
+// header of its HTTP API, since the heartbeat responses of the SDK carry no server time. The registry
+// probes it after every heartbeat to measure the clock skew, see RegistryDiagnostics.ClockSkew.
+func WithServerTimeProbe(probe func() (time.Time, error)) Option {
+	return func(o *options) {
+		o.serverTimeProbe = probe
+	}
+}
+
+// WithClockSkewThreshold sets the clock skew above which the heartbeat interval is shortened to a
+// third of the instance TTL, the default is 1s.
+func WithClockSkewThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.clockSkewThreshold = threshold
+	}
+}
+
+// WithMaxTrackedServices bounds the services whose state the resolver keeps, like a gateway resolving
+// many short-lived upstreams. Over n services the conversion caches, route traces, service metadata and
+// subscription of the least recently resolved one are dropped, EvictedServices counts them. Services with
+// Subscribe listeners or running watches are never evicted. By default the state is unbounded.
+func WithMaxTrackedServices(n int) Option {
+	return func(o *options) {
+		o.maxTrackedServices = n
+	}
+}
+
+// WithSetFilter makes Resolve keep only the instances of the polaris set setName, see TagSetName. When the
+// set has no instance the instances without set are kept, unless WithStrictSetFilter is set.
+func WithSetFilter(setName string) Option {
+	return func(o *options) {
+		o.setName = setName
+	}
+}
+
+// WithStrictSetFilter makes the filter of WithSetFilter fail with a NoInstanceError when the set is empty
+// instead of falling back to the instances without set.
+func WithStrictSetFilter(strict bool) Option {
+	return func(o *options) {
+		o.setStrict = strict
+	}
+}
+
+// WithMinInstanceAge makes Resolve drop the instances registered less than age ago, e.g. to keep the
+// traffic off instances still warming up. The age is taken from TagCreateTime, or else from the
+// MetadataStartTime metadata, the instances of unknown age are kept. When every instance is younger
+// none is dropped.
+func WithMinInstanceAge(age time.Duration) Option {
+	return func(o *options) {
+		o.minInstanceAge = age
+	}
+}
+
+// WithSetMetadataKeys sets instance metadata keys carrying the set name, tried in order before
+// MetadataSetName and the logic set of the instance.
+func WithSetMetadataKeys(keys ...string) Option {
+	return func(o *options) {
+		o.setMetadataKeys = append([]string(nil), keys...)
+	}
+}
+
+// WithShardMetadataKey sets the instance metadata key of the shard of the instances, DefaultShardMetadataKey
+// by default, see CtxWithShard.
+func WithShardMetadataKey(key string) Option {
+	return func(o *options) {
+		o.shardMetadata = key
+	}
+}
+
+// WithStrictShard makes Resolve fail with a NoInstanceError when the shard of CtxWithShard has no instance
+// instead of falling back to every instance of the service, which would send the calls to the wrong shard.
+// The static fallback of the service is not used either, only the one listed for the description.
+func WithStrictShard(strict bool) Option {
+	return func(o *options) {
+		o.shardStrict = strict
+	}
+}
+
+// WithLocalityFallback turns the target tags of the given metadata keys, ordered from the most specific
+// like "zone", "region", into a fallback ladder in Resolve: the instances matching every level are kept,
+// else the most specific level is dropped, down to no locality filter at all. The client values are
+// the target tags, so the keys must also be set by WithTargetTagKeys.
+func WithLocalityFallback(levels ...string) Option {
+	return func(o *options) {
+		o.localityLevels = levels
+	}
+}
+
+// WithInstanceLogSampling logs every nth instance got by Resolve and Watcher at debug level, next to
+// the summary line logged once per call. It is off by default, which is what large services want.
+func WithInstanceLogSampling(n int) Option {
+	return func(o *options) {
+		o.instanceLogSampling = n
+	}
+}
+
+// WithStructuredLogger makes Resolve and Watcher log structured records to logger instead of format strings
+// to the polaris-go logger, with the namespace, service, instance_count, duration_ms, revision and source
+// fields, source being remote for the queries of Resolve and cache for the subscription snapshots of Watcher.
+func WithStructuredLogger(logger StructuredLogger) Option {
+	return func(o *options) {
+		o.structuredLogger = logger
+	}
+}
+
+// WithLogFields sets a function returning request scoped keys and values, like the trace ID in ctx,
+// appended to the records of WithStructuredLogger. Resolve runs on the request path when Kitex has
+// no balancer cached for the target yet.
+func WithLogFields(fields func(ctx context.Context) []interface{}) Option {
+	return func(o *options) {
+		o.logFields = fields
+	}
+}
+
+// WithConsumerAPI runs the resolver or registry on a consumer API built by the caller, e.g. with custom
+// router plugins, instead of creating one from the endpoints. The options configuring the created
+// SDK context are rejected with it, and Close never destroys it.
+func WithConsumerAPI(consumer api.ConsumerAPI) Option {
+	return func(o *options) {
+		o.consumerAPI = consumer
+	}
+}
+
+// WithProviderAPI runs the resolver or registry on a provider API built by the caller, see WithConsumerAPI.
+func WithProviderAPI(provider api.ProviderAPI) Option {
+	return func(o *options) {
+		o.providerAPI = provider
+	}
+}
+
+// WithFallbackSnapshots makes Resolve persist the polaris instances of every description in dir, one file
+// written atomically when the revision changes, and serve them with the TagFallback tag set to "snapshot"
+// when polaris fails, e.g. on a cold start during an outage. The snapshots come before WithStaticFallback.
+func WithFallbackSnapshots(dir string) Option {
+	return func(o *options) {
+		o.snapshotDir = dir
+	}
+}
+
+// WithSnapshotCodec sets the codec writing the snapshots of WithFallbackSnapshots, the default is
+// JSONSnapshotCodec. The snapshots of every version of JSONSnapshotCodec, GobSnapshotCodec and codec
+// are loaded, a snapshot of another version is ignored with a SnapshotVersionError.
+func WithSnapshotCodec(codec SnapshotCodec) Option {
+	return func(o *options) {
+		o.snapshotCodec = codec
+	}
+}
+
+// WithRateLimitLabelExtractor adds the labels of extract to the calls of NewRateLimitMiddleware, they
+// override the built-in ones.
+func WithRateLimitLabelExtractor(extract LabelExtractor) Option {
+	return func(o *options) {
+		o.rateLimitLabels = extract
+	}
+}
+
+// WithRateLimitMetaKeys labels the calls of NewRateLimitMiddleware with the metainfo values of keys,
+// like a tenant sent by the callers, see MetaInfoLabelExtractor.
+func WithRateLimitMetaKeys(keys ...string) Option {
+	return func(o *options) {
+		o.rateLimitMetaKeys = append([]string(nil), keys...)
+	}
+}
+
+// WithRateLimitMaxLabels bounds the distinct values each label of NewRateLimitMiddleware takes, 1000 by
+// default, since the SDK keeps a rate limit window per label set. The new values over n are reported as
+// RateLimitLabelOverflow, e.g. when a label carries user IDs by mistake.
+func WithRateLimitMaxLabels(n int) Option {
+	return func(o *options) {
+		o.rateLimitMaxLabels = n
+	}
+}
+
+// WithStaticFallback sets emergency host:port addresses of desc, a description like "namespace:service",
+// returned by Resolve as a non-cacheable result only when polaris yields no instance after the filters.
+// The instances carry the TagFallback tag and the default weight, activations are counted by StaticFallbacks.
+func WithStaticFallback(desc string, addrs []string) Option {
+	return func(o *options) {
+		if o.staticFallbacks == nil {
+			o.staticFallbacks = make(map[string][]discovery.Instance)
+		}
+		o.staticFallbacks[desc] = newStaticFallback(desc, addrs)
+	}
+}
+
+// WithMetadataTagPrefixPassthrough copies only the instance metadata keys with one of the prefixes into the
+// Kitex instance tags, like MetadataConnPrefix for the connection hints, to limit the tags of services with
+// large metadata. The keys filtered on by WithTargetTagKeys, WithLocalityFallback and WithProtocolFilter
+// and the keys of WithSetMetadataKeys are always copied. By default every key is copied, an empty list copies none.
+func WithMetadataTagPrefixPassthrough(prefixes []string) Option {
+	return func(o *options) {
+		o.metadataTagPrefixes = append([]string{}, prefixes...)
+	}
+}
+
+// WithRegisterIsolated registers the instances isolated, so that they receive no traffic from Resolve
+// until IsolationRegistry.SetIsolated opens it, e.g. for dark launches. ResolveAll still returns them.
+func WithRegisterIsolated(isolated bool) Option {
+	return func(o *options) {
+		o.registerIsolated = isolated
+	}
+}
+
+// WithLocationProvider sets the provider of the region, zone and campus registered in the instance
+// metadata, see EnvLocationProvider. The instance is registered without location when it fails.
+func WithLocationProvider(provider LocationProvider) Option {
+	return func(o *options) {
+		o.location = provider
+	}
+}
+
+// WithCloudLocationDetection detects the registered location from the environment variables of
+// EnvLocationProvider, then from the EC2 and GCE metadata services, when no WithLocationProvider is set.
+func WithCloudLocationDetection(enable bool) Option {
+	return func(o *options) {
+		o.cloudLocation = enable
+	}
+}
+
+// WithLocationTimeout bounds the location detection of the first registration, the default is 1s.
+func WithLocationTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.locationTimeout = timeout
+	}
+}
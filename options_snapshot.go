@@ -0,0 +1,376 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/kitex"
+)
+
+// redacted replaces secrets in an OptionsSnapshot.
+const redacted = "***"
+
+// OptionsSnapshot is the redacted, JSON marshalable view of the options a resolver or registry runs
+// with, defaults included. Durations are formatted like "5s".
+type OptionsSnapshot struct {
+	KitexVersion           string            `json:"kitex_version"`
+	RegistryPolarisVersion string            `json:"registry_polaris_version"`
+	Endpoints              []string          `json:"endpoints"`
+	Namespace              string            `json:"namespace"`
+	ServiceToken           string            `json:"service_token,omitempty"`
+	Filters                []string          `json:"filters,omitempty"`
+	TargetTagKeys          []string          `json:"target_tag_keys,omitempty"`
+	TargetTagDefaults      map[string]string `json:"target_tag_defaults,omitempty"`
+	DescriptionCodec       string            `json:"description_codec"`
+	StrictDescriptions     bool              `json:"strict_descriptions"`
+	SystemServiceMode      bool              `json:"system_service_mode"`
+	PreferUDS              bool              `json:"prefer_uds"`
+	ErrorLogInterval       string            `json:"error_log_interval"`
+	TLSCapable             string            `json:"tls_capable,omitempty"`
+	StrictDeregister       bool              `json:"strict_deregister"`
+	Hooks                  []string          `json:"hooks,omitempty"`
+	InitialSyncTimeout     string            `json:"initial_sync_timeout"`
+	ResolveTimeout         string            `json:"resolve_timeout"`
+	WatchTimeout           string            `json:"watch_timeout"`
+	WatchLagThreshold      string            `json:"watch_lag_threshold"`
+	DeregisterTimeout      string            `json:"deregister_timeout"`
+	PostRegisterVerify     string            `json:"post_register_verification"`
+	HeartbeatInterval      string            `json:"heartbeat_interval"`
+	HeartbeatJitter        float64           `json:"heartbeat_jitter"`
+	HeartbeatFailureBudget int               `json:"heartbeat_failure_budget"`
+	ServerTimeProbe        bool              `json:"server_time_probe"`
+	ClockSkewThreshold     string            `json:"clock_skew_threshold"`
+	AutoMetadata           bool              `json:"auto_metadata"`
+	DisableStatReporter    bool              `json:"disable_stat_reporter"`
+	DisableLocation        bool              `json:"disable_location_provider"`
+	WatchWorkerPool        int               `json:"watch_worker_pool"`
+	ListenerQueueSize      int               `json:"listener_queue_size"`
+	ServiceMetadataTTL     string            `json:"service_metadata_ttl"`
+	CallResultFlush        string            `json:"call_result_flush_interval"`
+	CallResultBufferSize   int               `json:"call_result_buffer_size"`
+	CallResultClassifier   string            `json:"call_result_classifier"`
+	DefaultWeight          int               `json:"default_weight,omitempty"`
+	WeightClamp            string            `json:"weight_clamp,omitempty"`
+	WeightTargetSum        int               `json:"weight_target_sum,omitempty"`
+	RouteDebug             bool              `json:"route_debug"`
+	InstanceLogSampling    int               `json:"instance_log_sampling,omitempty"`
+	ExternalSDKAPIs        bool              `json:"external_sdk_apis"`
+	DedicatedSDKContext    bool              `json:"dedicated_sdk_context"`
+	StaticFallbacks        []string          `json:"static_fallbacks,omitempty"`
+	FallbackSnapshots      string            `json:"fallback_snapshots,omitempty"`
+	ResolveHistory         string            `json:"resolve_history,omitempty"`
+	SDKLogDir              string            `json:"sdk_log_dir,omitempty"`
+	SDKLogLevel            string            `json:"sdk_log_level,omitempty"`
+	SDKLogDiscard          bool              `json:"sdk_log_discard"`
+	ZeroWeightPolicy       string            `json:"zero_weight_policy"`
+	LenientOptions         bool              `json:"lenient_options"`
+	RouterChain            []string          `json:"router_chain,omitempty"`
+	ChangeLogCap           int               `json:"change_log_cap,omitempty"`
+	AdditionalPorts        map[string]int    `json:"additional_ports,omitempty"`
+	SnapshotCodec          string            `json:"snapshot_codec,omitempty"`
+	RateLimitMetaKeys      []string          `json:"rate_limit_meta_keys,omitempty"`
+	RateLimitMaxLabels     int               `json:"rate_limit_max_labels,omitempty"`
+	MetadataTagPrefixes    []string          `json:"metadata_tag_prefixes,omitempty"`
+	RegisterIsolated       bool              `json:"register_isolated"`
+	RegisterRetry          string            `json:"register_retry,omitempty"`
+	AutoCreateNamespace    bool              `json:"auto_create_namespace"`
+	AdminAddress           string            `json:"admin_address,omitempty"`
+	AdminToken             string            `json:"admin_token,omitempty"`
+	Canary                 string            `json:"canary,omitempty"`
+	RemovalGrace           string            `json:"removal_grace,omitempty"`
+	WeightUpdates          string            `json:"weight_updates,omitempty"`
+	SetMetadataKeys        []string          `json:"set_metadata_keys,omitempty"`
+	ShardMetadataKey       string            `json:"shard_metadata_key,omitempty"`
+	StrictShard            bool              `json:"strict_shard"`
+	MaxTrackedServices     int               `json:"max_tracked_services,omitempty"`
+	MetadataReconciliation string            `json:"metadata_reconciliation,omitempty"`
+	SelfWatch              bool              `json:"self_watch"`
+	StructuredLogger       bool              `json:"structured_logger"`
+	LocationProvider       string            `json:"location_provider,omitempty"`
+	LocationTimeout        string            `json:"location_timeout"`
+}
+
+// String returns the snapshot as JSON.
+func (s OptionsSnapshot) String() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%+v", s.Endpoints)
+	}
+	return string(b)
+}
+
+// OptionsReporter is the extension interface of the resolvers and registries reporting their options,
+// e.g. for a debug endpoint, the Resolvers and Registries of this package implement it.
+type OptionsReporter interface {
+	// EffectiveOptions returns the options the resolver or registry runs with, secrets are redacted.
+	EffectiveOptions() OptionsSnapshot
+}
+
+var (
+	_ OptionsReporter = (*polarisResolver)(nil)
+	_ OptionsReporter = (*polarisRegistry)(nil)
+)
+
+// newOptionsSnapshot captures the effective options, heartbeatInterval is the interval of the registry.
+func newOptionsSnapshot(endpoints []string, o *options, heartbeatInterval time.Duration) OptionsSnapshot {
+	s := OptionsSnapshot{
+		KitexVersion:           kitex.Version,
+		RegistryPolarisVersion: moduleVersion(),
+		Endpoints:              make([]string, 0, len(endpoints)),
+		Namespace:              o.defaultNamespace(),
+		TargetTagKeys:          append([]string(nil), o.targetTagKeys...),
+		DescriptionCodec:       fmt.Sprintf("%T", o.descriptionCodec()),
+		StrictDescriptions:     o.strictDescriptions,
+		SystemServiceMode:      o.systemServiceMode,
+		PreferUDS:              o.preferUDS,
+		StrictDeregister:       o.strictDeregister,
+		ErrorLogInterval:       orDefaultDuration(o.errorLogInterval, defaultErrorLogInterval).String(),
+		InitialSyncTimeout:     o.initialSyncTimeout.String(),
+		ResolveTimeout:         o.resolveTimeout.String(),
+		WatchTimeout:           o.watchTimeout.String(),
+		WatchLagThreshold:      orDefaultDuration(o.watchLagThreshold, defaultWatchLagThreshold).String(),
+		DeregisterTimeout:      o.deregisterTimeout.String(),
+		PostRegisterVerify:     o.postRegisterVerification.String(),
+		HeartbeatInterval:      heartbeatInterval.String(),
+		HeartbeatJitter:        o.heartbeatJitter,
+		HeartbeatFailureBudget: orDefault(o.heartbeatFailureBudget, defaultHeartbeatFailureBudget),
+		ServerTimeProbe:        o.serverTimeProbe != nil,
+		ClockSkewThreshold:     orDefaultDuration(o.clockSkewThreshold, defaultClockSkewThreshold).String(),
+		AutoMetadata:           o.autoMetadata,
+		DisableStatReporter:    o.disableStatReporter,
+		DisableLocation:        o.disableLocationProvider,
+		WatchWorkerPool:        orDefault(o.watchWorkerPoolSize, defaultWatchWorkerPoolSize),
+		ListenerQueueSize:      orDefault(o.listenerQueueSize, defaultListenerQueueSize),
+		ServiceMetadataTTL:     orDefaultDuration(o.serviceMetadataTTL, defaultServiceMetadataTTL).String(),
+		CallResultFlush:        orDefaultDuration(o.callResultFlushInterval, defaultCallResultFlushInterval).String(),
+		CallResultBufferSize:   orDefault(o.callResultMaxBuckets, defaultCallResultMaxBuckets),
+		CallResultClassifier:   "default",
+		DefaultWeight:          o.defaultInstanceWeight,
+		FallbackSnapshots:      o.snapshotDir,
+		SDKLogDir:              o.sdkLogDir,
+		SDKLogLevel:            o.sdkLogLevel,
+		SDKLogDiscard:          o.sdkLogDiscard,
+		ZeroWeightPolicy:       o.zeroWeightPolicy.String(),
+		LenientOptions:         o.lenientOptions,
+		RouterChain:            o.routerChain,
+		ChangeLogCap:           o.changeLogCap,
+		RateLimitMetaKeys:      o.rateLimitMetaKeys,
+		RateLimitMaxLabels:     o.rateLimitMaxLabels,
+		WeightTargetSum:        o.weightTargetSum,
+		RouteDebug:             o.routeDebug,
+		InstanceLogSampling:    o.instanceLogSampling,
+		ExternalSDKAPIs:        o.consumerAPI != nil || o.providerAPI != nil,
+		DedicatedSDKContext:    o.dedicatedSDKContext,
+		MetadataTagPrefixes:    o.metadataTagPrefixes,
+		RegisterIsolated:       o.registerIsolated,
+		AutoCreateNamespace:    o.autoCreateNamespace,
+		Canary:                 o.canary,
+		SetMetadataKeys:        o.setMetadataKeys,
+		ShardMetadataKey:       o.shardMetadata,
+		StrictShard:            o.shardStrict,
+		MaxTrackedServices:     o.maxTrackedServices,
+		SelfWatch:              o.selfWatch,
+		StructuredLogger:       o.structuredLogger != nil,
+		LocationTimeout:        orDefaultDuration(o.locationTimeout, defaultLocationTimeout).String(),
+	}
+	for _, endpoint := range endpoints {
+		s.Endpoints = append(s.Endpoints, redactEndpoint(endpoint))
+	}
+	if o.serviceToken != "" {
+		s.ServiceToken = redacted
+	}
+	if o.adminAddress != "" {
+		s.AdminAddress = redactEndpoint(o.adminAddress)
+	}
+	if o.adminToken != "" {
+		s.AdminToken = redacted
+	}
+	if len(o.additionalPorts) > 0 {
+		s.AdditionalPorts = make(map[string]int, len(o.additionalPorts))
+		for name, port := range o.additionalPorts {
+			s.AdditionalPorts[name] = port
+		}
+	}
+	if len(o.targetTagDefaults) > 0 {
+		s.TargetTagDefaults = make(map[string]string, len(o.targetTagDefaults))
+		for k, v := range o.targetTagDefaults {
+			s.TargetTagDefaults[k] = v
+		}
+	}
+	if o.healthyOnly {
+		s.Filters = append(s.Filters, "healthy")
+	}
+	if o.protocolFilter != "" {
+		s.Filters = append(s.Filters, TagProtocol+"="+o.protocolFilter)
+	}
+	if o.requireTLS {
+		s.Filters = append(s.Filters, tlsFilterName)
+	}
+	if o.setName != "" {
+		s.Filters = append(s.Filters, newSetFilter(o.setName, o.setStrict).name)
+	}
+	if o.minInstanceAge > 0 {
+		s.Filters = append(s.Filters, "min age("+o.minInstanceAge.String()+")")
+	}
+	if len(o.localityLevels) > 0 {
+		s.Filters = append(s.Filters, "locality("+strings.Join(o.localityLevels, ",")+")")
+	}
+	for _, key := range o.targetTagKeys {
+		if key != namespaceTagKey {
+			s.Filters = append(s.Filters, "tag("+key+")")
+		}
+	}
+	if o.adaptiveScoring {
+		s.Filters = append(s.Filters, "adaptive scoring")
+	}
+	hooks := map[string]bool{
+		"before resolve":          o.beforeResolve != nil,
+		"after resolve":           o.afterResolve != nil,
+		"before register":         o.beforeRegister != nil,
+		"after register":          o.afterRegister != nil,
+		"before deregister":       o.beforeDeregister != nil,
+		"after deregister":        o.afterDeregister != nil,
+		"heartbeat lost":          o.onHeartbeatLost != nil,
+		"instances removed":       o.onInstancesRemoved != nil,
+		"registered":              o.onRegistered != nil,
+		"externally deregistered": o.onExternallyDeregistered != nil,
+		"log fields":              o.logFields != nil,
+		"rate limit labels":       o.rateLimitLabels != nil,
+		"call result report":      o.onCallResultReport != nil,
+	}
+	for name, set := range hooks {
+		if set {
+			s.Hooks = append(s.Hooks, name)
+		}
+	}
+	sort.Strings(s.Hooks)
+	if o.weightClamp {
+		s.WeightClamp = fmt.Sprintf("%d-%d", o.weightMin, o.weightMax)
+	}
+	if o.registerRetry && o.registerRetryAttempts > 0 {
+		s.RegisterRetry = fmt.Sprintf("%d attempts", o.registerRetryAttempts)
+	} else if o.registerRetry {
+		s.RegisterRetry = "unlimited"
+	}
+	if o.metadataReconciliation > 0 {
+		s.MetadataReconciliation = o.metadataReconciliation.String()
+	}
+	if o.removalGracePeriod > 0 {
+		s.RemovalGrace = fmt.Sprintf("%v at %d%%", o.removalGracePeriod, o.removalResidualWeight)
+	}
+	if o.resolveHistoryPath != "" {
+		s.ResolveHistory = fmt.Sprintf("%s (%d entries, %v)", o.resolveHistoryPath,
+			orDefault(o.resolveHistoryEntries, defaultResolveHistoryEntries),
+			orDefaultDuration(o.resolveHistoryMaxAge, defaultResolveHistoryMaxAge))
+	}
+	if o.tlsCapable != nil {
+		s.TLSCapable = strconv.FormatBool(*o.tlsCapable)
+	}
+	if o.errorLogInterval < 0 {
+		s.ErrorLogInterval = "unlimited"
+	}
+	if o.weightUpdateThreshold > 0 {
+		s.WeightUpdates = fmt.Sprintf("%v%% synced every %v", o.weightUpdateThreshold,
+			orDefaultDuration(o.weightSyncInterval, defaultWeightSyncInterval))
+	}
+	if o.callClassifier != nil {
+		s.CallResultClassifier = "custom"
+	}
+	if o.location != nil {
+		s.LocationProvider = "custom"
+	} else if o.cloudLocation {
+		s.LocationProvider = "env,ec2,gce"
+	}
+	for desc := range o.staticFallbacks {
+		s.StaticFallbacks = append(s.StaticFallbacks, desc)
+	}
+	sort.Strings(s.StaticFallbacks)
+	if o.snapshotCodec != nil {
+		s.SnapshotCodec = fmt.Sprintf("%T", o.snapshotCodec)
+	}
+	return s
+}
+
+// redactEndpoints masks the credentials of every endpoint, see redactEndpoint.
+func redactEndpoints(endpoints []string) []string {
+	masked := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		masked = append(masked, redactEndpoint(endpoint))
+	}
+	return masked
+}
+
+// redactEndpoint masks the credentials of an endpoint given as a URL or with user info.
+func redactEndpoint(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		if i := strings.LastIndex(endpoint, "@"); i >= 0 {
+			return redacted + endpoint[i:]
+		}
+		return endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	query := u.Query()
+	for key := range query {
+		if strings.Contains(strings.ToLower(key), "token") {
+			query.Set(key, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+	s, _ := url.PathUnescape(u.String())
+	return s
+}
+
+func orDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+func orDefaultDuration(v, def time.Duration) time.Duration {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// EffectiveOptions implements the OptionsReporter interface.
+func (polaris *polarisResolver) EffectiveOptions() OptionsSnapshot {
+	interval := polaris.opts.heartbeatInterval
+	if interval <= 0 {
+		interval = heartbeatTime
+	}
+	return newOptionsSnapshot(polaris.currentEndpoints(), polaris.opts, interval)
+}
+
+// EffectiveOptions implements the OptionsReporter interface.
+func (svr *polarisRegistry) EffectiveOptions() OptionsSnapshot {
+	return newOptionsSnapshot(svr.currentEndpoints(), svr.opts, svr.heartbeatInterval)
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+)
+
+// newOrphanProvider creates the provider used by one DeregisterInstance call, replaced in tests.
+var newOrphanProvider = func(endpoints []string, opts ...Option) (api.ProviderAPI, error) {
+	sdkCtx, err := RawSDKContext(endpoints, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewProviderAPIByContext(sdkCtx), nil
+}
+
+// DeregisterInstance deregisters the instance host:port of a service through a temporary polaris SDK
+// context, without any registration state, e.g. to clean up the orphans of crashed processes. Unlike
+// Registry.Deregister it stops no heartbeat, an instance still heartbeating is registered again by its
+// registry. It returns a NotFoundError matching ErrInstanceNotFound, ErrServiceNotFound or
+// ErrNamespaceNotFound when polaris does not know the instance, and an UnauthorizedError when it rejects token.
+func DeregisterInstance(ctx context.Context, endpoints []string, namespace, service, host string, port int,
+	token string, opts ...Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if namespace == "" {
+		namespace = newOptions(opts).defaultNamespace()
+	}
+	provider, err := newOrphanProvider(endpoints, opts...)
+	if err != nil {
+		return perrors.WithMessage(err, "create polaris provider failed")
+	}
+	defer provider.Destroy()
+	req := &api.InstanceDeRegisterRequest{
+		InstanceDeRegisterRequest: model.InstanceDeRegisterRequest{
+			Service:      service,
+			ServiceToken: token,
+			Namespace:    namespace,
+			Host:         host,
+			Port:         port,
+			Timeout:      model.ToDurationPtr(registerTimeout),
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- provider.Deregister(req)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err != nil {
+		instance := net.JoinHostPort(host, strconv.Itoa(port))
+		return perrors.WithMessagef(deregisterInstanceError(err, namespace, service, instance),
+			"deregister %s of %s:%s", instance, namespace, service)
+	}
+	return nil
+}
+
+// deregisterInstanceError maps the polaris errors of a deregistration to the typed errors.
+func deregisterInstanceError(err error, namespace, service, instance string) error {
+	var sdkErr model.SDKError
+	if !perrors.As(err, &sdkErr) {
+		return err
+	}
+	switch {
+	case sdkErr.ErrorCode() == model.ErrCodeAPIInstanceNotFound || sdkErr.ServerCode() == namingpb.NotFoundInstance:
+		return &NotFoundError{Namespace: namespace, Service: service, Instance: instance, Kind: ErrInstanceNotFound, Err: err}
+	case sdkErr.ServerCode() == namingpb.Unauthorized || sdkErr.ServerCode() == namingpb.InvalidServiceToken:
+		return &UnauthorizedError{Namespace: namespace, Service: service, Err: err}
+	}
+	return notFoundError(err, namespace, service)
+}
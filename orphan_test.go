@@ -0,0 +1,81 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/stretchr/testify/require"
+)
+
+// useOrphanProvider makes DeregisterInstance use backend until the test ends.
+func useOrphanProvider(t *testing.T, backend *polaristest.Backend) {
+	origin := newOrphanProvider
+	t.Cleanup(func() { newOrphanProvider = origin })
+	newOrphanProvider = func(endpoints []string, opts ...Option) (api.ProviderAPI, error) {
+		return backend, nil
+	}
+}
+
+func TestDeregisterInstance(t *testing.T) {
+	backend := polaristest.NewBackend()
+	useOrphanProvider(t, backend)
+	rg := newTestRegistry(backend)
+	require.Nil(t, rg.Register(newTestInfo("127.0.0.1:6666", nil)))
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 1)
+
+	// another process cleans the instance up without the registration state.
+	err := DeregisterInstance(context.Background(), []string{"127.0.0.1:8091"}, "", serviceName, "127.0.0.1", 6666, "")
+	require.Nil(t, err)
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+	require.Equal(t, 1, backend.Destroyed())
+}
+
+func TestDeregisterInstanceNotFound(t *testing.T) {
+	backend := polaristest.NewBackend()
+	useOrphanProvider(t, backend)
+	err := DeregisterInstance(context.Background(), []string{"127.0.0.1:8091"}, polarisDefaultNamespace, serviceName,
+		"127.0.0.1", 6666, "")
+	require.True(t, errors.Is(err, ErrInstanceNotFound), "%v", err)
+	var notFound *NotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, "127.0.0.1:6666", notFound.Instance)
+	require.Equal(t, 1, backend.Destroyed())
+}
+
+func TestDeregisterInstanceUnauthorized(t *testing.T) {
+	backend := polaristest.NewBackend()
+	useOrphanProvider(t, backend)
+	backend.SetServiceToken(polarisDefaultNamespace, serviceName, "s3cret")
+	rg := newTestRegistry(backend, WithServiceToken("s3cret"))
+	require.Nil(t, rg.Register(newTestInfo("127.0.0.1:6666", nil)))
+
+	err := DeregisterInstance(context.Background(), []string{"127.0.0.1:8091"}, polarisDefaultNamespace, serviceName,
+		"127.0.0.1", 6666, "wrong")
+	var unauthorized *UnauthorizedError
+	require.True(t, errors.As(err, &unauthorized), "%v", err)
+	require.False(t, errors.Is(err, ErrInstanceNotFound))
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 1)
+
+	require.Nil(t, DeregisterInstance(context.Background(), []string{"127.0.0.1:8091"}, polarisDefaultNamespace,
+		serviceName, "127.0.0.1", 6666, "s3cret"))
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+}
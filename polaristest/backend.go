@@ -0,0 +1,492 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package polaristest provides an in-memory polaris backend for tests, it implements both
+// api.ConsumerAPI and api.ProviderAPI so it can stand in for a real polaris server.
+package polaristest
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	namingpb "github.com/polarismesh/polaris-go/pkg/model/pb/v1"
+)
+
+// Names of the operations counted by Backend.Calls.
+const (
+	OpGetInstances     = "GetInstances"
+	OpGetAllInstances  = "GetAllInstances"
+	OpWatchService     = "WatchService"
+	OpRegister         = "Register"
+	OpDeregister       = "Deregister"
+	OpHeartbeat        = "Heartbeat"
+	OpUpdateCallResult = "UpdateServiceCallResult"
+)
+
+const eventBufferSize = 64
+
+var (
+	_ api.ConsumerAPI = (*Backend)(nil)
+	_ api.ProviderAPI = (*Backend)(nil)
+)
+
+type service struct {
+	instances []*Instance
+	metadata  map[string]string
+	events    chan model.SubScribeEvent
+	token     string // checked by the provider calls when set
+}
+
+// Backend is an in-memory polaris server.
+type Backend struct {
+	lock       sync.Mutex
+	services   map[model.ServiceKey]*service
+	calls      map[string]int
+	heartbeats []model.InstanceHeartbeatRequest
+	requests   []model.GetInstancesRequest
+	results    []model.ServiceCallResult
+	revision   int
+	destroyed  int
+	dropEvents bool
+	faults     faults
+}
+
+// NewBackend creates an empty Backend.
+func NewBackend() *Backend {
+	return &Backend{
+		services: make(map[model.ServiceKey]*service),
+		calls:    make(map[string]int),
+		faults:   newFaults(),
+	}
+}
+
+// Calls returns how many times op has been invoked.
+func (b *Backend) Calls(op string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.calls[op]
+}
+
+// Destroyed returns how many times Destroy has been invoked.
+func (b *Backend) Destroyed() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.destroyed
+}
+
+// AddInstances adds instances to their services and publishes an add event to watchers.
+// Empty IDs and revisions are generated.
+func (b *Backend) AddInstances(instances ...*Instance) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	added := make(map[model.ServiceKey][]model.Instance)
+	for _, ins := range instances {
+		ins = ins.clone()
+		if ins.ID == "" {
+			ins.ID = fmt.Sprintf("%s:%s:%s:%d", ins.Namespace, ins.Service, ins.Host, ins.Port)
+		}
+		if ins.Revision == "" {
+			ins.Revision = b.nextRevision()
+		}
+		key := model.ServiceKey{Namespace: ins.Namespace, Service: ins.Service}
+		svc := b.service(key)
+		svc.instances = append(svc.instances, ins)
+		added[key] = append(added[key], ins.clone())
+	}
+	for key, list := range added {
+		b.publish(key, &model.InstanceEvent{AddEvent: &model.InstanceAddEvent{Instances: list}})
+	}
+}
+
+// UpdateInstance replaces the instance with the same ID and publishes an update event.
+// The revision is bumped unless explicitly set to a different value by the caller.
+func (b *Backend) UpdateInstance(ins *Instance) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	key := model.ServiceKey{Namespace: ins.Namespace, Service: ins.Service}
+	svc := b.service(key)
+	for i, old := range svc.instances {
+		if old.ID != ins.ID {
+			continue
+		}
+		after := ins.clone()
+		if after.Revision == "" || after.Revision == old.Revision {
+			after.Revision = b.nextRevision()
+		}
+		svc.instances[i] = after
+		b.publish(key, &model.InstanceEvent{UpdateEvent: &model.InstanceUpdateEvent{
+			UpdateList: []model.OneInstanceUpdate{{Before: old.clone(), After: after.clone()}},
+		}})
+		return nil
+	}
+	return fmt.Errorf("instance %s not found", ins.ID)
+}
+
+// RemoveInstances removes instances by ID from a service and publishes a delete event.
+func (b *Backend) RemoveInstances(namespace, serviceName string, ids ...string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	key := model.ServiceKey{Namespace: namespace, Service: serviceName}
+	if removed := b.remove(key, ids...); len(removed) > 0 {
+		b.publish(key, &model.InstanceEvent{DeleteEvent: &model.InstanceDeleteEvent{Instances: removed}})
+	}
+}
+
+// Publish pushes a raw event to the watchers of a service, it is useful for events the
+// mutation helpers can not produce, e.g. duplicate pushes.
+func (b *Backend) Publish(namespace, serviceName string, event model.SubScribeEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.publish(model.ServiceKey{Namespace: namespace, Service: serviceName}, event)
+}
+
+// DropEvents makes the mutations stop publishing events to watchers, like pushes lost on the way.
+func (b *Backend) DropEvents(drop bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.dropEvents = drop
+}
+
+// Instances returns a snapshot of all instances of a service.
+func (b *Backend) Instances(namespace, serviceName string) []*Instance {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	svc := b.service(model.ServiceKey{Namespace: namespace, Service: serviceName})
+	res := make([]*Instance, 0, len(svc.instances))
+	for _, ins := range svc.instances {
+		res = append(res, ins.clone())
+	}
+	return res
+}
+
+// SetServiceMetadata replaces the service level metadata of a service, no event is published.
+func (b *Backend) SetServiceMetadata(namespace, serviceName string, metadata map[string]string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	svc := b.service(model.ServiceKey{Namespace: namespace, Service: serviceName})
+	svc.metadata = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		svc.metadata[k] = v
+	}
+}
+
+// SetServiceToken makes Register, Deregister and Heartbeat fail with the Unauthorized server code of
+// polaris unless their service token is token, an empty token disables the check.
+func (b *Backend) SetServiceToken(namespace, serviceName, token string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.service(model.ServiceKey{Namespace: namespace, Service: serviceName}).token = token
+}
+
+// authorize checks the service token of a provider call, b.lock is held.
+func (b *Backend) authorize(key model.ServiceKey, token string) error {
+	if want := b.service(key).token; want != "" && want != token {
+		return model.NewServerSDKError(namingpb.Unauthorized, "unauthorized", nil,
+			"server error from 127.0.0.1:8091: unauthorized")
+	}
+	return nil
+}
+
+// GetOneInstance implements api.ConsumerAPI.
+func (b *Backend) GetOneInstance(req *api.GetOneInstanceRequest) (*model.OneInstanceResponse, error) {
+	return nil, model.NewSDKError(model.ErrCodeAPIInvalidArgument, nil, "GetOneInstance is not supported by polaristest")
+}
+
+// GetInstances implements api.ConsumerAPI, it returns healthy and not isolated instances
+// whose metadata matches the request.
+func (b *Backend) GetInstances(req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	if err := b.inject(OpGetInstances); err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls[OpGetInstances]++
+	b.requests = append(b.requests, req.GetInstancesRequest)
+	resp := b.response(req.Namespace, req.Service)
+	for _, ins := range b.served(model.ServiceKey{Namespace: req.Namespace, Service: req.Service}) {
+		if ins.Isolated || (ins.Unhealthy && !req.IncludeUnhealthyInstances) || !matchMetadata(ins, req.Metadata) {
+			continue
+		}
+		resp.Instances = append(resp.Instances, ins.clone())
+	}
+	return resp, nil
+}
+
+// GetInstancesRequests returns the GetInstances requests received so far.
+func (b *Backend) GetInstancesRequests() []model.GetInstancesRequest {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]model.GetInstancesRequest(nil), b.requests...)
+}
+
+// GetAllInstances implements api.ConsumerAPI.
+func (b *Backend) GetAllInstances(req *api.GetAllInstancesRequest) (*model.InstancesResponse, error) {
+	if err := b.inject(OpGetAllInstances); err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls[OpGetAllInstances]++
+	return b.allInstances(req.Namespace, req.Service), nil
+}
+
+// GetRouteRule implements api.ConsumerAPI.
+func (b *Backend) GetRouteRule(req *api.GetServiceRuleRequest) (*model.ServiceRuleResponse, error) {
+	return &model.ServiceRuleResponse{}, nil
+}
+
+// UpdateServiceCallResult implements api.ConsumerAPI.
+func (b *Backend) UpdateServiceCallResult(req *api.ServiceCallResult) error {
+	if err := b.inject(OpUpdateCallResult); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls[OpUpdateCallResult]++
+	b.results = append(b.results, req.ServiceCallResult)
+	return nil
+}
+
+// CallResults returns the call results reported so far.
+func (b *Backend) CallResults() []model.ServiceCallResult {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]model.ServiceCallResult(nil), b.results...)
+}
+
+// Destroy implements api.ConsumerAPI and api.ProviderAPI.
+func (b *Backend) Destroy() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.destroyed++
+}
+
+// SDKContext implements api.SDKOwner, the backend has no SDK context.
+func (b *Backend) SDKContext() api.SDKContext {
+	return nil
+}
+
+// WatchService implements api.ConsumerAPI. Like the polaris-go local channel subscriber,
+// all watchers of a service share one event channel.
+func (b *Backend) WatchService(req *api.WatchServiceRequest) (*model.WatchServiceResponse, error) {
+	if err := b.inject(OpWatchService); err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls[OpWatchService]++
+	svc := b.service(req.Key)
+	if svc.events == nil {
+		svc.events = make(chan model.SubScribeEvent, eventBufferSize)
+	}
+	return &model.WatchServiceResponse{
+		EventChannel:        svc.events,
+		GetAllInstancesResp: b.allInstances(req.Key.Namespace, req.Key.Service),
+	}, nil
+}
+
+// GetMeshConfig implements api.ConsumerAPI.
+func (b *Backend) GetMeshConfig(req *api.GetMeshConfigRequest) (*model.MeshConfigResponse, error) {
+	return &model.MeshConfigResponse{}, nil
+}
+
+// GetMesh implements api.ConsumerAPI.
+func (b *Backend) GetMesh(req *api.GetMeshRequest) (*model.MeshResponse, error) {
+	return &model.MeshResponse{}, nil
+}
+
+// GetServicesByBusiness implements api.ConsumerAPI.
+func (b *Backend) GetServicesByBusiness(req *api.GetServicesRequest) (*model.ServicesResponse, error) {
+	return &model.ServicesResponse{}, nil
+}
+
+// InitCalleeService implements api.ConsumerAPI.
+func (b *Backend) InitCalleeService(req *api.InitCalleeServiceRequest) error {
+	return nil
+}
+
+// Register implements api.ProviderAPI.
+func (b *Backend) Register(req *api.InstanceRegisterRequest) (*model.InstanceRegisterResponse, error) {
+	if err := b.inject(OpRegister); err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	b.calls[OpRegister]++
+	key := model.ServiceKey{Namespace: req.Namespace, Service: req.Service}
+	if err := b.authorize(key, req.ServiceToken); err != nil {
+		b.lock.Unlock()
+		return nil, err
+	}
+	id := fmt.Sprintf("%s:%s:%s:%d", req.Namespace, req.Service, req.Host, req.Port)
+	existed := len(b.remove(key, id)) > 0
+	b.lock.Unlock()
+
+	ins := &Instance{
+		ID:        id,
+		Namespace: req.Namespace,
+		Service:   req.Service,
+		Host:      req.Host,
+		Port:      uint32(req.Port),
+		Metadata:  req.Metadata,
+	}
+	if req.Protocol != nil {
+		ins.Protocol = *req.Protocol
+	}
+	if req.Version != nil {
+		ins.Version = *req.Version
+	}
+	if req.Weight != nil {
+		ins.Weight = *req.Weight
+	}
+	if req.Healthy != nil {
+		ins.Unhealthy = !*req.Healthy
+	}
+	if req.Isolate != nil {
+		ins.Isolated = *req.Isolate
+	}
+	b.AddInstances(ins)
+	return &model.InstanceRegisterResponse{InstanceID: id, Existed: existed}, nil
+}
+
+// Deregister implements api.ProviderAPI.
+func (b *Backend) Deregister(req *api.InstanceDeRegisterRequest) error {
+	if err := b.inject(OpDeregister); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.calls[OpDeregister]++
+	if err := b.authorize(model.ServiceKey{Namespace: req.Namespace, Service: req.Service}, req.ServiceToken); err != nil {
+		b.lock.Unlock()
+		return err
+	}
+	id := req.InstanceID
+	if id == "" {
+		id = fmt.Sprintf("%s:%s:%s:%d", req.Namespace, req.Service, req.Host, req.Port)
+	}
+	found := false
+	for _, ins := range b.service(model.ServiceKey{Namespace: req.Namespace, Service: req.Service}).instances {
+		found = found || ins.ID == id
+	}
+	b.lock.Unlock()
+	if !found {
+		return model.NewSDKError(model.ErrCodeAPIInstanceNotFound, nil, "instance %s not found", id)
+	}
+	b.RemoveInstances(req.Namespace, req.Service, id)
+	return nil
+}
+
+// Heartbeat implements api.ProviderAPI.
+func (b *Backend) Heartbeat(req *api.InstanceHeartbeatRequest) error {
+	if err := b.inject(OpHeartbeat); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls[OpHeartbeat]++
+	if err := b.authorize(model.ServiceKey{Namespace: req.Namespace, Service: req.Service}, req.ServiceToken); err != nil {
+		return err
+	}
+	b.heartbeats = append(b.heartbeats, req.InstanceHeartbeatRequest)
+	return nil
+}
+
+// Heartbeats returns the heartbeat requests received so far.
+func (b *Backend) Heartbeats() []model.InstanceHeartbeatRequest {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]model.InstanceHeartbeatRequest(nil), b.heartbeats...)
+}
+
+func (b *Backend) service(key model.ServiceKey) *service {
+	svc, ok := b.services[key]
+	if !ok {
+		svc = &service{}
+		b.services[key] = svc
+	}
+	return svc
+}
+
+func (b *Backend) remove(key model.ServiceKey, ids ...string) []model.Instance {
+	svc := b.service(key)
+	var removed []model.Instance
+	kept := svc.instances[:0]
+	for _, ins := range svc.instances {
+		if contains(ids, ins.ID) {
+			removed = append(removed, ins.clone())
+			continue
+		}
+		kept = append(kept, ins)
+	}
+	svc.instances = kept
+	return removed
+}
+
+func (b *Backend) publish(key model.ServiceKey, event model.SubScribeEvent) {
+	svc := b.service(key)
+	if svc.events == nil || b.dropEvents {
+		return
+	}
+	select {
+	case svc.events <- event:
+	default:
+	}
+}
+
+func (b *Backend) allInstances(namespace, serviceName string) *model.InstancesResponse {
+	resp := b.response(namespace, serviceName)
+	for _, ins := range b.served(model.ServiceKey{Namespace: namespace, Service: serviceName}) {
+		resp.Instances = append(resp.Instances, ins.clone())
+	}
+	return resp
+}
+
+func (b *Backend) response(namespace, serviceName string) *model.InstancesResponse {
+	svc := b.service(model.ServiceKey{Namespace: namespace, Service: serviceName})
+	metadata := make(map[string]string, len(svc.metadata))
+	for k, v := range svc.metadata {
+		metadata[k] = v
+	}
+	return &model.InstancesResponse{
+		ServiceInfo: model.ServiceInfo{Service: serviceName, Namespace: namespace, Metadata: metadata},
+		Revision:    strconv.Itoa(b.revision),
+	}
+}
+
+func (b *Backend) nextRevision() string {
+	b.revision++
+	return strconv.Itoa(b.revision)
+}
+
+func matchMetadata(ins *Instance, metadata map[string]string) bool {
+	for k, v := range metadata {
+		if ins.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaristest
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const defaultFaultSeed = 1
+
+// faults are the failures injected by a Backend, they are guarded by the lock of the Backend.
+type faults struct {
+	rng          *rand.Rand
+	failureRates map[string]float64
+	latencies    map[string]time.Duration
+	blackhole    bool
+	// stale is the snapshot served by GetInstances and GetAllInstances while SetStale is on.
+	stale map[model.ServiceKey][]*Instance
+}
+
+func newFaults() faults {
+	return faults{
+		rng:          rand.New(rand.NewSource(defaultFaultSeed)),
+		failureRates: make(map[string]float64),
+		latencies:    make(map[string]time.Duration),
+	}
+}
+
+// SetSeed reseeds the generator deciding the injected failures, a Backend behaves the same for the
+// same seed and sequence of calls. The default seed is 1.
+func (b *Backend) SetSeed(seed int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.faults.rng = rand.New(rand.NewSource(seed))
+}
+
+// SetFailureRate makes op fail with a network error with probability p, 0 disables the failures and
+// 1 fails every call. Failed calls are counted by Calls too.
+func (b *Backend) SetFailureRate(op string, p float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if p <= 0 {
+		delete(b.faults.failureRates, op)
+		return
+	}
+	b.faults.failureRates[op] = p
+}
+
+// SetLatency delays every call of op by d before it is served.
+func (b *Backend) SetLatency(op string, d time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if d <= 0 {
+		delete(b.faults.latencies, op)
+		return
+	}
+	b.faults.latencies[op] = d
+}
+
+// SetHeartbeatBlackhole makes heartbeats time out without reaching the backend, as if the server
+// stopped answering them. They are counted by Calls but not returned by Heartbeats.
+func (b *Backend) SetHeartbeatBlackhole(blackhole bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.faults.blackhole = blackhole
+}
+
+// SetStale makes GetInstances and GetAllInstances serve the instances of the moment it was turned on,
+// like a server answering from a stale cache. Events are still published.
+func (b *Backend) SetStale(stale bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !stale {
+		b.faults.stale = nil
+		return
+	}
+	b.faults.stale = make(map[model.ServiceKey][]*Instance, len(b.services))
+	for key, svc := range b.services {
+		snapshot := make([]*Instance, 0, len(svc.instances))
+		for _, ins := range svc.instances {
+			snapshot = append(snapshot, ins.clone())
+		}
+		b.faults.stale[key] = snapshot
+	}
+}
+
+// CloseEventChannel closes the event channel of a watched service. It is a synthetic fault, the
+// polaris-go local channel subscriber keeps one channel per service and never closes it.
+// The next WatchService of the service opens a new one.
+func (b *Backend) CloseEventChannel(namespace, serviceName string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	svc := b.service(model.ServiceKey{Namespace: namespace, Service: serviceName})
+	if svc.events != nil {
+		close(svc.events)
+		svc.events = nil
+	}
+}
+
+// inject applies the latency of op and returns the failure injected into it, if any.
+func (b *Backend) inject(op string) error {
+	b.lock.Lock()
+	latency := b.faults.latencies[op]
+	var err error
+	if op == OpHeartbeat && b.faults.blackhole {
+		err = model.NewSDKError(model.ErrCodeAPITimeoutError, nil, "injected heartbeat blackhole")
+	} else if p, ok := b.faults.failureRates[op]; ok && b.faults.rng.Float64() < p {
+		err = model.NewSDKError(model.ErrCodeNetworkError, nil, "injected failure of %s", op)
+	}
+	if err != nil {
+		b.calls[op]++
+	}
+	b.lock.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return err
+}
+
+// served returns the instances of a service as GetInstances sees them.
+func (b *Backend) served(key model.ServiceKey) []*Instance {
+	if b.faults.stale != nil {
+		return b.faults.stale[key]
+	}
+	return b.service(key).instances
+}
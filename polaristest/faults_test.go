@@ -0,0 +1,134 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaristest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testNamespace = "Production"
+	testService   = "faults.test"
+)
+
+func failures(b *Backend, n int) []bool {
+	req := &api.GetInstancesRequest{}
+	req.Namespace, req.Service = testNamespace, testService
+	var failed []bool
+	for i := 0; i < n; i++ {
+		_, err := b.GetInstances(req)
+		failed = append(failed, err != nil)
+	}
+	return failed
+}
+
+func TestFailureRateDeterministic(t *testing.T) {
+	b1, b2 := NewBackend(), NewBackend()
+	b1.SetFailureRate(OpGetInstances, 0.5)
+	b2.SetFailureRate(OpGetInstances, 0.5)
+	first := failures(b1, 32)
+	require.Equal(t, first, failures(b2, 32))
+	require.Contains(t, first, true)
+	require.Contains(t, first, false)
+	require.Equal(t, 32, b1.Calls(OpGetInstances))
+
+	b1.SetSeed(1)
+	require.Equal(t, first, failures(b1, 32))
+	b1.SetSeed(2)
+	require.NotEqual(t, first, failures(b1, 32))
+
+	b1.SetFailureRate(OpGetInstances, 0)
+	require.NotContains(t, failures(b1, 8), true)
+	b1.SetFailureRate(OpGetInstances, 1)
+	require.NotContains(t, failures(b1, 8), false)
+}
+
+func TestInjectedError(t *testing.T) {
+	b := NewBackend()
+	b.SetFailureRate(OpRegister, 1)
+	_, err := b.Register(&api.InstanceRegisterRequest{})
+	sdkErr, ok := err.(model.SDKError)
+	require.True(t, ok)
+	require.Equal(t, model.ErrCodeNetworkError, sdkErr.ErrorCode())
+}
+
+func TestLatency(t *testing.T) {
+	b := NewBackend()
+	b.SetLatency(OpGetAllInstances, 20*time.Millisecond)
+	req := &api.GetAllInstancesRequest{}
+	req.Namespace, req.Service = testNamespace, testService
+	start := time.Now()
+	_, err := b.GetAllInstances(req)
+	require.Nil(t, err)
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestHeartbeatBlackhole(t *testing.T) {
+	b := NewBackend()
+	b.SetHeartbeatBlackhole(true)
+	err := b.Heartbeat(&api.InstanceHeartbeatRequest{})
+	sdkErr, ok := err.(model.SDKError)
+	require.True(t, ok)
+	require.Equal(t, model.ErrCodeAPITimeoutError, sdkErr.ErrorCode())
+	require.Empty(t, b.Heartbeats())
+	require.Equal(t, 1, b.Calls(OpHeartbeat))
+
+	b.SetHeartbeatBlackhole(false)
+	require.Nil(t, b.Heartbeat(&api.InstanceHeartbeatRequest{}))
+	require.Len(t, b.Heartbeats(), 1)
+}
+
+func TestStale(t *testing.T) {
+	b := NewBackend()
+	b.AddInstances(&Instance{Namespace: testNamespace, Service: testService, Host: "127.0.0.1", Port: 6666})
+	b.SetStale(true)
+	b.AddInstances(&Instance{Namespace: testNamespace, Service: testService, Host: "127.0.0.1", Port: 7777})
+	req := &api.GetInstancesRequest{}
+	req.Namespace, req.Service = testNamespace, testService
+	resp, err := b.GetInstances(req)
+	require.Nil(t, err)
+	require.Len(t, resp.GetInstances(), 1)
+	require.Equal(t, uint32(6666), resp.GetInstances()[0].GetPort())
+
+	b.SetStale(false)
+	resp, err = b.GetInstances(req)
+	require.Nil(t, err)
+	require.Len(t, resp.GetInstances(), 2)
+}
+
+func TestCloseEventChannel(t *testing.T) {
+	b := NewBackend()
+	key := model.ServiceKey{Namespace: testNamespace, Service: testService}
+	resp, err := b.WatchService(&api.WatchServiceRequest{WatchServiceRequest: model.WatchServiceRequest{Key: key}})
+	require.Nil(t, err)
+	b.CloseEventChannel(testNamespace, testService)
+	_, ok := <-resp.EventChannel
+	require.False(t, ok)
+	// publishing to a closed watch does not panic.
+	b.AddInstances(&Instance{Namespace: testNamespace, Service: testService, Host: "127.0.0.1", Port: 6666})
+
+	resp, err = b.WatchService(&api.WatchServiceRequest{WatchServiceRequest: model.WatchServiceRequest{Key: key}})
+	require.Nil(t, err)
+	b.AddInstances(&Instance{Namespace: testNamespace, Service: testService, Host: "127.0.0.1", Port: 7777})
+	event := <-resp.EventChannel
+	require.Len(t, event.(*model.InstanceEvent).AddEvent.Instances, 1)
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaristest
+
+import (
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// Instance is an in-memory polaris instance served by Backend, it implements model.Instance.
+type Instance struct {
+	ID        string
+	Namespace string
+	Service   string
+	Host      string
+	Port      uint32
+	Protocol  string
+	Version   string
+	Weight    int
+	Priority  uint32
+	Metadata  map[string]string
+	LogicSet  string
+	Region    string
+	Zone      string
+	IDC       string
+	Campus    string
+	Revision  string
+	// Ctime and Mtime are the create and modify times of polaris, like "2006-01-02 15:04:05".
+	Ctime string
+	Mtime string
+	// Unhealthy and Isolated are negated so that the zero value is a normal serving instance.
+	Unhealthy bool
+	Isolated  bool
+	// Breaker is the circuit breaker status of the instance, zero means none.
+	Breaker model.Status
+}
+
+var _ model.Instance = (*Instance)(nil)
+
+// GetInstanceKey implements model.Instance.
+func (i *Instance) GetInstanceKey() model.InstanceKey {
+	return model.InstanceKey{
+		ServiceKey: model.ServiceKey{Namespace: i.Namespace, Service: i.Service},
+		Host:       i.Host,
+		Port:       int(i.Port),
+	}
+}
+
+// GetNamespace implements model.Instance.
+func (i *Instance) GetNamespace() string { return i.Namespace }
+
+// GetService implements model.Instance.
+func (i *Instance) GetService() string { return i.Service }
+
+// GetId implements model.Instance.
+func (i *Instance) GetId() string { return i.ID }
+
+// GetHost implements model.Instance.
+func (i *Instance) GetHost() string { return i.Host }
+
+// GetPort implements model.Instance.
+func (i *Instance) GetPort() uint32 { return i.Port }
+
+// GetVpcId implements model.Instance.
+func (i *Instance) GetVpcId() string { return "" }
+
+// GetProtocol implements model.Instance.
+func (i *Instance) GetProtocol() string { return i.Protocol }
+
+// GetVersion implements model.Instance.
+func (i *Instance) GetVersion() string { return i.Version }
+
+// GetWeight implements model.Instance.
+func (i *Instance) GetWeight() int { return i.Weight }
+
+// GetPriority implements model.Instance.
+func (i *Instance) GetPriority() uint32 { return i.Priority }
+
+// GetMetadata implements model.Instance.
+func (i *Instance) GetMetadata() map[string]string { return i.Metadata }
+
+// GetLogicSet implements model.Instance.
+func (i *Instance) GetLogicSet() string { return i.LogicSet }
+
+// GetCircuitBreakerStatus implements model.Instance.
+func (i *Instance) GetCircuitBreakerStatus() model.CircuitBreakerStatus {
+	if i.Breaker == 0 {
+		return nil
+	}
+	return breakerStatus(i.Breaker)
+}
+
+// IsHealthy implements model.Instance.
+func (i *Instance) IsHealthy() bool { return !i.Unhealthy }
+
+// IsIsolated implements model.Instance.
+func (i *Instance) IsIsolated() bool { return i.Isolated }
+
+// IsEnableHealthCheck implements model.Instance.
+func (i *Instance) IsEnableHealthCheck() bool { return true }
+
+// GetRegion implements model.Instance.
+func (i *Instance) GetRegion() string { return i.Region }
+
+// GetZone implements model.Instance.
+func (i *Instance) GetZone() string { return i.Zone }
+
+// GetIDC implements model.Instance.
+func (i *Instance) GetIDC() string { return i.IDC }
+
+// GetCampus implements model.Instance.
+func (i *Instance) GetCampus() string { return i.Campus }
+
+// GetRevision implements model.Instance.
+func (i *Instance) GetRevision() string { return i.Revision }
+
+// GetCtime returns the create time of the instance, like the polaris-go instances.
+func (i *Instance) GetCtime() string { return i.Ctime }
+
+// GetMtime returns the modify time of the instance, like the polaris-go instances.
+func (i *Instance) GetMtime() string { return i.Mtime }
+
+// clone returns a copy of the instance so that snapshots handed out are not mutated afterwards.
+func (i *Instance) clone() *Instance {
+	c := *i
+	if i.Metadata != nil {
+		c.Metadata = make(map[string]string, len(i.Metadata))
+		for k, v := range i.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return &c
+}
+
+// breakerStatus is a fixed circuit breaker status.
+type breakerStatus model.Status
+
+func (s breakerStatus) GetCircuitBreaker() string { return "polaristest" }
+
+func (s breakerStatus) GetStatus() model.Status { return model.Status(s) }
+
+func (s breakerStatus) GetStartTime() time.Time { return time.Time{} }
+
+func (s breakerStatus) IsAvailable() bool { return model.Status(s) != model.Open }
+
+func (s breakerStatus) Allocate() bool { return s.IsAvailable() }
+
+func (s breakerStatus) GetRequestsAfterHalfOpen() int32 { return 0 }
+
+func (s breakerStatus) GetFailRequestsAfterHalfOpen() int32 { return 0 }
+
+func (s breakerStatus) AddRequestCountAfterHalfOpen(n int32, success bool) int32 { return 0 }
+
+func (s breakerStatus) GetFinalAllocateTimeInt64() int64 { return 0 }
+
+func (s breakerStatus) AcquireStatusLock() bool { return false }
+
+func (s breakerStatus) AllocatedRequestsAfterHalfOpen() int32 { return 0 }
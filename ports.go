@@ -0,0 +1,96 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	perrors "github.com/pkg/errors"
+)
+
+// MetadataPortPrefix is the prefix of the metadata keys of the additional ports of an instance,
+// like "port.debug=6060", see WithAdditionalPorts.
+const MetadataPortPrefix = "port."
+
+const maxPortNameLength = 64
+
+// validPortName tells whether name is a name of WithAdditionalPorts, made of ASCII letters, digits,
+// '-' and '_' so that it reads back unchanged from the metadata key.
+func validPortName(name string) bool {
+	if name == "" || len(name) > maxPortNameLength {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validPort tells whether port is a TCP or UDP port number.
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// validateAdditionalPorts checks the ports of WithAdditionalPorts, an error names every invalid entry.
+func (o *options) validateAdditionalPorts() error {
+	var invalid []string
+	for name, port := range o.additionalPorts {
+		switch {
+		case !validPortName(name):
+			invalid = append(invalid, strconv.Quote(name)+": name is not made of letters, digits, '-' and '_'")
+		case !validPort(port):
+			invalid = append(invalid, name+": port "+strconv.Itoa(port)+" is not in 1-65535")
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	sort.Strings(invalid)
+	return perrors.Errorf("invalid WithAdditionalPorts entries %s", strings.Join(invalid, "; "))
+}
+
+// setAdditionalPorts sets the metadata of the ports of WithAdditionalPorts.
+func (o *options) setAdditionalPorts(metadata map[string]string) {
+	for name, port := range o.additionalPorts {
+		metadata[MetadataPortPrefix+name] = strconv.Itoa(port)
+	}
+}
+
+// InstancePort returns the additional port name of a resolved instance registered with WithAdditionalPorts,
+// ok is false when the instance has no valid port of that name. The resolvers created with
+// WithMetadataTagPrefixPassthrough keep the ports when MetadataPortPrefix is one of the prefixes.
+func InstancePort(ins discovery.Instance, name string) (port int, ok bool) {
+	if ins == nil {
+		return 0, false
+	}
+	value, ok := ins.Tag(MetadataPortPrefix + name)
+	if !ok {
+		return 0, false
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || !validPort(port) {
+		return 0, false
+	}
+	return port, true
+}
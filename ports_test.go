@@ -0,0 +1,106 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdditionalPortsRoundTrip(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithAdditionalPorts(map[string]int{"debug": 6060, "admin_http": 9090}))
+	info := newTestInfo("127.0.0.1:6666", map[string]string{"env": "prod"})
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	metadata := backend.Instances(polarisDefaultNamespace, serviceName)[0].Metadata
+	require.Equal(t, "6060", metadata["port.debug"])
+	require.Equal(t, "9090", metadata["port.admin_http"])
+
+	result, err := newTestResolver(backend).Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	ins := result.Instances[0]
+	require.Equal(t, "127.0.0.1:6666", ins.Address().String())
+	port, ok := InstancePort(ins, "debug")
+	require.True(t, ok)
+	require.Equal(t, 6060, port)
+	port, ok = InstancePort(ins, "admin_http")
+	require.True(t, ok)
+	require.Equal(t, 9090, port)
+	_, ok = InstancePort(ins, "metrics")
+	require.False(t, ok)
+
+	// the prefix passthrough drops the ports unless it keeps their prefix.
+	result, err = newTestResolver(backend, WithMetadataTagPrefixPassthrough([]string{MetadataConnPrefix})).
+		Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	_, ok = InstancePort(result.Instances[0], "debug")
+	require.False(t, ok)
+	result, err = newTestResolver(backend, WithMetadataTagPrefixPassthrough([]string{MetadataPortPrefix})).
+		Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	port, ok = InstancePort(result.Instances[0], "debug")
+	require.True(t, ok)
+	require.Equal(t, 6060, port)
+}
+
+func TestInstancePortInvalidMetadata(t *testing.T) {
+	ins := discovery.NewInstance("tcp", "127.0.0.1:6666", 10, map[string]string{
+		"port.debug": "6060", "port.admin": "http", "port.metrics": "70000", "port.zero": "0",
+	})
+	port, ok := InstancePort(ins, "debug")
+	require.True(t, ok)
+	require.Equal(t, 6060, port)
+	for _, name := range []string{"admin", "metrics", "zero", "missing"} {
+		_, ok := InstancePort(ins, name)
+		require.False(t, ok, name)
+	}
+	_, ok = InstancePort(nil, "debug")
+	require.False(t, ok)
+}
+
+func TestAdditionalPortsValidation(t *testing.T) {
+	require.Nil(t, newOptions([]Option{WithAdditionalPorts(map[string]int{"debug": 6060, "a-b_C9": 1, "max": 65535})}).
+		validateAdditionalPorts())
+	require.Nil(t, newOptions(nil).validateAdditionalPorts())
+
+	_, err := NewPolarisRegistry([]string{"127.0.0.1:8091"}, WithAdditionalPorts(map[string]int{
+		"debug": 6060, "de.bug": 6061, "": 6062, "metrics": 70000, "admin": 0,
+	}))
+	require.NotNil(t, err)
+	for _, want := range []string{`"de.bug"`, `""`, "metrics: port 70000", "admin: port 0"} {
+		require.True(t, strings.Contains(err.Error(), want), err.Error())
+	}
+	require.False(t, strings.Contains(err.Error(), "debug:"), err.Error())
+	require.False(t, validPortName(strings.Repeat("a", maxPortNameLength+1)))
+}
+
+func TestWithAdditionalPortsCopies(t *testing.T) {
+	ports := map[string]int{"debug": 6060}
+	o := newOptions([]Option{WithAdditionalPorts(ports)})
+	ports["debug"] = 7070
+	metadata := instanceMetadata(newTestInfo("127.0.0.1:6666", map[string]string{"port.admin": "9000"}), o)
+	require.Equal(t, "6060", metadata["port.debug"])
+	require.Equal(t, "9000", metadata["port.admin"])
+}
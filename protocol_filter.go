@@ -0,0 +1,80 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
+
+// TagProtocol is the instance metadata key declaring the Kitex transport protocol of an instance,
+// like TTHeader or GRPC, see WithProtocolFilter.
+const TagProtocol = "protocol"
+
+// networks are the values of the polaris protocol field that only name the network, the registry
+// stores the network of the server address there.
+var networks = map[string]struct{}{
+	"": {}, "tcp": {}, "tcp4": {}, "tcp6": {}, "udp": {}, "unix": {},
+}
+
+// instanceProtocol returns the transport protocol declared by an instance, either by the TagProtocol
+// metadata or by a polaris protocol field that is not a network name.
+func instanceProtocol(ins discovery.Instance) (string, bool) {
+	if proto, ok := ins.Tag(TagProtocol); ok && proto != "" {
+		return proto, true
+	}
+	network := ins.Address().Network()
+	if _, ok := networks[strings.ToLower(network)]; ok {
+		return "", false
+	}
+	return network, true
+}
+
+// filterProtocol keeps the instances declaring proto, case insensitively. The instances are returned
+// unfiltered when proto is empty or none of them declares a protocol.
+func filterProtocol(proto string, instances []discovery.Instance) []discovery.Instance {
+	if proto == "" || len(instances) == 0 {
+		return instances
+	}
+	matched := make([]discovery.Instance, 0, len(instances))
+	declared := false
+	for _, ins := range instances {
+		p, ok := instanceProtocol(ins)
+		if !ok {
+			continue
+		}
+		declared = true
+		if strings.EqualFold(p, proto) {
+			matched = append(matched, ins)
+		}
+	}
+	if !declared {
+		return instances
+	}
+	return matched
+}
+
+func newProtocolFilter(proto string) instanceFilter {
+	return instanceFilter{
+		name: TagProtocol + "=" + proto,
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			return filterProtocol(proto, instances)
+		},
+	}
+}
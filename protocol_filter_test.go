@@ -0,0 +1,122 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/transport"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newProtocolInstance(port uint32, protocol string, metadata map[string]string) *polaristest.Instance {
+	return &polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1",
+		Port: port, Protocol: protocol, Metadata: metadata}
+}
+
+func addresses(instances []discovery.Instance) []string {
+	addrs := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		addrs = append(addrs, ins.Address().String())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestResolveProtocolFilter(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		newProtocolInstance(6666, "tcp", map[string]string{TagProtocol: "GRPC"}),
+		newProtocolInstance(7777, "tcp", map[string]string{TagProtocol: "TTHeader"}),
+		newProtocolInstance(8888, "grpc", nil),
+		newProtocolInstance(9999, "tcp", nil),
+	)
+	rs := newTestResolver(backend, WithProtocolFilter(transport.GRPC.String()))
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666", "127.0.0.1:8888"}, addresses(result.Instances))
+}
+
+func TestResolveProtocolFilterUnmatched(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newProtocolInstance(7777, "tcp", map[string]string{TagProtocol: "TTHeader"}))
+	rs := newTestResolver(backend, WithProtocolFilter(transport.GRPC.String()))
+
+	_, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance))
+	require.Equal(t, 1, noInstance.TotalFromPolaris)
+	require.Equal(t, []string{TagProtocol + "=GRPC"}, noInstance.Filters)
+}
+
+func TestResolveProtocolFilterUndeclared(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newProtocolInstance(6666, "tcp", nil), newProtocolInstance(7777, "", nil))
+	rs := newTestResolver(backend, WithProtocolFilter(transport.GRPC.String()))
+
+	result, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666", "127.0.0.1:7777"}, addresses(result.Instances))
+}
+
+func TestWatcherProtocolFilter(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newProtocolInstance(6666, "tcp", map[string]string{TagProtocol: "GRPC"}))
+	rs := newTestResolver(backend, WithProtocolFilter(transport.GRPC.String()))
+	requireInitialWatch(t, rs, polarisDefaultNamespace+":"+serviceName, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		backend.AddInstances(
+			newProtocolInstance(7777, "tcp", map[string]string{TagProtocol: "TTHeader"}),
+			newProtocolInstance(8888, "tcp", map[string]string{TagProtocol: "grpc"}),
+		)
+	}()
+
+	change, err := rs.Watcher(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addresses(change.Result.Instances))
+	require.Equal(t, []string{"127.0.0.1:8888"}, addresses(change.Added))
+}
+
+func TestSubscribeProtocolFilter(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newProtocolInstance(6666, "tcp", map[string]string{TagProtocol: "GRPC"}))
+	rs := newTestResolver(backend, WithProtocolFilter(transport.GRPC.String()))
+	changes := make(chan discovery.Change, 1)
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":"+serviceName, func(change discovery.Change) {
+		changes <- change
+	})
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	backend.AddInstances(newProtocolInstance(7777, "tcp", map[string]string{TagProtocol: "TTHeader"}))
+	select {
+	case change := <-changes:
+		require.Empty(t, change.Added)
+		require.Equal(t, []string{"127.0.0.1:6666"}, addresses(change.Result.Instances))
+	case <-time.After(time.Second):
+		t.Fatal("no change received")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// Labels of the built-in LabelExtractors, matched by the polaris rate limit rules.
+const (
+	RateLimitLabelMethod        = "method"
+	RateLimitLabelCallerService = "caller_service"
+	// RateLimitLabelOverflow replaces the values of a label over WithRateLimitMaxLabels.
+	RateLimitLabelOverflow = "overflow"
+
+	defaultRateLimitMaxLabels = 1000
+)
+
+// LabelExtractor returns the labels of a call of method matched by the polaris rate limit rules.
+type LabelExtractor func(ctx context.Context, method string) map[string]string
+
+// QuotaAPI is the part of api.LimitAPI used by NewRateLimitMiddleware.
+type QuotaAPI interface {
+	GetQuota(request api.QuotaRequest) (api.QuotaFuture, error)
+}
+
+// MethodLabelExtractor labels the calls with their method as RateLimitLabelMethod.
+func MethodLabelExtractor(ctx context.Context, method string) map[string]string {
+	if method == "" {
+		return nil
+	}
+	return map[string]string{RateLimitLabelMethod: method}
+}
+
+// CallerServiceLabelExtractor labels the calls with the service name of the caller as RateLimitLabelCallerService.
+func CallerServiceLabelExtractor(ctx context.Context, method string) map[string]string {
+	ri := rpcinfo.GetRPCInfo(ctx)
+	if ri == nil || ri.From() == nil || ri.From().ServiceName() == "" {
+		return nil
+	}
+	return map[string]string{RateLimitLabelCallerService: ri.From().ServiceName()}
+}
+
+// MetaInfoLabelExtractor labels the calls with the metainfo values of keys sent by the caller, transient
+// values before persistent ones.
+func MetaInfoLabelExtractor(keys ...string) LabelExtractor {
+	keys = append([]string(nil), keys...)
+	return func(ctx context.Context, method string) map[string]string {
+		var labels map[string]string
+		for _, key := range keys {
+			value, ok := metainfo.GetValue(ctx, key)
+			if !ok {
+				value, ok = metainfo.GetPersistentValue(ctx, key)
+			}
+			if !ok {
+				continue
+			}
+			if labels == nil {
+				labels = make(map[string]string, len(keys))
+			}
+			labels[key] = value
+		}
+		return labels
+	}
+}
+
+// labelGuard bounds the distinct values of every label, the SDK keeps a rate limit window per label set.
+type labelGuard struct {
+	max    int
+	lock   sync.Mutex
+	values map[string]map[string]struct{}
+}
+
+// guard replaces the values over the bound with RateLimitLabelOverflow, the first time a label overflows
+// is logged.
+func (g *labelGuard) guard(labels map[string]string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for key, value := range labels {
+		seen, ok := g.values[key]
+		if !ok {
+			seen = make(map[string]struct{})
+			g.values[key] = seen
+		}
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		if len(seen) < g.max {
+			seen[value] = struct{}{}
+			continue
+		}
+		if _, ok := seen[RateLimitLabelOverflow]; !ok {
+			seen[RateLimitLabelOverflow] = struct{}{}
+			log.GetBaseLogger().Warnf("[Polaris ratelimit] label %s has over %d values, reporting the new ones as %s",
+				key, g.max, RateLimitLabelOverflow)
+		}
+		labels[key] = RateLimitLabelOverflow
+	}
+}
+
+// rateLimitExtractors returns the extractors of the options in precedence order, later labels override earlier ones.
+func (o *options) rateLimitExtractors() []LabelExtractor {
+	extractors := []LabelExtractor{MethodLabelExtractor, CallerServiceLabelExtractor}
+	if len(o.rateLimitMetaKeys) > 0 {
+		extractors = append(extractors, MetaInfoLabelExtractor(o.rateLimitMetaKeys...))
+	}
+	if o.rateLimitLabels != nil {
+		extractors = append(extractors, o.rateLimitLabels)
+	}
+	return extractors
+}
+
+// NewRateLimitMiddleware returns a server middleware asking limiter, like an api.LimitAPI of the SDK context
+// of the registry, for a quota of the service in namespace for every call. The calls over the polaris
+// rate limit rules fail with kerrors.ErrOverlimit, the calls are let through when polaris fails.
+//
+// The rules match the labels of the call, merged in this order, a later label overriding an earlier one:
+// RateLimitLabelMethod, RateLimitLabelCallerService, the metainfo of WithRateLimitMetaKeys and the labels
+// of WithRateLimitLabelExtractor. Their values are bounded by WithRateLimitMaxLabels.
+//
+//	server.WithMiddleware(polaris.NewRateLimitMiddleware(limitAPI, "Production", "echo",
+//		polaris.WithRateLimitMetaKeys("tenant")))
+func NewRateLimitMiddleware(limiter QuotaAPI, namespace, service string, opts ...Option) endpoint.Middleware {
+	o := newOptions(opts)
+	extractors := o.rateLimitExtractors()
+	max := o.rateLimitMaxLabels
+	if max <= 0 {
+		max = defaultRateLimitMaxLabels
+	}
+	guard := &labelGuard{max: max, values: make(map[string]map[string]struct{})}
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request, response interface{}) error {
+			var method string
+			if ri := rpcinfo.GetRPCInfo(ctx); ri != nil && ri.Invocation() != nil {
+				method = ri.Invocation().MethodName()
+			}
+			labels := make(map[string]string)
+			for _, extract := range extractors {
+				for k, v := range extract(ctx, method) {
+					labels[k] = v
+				}
+			}
+			guard.guard(labels)
+			req := api.NewQuotaRequest()
+			req.SetNamespace(namespace)
+			req.SetService(service)
+			req.SetLabels(labels)
+			future, err := limiter.GetQuota(req)
+			if err != nil {
+				log.GetBaseLogger().Warnf("[Polaris ratelimit] fail to get the quota of %s:%s, letting the call through: %v",
+					namespace, service, err)
+				return next(ctx, request, response)
+			}
+			if resp := future.Get(); resp != nil && resp.Code == api.QuotaResultLimited {
+				return kerrors.ErrOverlimit.WithCause(fmt.Errorf("polaris rate limit of %s:%s with labels %s: %s",
+					namespace, service, formatLabels(labels), resp.Info))
+			}
+			// the quota of the concurrency rules is given back once the call is done.
+			defer future.Release()
+			return next(ctx, request, response)
+		}
+	}
+}
+
+// formatLabels formats labels as sorted key=value pairs.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
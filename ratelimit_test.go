@@ -0,0 +1,164 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// quotaFuture is an already decided QuotaFuture.
+type quotaFuture struct {
+	resp     *model.QuotaResponse
+	released *int
+}
+
+func (f *quotaFuture) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func (f *quotaFuture) Get() *model.QuotaResponse { return f.resp }
+
+func (f *quotaFuture) Release() { *f.released++ }
+
+// fakeQuotaAPI records the labels of the quota requests and limits the calls whose labels match limited.
+type fakeQuotaAPI struct {
+	lock     sync.Mutex
+	labels   []map[string]string
+	limited  map[string]string
+	err      error
+	released int
+}
+
+func (f *fakeQuotaAPI) GetQuota(request api.QuotaRequest) (api.QuotaFuture, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	req := request.(*model.QuotaRequestImpl)
+	f.labels = append(f.labels, req.GetLabels())
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := &model.QuotaResponse{Code: model.QuotaResultOk}
+	if len(f.limited) > 0 && matchLabels(req.GetLabels(), f.limited) {
+		resp = &model.QuotaResponse{Code: model.QuotaResultLimited, Info: "too many calls"}
+	}
+	return &quotaFuture{resp: resp, released: &f.released}, nil
+}
+
+func matchLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeQuotaAPI) lastLabels() map[string]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.labels[len(f.labels)-1]
+}
+
+// newServerCtx returns the context of a server call of method from caller.
+func newServerCtx(caller, method string) context.Context {
+	from := rpcinfo.NewEndpointInfo(caller, "", nil, nil)
+	ri := rpcinfo.NewRPCInfo(from, nil, rpcinfo.NewInvocation(serviceName, method), rpcinfo.NewRPCConfig(), rpcinfo.NewRPCStats())
+	return rpcinfo.NewCtxWithRPCInfo(context.Background(), ri)
+}
+
+func callRateLimited(limiter QuotaAPI, ctx context.Context, opts ...Option) error {
+	mw := NewRateLimitMiddleware(limiter, polarisDefaultNamespace, serviceName, opts...)
+	return mw(func(ctx context.Context, request, response interface{}) error { return nil })(ctx, nil, nil)
+}
+
+func TestRateLimitBuiltinLabels(t *testing.T) {
+	ctx := newServerCtx("caller.api", "echo")
+	require.Equal(t, map[string]string{RateLimitLabelMethod: "echo"}, MethodLabelExtractor(ctx, "echo"))
+	require.Nil(t, MethodLabelExtractor(ctx, ""))
+	require.Equal(t, map[string]string{RateLimitLabelCallerService: "caller.api"}, CallerServiceLabelExtractor(ctx, "echo"))
+	require.Nil(t, CallerServiceLabelExtractor(context.Background(), "echo"))
+
+	ctx = metainfo.WithValue(ctx, "tenant", "a")
+	ctx = metainfo.WithPersistentValue(ctx, "region", "sz")
+	require.Equal(t, map[string]string{"tenant": "a", "region": "sz"},
+		MetaInfoLabelExtractor("tenant", "region", "missing")(ctx, "echo"))
+	require.Nil(t, MetaInfoLabelExtractor("missing")(ctx, "echo"))
+}
+
+func TestRateLimitLabelPrecedence(t *testing.T) {
+	limiter := &fakeQuotaAPI{}
+	ctx := metainfo.WithValue(newServerCtx("caller.api", "echo"), "tenant", "a")
+	ctx = metainfo.WithValue(ctx, RateLimitLabelMethod, "from-metainfo")
+	require.Nil(t, callRateLimited(limiter, ctx))
+	require.Equal(t, map[string]string{RateLimitLabelMethod: "echo", RateLimitLabelCallerService: "caller.api"},
+		limiter.lastLabels())
+
+	// the metainfo keys override the built-in labels and the extractor overrides everything.
+	require.Nil(t, callRateLimited(limiter, ctx, WithRateLimitMetaKeys("tenant", RateLimitLabelMethod),
+		WithRateLimitLabelExtractor(func(ctx context.Context, method string) map[string]string {
+			return map[string]string{"tenant": "b", "plan": "free"}
+		})))
+	require.Equal(t, map[string]string{RateLimitLabelMethod: "from-metainfo", RateLimitLabelCallerService: "caller.api",
+		"tenant": "b", "plan": "free"}, limiter.lastLabels())
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := &fakeQuotaAPI{limited: map[string]string{"tenant": "a"}}
+	ctx := newServerCtx("caller.api", "echo")
+	err := callRateLimited(limiter, metainfo.WithValue(ctx, "tenant", "a"), WithRateLimitMetaKeys("tenant"))
+	require.True(t, errors.Is(err, kerrors.ErrOverlimit), "%v", err)
+	require.Nil(t, callRateLimited(limiter, metainfo.WithValue(ctx, "tenant", "b"), WithRateLimitMetaKeys("tenant")))
+	require.Equal(t, 1, limiter.released)
+
+	// the calls are let through when polaris fails.
+	limiter.err = errors.New("polaris unavailable")
+	require.Nil(t, callRateLimited(limiter, metainfo.WithValue(ctx, "tenant", "a"), WithRateLimitMetaKeys("tenant")))
+}
+
+func TestRateLimitMaxLabels(t *testing.T) {
+	limiter := &fakeQuotaAPI{}
+	mw := NewRateLimitMiddleware(limiter, polarisDefaultNamespace, serviceName, WithRateLimitMetaKeys("uid"),
+		WithRateLimitMaxLabels(3))
+	call := mw(func(ctx context.Context, request, response interface{}) error { return nil })
+	ctx := newServerCtx("caller.api", "echo")
+	for i := 0; i < 5; i++ {
+		require.Nil(t, call(metainfo.WithValue(ctx, "uid", strconv.Itoa(i)), nil, nil))
+	}
+	uids := make([]string, 0, len(limiter.labels))
+	for _, labels := range limiter.labels {
+		uids = append(uids, labels["uid"])
+	}
+	require.Equal(t, []string{"0", "1", "2", RateLimitLabelOverflow, RateLimitLabelOverflow}, uids)
+
+	// the values seen before the bound keep being reported.
+	require.Nil(t, call(metainfo.WithValue(ctx, "uid", "1"), nil, nil))
+	require.Equal(t, "1", limiter.lastLabels()["uid"])
+	require.Equal(t, "echo", limiter.lastLabels()[RateLimitLabelMethod])
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// RefreshResolver is the extension interface of the resolvers refreshing a service on demand, the
+// Resolvers of this package implement it:
+//
+//	change, err := resolver.(polaris.RefreshResolver).Refresh(ctx, desc)
+type RefreshResolver interface {
+	// Refresh queries polaris for the service again bypassing the caches of the resolver, like after an
+	// incident, diffs it with the last known Result and notifies the Subscribe listeners with the Change.
+	Refresh(ctx context.Context, desc string) (discovery.Change, error)
+}
+
+var _ RefreshResolver = (*polarisResolver)(nil)
+
+// Refresh implements the RefreshResolver interface.
+// The conversion cache and the service metadata of the service are dropped, the watch compares the next
+// events with the refreshed instances. The Change is pushed to the listeners even when nothing changed.
+func (polaris *polarisResolver) Refresh(ctx context.Context, desc string) (discovery.Change, error) {
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return discovery.Change{}, err
+	}
+	key := model.ServiceKey{Namespace: info.Namespace, Service: info.Service}
+	polaris.track(key, desc)
+	resp, err := polaris.getAllInstances(ctx, key)
+	if _, ok := err.(*ResolveContextError); ok {
+		return discovery.Change{}, err
+	}
+	if err != nil {
+		return discovery.Change{}, perrors.WithMessagef(err, "refresh instances of %s", desc)
+	}
+	polaris.serviceMetadata.invalidate(key)
+	polaris.watcher.resetRevisions(key, resp)
+
+	prev := discovery.Result{Cacheable: true, CacheKey: desc, Instances: polaris.instanceCache(desc).snapshot()}
+	cache := newInstanceCache(polaris.opts.instanceConversion())
+	polaris.caches.Store(desc, cache)
+	next := discovery.Result{Cacheable: true, CacheKey: desc, Instances: cache.convertAll(resp.GetInstances())}
+	filters := polaris.changeFilters(info.Tags)
+	change, _ := diffResults(desc, filterResult(ctx, filters, prev), filterResult(ctx, filters, next))
+
+	polaris.listenerLock.Lock()
+	hub := polaris.hubs[desc]
+	polaris.listenerLock.Unlock()
+	if hub != nil {
+		hub.refresh(cache, resp.GetInstances(), change)
+	}
+	log.GetBaseLogger().Infof("[Polaris resolver] refresh of %s got %d instances, %d added, %d removed",
+		desc, len(next.Instances), len(change.Added), len(change.Removed))
+	return change, nil
+}
+
+// getAllInstances queries every instance of a service, it gives up when ctx is done.
+func (polaris *polarisResolver) getAllInstances(ctx context.Context, key model.ServiceKey) (*model.InstancesResponse, error) {
+	req := &api.GetAllInstancesRequest{}
+	req.Namespace = key.Namespace
+	req.Service = key.Service
+	if err := ctx.Err(); err != nil {
+		return nil, &ResolveContextError{Namespace: key.Namespace, Service: key.Service, Err: err}
+	}
+	type response struct {
+		resp *model.InstancesResponse
+		err  error
+	}
+	done := make(chan response, 1)
+	go func() {
+		resp, err := polaris.consumerAPI().GetAllInstances(req)
+		done <- response{resp: resp, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, &ResolveContextError{Namespace: key.Namespace, Service: key.Service, Err: ctx.Err()}
+	}
+}
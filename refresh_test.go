@@ -0,0 +1,102 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshNotifiesListeners(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{ID: "ins-1", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666},
+		&polaristest.Instance{ID: "ins-2", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6667},
+	)
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+	changes := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	requireInitialChange(t, changes, 2)
+
+	// the pushes of these changes are lost.
+	backend.DropEvents(true)
+	backend.AddInstances(&polaristest.Instance{ID: "ins-3", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6668})
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, "ins-1")
+	backend.DropEvents(false)
+
+	change, err := rs.Refresh(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, change.Result.Instances, 2)
+	require.Len(t, change.Added, 1)
+	require.Equal(t, "127.0.0.1:6668", change.Added[0].Address().String())
+	require.Len(t, change.Removed, 1)
+	require.Equal(t, "127.0.0.1:6666", change.Removed[0].Address().String())
+	select {
+	case notified := <-changes:
+		require.Equal(t, change, notified)
+	case <-time.After(time.Second):
+		t.Fatal("refresh not notified")
+	}
+
+	// the next events apply to the refreshed snapshot.
+	ins := backend.Instances(polarisDefaultNamespace, serviceName)[1]
+	ins.Weight = 50
+	require.Nil(t, backend.UpdateInstance(ins))
+	select {
+	case updated := <-changes:
+		require.Len(t, updated.Updated, 1)
+		addrs := make([]string, 0, len(updated.Result.Instances))
+		for _, ep := range updated.Result.Instances {
+			addrs = append(addrs, ep.Address().String())
+		}
+		require.ElementsMatch(t, []string{"127.0.0.1:6667", "127.0.0.1:6668"}, addrs)
+	case <-time.After(time.Second):
+		t.Fatal("update not notified")
+	}
+}
+
+func TestRefreshWithoutListeners(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{ID: "ins-1", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6666})
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+	_, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+
+	change, err := rs.Refresh(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, change.Result.Instances, 1)
+	require.Empty(t, change.Added)
+	require.Empty(t, change.Removed)
+	require.Len(t, rs.instanceCache(desc).snapshot(), 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rs.Refresh(ctx, desc)
+	_, ok := err.(*ResolveContextError)
+	require.True(t, ok)
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+const (
+	defaultRegisterRetryBase = time.Second
+	defaultRegisterRetryMax  = 30 * time.Second
+)
+
+// Backoff returns the delay before a retry, attempt is 1 for the first retry.
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff returns a Backoff starting at base and doubling up to max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		backoff := base
+		for i := 1; i < attempt && backoff < max; i++ {
+			backoff *= 2
+		}
+		if backoff > max {
+			return max
+		}
+		return backoff
+	}
+}
+
+// retryRegister starts the background retries of a failed registration when WithRegisterRetry is set,
+// otherwise or for an invalid info err is returned.
+func (svr *polarisRegistry) retryRegister(info *registry.Info, err error) error {
+	if !svr.opts.registerRetry || validateInfo(info) != nil {
+		return err
+	}
+	_, instanceKey, keyErr := createDeregisterParam(info, svr.opts)
+	if keyErr != nil {
+		return err
+	}
+	svr.startRegisterRetry(instanceKey, err, func() error {
+		return svr.register(info, false)
+	}, func() error {
+		return svr.deregister(info)
+	})
+	return nil
+}
+
+// startRegisterRetry retries register in the background with the WithRegisterRetry policy after the failure
+// err, deregister undoes the registration of an attempt which completed after Deregister cancelled the retries.
+func (svr *polarisRegistry) startRegisterRetry(instanceKey string, err error, register, deregister func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	svr.retryLock.Lock()
+	if previous, ok := svr.registerRetries[instanceKey]; ok {
+		previous()
+	}
+	if svr.registerRetries == nil {
+		svr.registerRetries = make(map[string]context.CancelFunc)
+	}
+	svr.registerRetries[instanceKey] = cancel
+	svr.retryLock.Unlock()
+	log.GetBaseLogger().Warnf("[Polaris registry] register instance{%s} failed, retrying in the background: %v",
+		instanceKey, err)
+	go svr.doRegisterRetry(ctx, instanceKey, register, deregister)
+}
+
+func (svr *polarisRegistry) doRegisterRetry(ctx context.Context, instanceKey string, register, deregister func() error) {
+	defer svr.finishRegisterRetry(ctx, instanceKey)
+	backoff := svr.opts.registerRetryBackoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(defaultRegisterRetryBase, defaultRegisterRetryMax)
+	}
+	after := svr.after
+	if after == nil {
+		after = time.After
+	}
+	maxAttempts := svr.opts.registerRetryAttempts
+	for attempt := 1; maxAttempts <= 0 || attempt < maxAttempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-after(backoff(attempt)):
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		err := register()
+		if err != nil {
+			log.GetBaseLogger().Warnf("[Polaris registry] retry %d of register instance{%s}: %v", attempt, instanceKey, err)
+			continue
+		}
+		if ctx.Err() != nil {
+			// Deregister was called during the attempt.
+			if err := deregister(); err != nil {
+				log.GetBaseLogger().Warnf("[Polaris registry] deregister instance{%s} registered by a cancelled retry: %v",
+					instanceKey, err)
+			}
+			return
+		}
+		log.GetBaseLogger().Infof("[Polaris registry] instance{%s} registered by retry %d", instanceKey, attempt)
+		svr.registered()
+		return
+	}
+	log.GetBaseLogger().Errorf("[Polaris registry] register instance{%s} gave up after %d attempts", instanceKey, maxAttempts)
+}
+
+// finishRegisterRetry forgets the retries of instanceKey unless a later Register replaced them.
+func (svr *polarisRegistry) finishRegisterRetry(ctx context.Context, instanceKey string) {
+	svr.retryLock.Lock()
+	if cancel, ok := svr.registerRetries[instanceKey]; ok && ctx.Err() == nil {
+		cancel()
+		delete(svr.registerRetries, instanceKey)
+	}
+	svr.retryLock.Unlock()
+	svr.transition(nil)
+}
+
+// cancelRegisterRetry stops the pending retries of instanceKey and reports whether there were any.
+func (svr *polarisRegistry) cancelRegisterRetry(instanceKey string) bool {
+	svr.retryLock.Lock()
+	cancel, ok := svr.registerRetries[instanceKey]
+	if ok {
+		cancel()
+		delete(svr.registerRetries, instanceKey)
+	}
+	svr.retryLock.Unlock()
+	if ok {
+		svr.transition(nil)
+	}
+	return ok
+}
+
+// registered calls the function set by WithOnRegistered.
+func (svr *polarisRegistry) registered() {
+	if registered := svr.opts.onRegistered; registered != nil {
+		runHook("registered", registered)
+	}
+}
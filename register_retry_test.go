@@ -0,0 +1,131 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableProvider fails the first failures registrations, like polaris coming up late.
+type unreachableProvider struct {
+	*polaristest.Backend
+	failures int32
+}
+
+func (p *unreachableProvider) Register(req *api.InstanceRegisterRequest) (*model.InstanceRegisterResponse, error) {
+	if atomic.AddInt32(&p.failures, -1) >= 0 {
+		return nil, errors.New("polaris unreachable")
+	}
+	return p.Backend.Register(req)
+}
+
+// recordDelays makes the retry delays of rg elapse at once and sends them on the returned channel,
+// a nil fire keeps them pending.
+func recordDelays(rg *polarisRegistry, fire chan time.Time) chan time.Duration {
+	delays := make(chan time.Duration, 16)
+	rg.after = func(d time.Duration) <-chan time.Time {
+		delays <- d
+		if fire != nil {
+			return fire
+		}
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	return delays
+}
+
+func TestRegisterRetry(t *testing.T) {
+	backend := polaristest.NewBackend()
+	registered := make(chan struct{}, 1)
+	rg := newTestRegistry(backend, WithRegisterRetry(5, ExponentialBackoff(100*time.Millisecond, 250*time.Millisecond)),
+		WithOnRegistered(func() { registered <- struct{}{} }))
+	rg.provider = &unreachableProvider{Backend: backend, failures: 3}
+	delays := recordDelays(rg, nil)
+	info := newTestInfo("127.0.0.1:6666", nil)
+
+	require.Nil(t, rg.Register(info))
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Fatal("not registered by the retries")
+	}
+	require.Equal(t, 100*time.Millisecond, <-delays)
+	require.Equal(t, 200*time.Millisecond, <-delays)
+	require.Equal(t, 250*time.Millisecond, <-delays)
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 1)
+	require.False(t, rg.cancelRegisterRetry(GetInstanceKey(polarisDefaultNamespace, serviceName, "127.0.0.1", "6666")))
+	require.Nil(t, rg.Deregister(info))
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+}
+
+func TestRegisterRetryGivesUp(t *testing.T) {
+	backend := polaristest.NewBackend()
+	provider := &unreachableProvider{Backend: backend, failures: 10}
+	rg := newTestRegistry(backend, WithRegisterRetry(3, nil))
+	rg.provider = provider
+	delays := recordDelays(rg, nil)
+
+	require.Nil(t, rg.Register(newTestInfo("127.0.0.1:6666", nil)))
+	require.Equal(t, time.Second, <-delays)
+	require.Equal(t, 2*time.Second, <-delays)
+	require.Eventually(t, func() bool {
+		rg.retryLock.Lock()
+		defer rg.retryLock.Unlock()
+		return len(rg.registerRetries) == 0
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(7), atomic.LoadInt32(&provider.failures))
+	require.Empty(t, delays)
+}
+
+func TestRegisterRetryCancelledByDeregister(t *testing.T) {
+	backend := polaristest.NewBackend()
+	provider := &unreachableProvider{Backend: backend, failures: 1}
+	rg := newTestRegistry(backend, WithRegisterRetry(0, nil))
+	rg.provider = provider
+	fire := make(chan time.Time, 1)
+	delays := recordDelays(rg, fire)
+	info := newTestInfo("127.0.0.1:6666", nil)
+
+	require.Nil(t, rg.Register(info))
+	<-delays
+	require.Nil(t, rg.Deregister(info))
+	fire <- time.Now()
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+	require.Equal(t, 0, backend.Calls(polaristest.OpRegister))
+	require.Nil(t, rg.Deregister(info))
+	require.Equal(t, 0, backend.Calls(polaristest.OpDeregister))
+}
+
+func TestRegisterWithoutRetry(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	rg.provider = &unreachableProvider{Backend: backend, failures: 1}
+	require.EqualError(t, rg.Register(newTestInfo("127.0.0.1:6666", nil)), "polaris unreachable")
+
+	rg = newTestRegistry(backend, WithRegisterRetry(0, nil))
+	require.NotNil(t, rg.Register(newTestInfo("", nil)))
+}
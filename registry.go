@@ -19,8 +19,11 @@ package polaris
 import (
 	"context"
 	"fmt"
+	"io"
+	"math/rand"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudwego/kitex/pkg/registry"
@@ -35,54 +38,197 @@ var (
 	registerTimeout             = 10 * time.Second
 	heartbeatTimeout            = 5 * time.Second
 	heartbeatTime               = 5 * time.Second
+	verifyPollInterval          = 200 * time.Millisecond
+)
+
+const (
+	defaultHeartbeatJitter        = 0.2
+	defaultHeartbeatFailureBudget = 3
 )
 
 // Registry is extension interface of Kitex registry.Registry.
+// The registries of this package implement the extension interfaces too, like HealthRegistry or
+// BatchRegistry, and io.Closer.
 type Registry interface {
 	registry.Registry
+}
+
+// HealthRegistry is the extension interface of the registries reporting the local health of the server,
+// the Registries of this package implement it:
+//
+//	err := reg.(polaris.HealthRegistry).SetHealthy(false)
+type HealthRegistry interface {
+	// SetHealthy reports the local health of the server. The polaris-go provider API has no way to
+	// update an instance, so unhealthy pauses the heartbeats until polaris expires the instance TTL,
+	// and healthy resumes them with an immediate heartbeat of every registered instance.
+	SetHealthy(healthy bool) error
+}
+
+// IsolationRegistry is the extension interface of the registries isolating their instances, the
+// Registries of this package implement it.
+type IsolationRegistry interface {
+	// SetIsolated changes the isolation of the instance registered for info, e.g. to open the traffic of
+	// an instance registered with WithRegisterIsolated. The polaris-go provider API has no way to update
+	// an instance, so the instance is deregistered and registered again with the new isolation.
+	SetIsolated(info *registry.Info, isolated bool) error
+}
+
+// VerifyingRegistry is the extension interface of the registries checking that their instances are
+// visible to the consumers, the Registries of this package implement it.
+type VerifyingRegistry interface {
+	// VerifyRegistration polls the consumer API until the instance registered for info is visible,
+	// it returns a RegistrationNotVisibleError when it is not within timeout.
+	VerifyRegistration(ctx context.Context, info *registry.Info, timeout time.Duration) error
+}
+
+// RegistryDiagnostics is the extension interface of the registries exposing their counters for the
+// metrics and the debug tools, the Registries of this package implement it.
+type RegistryDiagnostics interface {
+	// HeartbeatsLost returns how many times a registered instance used up its heartbeat failure budget.
+	HeartbeatsLost() uint64
 
-	doHeartbeat(ctx context.Context, ins *api.InstanceRegisterRequest)
+	// ClockSkew returns the last measured offset of the polaris server clock, the
+	// polaris_clock_skew_seconds gauge. It stays zero without WithServerTimeProbe.
+	ClockSkew() time.Duration
+
+	// MetadataCorrections returns how many times WithMetadataReconciliation registered an instance
+	// again because its metadata or weight drifted in polaris.
+	MetadataCorrections() uint64
 }
 
+var (
+	_ HealthRegistry      = (*polarisRegistry)(nil)
+	_ IsolationRegistry   = (*polarisRegistry)(nil)
+	_ VerifyingRegistry   = (*polarisRegistry)(nil)
+	_ RegistryDiagnostics = (*polarisRegistry)(nil)
+)
+
 type polarisHeartbeat struct {
 	cancel      context.CancelFunc
 	instanceKey string
+	heartbeat   *api.InstanceHeartbeatRequest
+	desired     *api.InstanceRegisterRequest // the registration, restored by WithMetadataReconciliation
+	shared      bool                         // beaten by the scheduler of RegisterBatch
 }
 
 // polarisRegistry is a registry using polaris.
 type polarisRegistry struct {
-	consumer    api.ConsumerAPI
-	provider    api.ProviderAPI
-	lock        *sync.RWMutex
-	registryIns map[string]*polarisHeartbeat
+	heartbeatsLost    uint64 // accessed atomically, keep it first for 64-bit alignment
+	corrections       uint64 // accessed atomically, see WithMetadataReconciliation
+	clockSkew         int64  // accessed atomically, a time.Duration
+	consumer          api.ConsumerAPI
+	provider          api.ProviderAPI
+	releaseSDK        func()       // nil for the APIs given by WithConsumerAPI and WithProviderAPI
+	apiLock           sync.RWMutex // guards provider, consumer, releaseSDK and endpoints
+	lock              *sync.RWMutex
+	registryIns       map[string]*polarisHeartbeat
+	heartbeatInterval time.Duration
+	verifyInterval    time.Duration
+	healthLock        sync.Mutex
+	unhealthy         int32                                  // accessed atomically
+	skewed            int32                                  // accessed atomically
+	after             func(d time.Duration) <-chan time.Time // time.After, replaced in tests
+	heartbeatAfter    func(d time.Duration) <-chan time.Time // time.After, replaced in tests
+	random            func() float64                         // rand.Float64, replaced in tests
+	now               func() time.Time                       // time.Now, replaced in tests
+	errorLogs         errorLogLimiter
+	endpoints         []string
+	opts              *options
+	locationLock      sync.Mutex
+	locationDetected  bool
+	region            string
+	zone              string
+	campus            string
+	batchHeartbeats   heartbeatScheduler
+	retryLock         sync.Mutex
+	registerRetries   map[string]context.CancelFunc // instance key -> cancel, the pending WithRegisterRetry retries
+	createNamespace   func(namespace string) error  // createNamespaceByHTTP, replaced in tests
+	states            registryStates
+	updateLock        sync.Mutex // serializes the registrations replaced by SetIsolated, the reconciliation and UpdateEndpoints
+	selfWatchLock     sync.Mutex
+	selfWatches       *watchManager              // the subscriptions of WithSelfWatch
+	selfWatched       map[model.ServiceKey]bool  // the services subscribed by selfWatches
+	ownDeletes        map[string]time.Time       // instance key -> time, the deregistrations of the registry
+	deregistrations   map[string]*deregistration // instance key -> the in-flight Deregister, guarded by lock
+	closeOnce         sync.Once
 }
 
-// NewPolarisRegistry creates a polaris based registry.
-func NewPolarisRegistry(endpoints []string) (Registry, error) {
-	sdkCtx, err := GetPolarisConfig(endpoints)
+var _ io.Closer = (*polarisRegistry)(nil)
+
+// NewPolarisRegistry creates a polaris based registry. It is an io.Closer, see polarisRegistry.Close.
+func NewPolarisRegistry(endpoints []string, opts ...Option) (Registry, error) {
+	o := newOptions(opts)
+	if err := o.validate("registry"); err != nil {
+		return &polarisRegistry{}, err
+	}
+	if err := o.validateAdditionalPorts(); err != nil {
+		return &polarisRegistry{}, err
+	}
+	apis, err := newSDKAPIs(endpoints, o)
 	if err != nil {
 		return &polarisRegistry{}, err
 	}
+	if apis.provider == nil {
+		return &polarisRegistry{}, perrors.New("WithConsumerAPI without SDK context needs WithProviderAPI too")
+	}
+	interval := heartbeatTime
+	if o.heartbeatInterval > 0 {
+		interval = o.heartbeatInterval
+	}
 	pRegistry := &polarisRegistry{
-		consumer:    api.NewConsumerAPIByContext(sdkCtx),
-		provider:    api.NewProviderAPIByContext(sdkCtx),
-		registryIns: make(map[string]*polarisHeartbeat),
-		lock:        &sync.RWMutex{},
+		consumer:          apis.consumer,
+		provider:          apis.provider,
+		releaseSDK:        apis.release,
+		registryIns:       make(map[string]*polarisHeartbeat),
+		lock:              &sync.RWMutex{},
+		heartbeatInterval: interval,
+		verifyInterval:    verifyPollInterval,
+		after:             time.After,
+		heartbeatAfter:    time.After,
+		random:            rand.Float64,
+		now:               time.Now,
+		endpoints:         append([]string(nil), endpoints...),
+		opts:              o,
 	}
+	log.GetBaseLogger().Infof("[Polaris registry] effective options %s", pRegistry.EffectiveOptions())
 
 	return pRegistry, nil
 }
 
 // Register registers a server with given registry info.
-func (svr *polarisRegistry) Register(info *registry.Info) error {
+func (svr *polarisRegistry) Register(info *registry.Info) (err error) {
+	if before := svr.opts.beforeRegister; before != nil {
+		runHook("before register", func() { before(info) })
+	}
+	if after := svr.opts.afterRegister; after != nil {
+		defer func() {
+			runHook("after register", func() { after(info, err) })
+		}()
+	}
+	svr.beginRegister()
+	defer svr.endRegister()
+	if err := svr.register(info, false); err != nil {
+		return svr.retryRegister(info, err)
+	}
+	svr.registered()
+	return nil
+}
+
+// register registers info, shared puts the heartbeats of the instance on the scheduler of RegisterBatch.
+func (svr *polarisRegistry) register(info *registry.Info, shared bool) error {
 	if err := validateInfo(info); err != nil {
 		return err
 	}
-	param, instanceKey, err := createRegisterParam(info)
+	param, instanceKey, err := createRegisterParam(info, svr.opts)
 	if err != nil {
 		return err
 	}
-	resp, err := svr.provider.Register(param)
+	svr.setLocation(param.Metadata, info)
+	resp, err := svr.providerAPI().Register(param)
+	err = notFoundError(err, param.Namespace, param.Service)
+	if err != nil && svr.opts.autoCreateNamespace && isNamespaceNotFound(err) {
+		resp, err = svr.registerCreatingNamespace(param, err)
+	}
 	if err != nil {
 		return err
 	}
@@ -90,77 +236,389 @@ func (svr *polarisRegistry) Register(info *registry.Info) error {
 		log.GetBaseLogger().Warnf("instance already registered, namespace:%s, service:%s, port:%s",
 			param.Namespace, param.Service, param.Host)
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	go svr.doHeartbeat(ctx, param)
+	svr.startHeartbeat(instanceKey, param, resp, shared)
+	svr.watchSelf(param.Namespace, param.Service)
+	if timeout := svr.opts.postRegisterVerification; timeout > 0 {
+		if err := svr.VerifyRegistration(context.Background(), info, timeout); err != nil {
+			if derr := svr.deregister(info); derr != nil {
+				log.GetBaseLogger().Warnf("[Polaris registry] deregister unverified instance: %v", derr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// startHeartbeat starts the heartbeats of a registered instance, replacing the ones of a previous registration.
+// Shared heartbeats are beaten by the scheduler of RegisterBatch instead of their own goroutine.
+func (svr *polarisRegistry) startHeartbeat(instanceKey string, param *api.InstanceRegisterRequest,
+	resp *model.InstanceRegisterResponse, shared bool) {
+	heartbeat := createHeartbeatParam(param, resp)
+	var cancel context.CancelFunc
+	if shared {
+		cancel = svr.batchHeartbeats.add(svr, heartbeat)
+	} else {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go svr.doHeartbeat(ctx, heartbeat)
+	}
+	if interval := svr.opts.metadataReconciliation; interval > 0 && svr.consumerAPI() != nil {
+		ctx, stop := context.WithCancel(context.Background())
+		go svr.reconcileMetadata(ctx, instanceKey, param, resp.InstanceID, interval)
+		stopHeartbeat := cancel
+		cancel = func() {
+			stopHeartbeat()
+			stop()
+		}
+	}
 	svr.lock.Lock()
-	defer svr.lock.Unlock()
+	if previous, ok := svr.registryIns[instanceKey]; ok {
+		previous.cancel()
+	}
 	svr.registryIns[instanceKey] = &polarisHeartbeat{
 		instanceKey: instanceKey,
 		cancel:      cancel,
+		heartbeat:   heartbeat,
+		desired:     param,
+		shared:      shared,
 	}
-	return nil
+	svr.lock.Unlock()
 }
 
-// Deregister deregisters a server with given registry info.
-func (svr *polarisRegistry) Deregister(info *registry.Info) error {
+// Deregister deregisters a server with given registry info. The Deregister of a server the registry has no
+// registration of returns nil, see WithStrictDeregister, and the concurrent ones share one deregistration.
+func (svr *polarisRegistry) Deregister(info *registry.Info) (err error) {
+	if before := svr.opts.beforeDeregister; before != nil {
+		runHook("before deregister", func() { before(info) })
+	}
+	if after := svr.opts.afterDeregister; after != nil {
+		defer func() {
+			runHook("after deregister", func() { after(info, err) })
+		}()
+	}
+	return svr.deregister(info)
+}
+
+func (svr *polarisRegistry) deregister(info *registry.Info) error {
 	if err := validateInfo(info); err != nil {
 		return err
 	}
-	request, instanceKey, err := createDeregisterParam(info)
+	request, instanceKey, err := createDeregisterParam(info, svr.opts)
 	if err != nil {
 		return err
 	}
-	svr.lock.RLock()
-	insHeartbeat, ok := svr.registryIns[instanceKey]
-	svr.lock.RUnlock()
-	if !ok {
-		err = perrors.Errorf("instance{%s} has not registered", instanceKey)
-		return err
+	retrying := svr.cancelRegisterRetry(instanceKey)
+	insHeartbeat, pending, first := svr.startDeregistration(instanceKey)
+	if insHeartbeat == nil {
+		if retrying {
+			// the instance never registered, stopping the retries is enough.
+			return nil
+		}
+		return svr.deregisterUnknown(request, instanceKey)
+	}
+	if !first {
+		<-pending.done
+		return pending.err
+	}
+	err = svr.deregisterRegistered(request, instanceKey, insHeartbeat)
+	svr.finishDeregistration(instanceKey, pending, err)
+	return err
+}
+
+// deregisterRegistered deregisters an instance of the registry, its local state is dropped on success.
+func (svr *polarisRegistry) deregisterRegistered(request *api.InstanceDeRegisterRequest, instanceKey string,
+	insHeartbeat *polarisHeartbeat) error {
+	err := svr.deregisterWithTimeout(request)
+	if _, ok := err.(*DeregisterTimeoutError); ok {
+		// best effort, polaris expires the instance once the heartbeats stop.
+		svr.forget(instanceKey, insHeartbeat)
+		return perrors.WithMessagef(err, "instance{%s} deregister", instanceKey)
 	}
-	err = svr.provider.Deregister(request)
 	if err != nil {
 		return perrors.WithMessagef(err, "instance{%s} deregister fail (err:%+v)", instanceKey, err)
 	} else {
-		svr.lock.Lock()
-		insHeartbeat.cancel()
-		delete(svr.registryIns, instanceKey)
-		svr.lock.Unlock()
+		svr.forget(instanceKey, insHeartbeat)
 	}
 
 	return nil
 }
 
+// forget stops the heartbeats of an instance and drops its local registration state.
+func (svr *polarisRegistry) forget(instanceKey string, insHeartbeat *polarisHeartbeat) {
+	svr.lock.Lock()
+	insHeartbeat.cancel()
+	if svr.registryIns[instanceKey] == insHeartbeat {
+		delete(svr.registryIns, instanceKey)
+	}
+	svr.lock.Unlock()
+	svr.transition(nil)
+}
+
+// deregisterWithTimeout bounds the provider call by WithDeregisterTimeout, the abandoned call finishes on its own.
+func (svr *polarisRegistry) deregisterWithTimeout(request *api.InstanceDeRegisterRequest) error {
+	svr.recordOwnDeregistration(request)
+	timeout := svr.opts.deregisterTimeout
+	if timeout <= 0 {
+		return svr.providerAPI().Deregister(request)
+	}
+	request.Timeout = model.ToDurationPtr(timeout)
+	done := make(chan error, 1)
+	go func() {
+		done <- svr.providerAPI().Deregister(request)
+	}()
+	after := svr.after
+	if after == nil {
+		after = time.After
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-after(timeout):
+		return &DeregisterTimeoutError{Namespace: request.Namespace, Service: request.Service,
+			Host: request.Host, Port: request.Port, Timeout: timeout}
+	}
+}
+
 // IsAvailable always return true when use polaris.
 func (svr *polarisRegistry) IsAvailable() bool {
 	return true
 }
 
-// doHeartbeat Since polaris does not support automatic reporting of instance heartbeats, separate logic is needed to implement it.
-func (svr *polarisRegistry) doHeartbeat(ctx context.Context, ins *api.InstanceRegisterRequest) {
-	ticker := time.NewTicker(heartbeatTime)
+// Close stops the heartbeats, the registration retries and the watches of the registry and releases its SDK
+// context unless the APIs were given by WithConsumerAPI or WithProviderAPI, the context is destroyed once no
+// other resolver or registry shares it. The instances are not deregistered, polaris expires them with the
+// heartbeat TTL, so Deregister them first to remove them at once. The registry must not be used after Close.
+func (svr *polarisRegistry) Close() error {
+	svr.closeOnce.Do(func() {
+		svr.lock.RLock()
+		for _, insHeartbeat := range svr.registryIns {
+			insHeartbeat.cancel()
+		}
+		svr.lock.RUnlock()
+		svr.retryLock.Lock()
+		for _, cancel := range svr.registerRetries {
+			cancel()
+		}
+		svr.retryLock.Unlock()
+		svr.selfWatchLock.Lock()
+		if svr.selfWatches != nil {
+			svr.selfWatches.close()
+		}
+		svr.selfWatchLock.Unlock()
+		svr.apiLock.RLock()
+		release := svr.releaseSDK
+		svr.apiLock.RUnlock()
+		if release != nil {
+			release()
+		}
+	})
+	return nil
+}
 
-	heartbeat := &api.InstanceHeartbeatRequest{
-		InstanceHeartbeatRequest: model.InstanceHeartbeatRequest{
-			Service:   ins.Service,
-			Namespace: ins.Namespace,
-			Host:      ins.Host,
-			Port:      ins.Port,
-			Timeout:   model.ToDurationPtr(heartbeatTimeout),
-		},
+// doHeartbeat Since polaris does not support automatic reporting of instance heartbeats, separate logic is needed to implement it.
+// The interval is jittered so that a fleet does not beat in step, and a run of failures exhausting the
+// failure budget is reported once until a heartbeat succeeds again.
+func (svr *polarisRegistry) doHeartbeat(ctx context.Context, heartbeat *api.InstanceHeartbeatRequest) {
+	after := svr.heartbeatAfter
+	if after == nil {
+		after = time.After
 	}
+	failures := 0
 	for {
 		select {
 		case <-ctx.Done():
-			ticker.Stop()
+			svr.errorLogs.forget(model.ServiceKey{Namespace: heartbeat.Namespace, Service: heartbeat.Service})
 			return
+		case <-after(svr.nextHeartbeatInterval()):
+			failures = svr.beat(heartbeat, failures)
+		}
+	}
+}
+
+// beat sends one heartbeat unless the server is unhealthy, failures counts the previous failures in a row
+// and the updated count is returned.
+func (svr *polarisRegistry) beat(heartbeat *api.InstanceHeartbeatRequest, failures int) int {
+	if atomic.LoadInt32(&svr.unhealthy) == 1 {
+		return failures
+	}
+	budget := svr.opts.heartbeatFailureBudget
+	if budget <= 0 {
+		budget = defaultHeartbeatFailureBudget
+	}
+	err := svr.providerAPI().Heartbeat(heartbeat)
+	svr.sampleClockSkew()
+	if err == nil {
+		if failures >= budget {
+			log.GetBaseLogger().Infof("[Polaris registry] heartbeat of %s recovered", heartbeat.InstanceID)
+			svr.heartbeatDegraded(heartbeat, false)
+		}
+		return 0
+	}
+	service := model.ServiceKey{Namespace: heartbeat.Namespace, Service: heartbeat.Service}
+	if ok, suppressed := svr.errorLogs.allow(errorLogHeartbeat, service, svr.opts.errorLogInterval); ok {
+		log.GetBaseLogger().Errorf("[Polaris registry] heartbeat of %s failed: %v%s", heartbeat.InstanceID, err,
+			suppressedSuffix(suppressed))
+	}
+	if failures++; failures == budget {
+		svr.heartbeatLost(heartbeat, failures, err)
+	}
+	return failures
+}
+
+// nextHeartbeatInterval returns the heartbeat interval randomly shortened by up to the jitter fraction,
+// it is never longer than the interval so that a beat does not land past the TTL.
+func (svr *polarisRegistry) nextHeartbeatInterval() time.Duration {
+	interval := svr.effectiveHeartbeatInterval()
+	jitter := svr.opts.heartbeatJitter
+	if jitter <= 0 {
+		return interval
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	random := svr.random
+	if random == nil {
+		random = rand.Float64
+	}
+	return time.Duration(float64(interval) * (1 - jitter*random()))
+}
+
+func (svr *polarisRegistry) heartbeatLost(heartbeat *api.InstanceHeartbeatRequest, failures int, err error) {
+	atomic.AddUint64(&svr.heartbeatsLost, 1)
+	log.GetBaseLogger().Errorf("[Polaris registry] heartbeat of %s failed %d times in a row: %v",
+		heartbeat.InstanceID, failures, err)
+	svr.heartbeatDegraded(heartbeat, true)
+	if lost := svr.opts.onHeartbeatLost; lost != nil {
+		runHook("heartbeat lost", func() { lost(err) })
+	}
+}
+
+// HeartbeatsLost implements the RegistryDiagnostics interface.
+func (svr *polarisRegistry) HeartbeatsLost() uint64 {
+	return atomic.LoadUint64(&svr.heartbeatsLost)
+}
+
+// SetHealthy implements the HealthRegistry interface.
+func (svr *polarisRegistry) SetHealthy(healthy bool) error {
+	svr.healthLock.Lock()
+	defer svr.healthLock.Unlock()
+	if !healthy {
+		if atomic.SwapInt32(&svr.unhealthy, 1) == 0 {
+			log.GetBaseLogger().Warnf("[Polaris registry] local health check failed, heartbeats paused")
+		}
+		return nil
+	}
+	if atomic.SwapInt32(&svr.unhealthy, 0) == 0 {
+		return nil
+	}
+	log.GetBaseLogger().Infof("[Polaris registry] local health check recovered, heartbeats resumed")
+	svr.lock.RLock()
+	heartbeats := make([]*api.InstanceHeartbeatRequest, 0, len(svr.registryIns))
+	for _, ins := range svr.registryIns {
+		heartbeats = append(heartbeats, ins.heartbeat)
+	}
+	svr.lock.RUnlock()
+	var firstErr error
+	for _, heartbeat := range heartbeats {
+		if err := svr.providerAPI().Heartbeat(heartbeat); err != nil && firstErr == nil {
+			firstErr = perrors.WithMessagef(err, "heartbeat %s", heartbeat.InstanceID)
+		}
+	}
+	return firstErr
+}
+
+// SetIsolated implements the IsolationRegistry interface.
+func (svr *polarisRegistry) SetIsolated(info *registry.Info, isolated bool) error {
+	if err := validateInfo(info); err != nil {
+		return err
+	}
+	param, instanceKey, err := createRegisterParam(info, svr.opts)
+	if err != nil {
+		return err
+	}
+	svr.setLocation(param.Metadata, info)
+	param.Isolate = &isolated
+	svr.updateLock.Lock()
+	defer svr.updateLock.Unlock()
+	request, _, err := createDeregisterParam(info, svr.opts)
+	if err != nil {
+		return err
+	}
+	svr.lock.RLock()
+	previous, ok := svr.registryIns[instanceKey]
+	svr.lock.RUnlock()
+	if !ok {
+		return perrors.Errorf("instance{%s} has not registered", instanceKey)
+	}
+	if err := svr.deregisterWithTimeout(request); err != nil {
+		return perrors.WithMessagef(err, "instance{%s} deregister before isolation change", instanceKey)
+	}
+	resp, err := svr.providerAPI().Register(param)
+	if err != nil {
+		// polaris expires the instance once the heartbeats of the previous registration stop.
+		return perrors.WithMessagef(err, "instance{%s} register with isolated=%t", instanceKey, isolated)
+	}
+	svr.startHeartbeat(instanceKey, param, resp, previous.shared)
+	return nil
+}
+
+// VerifyRegistration implements the VerifyingRegistry interface.
+func (svr *polarisRegistry) VerifyRegistration(ctx context.Context, info *registry.Info, timeout time.Duration) error {
+	if err := validateInfo(info); err != nil {
+		return err
+	}
+	if svr.consumerAPI() == nil {
+		return perrors.New("VerifyRegistration needs a consumer API, set WithConsumerAPI")
+	}
+	request, instanceKey, err := createDeregisterParam(info, svr.opts)
+	if err != nil {
+		return err
+	}
+	svr.lock.RLock()
+	insHeartbeat, ok := svr.registryIns[instanceKey]
+	svr.lock.RUnlock()
+	if !ok {
+		return perrors.Errorf("instance{%s} has not registered", instanceKey)
+	}
+	notVisible := &RegistrationNotVisibleError{Namespace: request.Namespace, Service: request.Service,
+		InstanceID: insHeartbeat.heartbeat.InstanceID, Timeout: timeout}
+	interval := svr.verifyInterval
+	if interval <= 0 {
+		interval = verifyPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		req := &api.GetAllInstancesRequest{}
+		req.Namespace = request.Namespace
+		req.Service = request.Service
+		resp, err := svr.consumerAPI().GetAllInstances(req)
+		notVisible.Err = err
+		if err == nil {
+			for _, instance := range resp.GetInstances() {
+				if instance.GetId() == notVisible.InstanceID {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			notVisible.Err = ctx.Err()
+			return notVisible
+		case <-timer.C:
+			return notVisible
 		case <-ticker.C:
-			svr.provider.Heartbeat(heartbeat)
 		}
 	}
 }
 
 // validateInfo validates registry.Info.
 func validateInfo(info *registry.Info) error {
+	if info == nil {
+		return fmt.Errorf("missing registry.Info")
+	}
 	if info.ServiceName == "" {
 		return fmt.Errorf("missing service name in Register")
 	}
@@ -177,7 +635,7 @@ func validateInfo(info *registry.Info) error {
 }
 
 // createRegisterParam convert registry.Info to polaris instance register request.
-func createRegisterParam(info *registry.Info) (*api.InstanceRegisterRequest, string, error) {
+func createRegisterParam(info *registry.Info, o *options) (*api.InstanceRegisterRequest, string, error) {
 	instanceHost, instancePort, err := GetInfoHostAndPort(info.Addr.String())
 	if err != nil {
 		return nil, "", err
@@ -186,29 +644,52 @@ func createRegisterParam(info *registry.Info) (*api.InstanceRegisterRequest, str
 
 	namespace, ok := info.Tags["namespace"]
 	if !ok {
-		namespace = polarisDefaultNamespace
+		namespace = o.defaultNamespace()
 	}
 	instanceKey := GetInstanceKey(namespace, info.ServiceName, instanceHost, strconv.Itoa(instancePort))
+	weight := o.registrationWeight(info)
 
 	req := &api.InstanceRegisterRequest{
 		InstanceRegisterRequest: model.InstanceRegisterRequest{
-			Service:   info.ServiceName,
-			Namespace: namespace,
-			Host:      instanceHost,
-			Port:      instancePort,
-			Protocol:  &protocol,
-			Timeout:   model.ToDurationPtr(registerTimeout),
-			TTL:       &defaultHeartbeatIntervalSec,
+			Service:      info.ServiceName,
+			ServiceToken: o.serviceToken,
+			Namespace:    namespace,
+			Host:         instanceHost,
+			Port:         instancePort,
+			Protocol:     &protocol,
+			Metadata:     instanceMetadata(info, o),
+			Weight:       &weight,
+			Timeout:      model.ToDurationPtr(registerTimeout),
+			TTL:          &defaultHeartbeatIntervalSec,
 			// If the TTL field is not set, polaris will think that this instance does not need to perform the heartbeat health check operation,
 			// then after the instance goes offline, the instance cannot be converted to unhealthy normally.
 		},
 	}
+	if o.registerIsolated {
+		req.SetIsolate(true)
+	}
 
 	return req, instanceKey, nil
 }
 
+// createHeartbeatParam builds the heartbeat request from a successful registration, the identity is
+// captured once so that heartbeats of multi-homed hosts always match the registered instance.
+func createHeartbeatParam(ins *api.InstanceRegisterRequest, resp *model.InstanceRegisterResponse) *api.InstanceHeartbeatRequest {
+	return &api.InstanceHeartbeatRequest{
+		InstanceHeartbeatRequest: model.InstanceHeartbeatRequest{
+			Service:      ins.Service,
+			ServiceToken: ins.ServiceToken,
+			Namespace:    ins.Namespace,
+			InstanceID:   resp.InstanceID,
+			Host:         ins.Host,
+			Port:         ins.Port,
+			Timeout:      model.ToDurationPtr(heartbeatTimeout),
+		},
+	}
+}
+
 // createDeregisterParam convert registry.info to polaris instance deregister request.
-func createDeregisterParam(info *registry.Info) (*api.InstanceDeRegisterRequest, string, error) {
+func createDeregisterParam(info *registry.Info, o *options) (*api.InstanceDeRegisterRequest, string, error) {
 	instanceHost, instancePort, err := GetInfoHostAndPort(info.Addr.String())
 	if err != nil {
 		return nil, "", err
@@ -216,17 +697,31 @@ func createDeregisterParam(info *registry.Info) (*api.InstanceDeRegisterRequest,
 
 	namespace, ok := info.Tags["namespace"]
 	if !ok {
-		namespace = polarisDefaultNamespace
+		namespace = o.defaultNamespace()
 	}
 
 	instanceKey := GetInstanceKey(namespace, info.ServiceName, instanceHost, strconv.Itoa(instancePort))
 	req := &api.InstanceDeRegisterRequest{
 		InstanceDeRegisterRequest: model.InstanceDeRegisterRequest{
-			Service:   info.ServiceName,
-			Namespace: namespace,
-			Host:      instanceHost,
-			Port:      instancePort,
+			Service:      info.ServiceName,
+			ServiceToken: o.serviceToken,
+			Namespace:    namespace,
+			Host:         instanceHost,
+			Port:         instancePort,
 		},
 	}
 	return req, instanceKey, nil
 }
+
+// createDeregisterParamOf builds the deregister request of the instance registered by param.
+func createDeregisterParamOf(param *api.InstanceRegisterRequest) *api.InstanceDeRegisterRequest {
+	return &api.InstanceDeRegisterRequest{
+		InstanceDeRegisterRequest: model.InstanceDeRegisterRequest{
+			Service:      param.Service,
+			ServiceToken: param.ServiceToken,
+			Namespace:    param.Namespace,
+			Host:         param.Host,
+			Port:         param.Port,
+		},
+	}
+}
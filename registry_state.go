@@ -0,0 +1,185 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync"
+
+	"github.com/polarismesh/polaris-go/api"
+)
+
+// RegistryState is the state of the registrations of a registry.
+type RegistryState int
+
+// The states of a registry. A registry holding several instances is Degraded when the heartbeats of one
+// of them are lost, Registered when one is registered and Registering while a Register is running or
+// retried. Without any of these it is Deregistered after a registered instance left and Unregistered otherwise.
+const (
+	StateUnregistered RegistryState = iota
+	StateRegistering
+	StateRegistered
+	StateDegraded
+	StateDeregistered
+)
+
+const registryStateBufferSize = 16
+
+// String implements the fmt.Stringer interface.
+func (s RegistryState) String() string {
+	switch s {
+	case StateUnregistered:
+		return "unregistered"
+	case StateRegistering:
+		return "registering"
+	case StateRegistered:
+		return "registered"
+	case StateDegraded:
+		return "degraded"
+	case StateDeregistered:
+		return "deregistered"
+	}
+	return "unknown"
+}
+
+// registryStates tracks the RegistryState of a registry from its registrations and heartbeats.
+type registryStates struct {
+	lock        sync.Mutex
+	current     RegistryState
+	registering int
+	degraded    map[*api.InstanceHeartbeatRequest]struct{} // the heartbeats which used up their failure budget
+	changes     chan RegistryState
+}
+
+// channel returns the channel of the changes, it is called with the lock held.
+func (s *registryStates) channel() chan RegistryState {
+	if s.changes == nil {
+		s.changes = make(chan RegistryState, registryStateBufferSize)
+	}
+	return s.changes
+}
+
+// push never blocks, the oldest change is dropped when the application falls behind.
+func (s *registryStates) push(state RegistryState) {
+	changes := s.channel()
+	for {
+		select {
+		case changes <- state:
+			return
+		default:
+		}
+		select {
+		case <-changes:
+		default:
+		}
+	}
+}
+
+// StateRegistry is the extension interface of the registries reporting the state of their registrations,
+// the Registries of this package implement it.
+type StateRegistry interface {
+	// StateChanges returns the channel receiving every change of CurrentState, e.g. to open an admin port
+	// once registered or to alarm when degraded. The registry never blocks on it, the oldest change is
+	// dropped when the buffer of 16 changes is full.
+	StateChanges() <-chan RegistryState
+
+	// CurrentState returns the state of the registrations of the registry.
+	CurrentState() RegistryState
+}
+
+var _ StateRegistry = (*polarisRegistry)(nil)
+
+// StateChanges implements the StateRegistry interface.
+func (svr *polarisRegistry) StateChanges() <-chan RegistryState {
+	svr.states.lock.Lock()
+	defer svr.states.lock.Unlock()
+	return svr.states.channel()
+}
+
+// CurrentState implements the StateRegistry interface.
+func (svr *polarisRegistry) CurrentState() RegistryState {
+	svr.states.lock.Lock()
+	defer svr.states.lock.Unlock()
+	return svr.states.current
+}
+
+// transition applies change to the tracked state, computes the state again and pushes it when it changed.
+// It must be called without the locks of the registrations and of the retries held.
+func (svr *polarisRegistry) transition(change func(s *registryStates)) {
+	s := &svr.states
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if change != nil {
+		change(s)
+	}
+	svr.retryLock.Lock()
+	registering := s.registering + len(svr.registerRetries)
+	svr.retryLock.Unlock()
+
+	registered, degraded := 0, false
+	svr.lock.RLock()
+	live := make(map[*api.InstanceHeartbeatRequest]struct{}, len(s.degraded))
+	for _, ins := range svr.registryIns {
+		registered++
+		if _, ok := s.degraded[ins.heartbeat]; ok {
+			live[ins.heartbeat] = struct{}{}
+			degraded = true
+		}
+	}
+	svr.lock.RUnlock()
+	// the heartbeats of the instances deregistered or registered again are forgotten.
+	s.degraded = live
+
+	next := StateUnregistered
+	switch {
+	case degraded:
+		next = StateDegraded
+	case registered > 0:
+		next = StateRegistered
+	case registering > 0:
+		next = StateRegistering
+	case s.current == StateRegistered || s.current == StateDegraded || s.current == StateDeregistered:
+		next = StateDeregistered
+	}
+	if next == s.current {
+		return
+	}
+	s.current = next
+	s.push(next)
+}
+
+// beginRegister counts a running Register, endRegister must follow.
+func (svr *polarisRegistry) beginRegister() {
+	svr.transition(func(s *registryStates) { s.registering++ })
+}
+
+func (svr *polarisRegistry) endRegister() {
+	svr.transition(func(s *registryStates) { s.registering-- })
+}
+
+// heartbeatDegraded records whether the heartbeats of an instance used up their failure budget.
+func (svr *polarisRegistry) heartbeatDegraded(heartbeat *api.InstanceHeartbeatRequest, degraded bool) {
+	svr.transition(func(s *registryStates) {
+		if !degraded {
+			delete(s.degraded, heartbeat)
+			return
+		}
+		if s.degraded == nil {
+			s.degraded = make(map[*api.InstanceHeartbeatRequest]struct{})
+		}
+		s.degraded[heartbeat] = struct{}{}
+	})
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// requireStates asserts the next changes of rg and that no other change follows.
+func requireStates(t *testing.T, rg *polarisRegistry, states ...RegistryState) {
+	for _, want := range states {
+		select {
+		case got := <-rg.StateChanges():
+			require.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("no change to %v", want)
+		}
+	}
+	select {
+	case got := <-rg.StateChanges():
+		t.Fatalf("unexpected change to %v", got)
+	default:
+	}
+}
+
+func TestRegistryStateLifecycle(t *testing.T) {
+	backend := polaristest.NewBackend()
+	provider := &failingHeartbeatProvider{Backend: backend}
+	rg := newTestRegistry(backend, WithHeartbeatFailureBudget(2))
+	rg.provider = provider
+	clock := newFakeHeartbeatClock(rg)
+	require.Equal(t, StateUnregistered, rg.CurrentState())
+
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	requireStates(t, rg, StateRegistering, StateRegistered)
+	require.Equal(t, StateRegistered, rg.CurrentState())
+
+	clock.beat(t)
+	atomic.StoreInt32(&provider.failing, 1)
+	clock.beat(t)
+	clock.beat(t)
+	<-clock.intervals
+	requireStates(t, rg, StateDegraded)
+	require.Equal(t, StateDegraded, rg.CurrentState())
+
+	// failures past the budget change nothing until a heartbeat succeeds.
+	clock.fire <- time.Now()
+	<-clock.intervals
+	requireStates(t, rg)
+	atomic.StoreInt32(&provider.failing, 0)
+	clock.fire <- time.Now()
+	<-clock.intervals
+	requireStates(t, rg, StateRegistered)
+
+	require.Nil(t, rg.Deregister(info))
+	requireStates(t, rg, StateDeregistered)
+	require.Equal(t, StateDeregistered, rg.CurrentState())
+
+	// a failed registration ends unregistered.
+	backend.SetFailureRate(polaristest.OpRegister, 1)
+	require.NotNil(t, rg.Register(info))
+	requireStates(t, rg, StateRegistering, StateUnregistered)
+}
+
+func TestRegistryStateSeveralInstances(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	first, second := newTestInfo("127.0.0.1:6666", nil), newTestInfo("127.0.0.1:7777", nil)
+	require.Nil(t, rg.Register(first))
+	require.Nil(t, rg.Register(second))
+	requireStates(t, rg, StateRegistering, StateRegistered)
+
+	// the registry stays registered while one instance is.
+	require.Nil(t, rg.Deregister(first))
+	requireStates(t, rg)
+	require.Nil(t, rg.Deregister(second))
+	requireStates(t, rg, StateDeregistered)
+}
+
+func TestRegistryStateRetry(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithRegisterRetry(0, func(attempt int) time.Duration { return time.Millisecond }))
+	backend.SetFailureRate(polaristest.OpRegister, 1)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	requireStates(t, rg, StateRegistering)
+
+	// the background retries keep the registry registering until one succeeds.
+	backend.SetFailureRate(polaristest.OpRegister, 0)
+	requireStates(t, rg, StateRegistered)
+	require.Nil(t, rg.Deregister(info))
+	requireStates(t, rg, StateDeregistered)
+}
+
+func TestRegistryStateChangesDropOldest(t *testing.T) {
+	s := &registryStates{}
+	for i := 0; i < registryStateBufferSize+2; i++ {
+		s.push(RegistryState(i % 5))
+	}
+	require.Len(t, s.changes, registryStateBufferSize)
+	require.Equal(t, RegistryState(2), <-s.changes)
+	require.Equal(t, "degraded", StateDegraded.String())
+	require.Equal(t, "unknown", RegistryState(-1).String())
+}
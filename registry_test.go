@@ -0,0 +1,497 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(backend *polaristest.Backend, opts ...Option) *polarisRegistry {
+	return &polarisRegistry{
+		consumer:          backend,
+		provider:          backend,
+		registryIns:       make(map[string]*polarisHeartbeat),
+		lock:              &sync.RWMutex{},
+		heartbeatInterval: 10 * time.Millisecond,
+		verifyInterval:    5 * time.Millisecond,
+		opts:              newOptions(opts),
+	}
+}
+
+func newTestInfo(addr string, tags map[string]string) *registry.Info {
+	return &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", addr),
+		Weight:      100,
+		Tags:        tags,
+	}
+}
+
+func TestAutoMetadata(t *testing.T) {
+	startTime := time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC)
+	info := newTestInfo("127.0.0.1:6666", map[string]string{"env": "prod"})
+	info.StartTime = startTime
+
+	param, _, err := createRegisterParam(info, newOptions(nil))
+	require.Nil(t, err)
+	require.Equal(t, startTime.Format(time.RFC3339), param.Metadata[MetadataStartTime])
+	require.Equal(t, kitex.Version, param.Metadata[MetadataKitexVersion])
+	require.NotEmpty(t, param.Metadata[MetadataRegistryPolarisVersion])
+	require.Equal(t, "prod", param.Metadata["env"])
+
+	info.Tags[MetadataKitexVersion] = "custom"
+	param, _, err = createRegisterParam(info, newOptions(nil))
+	require.Nil(t, err)
+	require.Equal(t, "custom", param.Metadata[MetadataKitexVersion])
+
+	param, _, err = createRegisterParam(info, newOptions([]Option{WithAutoMetadata(false)}))
+	require.Nil(t, err)
+	require.NotContains(t, param.Metadata, MetadataStartTime)
+	require.NotContains(t, param.Metadata, MetadataRegistryPolarisVersion)
+	require.Equal(t, "custom", param.Metadata[MetadataKitexVersion])
+}
+
+func TestRegisterAutoMetadata(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	instances := backend.Instances(polarisDefaultNamespace, serviceName)
+	require.Len(t, instances, 1)
+	require.Equal(t, kitex.Version, instances[0].Metadata[MetadataKitexVersion])
+}
+
+func TestRegisterHooks(t *testing.T) {
+	backend := polaristest.NewBackend()
+	var calls []string
+	rg := newTestRegistry(backend,
+		WithRegisterHooks(
+			func(info *registry.Info) {
+				calls = append(calls, "before register")
+				require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+			},
+			func(info *registry.Info, err error) {
+				require.Nil(t, err)
+				calls = append(calls, "after register")
+				require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 1)
+			},
+		),
+		WithDeregisterHooks(
+			func(info *registry.Info) { calls = append(calls, "before deregister") },
+			func(info *registry.Info, err error) {
+				require.Nil(t, err)
+				calls = append(calls, "after deregister")
+			},
+		),
+	)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	require.Nil(t, rg.Deregister(info))
+	require.Equal(t, []string{"before register", "after register", "before deregister", "after deregister"}, calls)
+}
+
+func TestRegisterHooksPanic(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend,
+		WithRegisterHooks(func(info *registry.Info) { panic("before") }, func(info *registry.Info, err error) { panic("after") }),
+		WithDeregisterHooks(func(info *registry.Info) { panic("before") }, func(info *registry.Info, err error) { panic("after") }),
+	)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 1)
+	require.Nil(t, rg.Deregister(info))
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+}
+
+func TestHeartbeatUsesRegisteredIdentity(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	info := newTestInfo("10.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(newTestInfo("10.0.0.1:6666", nil))
+	registered := backend.Instances(polarisDefaultNamespace, serviceName)
+	require.Len(t, registered, 1)
+
+	// the listener now reports a different address, heartbeats must keep the registered identity.
+	info.Addr = utils.NewNetAddr("tcp", "192.168.0.1:7777")
+	require.Eventually(t, func() bool {
+		return len(backend.Heartbeats()) >= 2
+	}, time.Second, 5*time.Millisecond)
+	for _, heartbeat := range backend.Heartbeats() {
+		require.Equal(t, registered[0].ID, heartbeat.InstanceID)
+		require.Equal(t, "10.0.0.1", heartbeat.Host)
+		require.Equal(t, 6666, heartbeat.Port)
+		require.Equal(t, polarisDefaultNamespace, heartbeat.Namespace)
+		require.Equal(t, serviceName, heartbeat.Service)
+	}
+}
+
+func TestSetHealthy(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpHeartbeat) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.Nil(t, rg.SetHealthy(false))
+	require.Nil(t, rg.SetHealthy(false))
+	// let an in flight tick finish before sampling.
+	time.Sleep(20 * time.Millisecond)
+	paused := backend.Calls(polaristest.OpHeartbeat)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, paused, backend.Calls(polaristest.OpHeartbeat), "heartbeats must stop while unhealthy")
+
+	require.Nil(t, rg.SetHealthy(true))
+	require.GreaterOrEqual(t, backend.Calls(polaristest.OpHeartbeat), paused+1, "recovery heartbeats immediately")
+	require.Nil(t, rg.SetHealthy(true))
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpHeartbeat) > paused+2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRegistryClose(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	released := 0
+	rg.releaseSDK = func() { released++ }
+	require.Nil(t, rg.Register(newTestInfo("127.0.0.1:6666", nil)))
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpHeartbeat) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.Nil(t, rg.Close())
+	require.Nil(t, rg.Close())
+	require.Equal(t, 1, released)
+	// let an in flight tick finish before sampling.
+	time.Sleep(20 * time.Millisecond)
+	heartbeats := backend.Calls(polaristest.OpHeartbeat)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, heartbeats, backend.Calls(polaristest.OpHeartbeat), "heartbeats must stop after Close")
+	// the instance is left to expire.
+	require.Len(t, backend.Instances(polarisDefaultNamespace, serviceName), 1)
+}
+
+func TestSetHealthyConcurrentFlapping(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				require.Nil(t, rg.SetHealthy((i+j)%2 == 0))
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Nil(t, rg.SetHealthy(true))
+	calls := backend.Calls(polaristest.OpHeartbeat)
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpHeartbeat) > calls
+	}, time.Second, 5*time.Millisecond)
+}
+
+// hangingProvider blocks Deregister until release is closed.
+type hangingProvider struct {
+	*polaristest.Backend
+	release chan struct{}
+}
+
+func (p *hangingProvider) Deregister(req *api.InstanceDeRegisterRequest) error {
+	<-p.release
+	return p.Backend.Deregister(req)
+}
+
+func TestDeregisterTimeout(t *testing.T) {
+	backend := polaristest.NewBackend()
+	provider := &hangingProvider{Backend: backend, release: make(chan struct{})}
+	defer close(provider.release)
+	rg := newTestRegistry(backend, WithDeregisterTimeout(2*time.Second))
+	rg.provider = provider
+	clock := make(chan time.Time)
+	var timeout time.Duration
+	rg.after = func(d time.Duration) <-chan time.Time {
+		timeout = d
+		return clock
+	}
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- rg.Deregister(info) }()
+	clock <- time.Now()
+	err := <-errCh
+	var timeoutErr *DeregisterTimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+	require.Equal(t, 2*time.Second, timeoutErr.Timeout)
+	require.Equal(t, 2*time.Second, timeout)
+
+	// the local state is gone, so the heartbeats stopped.
+	rg.lock.RLock()
+	require.Empty(t, rg.registryIns)
+	rg.lock.RUnlock()
+	heartbeats := backend.Calls(polaristest.OpHeartbeat)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, heartbeats, backend.Calls(polaristest.OpHeartbeat))
+
+	require.Nil(t, rg.Register(info))
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpHeartbeat) > heartbeats
+	}, time.Second, 5*time.Millisecond)
+	rg.lock.RLock()
+	require.Len(t, rg.registryIns, 1)
+	rg.lock.RUnlock()
+}
+
+// lateConsumer hides all instances from the first hidden GetAllInstances calls, a negative hidden hides them forever.
+type lateConsumer struct {
+	*polaristest.Backend
+	hidden int32
+	polls  int32
+}
+
+func (c *lateConsumer) GetAllInstances(req *api.GetAllInstancesRequest) (*model.InstancesResponse, error) {
+	poll := atomic.AddInt32(&c.polls, 1)
+	if c.hidden < 0 || poll <= c.hidden {
+		return &model.InstancesResponse{}, nil
+	}
+	return c.Backend.GetAllInstances(req)
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	backend := polaristest.NewBackend()
+	consumer := &lateConsumer{Backend: backend, hidden: 2}
+	rg := newTestRegistry(backend)
+	rg.consumer = consumer
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	require.Nil(t, rg.VerifyRegistration(context.Background(), info, time.Second))
+	require.Equal(t, int32(3), atomic.LoadInt32(&consumer.polls))
+}
+
+func TestVerifyRegistrationNeverVisible(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend)
+	rg.consumer = &lateConsumer{Backend: backend, hidden: -1}
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	err := rg.VerifyRegistration(context.Background(), info, 30*time.Millisecond)
+	var notVisible *RegistrationNotVisibleError
+	require.True(t, errors.As(err, &notVisible))
+	require.Equal(t, polarisDefaultNamespace+":"+serviceName+":127.0.0.1:6666", notVisible.InstanceID)
+	require.Equal(t, 30*time.Millisecond, notVisible.Timeout)
+}
+
+func TestPostRegisterVerification(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithPostRegisterVerification(time.Second))
+	rg.consumer = &lateConsumer{Backend: backend, hidden: 2}
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	require.Nil(t, rg.Deregister(info))
+}
+
+func TestPostRegisterVerificationFails(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithPostRegisterVerification(30*time.Millisecond))
+	rg.consumer = &lateConsumer{Backend: backend, hidden: -1}
+	info := newTestInfo("127.0.0.1:6666", nil)
+
+	err := rg.Register(info)
+	var notVisible *RegistrationNotVisibleError
+	require.True(t, errors.As(err, &notVisible))
+	require.Equal(t, 1, backend.Calls(polaristest.OpDeregister))
+	require.Empty(t, rg.registryIns)
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+}
+
+// failingHeartbeatProvider fails the heartbeats while failing is set.
+type failingHeartbeatProvider struct {
+	*polaristest.Backend
+	failing int32
+}
+
+func (p *failingHeartbeatProvider) Heartbeat(req *api.InstanceHeartbeatRequest) error {
+	if atomic.LoadInt32(&p.failing) == 1 {
+		return errors.New("polaris unreachable")
+	}
+	return p.Backend.Heartbeat(req)
+}
+
+// fakeHeartbeatClock records the requested heartbeat intervals and fires them on demand.
+type fakeHeartbeatClock struct {
+	intervals chan time.Duration
+	fire      chan time.Time
+}
+
+func newFakeHeartbeatClock(rg *polarisRegistry) *fakeHeartbeatClock {
+	c := &fakeHeartbeatClock{intervals: make(chan time.Duration, 1), fire: make(chan time.Time)}
+	rg.heartbeatAfter = func(d time.Duration) <-chan time.Time {
+		c.intervals <- d
+		return c.fire
+	}
+	return c
+}
+
+// beat waits for the next scheduled heartbeat and fires it.
+func (c *fakeHeartbeatClock) beat(t *testing.T) time.Duration {
+	select {
+	case d := <-c.intervals:
+		c.fire <- time.Now()
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat scheduled")
+		return 0
+	}
+}
+
+func TestHeartbeatJitter(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg := newTestRegistry(backend, WithHeartbeatJitter(0.2))
+	rg.heartbeatInterval = time.Second
+	randoms := []float64{0, 0.5, 0.999, 0.25}
+	var next int32
+	rg.random = func() float64 { return randoms[int(atomic.AddInt32(&next, 1)-1)%len(randoms)] }
+	clock := newFakeHeartbeatClock(rg)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	want := []time.Duration{time.Second, 900 * time.Millisecond, 800200 * time.Microsecond, 950 * time.Millisecond}
+	for _, w := range want {
+		d := clock.beat(t)
+		require.InDelta(t, float64(w), float64(d), float64(time.Microsecond))
+		require.True(t, d >= 800*time.Millisecond && d <= time.Second)
+	}
+}
+
+func TestHeartbeatJitterWithinTTL(t *testing.T) {
+	ttl := time.Duration(defaultHeartbeatIntervalSec) * time.Second
+	for _, jitter := range []float64{defaultHeartbeatJitter, 0.5, 1, 2} {
+		rg := newTestRegistry(polaristest.NewBackend(), WithHeartbeatJitter(jitter))
+		for _, random := range []float64{0, 0.25, 0.5, 0.999, 1} {
+			random := random
+			rg.random = func() float64 { return random }
+			d := rg.nextHeartbeatInterval()
+			require.True(t, d <= rg.heartbeatInterval, "jitter %v random %v: %v", jitter, random, d)
+			require.True(t, d <= ttl, "jitter %v random %v: %v", jitter, random, d)
+			require.True(t, d >= 0, "jitter %v random %v: %v", jitter, random, d)
+		}
+	}
+}
+
+func TestHeartbeatLost(t *testing.T) {
+	backend := polaristest.NewBackend()
+	provider := &failingHeartbeatProvider{Backend: backend, failing: 1}
+	lost := make(chan error, 4)
+	rg := newTestRegistry(backend, WithHeartbeatFailureBudget(3), WithOnHeartbeatLost(func(err error) { lost <- err }))
+	rg.provider = provider
+	clock := newFakeHeartbeatClock(rg)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+
+	clock.beat(t)
+	clock.beat(t)
+	require.Empty(t, lost)
+	clock.beat(t)
+	// wait for the third heartbeat to be handled.
+	<-clock.intervals
+	require.Len(t, lost, 1)
+	require.Equal(t, uint64(1), rg.HeartbeatsLost())
+
+	// later failures are not reported again until a heartbeat succeeds.
+	clock.fire <- time.Now()
+	clock.beat(t)
+	<-clock.intervals
+	require.Len(t, lost, 1)
+	atomic.StoreInt32(&provider.failing, 0)
+	clock.fire <- time.Now()
+	<-clock.intervals
+	atomic.StoreInt32(&provider.failing, 1)
+	clock.fire <- time.Now()
+	clock.beat(t)
+	clock.beat(t)
+	<-clock.intervals
+	require.Len(t, lost, 2)
+	require.Equal(t, uint64(2), rg.HeartbeatsLost())
+}
+
+func TestRegisterIsolatedDarkLaunch(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777})
+	rg := newTestRegistry(backend, WithRegisterIsolated(true))
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+	dark := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(dark))
+	defer rg.Deregister(dark)
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, "127.0.0.1:7777", result.Instances[0].Address().String())
+	all, err := rs.ResolveAll(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, all.Instances, 2)
+	for _, ins := range all.Instances {
+		isolated, _ := ins.Tag(TagIsolated)
+		require.Equal(t, strconv.FormatBool(ins.Address().String() == "127.0.0.1:6666"), isolated)
+	}
+
+	// the admin call opens the traffic, the heartbeats go on.
+	require.Nil(t, rg.SetIsolated(dark, false))
+	result, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 2)
+	heartbeats := len(backend.Heartbeats())
+	require.Eventually(t, func() bool { return len(backend.Heartbeats()) > heartbeats }, time.Second, time.Millisecond)
+
+	require.Nil(t, rg.SetIsolated(dark, true))
+	result, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.NotNil(t, rg.SetIsolated(newTestInfo("127.0.0.1:5555", nil), false))
+}
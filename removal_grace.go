@@ -0,0 +1,214 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sort"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// TagDraining marks the instances kept at a residual weight after their removal, see WithRemovalGracePeriod.
+const TagDraining = "draining"
+
+// drainingInstance is a removed instance kept for the grace period at a residual weight.
+type drainingInstance struct {
+	model.Instance
+	weight   int
+	metadata map[string]string
+}
+
+func newDrainingInstance(instance model.Instance, percent int) *drainingInstance {
+	base := polarisWeight(instance.GetWeight(), instance.GetMetadata(), defaultWeight)
+	weight := base * percent / 100
+	if weight < 1 && base > 0 {
+		// a zero weight is converted to the default one, the drained instances keep it.
+		weight = 1
+	}
+	metadata := make(map[string]string, len(instance.GetMetadata())+1)
+	for k, v := range instance.GetMetadata() {
+		metadata[k] = v
+	}
+	metadata[TagDraining] = "true"
+	return &drainingInstance{Instance: instance, weight: weight, metadata: metadata}
+}
+
+// GetWeight implements model.Instance.
+func (d *drainingInstance) GetWeight() int {
+	return d.weight
+}
+
+// GetMetadata implements model.Instance.
+func (d *drainingInstance) GetMetadata() map[string]string {
+	return d.metadata
+}
+
+// GetRevision implements model.Instance, the revision differs from the one of the instance so that
+// the conversion caches convert it again.
+func (d *drainingInstance) GetRevision() string {
+	return d.Instance.GetRevision() + "-draining"
+}
+
+// removalGrace holds the removed instances of a serviceWatch until their grace period expires.
+type removalGrace struct {
+	period   time.Duration
+	percent  int
+	draining map[string]*drainingEntry // by instance ID
+}
+
+type drainingEntry struct {
+	instance *drainingInstance
+	timer    *time.Timer
+}
+
+// newRemovalGrace returns the grace of the serviceWatches, nil when WithRemovalGracePeriod is not set.
+func (o *options) newRemovalGrace() *removalGrace {
+	if o.removalGracePeriod <= 0 {
+		return nil
+	}
+	return &removalGrace{period: o.removalGracePeriod, percent: o.removalResidualWeight,
+		draining: make(map[string]*drainingEntry)}
+}
+
+// drain rewrites an event for the grace period, it is called with the lock of sw held. The deleted
+// instances become updates to their draining copies and an instance added again during its grace
+// period becomes an update of its draining copy. It returns nil when nothing is left to dispatch.
+func (g *removalGrace) drain(sw *serviceWatch, insEvent *model.InstanceEvent) *model.InstanceEvent {
+	var updates []model.OneInstanceUpdate
+	if insEvent.UpdateEvent != nil {
+		for _, update := range insEvent.UpdateEvent.UpdateList {
+			if before := g.restore(update.After.GetId()); before != nil {
+				update.Before = before
+			}
+			updates = append(updates, update)
+		}
+	}
+	var added []model.Instance
+	if insEvent.AddEvent != nil {
+		for _, instance := range insEvent.AddEvent.Instances {
+			if before := g.restore(instance.GetId()); before != nil {
+				updates = append(updates, model.OneInstanceUpdate{Before: before, After: instance})
+				continue
+			}
+			added = append(added, instance)
+		}
+	}
+	if insEvent.DeleteEvent != nil {
+		for _, instance := range insEvent.DeleteEvent.Instances {
+			if _, ok := g.draining[instance.GetId()]; ok {
+				continue
+			}
+			draining := newDrainingInstance(instance, g.percent)
+			g.draining[instance.GetId()] = &drainingEntry{
+				instance: draining,
+				timer:    time.AfterFunc(g.period, func() { sw.expire(draining) }),
+			}
+			updates = append(updates, model.OneInstanceUpdate{Before: instance, After: draining})
+		}
+	}
+	if len(updates) == 0 && len(added) == 0 {
+		return nil
+	}
+	drained := &model.InstanceEvent{}
+	if len(added) > 0 {
+		drained.AddEvent = &model.InstanceAddEvent{Instances: added}
+	}
+	if len(updates) > 0 {
+		drained.UpdateEvent = &model.InstanceUpdateEvent{UpdateList: updates}
+	}
+	return drained
+}
+
+// restore stops the grace period of an instance added again and returns its draining copy, if any.
+func (g *removalGrace) restore(id string) model.Instance {
+	entry, ok := g.draining[id]
+	if !ok {
+		return nil
+	}
+	entry.timer.Stop()
+	delete(g.draining, id)
+	return entry.instance
+}
+
+// reset drops the instances in their grace period, the subscription restarts from a new snapshot.
+func (g *removalGrace) reset() {
+	for id, entry := range g.draining {
+		entry.timer.Stop()
+		delete(g.draining, id)
+	}
+}
+
+// instances returns the draining copies of the instances in their grace period.
+func (g *removalGrace) instances() []model.Instance {
+	instances := make([]model.Instance, 0, len(g.draining))
+	for _, entry := range g.draining {
+		instances = append(instances, entry.instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].GetId() < instances[j].GetId() })
+	return instances
+}
+
+// expire dispatches the removal of an instance whose grace period expired, unless it was added again.
+func (sw *serviceWatch) expire(draining *drainingInstance) {
+	sw.lock.Lock()
+	entry, ok := sw.grace.draining[draining.GetId()]
+	if !ok || entry.instance != draining {
+		sw.lock.Unlock()
+		return
+	}
+	delete(sw.grace.draining, draining.GetId())
+	sw.lock.Unlock()
+	if sw.onEvent != nil {
+		sw.onEvent(sw.key)
+	}
+	removed := []model.Instance{draining}
+	if sw.onRemoved != nil {
+		sw.onRemoved(removed)
+	}
+	sw.broadcast(&model.InstanceEvent{DeleteEvent: &model.InstanceDeleteEvent{Instances: removed}})
+}
+
+// withDraining appends the instances of key in their grace period which are missing from instances.
+func (m *watchManager) withDraining(key model.ServiceKey, instances []model.Instance) []model.Instance {
+	if m == nil {
+		return instances
+	}
+	m.lock.Lock()
+	sw, ok := m.watches[key]
+	m.lock.Unlock()
+	if !ok || sw.grace == nil {
+		return instances
+	}
+	sw.lock.Lock()
+	draining := sw.grace.instances()
+	sw.lock.Unlock()
+	if len(draining) == 0 {
+		return instances
+	}
+	present := make(map[string]struct{}, len(instances))
+	for _, instance := range instances {
+		present[instance.GetId()] = struct{}{}
+	}
+	merged := append([]model.Instance(nil), instances...)
+	for _, instance := range draining {
+		if _, ok := present[instance.GetId()]; !ok {
+			merged = append(merged, instance)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func nextChange(t *testing.T, changes <-chan discovery.Change) discovery.Change {
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(time.Second):
+		t.Fatal("no change")
+		return discovery.Change{}
+	}
+}
+
+func subscribeGrace(t *testing.T, backend *polaristest.Backend, d time.Duration) (*polarisResolver, <-chan discovery.Change, func()) {
+	backend.AddInstances(
+		&polaristest.Instance{ID: "a", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666, Weight: 100},
+		&polaristest.Instance{ID: "b", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.2", Port: 6666, Weight: 100},
+	)
+	rs := newTestResolver(backend, WithRemovalGracePeriod(d, 10))
+	changes := make(chan discovery.Change, 8)
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":"+serviceName, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	require.Len(t, nextChange(t, changes).Result.Instances, 2)
+	return rs, changes, func() {
+		unsubscribe()
+		rs.Close()
+	}
+}
+
+func TestRemovalGraceExpire(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs, changes, closeFn := subscribeGrace(t, backend, 200*time.Millisecond)
+	defer closeFn()
+
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, "a")
+	change := nextChange(t, changes)
+	require.Empty(t, change.Removed)
+	require.Len(t, change.Updated, 1)
+	draining := change.Updated[0]
+	require.Equal(t, "127.0.0.1:6666", draining.Address().String())
+	require.Equal(t, 10, draining.Weight())
+	tag, ok := draining.Tag(TagDraining)
+	require.True(t, ok)
+	require.Equal(t, "true", tag)
+	require.Len(t, change.Result.Instances, 2)
+
+	// Resolve keeps the draining instance of the watched service too.
+	result, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"127.0.0.1:6666", "127.0.0.2:6666"}, addrs(result.Instances))
+
+	change = nextChange(t, changes)
+	require.Len(t, change.Removed, 1)
+	require.Equal(t, "127.0.0.1:6666", change.Removed[0].Address().String())
+	require.Equal(t, draining, change.Removed[0])
+	result, err = rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.2:6666"}, addrs(result.Instances))
+}
+
+func TestRemovalGraceReAdd(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs, changes, closeFn := subscribeGrace(t, backend, time.Minute)
+	defer closeFn()
+
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, "a")
+	require.Equal(t, 10, nextChange(t, changes).Updated[0].Weight())
+
+	backend.AddInstances(&polaristest.Instance{ID: "a", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6666, Weight: 100})
+	change := nextChange(t, changes)
+	require.Empty(t, change.Added)
+	require.Len(t, change.Updated, 1)
+	require.Equal(t, 100, change.Updated[0].Weight())
+	_, ok := change.Updated[0].Tag(TagDraining)
+	require.False(t, ok)
+	require.Len(t, change.Result.Instances, 2)
+
+	sw := rs.watcher.serviceWatch(model.ServiceKey{Namespace: polarisDefaultNamespace, Service: serviceName})
+	sw.lock.Lock()
+	require.Empty(t, sw.grace.draining)
+	sw.lock.Unlock()
+}
+
+func TestRemovalGraceResidualWeight(t *testing.T) {
+	instance := &polaristest.Instance{ID: "a", Weight: 5}
+	require.Equal(t, 1, newDrainingInstance(instance, 10).GetWeight())
+	require.Equal(t, defaultWeight/2, newDrainingInstance(&polaristest.Instance{ID: "a"}, 50).GetWeight())
+	require.NotEqual(t, instance.GetRevision(), newDrainingInstance(instance, 10).GetRevision())
+	require.Nil(t, newOptions(nil).newRemovalGrace())
+	require.Equal(t, 100, newOptions([]Option{WithRemovalGracePeriod(time.Second, 200)}).removalResidualWeight)
+}
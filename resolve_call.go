@@ -0,0 +1,82 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	resolveTimeoutKey struct{}
+	resolveRetriesKey struct{}
+	freshResolveKey   struct{}
+)
+
+// CtxWithResolveTimeout returns a context bounding the Resolve of the calls made with it by timeout,
+// overriding WithResolveTimeout for those calls only. The timeout is also the GetInstances timeout of
+// the SDK, a zero timeout resolves with the default of the SDK and no bound. Concurrent Resolves of the
+// same description share one GetInstances request, which carries the timeout of the first of them.
+func CtxWithResolveTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, resolveTimeoutKey{}, timeout)
+}
+
+// CtxWithResolveRetries returns a context setting the GetInstances retry count of the SDK for the
+// Resolve of the calls made with it, like CtxWithResolveTimeout. A negative count keeps the default of the SDK.
+func CtxWithResolveRetries(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, resolveRetriesKey{}, retries)
+}
+
+// resolveTimeout returns the timeout of a Resolve, the one of CtxWithResolveTimeout or else the one of
+// WithResolveTimeout.
+func (polaris *polarisResolver) resolveTimeout(ctx context.Context) time.Duration {
+	if ctx != nil {
+		if timeout, ok := ctx.Value(resolveTimeoutKey{}).(time.Duration); ok {
+			return timeout
+		}
+	}
+	return polaris.opts.resolveTimeout
+}
+
+// resolveRetries returns the retry count of a Resolve set by CtxWithResolveRetries.
+func resolveRetries(ctx context.Context) (int, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	retries, ok := ctx.Value(resolveRetriesKey{}).(int)
+	return retries, ok && retries >= 0
+}
+
+// CtxWithFreshResolve returns a context making the Resolve of the calls made with it bypass the caches
+// of the resolver, to debug one request: the GetInstances call is not shared with the concurrent ones,
+// the instances are converted afresh, no snapshot is saved, neither the snapshot nor the static fallbacks
+// are served and the Result is not cacheable. Nothing it resolves feeds the other Resolves. polaris-go
+// v1.0.1 answers GetInstances from the instances its SDK context synced, it has no request forcing a
+// remote query. The Kitex clients cache the balancer of a description, only its first Resolve, or a direct
+// call of Resolve, sees the context of a call.
+func CtxWithFreshResolve(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshResolveKey{}, true)
+}
+
+// freshResolve tells whether a Resolve bypasses the caches, see CtxWithFreshResolve.
+func freshResolve(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	fresh, _ := ctx.Value(freshResolveKey{}).(bool)
+	return fresh
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCallOptions(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	desc := polarisDefaultNamespace + ":" + serviceName
+	last := func() model.GetInstancesRequest {
+		requests := backend.GetInstancesRequests()
+		return requests[len(requests)-1]
+	}
+
+	// without any option the defaults of the SDK apply.
+	_, err := newTestResolver(backend).Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Nil(t, last().Timeout)
+	require.Nil(t, last().RetryCount)
+
+	rs := newTestResolver(backend, WithResolveTimeout(2*time.Second))
+	_, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, 2*time.Second, *last().Timeout)
+	require.Nil(t, last().RetryCount)
+
+	ctx := CtxWithResolveRetries(CtxWithResolveTimeout(context.TODO(), 500*time.Millisecond), 3)
+	_, err = rs.Resolve(ctx, desc)
+	require.Nil(t, err)
+	require.Equal(t, 500*time.Millisecond, *last().Timeout)
+	require.Equal(t, 3, *last().RetryCount)
+
+	// the overrides do not leak into the next calls.
+	_, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, 2*time.Second, *last().Timeout)
+	require.Nil(t, last().RetryCount)
+
+	// a zero timeout lifts the bound of the resolver, a negative retry count keeps the default.
+	_, err = rs.Resolve(CtxWithResolveRetries(CtxWithResolveTimeout(context.TODO(), 0), -1), desc)
+	require.Nil(t, err)
+	require.Nil(t, last().Timeout)
+	require.Nil(t, last().RetryCount)
+}
+
+func TestResolveCallTimeoutUnderLatency(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	rs := newTestResolver(backend, WithResolveTimeout(10*time.Millisecond))
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	backend.SetLatency(polaristest.OpGetInstances, 50*time.Millisecond)
+	result, err := rs.Resolve(CtxWithResolveTimeout(context.TODO(), time.Second), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+
+	_, err = rs.Resolve(context.TODO(), desc)
+	_, ok := err.(*ResolveContextError)
+	require.True(t, ok, "%v", err)
+}
+
+func TestFreshResolveIsolation(t *testing.T) {
+	const normal, fresh = 50, 5
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6666))
+	backend.SetLatency(polaristest.OpGetInstances, 200*time.Millisecond)
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	results := make([]discovery.Result, normal+fresh)
+	errs := make([]error, normal+fresh)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range results {
+		ctx := context.Background()
+		if i >= normal {
+			ctx = CtxWithFreshResolve(ctx)
+		}
+		wg.Add(1)
+		go func(i int, ctx context.Context) {
+			defer wg.Done()
+			<-start
+			results[i], errs[i] = rs.Resolve(ctx, desc)
+		}(i, ctx)
+	}
+	close(start)
+	wg.Wait()
+
+	// the normal resolves share one call, every fresh one makes its own.
+	require.Equal(t, 1+fresh, backend.Calls(polaristest.OpGetInstances))
+	for i, result := range results {
+		require.Nil(t, errs[i])
+		require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+		require.Equal(t, i < normal, result.Cacheable, i)
+		require.Equal(t, desc, result.CacheKey)
+	}
+}
+
+func TestFreshResolveBypassesCaches(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6666))
+	rs := newTestResolver(backend, WithFallbackSnapshots(t.TempDir()))
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	result, err := rs.Resolve(CtxWithFreshResolve(context.Background()), desc)
+	require.Nil(t, err)
+	require.False(t, result.Cacheable)
+	_, cached := rs.caches.Load(desc)
+	require.False(t, cached, "a fresh resolve fills the conversion cache")
+	_, err = rs.snapshots.load(desc)
+	require.True(t, os.IsNotExist(err), "a fresh resolve saved a snapshot: %v", err)
+
+	// the fallbacks of the normal resolves are not served, the debugged request sees the polaris error.
+	_, err = rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	backend.SetFailureRate(polaristest.OpGetInstances, 1)
+	result, err = rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+	_, err = rs.Resolve(CtxWithFreshResolve(context.Background()), desc)
+	require.NotNil(t, err)
+}
+
+func TestFreshResolveStaticFallback(t *testing.T) {
+	desc := polarisDefaultNamespace + ":" + serviceName
+	rs := newTestResolver(polaristest.NewBackend(), WithStaticFallback(desc, []string{"10.0.0.1:8888"}))
+
+	_, err := rs.Resolve(CtxWithFreshResolve(context.Background()), desc)
+	require.NotNil(t, err)
+	require.Zero(t, rs.StaticFallbacks())
+	result, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1:8888"}, addrs(result.Instances))
+}
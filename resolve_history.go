@@ -0,0 +1,257 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+const (
+	// resolveHistoryVersion is the version of the files of WithResolveHistory.
+	resolveHistoryVersion        = 1
+	defaultResolveHistoryEntries = 1000
+	defaultResolveHistoryMaxAge  = 7 * 24 * time.Hour
+	resolveHistoryFlushInterval  = time.Minute
+	// resolveHistoryWarmupTimeout bounds the warm up Resolve of every description of the history.
+	resolveHistoryWarmupTimeout = 5 * time.Second
+)
+
+// ResolveHistoryEntry is a description of the history of WithResolveHistory.
+type ResolveHistoryEntry struct {
+	Desc     string    `json:"desc"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// resolveHistoryFile is the content of the file of WithResolveHistory.
+type resolveHistoryFile struct {
+	Version int                   `json:"version"`
+	Entries []ResolveHistoryEntry `json:"entries"`
+}
+
+// resolveHistory tracks the descriptions resolved by the process and persists them, so that the next
+// process resolves them once at startup before Kitex asks for them.
+type resolveHistory struct {
+	path       string
+	maxEntries int
+	maxAge     time.Duration
+	now        func() time.Time // time.Now, replaced in tests
+	lock       sync.Mutex
+	entries    map[string]time.Time
+	dirty      bool
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// newResolveHistory returns the history of WithResolveHistory, nil without it.
+func newResolveHistory(o *options) *resolveHistory {
+	if o.resolveHistoryPath == "" {
+		return nil
+	}
+	return &resolveHistory{
+		path:       o.resolveHistoryPath,
+		maxEntries: orDefault(o.resolveHistoryEntries, defaultResolveHistoryEntries),
+		maxAge:     orDefaultDuration(o.resolveHistoryMaxAge, defaultResolveHistoryMaxAge),
+		now:        time.Now,
+		entries:    make(map[string]time.Time),
+		done:       make(chan struct{}),
+	}
+}
+
+// load reads the history file, an unreadable or corrupt file is logged and the history starts empty.
+func (h *resolveHistory) load() {
+	data, err := os.ReadFile(h.path)
+	if os.IsNotExist(err) {
+		return
+	}
+	file := &resolveHistoryFile{}
+	if err == nil {
+		err = json.Unmarshal(data, file)
+	}
+	if err == nil && file.Version != resolveHistoryVersion {
+		err = perrors.Errorf("unknown version %d", file.Version)
+	}
+	if err != nil {
+		log.GetBaseLogger().Warnf("[Polaris resolver] ignore the resolve history %s: %v", h.path, err)
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, entry := range file.Entries {
+		if entry.Desc != "" && entry.LastUsed.After(h.entries[entry.Desc]) {
+			h.entries[entry.Desc] = entry.LastUsed
+		}
+	}
+	h.prune()
+}
+
+// touch records a Resolve of desc.
+func (h *resolveHistory) touch(desc string) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.entries[desc] = h.now().UTC()
+	h.dirty = true
+	if len(h.entries) > h.maxEntries {
+		h.prune()
+	}
+}
+
+// prune drops the entries unused for more than maxAge and the oldest ones above maxEntries, it is
+// called with lock held.
+func (h *resolveHistory) prune() {
+	deadline := h.now().Add(-h.maxAge)
+	for desc, lastUsed := range h.entries {
+		if lastUsed.Before(deadline) {
+			delete(h.entries, desc)
+			h.dirty = true
+		}
+	}
+	if len(h.entries) <= h.maxEntries {
+		return
+	}
+	for _, entry := range h.sorted()[h.maxEntries:] {
+		delete(h.entries, entry.Desc)
+	}
+	h.dirty = true
+}
+
+// sorted returns the entries most recent first, it is called with lock held.
+func (h *resolveHistory) sorted() []ResolveHistoryEntry {
+	entries := make([]ResolveHistoryEntry, 0, len(h.entries))
+	for desc, lastUsed := range h.entries {
+		entries = append(entries, ResolveHistoryEntry{Desc: desc, LastUsed: lastUsed})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].LastUsed.Equal(entries[j].LastUsed) {
+			return entries[i].LastUsed.After(entries[j].LastUsed)
+		}
+		return entries[i].Desc < entries[j].Desc
+	})
+	return entries
+}
+
+// snapshot returns the entries most recent first.
+func (h *resolveHistory) snapshot() []ResolveHistoryEntry {
+	if h == nil {
+		return nil
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.sorted()
+}
+
+// save writes the history when it changed, through a temporary file renamed over the previous one so
+// that a crash never leaves a partial file.
+func (h *resolveHistory) save() error {
+	h.lock.Lock()
+	if !h.dirty {
+		h.lock.Unlock()
+		return nil
+	}
+	h.prune()
+	data, err := json.Marshal(resolveHistoryFile{Version: resolveHistoryVersion, Entries: h.sorted()})
+	h.dirty = false
+	h.lock.Unlock()
+	if err == nil {
+		err = writeFileAtomic(h.path, data)
+	}
+	if err != nil {
+		h.lock.Lock()
+		h.dirty = true
+		h.lock.Unlock()
+	}
+	return err
+}
+
+// run saves the history every resolveHistoryFlushInterval until close.
+func (h *resolveHistory) run() {
+	ticker := time.NewTicker(resolveHistoryFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			if err := h.save(); err != nil {
+				log.GetBaseLogger().Warnf("[Polaris resolver] save the resolve history %s: %v", h.path, err)
+			}
+		}
+	}
+}
+
+// close stops run and saves the history a last time.
+func (h *resolveHistory) close() {
+	if h == nil {
+		return
+	}
+	h.closeOnce.Do(func() {
+		close(h.done)
+		if err := h.save(); err != nil {
+			log.GetBaseLogger().Warnf("[Polaris resolver] save the resolve history %s: %v", h.path, err)
+		}
+	})
+}
+
+// startResolveHistory loads the history of WithResolveHistory, resolves its descriptions in the background
+// to warm the caches of the SDK up and starts saving it.
+func (polaris *polarisResolver) startResolveHistory() {
+	h := polaris.history
+	if h == nil {
+		return
+	}
+	h.load()
+	entries := h.snapshot()
+	go h.run()
+	if len(entries) > 0 {
+		go polaris.warmup(entries)
+	}
+}
+
+// warmup resolves the descriptions of the history most recent first, the warm up resolves do not count
+// as uses so that the descriptions the process stopped using age out.
+func (polaris *polarisResolver) warmup(entries []ResolveHistoryEntry) {
+	warmed := 0
+	for _, entry := range entries {
+		select {
+		case <-polaris.history.done:
+			return
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), resolveHistoryWarmupTimeout)
+		if _, err := polaris.resolve(ctx, entry.Desc); err == nil {
+			warmed++
+		}
+		cancel()
+	}
+	log.GetBaseLogger().Infof("[Polaris resolver] warmed %d of the %d descriptions of the resolve history up",
+		warmed, len(entries))
+}
+
+// ResolveHistory implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) ResolveHistory() []ResolveHistoryEntry {
+	return polaris.history.snapshot()
+}
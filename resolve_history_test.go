@@ -0,0 +1,121 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// newHistoryResolver creates a test resolver with the history at path started like NewPolarisResolver.
+func newHistoryResolver(backend *polaristest.Backend, path string, opts ...Option) *polarisResolver {
+	rs := newTestResolver(backend, append([]Option{WithResolveHistory(path, 0)}, opts...)...)
+	rs.history = newResolveHistory(rs.opts)
+	rs.startResolveHistory()
+	return rs
+}
+
+func TestResolveHistoryPersistence(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6666),
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: "other", Host: "127.0.0.1", Port: 6667})
+	path := filepath.Join(t.TempDir(), "state", "history.json")
+	first, second := polarisDefaultNamespace+":"+serviceName, polarisDefaultNamespace+":other"
+
+	rs := newHistoryResolver(backend, path)
+	require.Empty(t, rs.ResolveHistory())
+	_, err := rs.Resolve(context.TODO(), first)
+	require.Nil(t, err)
+	_, err = rs.Resolve(context.TODO(), second)
+	require.Nil(t, err)
+	// a failing Resolve is not recorded.
+	_, err = rs.Resolve(context.TODO(), polarisDefaultNamespace+":missing")
+	require.NotNil(t, err)
+	history := rs.ResolveHistory()
+	require.Len(t, history, 2)
+	require.Nil(t, rs.Close())
+
+	calls := backend.Calls(polaristest.OpGetInstances)
+	rs = newHistoryResolver(backend, path)
+	defer rs.Close()
+	require.Equal(t, history, rs.ResolveHistory())
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpGetInstances) == calls+2
+	}, time.Second, time.Millisecond)
+	// the warm up keeps the times of the last uses.
+	require.Equal(t, history, rs.ResolveHistory())
+}
+
+func TestResolveHistoryPruning(t *testing.T) {
+	now := time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "history.json")
+	o := newOptions([]Option{WithResolveHistory(path, 2), WithResolveHistoryMaxAge(time.Hour)})
+	h := newResolveHistory(o)
+	h.now = func() time.Time { return now }
+	for _, desc := range []string{"a", "b", "c"} {
+		h.touch(desc)
+		now = now.Add(time.Minute)
+	}
+	// the oldest entry goes above maxEntries.
+	require.Equal(t, []string{"c", "b"}, historyDescs(h.snapshot()))
+	require.Nil(t, h.save())
+
+	now = now.Add(time.Hour - 90*time.Second)
+	reloaded := newResolveHistory(o)
+	reloaded.now = h.now
+	reloaded.load()
+	// b was last used more than an hour ago.
+	require.Equal(t, []string{"c"}, historyDescs(reloaded.snapshot()))
+}
+
+func TestResolveHistoryCorruption(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(newTestListenerInstance(6666))
+	desc := polarisDefaultNamespace + ":" + serviceName
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	for _, content := range []string{"{not json", `{"version":9,"entries":[{"desc":"x","last_used":"2021-11-01T08:00:00Z"}]}`} {
+		require.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+		rs := newHistoryResolver(backend, path)
+		require.Empty(t, rs.ResolveHistory())
+		_, err := rs.Resolve(context.TODO(), desc)
+		require.Nil(t, err)
+		require.Nil(t, rs.Close())
+
+		// the corrupt file is replaced by a valid one.
+		rs = newHistoryResolver(backend, path)
+		require.Equal(t, []string{desc}, historyDescs(rs.ResolveHistory()))
+		require.Nil(t, rs.Close())
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.Nil(t, err)
+	require.Len(t, entries, 1, "no temporary file is left")
+}
+
+func historyDescs(entries []ResolveHistoryEntry) []string {
+	descs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		descs = append(descs, entry.Desc)
+	}
+	return descs
+}
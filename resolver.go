@@ -18,13 +18,17 @@ package polaris
 
 import (
 	"context"
-	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudwego/kitex/pkg/discovery"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	perrors "github.com/pkg/errors"
 	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/config"
 	"github.com/polarismesh/polaris-go/pkg/log"
 	"github.com/polarismesh/polaris-go/pkg/model"
 )
@@ -32,79 +36,271 @@ import (
 const (
 	defaultWeight           = 10
 	polarisDefaultNamespace = "default"
+	// SystemNamespace is the namespace of the services of the polaris control plane, see WithSystemServiceMode.
+	SystemNamespace = config.ServerNamespace
+	// TagHealthy and TagIsolated carry the instance status in the results of ResolveAll.
+	TagHealthy              = "healthy"
+	TagIsolated             = "isolated"
+	descriptionSeparator    = ":"
+	qualifiedNameSeparator  = "/"
+	tagsSeparator           = "?"
+	tagSeparator            = "&"
+	namespaceTagKey         = "namespace"
+	initialSyncPollInterval = 20 * time.Millisecond
 )
 
 // Resolver is extension interface of Kitex discovery.Resolver.
+// A Resolver is safe for concurrent use, one instance can be shared by many Kitex clients. The resolvers
+// of this package implement the extension interfaces too, like ListenerResolver or ResolverDiagnostics,
+// and io.Closer. After Close, Watcher and Subscribe return ErrResolverClosed while the other methods keep
+// working on the SDK state.
 type Resolver interface {
 	discovery.Resolver
 
+	// Watcher returns the next Change of the service. The first call of a watcher returns at once with a
+	// Change carrying only the current Result, so that static services are delivered too, unless the
+	// service has no instance. The next calls wait for an event or for ctx to be done. A watcher is the
+	// ctx it passes to every call, each watcher of a description gets the initial Result.
 	Watcher(ctx context.Context, desc string) (discovery.Change, error)
 }
 
+// AllInstancesResolver is the extension interface of the resolvers listing every instance of a service
+// for admin tooling, the Resolvers of this package implement it:
+//
+//	result, err := resolver.(polaris.AllInstancesResolver).ResolveAll(ctx, desc)
+type AllInstancesResolver interface {
+	// ResolveAll returns every instance of the service including unhealthy and isolated ones.
+	ResolveAll(ctx context.Context, desc string) (discovery.Result, error)
+
+	// IterateInstances calls fn with every instance of the service, like ResolveAll, until fn returns false.
+	// The instances are converted in chunks so that tools never hold all of them converted at once.
+	// It returns the error of ctx when it is done before the end.
+	IterateInstances(ctx context.Context, desc string, fn func(InstanceInfo) bool) error
+}
+
+// ResolverDiagnostics is the extension interface of the resolvers exposing their counters and traces
+// for the metrics and the debug tools, the Resolvers of this package implement it.
+type ResolverDiagnostics interface {
+	// DroppedListenerChanges returns how many Changes were dropped for slow listeners and how many events the
+	// listeners of a service missed while they fell behind, they are then resynced with polaris.
+	DroppedListenerChanges() uint64
+
+	// DroppedCallResults returns how many call results could not be reported.
+	DroppedCallResults() uint64
+
+	// LastRouteTrace returns the route trace of the last Resolve of desc, see WithRouteDebug.
+	LastRouteTrace(desc string) (RouteTrace, bool)
+
+	// StaticFallbacks returns how many times Resolve returned a static fallback list, see WithStaticFallback.
+	StaticFallbacks() uint64
+
+	// ZeroWeightResults returns how many times Resolve found only instances with a zero weight,
+	// see WithZeroWeightPolicy.
+	ZeroWeightResults() uint64
+
+	// ChangeStats counts the Changes of the watched services and their instances, see WithChangeLogCap.
+	ChangeStats() ChangeStats
+
+	// EvictedServices returns how many services had their state evicted, see WithMaxTrackedServices.
+	EvictedServices() uint64
+
+	// LastRevision returns the revision of the instances of the watched service last applied from polaris,
+	// ok is false when the service is not watched.
+	LastRevision(desc string) (revision string, ok bool)
+
+	// SkippedEvents returns how many polaris events were dropped for changing no instance revision.
+	SkippedEvents() uint64
+
+	// WatchDeliveryLag returns the WatchDeliveryLagMetric histogram, the lags from the polaris events to the
+	// handover of their Changes by Watcher or to the Subscribe listeners. The time of an event is the modify
+	// time of its instances from the server when it has one, else the time the SDK delivered it.
+	WatchDeliveryLag() LagHistogram
+
+	// ResolveHistory returns the descriptions resolved by this process and the previous ones with the time of
+	// their last Resolve, most recent first, see WithResolveHistory. It is empty without it.
+	ResolveHistory() []ResolveHistoryEntry
+}
+
+var (
+	_ AllInstancesResolver = (*polarisResolver)(nil)
+	_ ResolverDiagnostics  = (*polarisResolver)(nil)
+	_ io.Closer            = (*polarisResolver)(nil)
+)
+
 // polarisResolver is a resolver using polaris.
 type polarisResolver struct {
-	provider api.ProviderAPI
-	consumer api.ConsumerAPI
+	droppedChanges    uint64 // accessed atomically, keep it first for 64-bit alignment
+	loggedInstances   uint64 // accessed atomically
+	staticFallbacks   uint64 // accessed atomically
+	zeroWeightResults uint64 // accessed atomically
+	changeCount       uint64 // accessed atomically, the counters of ChangeStats
+	changeAdded       uint64 // accessed atomically
+	changeUpdated     uint64 // accessed atomically
+	changeRemoved     uint64 // accessed atomically
+	summarizedChanges uint64 // accessed atomically
+	evictedServices   uint64 // accessed atomically
+	provider          api.ProviderAPI
+	consumer          api.ConsumerAPI
+	releaseSDK        func()       // nil for the APIs given by WithConsumerAPI and WithProviderAPI
+	apiLock           sync.RWMutex // guards provider, consumer, releaseSDK, routerChain and endpoints
+	updateLock        sync.Mutex   // serializes UpdateEndpoints
+	closeOnce         sync.Once
+	filters           []instanceFilter
+	caches            sync.Map // desc -> *instanceCache
+	watcher           *watchManager
+	listenerLock      sync.Mutex
+	hubs              map[string]*listenerHub
+	serviceMetadata   *serviceMetadataCache
+	routerChain       []string
+	routeTraces       sync.Map // desc -> RouteTrace
+	targets           sync.Map // targetKey -> desc, see Target
+	cachedTargets     int32    // accessed atomically, the size of targets
+	watchDelivered    watchDeliveries
+	tracked           serviceTracker
+	reporter          *callResultReporter
+	snapshots         *snapshotStore  // nil without WithFallbackSnapshots
+	history           *resolveHistory // nil without WithResolveHistory
+	inflight          inflightCalls
+	watchLag          lagHistogram
+	errorLogs         errorLogLimiter
+	tlsWarned         sync.Map // model.ServiceKey -> struct{}, see warnNoTLSInstance
+	endpoints         []string
+	opts              *options
 }
 
 // NewPolarisResolver creates a polaris based resolver.
-func NewPolarisResolver(endpoints []string) (Resolver, error) {
-	sdkCtx, err := GetPolarisConfig(endpoints)
+func NewPolarisResolver(endpoints []string, opts ...Option) (Resolver, error) {
+	o := newOptions(opts)
+	if err := o.validate("resolver"); err != nil {
+		return nil, err
+	}
+	apis, err := newSDKAPIs(endpoints, o)
 	if err != nil {
 		return nil, perrors.WithMessage(err, "create polaris namingClient failed.")
 	}
+	if apis.consumer == nil {
+		return nil, perrors.New("WithProviderAPI without SDK context needs WithConsumerAPI too")
+	}
 
+	serviceMetadata := newServiceMetadataCache(o)
 	newInstance := &polarisResolver{
-		consumer: api.NewConsumerAPIByContext(sdkCtx),
-		provider: api.NewProviderAPIByContext(sdkCtx),
+		consumer:        apis.consumer,
+		provider:        apis.provider,
+		releaseSDK:      apis.release,
+		watcher:         newWatchManager(apis.consumer, o, serviceMetadata.invalidate),
+		serviceMetadata: serviceMetadata,
+		routerChain:     apis.routerChain,
+		reporter:        newCallResultReporter(apis.consumer, o),
+		snapshots:       newSnapshotStore(o),
+		history:         newResolveHistory(o),
+		endpoints:       append([]string(nil), endpoints...),
+		opts:            o,
 	}
+	log.GetBaseLogger().Infof("[Polaris resolver] effective options %s", newInstance.EffectiveOptions())
+	newInstance.startResolveHistory()
 
 	return newInstance, nil
 }
 
 // Target implements the Resolver interface.
+// The service name may be fully qualified as "namespace/service", an explicit namespace tag takes precedence.
+// The other tag keys set by WithTargetTagKeys are kept in order, the description is built by the
+// DescriptionCodec, "namespace:service?env=prod&idc=sh" by default. Target runs on every call, so the
+// descriptions are cached by the service name and the tag values they are built from.
 func (polaris *polarisResolver) Target(ctx context.Context, target rpcinfo.EndpointInfo) (description string) {
-	// serviceName identification is generated by namespace and serviceName to identify serviceName
-	var serviceIdentification strings.Builder
+	if target == nil {
+		return invalidTarget("nil target")
+	}
+	key := polaris.targetKey(ctx, target)
+	if desc, ok := polaris.cachedTarget(key); ok {
+		return desc
+	}
+	desc := polaris.describe(ctx, target)
+	if !strings.HasPrefix(desc, invalidTargetPrefix) {
+		polaris.cacheTarget(key, desc)
+	}
+	return desc
+}
 
-	namespace, ok := target.Tag("namespace")
-	if ok {
-		serviceIdentification.WriteString(namespace)
-	} else {
-		serviceIdentification.WriteString(polarisDefaultNamespace)
+// describe builds the description of target, Target caches it.
+func (polaris *polarisResolver) describe(ctx context.Context, target rpcinfo.EndpointInfo) string {
+	if strings.TrimSpace(target.ServiceName()) == "" {
+		return invalidTarget("empty service name")
+	}
+	namespace, serviceName, qualified := splitQualifiedServiceName(target.ServiceName())
+	if tagNamespace, ok := target.Tag(namespaceTagKey); ok {
+		namespace = tagNamespace
+	} else if !qualified {
+		namespace = polaris.opts.defaultNamespace()
+		if defaultNamespace, ok := polaris.opts.targetTagDefaults[namespaceTagKey]; ok {
+			namespace = defaultNamespace
+		}
+	}
+	if strings.TrimSpace(serviceName) == "" {
+		return invalidTarget("empty service name in " + target.ServiceName())
+	}
+	if namespace == "" || strings.ContainsAny(namespace, descriptionSeparator+qualifiedNameSeparator+tagsSeparator) {
+		return invalidTarget("invalid namespace " + strconv.Quote(namespace) + " of " + serviceName)
 	}
-	serviceIdentification.WriteString(":")
-	serviceIdentification.WriteString(target.ServiceName())
+	return polaris.opts.descriptionCodec().Encode(TargetInfo{
+		Namespace: namespace,
+		Service:   serviceName,
+		Tags: append(append(append(polaris.targetTags(target), routeLabelTags(ctx)...), polaris.canaryTags(ctx)...),
+			shardTags(ctx)...),
+	})
+}
 
-	return serviceIdentification.String()
+// targetTags returns the configured tags of target other than namespace, missing tags use their defaults.
+func (polaris *polarisResolver) targetTags(target rpcinfo.EndpointInfo) []TargetTag {
+	var tags []TargetTag
+	for _, key := range polaris.opts.targetTagKeys {
+		if key == namespaceTagKey {
+			continue
+		}
+		value, ok := target.Tag(key)
+		if !ok {
+			value, ok = polaris.opts.targetTagDefaults[key]
+		}
+		if ok {
+			tags = append(tags, TargetTag{Key: key, Value: value})
+		}
+	}
+	return tags
 }
 
 // Watcher return registered service changes.
 func (polaris *polarisResolver) Watcher(ctx context.Context, desc string) (discovery.Change, error) {
-	var (
-		eps    []discovery.Instance
-		add    []discovery.Instance
-		update []discovery.Instance
-		remove []discovery.Instance
-	)
-	namespace, serviceName := SplitDescription(desc)
+	var eps []discovery.Instance
+	start := time.Now()
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return discovery.Change{}, err
+	}
 	key := model.ServiceKey{
-		Namespace: namespace,
-		Service:   serviceName,
+		Namespace: info.Namespace,
+		Service:   info.Service,
 	}
-	watchReq := api.WatchServiceRequest{}
-	watchReq.Key = key
-	watchRsp, err := polaris.consumer.WatchService(&watchReq)
+	polaris.track(key, desc)
+	sw, waiter, snapshot, err := polaris.watcher.subscribe(key, 1)
 	if nil != err {
-		log.GetBaseLogger().Fatalf("fail to WatchService, err is %v", err)
+		if ok, suppressed := polaris.errorLogs.allow(errorLogWatch, key, polaris.opts.errorLogInterval); ok {
+			if logger := polaris.opts.structuredLogger; logger != nil {
+				logger.ErrorContext(ctx, "polaris watch failed", polaris.logFields(ctx, "namespace", key.Namespace,
+					"service", key.Service, "description", desc, "error", err.Error(), "suppressed", suppressed)...)
+			} else {
+				log.GetBaseLogger().Errorf("fail to WatchService, err is %v%s", err, suppressedSuffix(suppressed))
+			}
+		}
+		return discovery.Change{}, err
 	}
-	instances := watchRsp.GetAllInstancesResp.Instances
+	defer sw.removeWaiter(waiter)
+	instances := snapshot.Instances
+	cache := polaris.instanceCache(desc)
 
 	if nil != instances {
-		for _, instance := range instances {
-			log.GetBaseLogger().Infof("instance getOneInstance is %s:%d", instance.GetHost(), instance.GetPort())
-			eps = append(eps, ChangePolarisInstanceToKitex(instance))
-		}
+		polaris.logInstances(ctx, instancesLog{op: "watch", desc: desc, key: key, revision: snapshot.GetRevision(),
+			source: logSourceCache, elapsed: time.Since(start)}, instances)
+		eps = cache.convertAll(instances)
 	}
 
 	result := discovery.Result{
@@ -112,31 +308,28 @@ func (polaris *polarisResolver) Watcher(ctx context.Context, desc string) (disco
 		CacheKey:  desc,
 		Instances: eps,
 	}
+	if len(eps) > 0 {
+		if polaris.watchDelivered.deliver(ctx, desc) {
+			return filterChange(ctx, polaris.changeFilters(info.Tags), discovery.Change{Result: result}), nil
+		}
+	}
+	if len(eps) == 0 && polaris.opts.initialSyncTimeout > 0 {
+		change := polaris.waitInitialSync(ctx, key, cache, waiter, result)
+		return filterChange(ctx, polaris.changeFilters(info.Tags), change), nil
+	}
 	Change := discovery.Change{}
 
 	select {
 	case <-ctx.Done():
 		log.GetBaseLogger().Infof("[Polaris resolver] Watch has been finished")
 		return Change, nil
-	case event := <-watchRsp.EventChannel:
-		eType := event.GetSubScribeEventType()
-		if eType == api.EventInstance {
-			insEvent := event.(*model.InstanceEvent)
-			if insEvent.AddEvent != nil {
-				for _, instance := range insEvent.AddEvent.Instances {
-					add = append(add, ChangePolarisInstanceToKitex(instance))
-				}
-			}
+	case event := <-waiter:
+		if insEvent, origin, ok := instanceEvent(event); ok {
+			defer polaris.observeWatchLag(desc, origin)
 			if insEvent.UpdateEvent != nil {
-				for i := range insEvent.UpdateEvent.UpdateList {
-					update = append(update, ChangePolarisInstanceToKitex(insEvent.UpdateEvent.UpdateList[i].After))
-				}
-			}
-			if insEvent.DeleteEvent != nil {
-				for _, instance := range insEvent.DeleteEvent.Instances {
-					remove = append(remove, ChangePolarisInstanceToKitex(instance))
-				}
+				result.Instances = cache.convertAll(applyUpdates(instances, insEvent))
 			}
+			add, update, remove := convertInstanceEvent(cache, insEvent)
 			Change = discovery.Change{
 				Result:  result,
 				Added:   add,
@@ -144,39 +337,407 @@ func (polaris *polarisResolver) Watcher(ctx context.Context, desc string) (disco
 				Removed: remove,
 			}
 		}
+		Change = filterChange(ctx, polaris.changeFilters(info.Tags), Change)
+		polaris.observeChange(ctx, desc, Change)
 		return Change, nil
 	}
 }
 
+// waitInitialSync waits for the first instances of a service that was empty when the watch started,
+// either from an add event or from polling, and returns the empty result once the timeout expires.
+func (polaris *polarisResolver) waitInitialSync(ctx context.Context, key model.ServiceKey, cache *instanceCache,
+	events <-chan model.SubScribeEvent, result discovery.Result) discovery.Change {
+	timer := time.NewTimer(polaris.opts.initialSyncTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(initialSyncPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.GetBaseLogger().Infof("[Polaris resolver] Watch has been finished")
+			return discovery.Change{}
+		case <-timer.C:
+			log.GetBaseLogger().Warnf("[Polaris resolver] no instance of %s synced after %v",
+				key, polaris.opts.initialSyncTimeout)
+			return discovery.Change{Result: result}
+		case event := <-events:
+			insEvent, origin, ok := instanceEvent(event)
+			if !ok {
+				continue
+			}
+			defer polaris.observeWatchLag(result.CacheKey, origin)
+			add, update, remove := convertInstanceEvent(cache, insEvent)
+			result.Instances = append(result.Instances, add...)
+			return discovery.Change{Result: result, Added: add, Updated: update, Removed: remove}
+		case <-ticker.C:
+			req := &api.GetAllInstancesRequest{}
+			req.Namespace = key.Namespace
+			req.Service = key.Service
+			resp, err := polaris.consumerAPI().GetAllInstances(req)
+			if err != nil || len(resp.GetInstances()) == 0 {
+				continue
+			}
+			result.Instances = cache.convertAll(resp.GetInstances())
+			return discovery.Change{Result: result, Added: result.Instances}
+		}
+	}
+}
+
+// convertInstanceEvent converts the instances carried by a polaris instance event.
+func convertInstanceEvent(cache *instanceCache, insEvent *model.InstanceEvent) (add, update, remove []discovery.Instance) {
+	if insEvent.AddEvent != nil {
+		for _, instance := range insEvent.AddEvent.Instances {
+			add = append(add, cache.convert(instance))
+		}
+	}
+	if insEvent.UpdateEvent != nil {
+		for i := range insEvent.UpdateEvent.UpdateList {
+			update = append(update, cache.convert(insEvent.UpdateEvent.UpdateList[i].After))
+		}
+	}
+	if insEvent.DeleteEvent != nil {
+		remove = cache.remove(insEvent.DeleteEvent.Instances)
+	}
+	return add, update, remove
+}
+
+// healthyInstances returns the healthy and not isolated instances.
+func healthyInstances(instances []model.Instance) []model.Instance {
+	healthy := make([]model.Instance, 0, len(instances))
+	for _, instance := range instances {
+		if instance.IsHealthy() && !instance.IsIsolated() {
+			healthy = append(healthy, instance)
+		}
+	}
+	return healthy
+}
+
+// applyUpdates returns a copy of instances where the instances updated by insEvent are replaced,
+// matched by instance ID, so that the Result of a Change carries the new weights and metadata.
+func applyUpdates(instances []model.Instance, insEvent *model.InstanceEvent) []model.Instance {
+	updated := append([]model.Instance(nil), instances...)
+	if insEvent.UpdateEvent == nil {
+		return updated
+	}
+	for _, update := range insEvent.UpdateEvent.UpdateList {
+		for i, instance := range updated {
+			if instance.GetId() == update.After.GetId() {
+				updated[i] = update.After
+			}
+		}
+	}
+	return updated
+}
+
 // Resolve implements the Resolver interface.
-func (polaris *polarisResolver) Resolve(ctx context.Context, desc string) (discovery.Result, error) {
+func (polaris *polarisResolver) Resolve(ctx context.Context, desc string) (result discovery.Result, err error) {
+	if before := polaris.opts.beforeResolve; before != nil {
+		runHook("before resolve", func() { before(ctx, desc) })
+	}
+	if after := polaris.opts.afterResolve; after != nil {
+		defer func() {
+			runHook("after resolve", func() { after(ctx, desc, result, err) })
+		}()
+	}
+	result, err = polaris.resolve(ctx, desc)
+	if err == nil {
+		polaris.history.touch(desc)
+	}
+	return result, err
+}
+
+func (polaris *polarisResolver) resolve(ctx context.Context, desc string) (discovery.Result, error) {
 	var eps []discovery.Instance
-	namespace, serviceName := SplitDescription(desc)
+	start := time.Now()
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return discovery.Result{}, err
+	}
+	namespace, serviceName := info.Namespace, info.Service
+	polaris.track(model.ServiceKey{Namespace: namespace, Service: serviceName}, desc)
+	timeout := polaris.resolveTimeout(ctx)
+	fresh := freshResolve(ctx)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	descTags, labels := splitRouteLabels(info.Tags)
+	descTags, canary := splitCanary(descTags)
 	getInstances := &api.GetInstancesRequest{}
 	getInstances.Namespace = namespace
 	getInstances.Service = serviceName
-	InstanceResp, err := polaris.consumer.GetInstances(getInstances)
+	getInstances.Canary = canary
+	getInstances.SkipRouteFilter = polaris.opts.systemServiceMode
+	if timeout > 0 {
+		getInstances.SetTimeout(timeout)
+	}
+	if retries, ok := resolveRetries(ctx); ok {
+		getInstances.SetRetryCount(retries)
+	}
+	if len(labels) > 0 {
+		getInstances.SourceService = &model.ServiceInfo{Metadata: labels}
+	}
+	InstanceResp, err := polaris.getInstances(ctx, desc, getInstances)
+	if _, ok := err.(*ResolveContextError); ok {
+		return discovery.Result{}, err
+	}
 	if nil != err {
-		log.GetBaseLogger().Fatalf("fail to getOneInstance, err is %v", err)
+		service := model.ServiceKey{Namespace: namespace, Service: serviceName}
+		if ok, suppressed := polaris.errorLogs.allow(errorLogResolve, service, polaris.opts.errorLogInterval); ok {
+			if logger := polaris.opts.structuredLogger; logger != nil {
+				logger.ErrorContext(ctx, "polaris resolve failed", polaris.logFields(ctx, "namespace", namespace,
+					"service", serviceName, "description", desc, "error", err.Error(), "suppressed", suppressed)...)
+			} else {
+				log.GetBaseLogger().Errorf("fail to getOneInstance, err is %v%s", err, suppressedSuffix(suppressed))
+			}
+		}
+		err = perrors.WithMessagef(notFoundError(err, namespace, serviceName), "get instances of %s", desc)
+		if fresh {
+			return discovery.Result{}, err
+		}
+		if fallback, ok := polaris.snapshotFallback(ctx, desc, descTags, err); ok {
+			return fallback, nil
+		}
+		if fallback, ok := polaris.staticFallback(desc, info, err); ok {
+			return fallback, nil
+		}
+		return discovery.Result{}, err
+	}
+	instances := polaris.watcher.withDraining(model.ServiceKey{Namespace: namespace, Service: serviceName},
+		InstanceResp.GetInstances())
+	total := len(instances)
+	var zero map[discovery.Instance]struct{}
+	if polaris.opts.healthyOnly {
+		instances = healthyInstances(instances)
 	}
-	instances := InstanceResp.GetInstances()
 	if nil != instances {
-		for _, instance := range instances {
-			log.GetBaseLogger().Infof("instance getOneInstance is %s:%d", instance.GetHost(), instance.GetPort())
-			eps = append(eps, ChangePolarisInstanceToKitex(instance))
+		polaris.logInstances(ctx, instancesLog{op: "resolve", desc: desc,
+			key: model.ServiceKey{Namespace: namespace, Service: serviceName}, revision: InstanceResp.GetRevision(),
+			source: logSourceRemote, elapsed: time.Since(start)}, instances)
+		if fresh {
+			eps = newInstanceCache(polaris.opts.instanceConversion()).convertAll(instances)
+		} else {
+			eps = polaris.instanceCache(desc).convertAll(instances)
+			polaris.saveSnapshot(desc, InstanceResp.GetRevision(), instances)
 		}
+		zero = zeroWeights(instances, eps)
 	}
 
+	var trace *RouteTrace
+	if polaris.opts.routeDebug {
+		trace = polaris.newRouteTrace(desc, namespace, serviceName, total)
+	}
+	tags, locality := splitLocalityTags(descTags, polaris.opts.localityLevels)
+	steps := polaris.descriptionFilters(tags)
+	if polaris.opts.adaptiveScoring {
+		steps = append(steps[:len(steps):len(steps)], polaris.newScoringFilter(instances, eps))
+	}
+	eps, filters := applyFilters(ctx, steps, eps, trace)
+	if len(eps) == 0 && len(filters) > 0 && filters[len(filters)-1] == tlsFilterName {
+		polaris.warnNoTLSInstance(model.ServiceKey{Namespace: namespace, Service: serviceName}, total)
+	}
+	if len(polaris.opts.localityLevels) > 0 {
+		var steps []string
+		eps, steps = polaris.localityFallback(ctx, locality, eps, trace)
+		filters = append(filters, steps...)
+	}
+	if trace != nil {
+		polaris.recordRouteTrace(trace)
+	}
 	if len(eps) == 0 {
-		return discovery.Result{}, fmt.Errorf("no instance remains for %s", desc)
+		err := &NoInstanceError{
+			Namespace:        namespace,
+			Service:          serviceName,
+			TotalFromPolaris: total,
+			AfterFilter:      len(eps),
+			Filters:          filters,
+		}
+		if !fresh {
+			if fallback, ok := polaris.staticFallback(desc, info, err); ok {
+				return fallback, nil
+			}
+		}
+		service := model.ServiceKey{Namespace: namespace, Service: serviceName}
+		if ok, suppressed := polaris.errorLogs.allow(errorLogNoInstance, service, polaris.opts.errorLogInterval); ok {
+			if logger := polaris.opts.structuredLogger; logger != nil {
+				logger.WarnContext(ctx, "polaris resolve found no instance", polaris.logFields(ctx, "namespace", namespace,
+					"service", serviceName, "description", desc, "instance_count", 0, "total_from_polaris", total,
+					"filters", filters, "suppressed", suppressed)...)
+			} else {
+				log.GetBaseLogger().Warnf("[Polaris resolver] %v%s", err, suppressedSuffix(suppressed))
+			}
+		}
+		return discovery.Result{}, err
+	}
+	eps, err = polaris.applyZeroWeightPolicy(ctx, desc, model.ServiceKey{Namespace: namespace, Service: serviceName},
+		eps, zero)
+	if err != nil {
+		return discovery.Result{}, err
 	}
 	return discovery.Result{
-		Cacheable: true,
+		Cacheable: !fresh,
+		CacheKey:  desc,
+		Instances: adjustWeights(eps, polaris.opts),
+	}, nil
+}
+
+// getInstances calls the SDK, which has no context support, in a goroutine raced with ctx.
+// When ctx is done first the call is left to finish on its own, its result is discarded and
+// a ResolveContextError is returned. The concurrent calls of the same desc share one SDK call, unless
+// made with CtxWithFreshResolve.
+func (polaris *polarisResolver) getInstances(ctx context.Context, desc string,
+	req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &ResolveContextError{Namespace: req.Namespace, Service: req.Service, Err: err}
+	}
+	fn := func() (*model.InstancesResponse, error) {
+		return polaris.consumerAPI().GetInstances(req)
+	}
+	var call *inflightCall
+	if freshResolve(ctx) {
+		call = startAlone(fn)
+	} else {
+		call = polaris.inflight.start(desc, fn)
+	}
+	select {
+	case <-call.done:
+		return call.resp, call.err
+	case <-ctx.Done():
+		return nil, &ResolveContextError{Namespace: req.Namespace, Service: req.Service, Err: ctx.Err()}
+	}
+}
+
+// ResolveAll implements the AllInstancesResolver interface.
+// No client side filter is applied and the result is not cacheable, it is meant for admin tooling.
+func (polaris *polarisResolver) ResolveAll(ctx context.Context, desc string) (discovery.Result, error) {
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return discovery.Result{}, err
+	}
+	namespace, serviceName := info.Namespace, info.Service
+	getAllInstances := &api.GetAllInstancesRequest{}
+	getAllInstances.Namespace = namespace
+	getAllInstances.Service = serviceName
+	resp, err := polaris.consumerAPI().GetAllInstances(getAllInstances)
+	if err != nil {
+		return discovery.Result{}, perrors.WithMessagef(err, "get all instances of %s", desc)
+	}
+	conv := polaris.opts.instanceConversion()
+	conv.status = true
+	eps := conv.convertRaw(resp.GetInstances())
+	if len(eps) == 0 {
+		return discovery.Result{}, &NoInstanceError{Namespace: namespace, Service: serviceName}
+	}
+	return discovery.Result{
+		Cacheable: false,
 		CacheKey:  desc,
 		Instances: eps,
 	}, nil
 }
 
+// descriptionFilters returns the protocol, TLS, set and age filters and the metadata filters of the target tags
+// followed by the resolver filters.
+func (polaris *polarisResolver) descriptionFilters(tags []TargetTag) []instanceFilter {
+	proto, set, age := polaris.opts.protocolFilter, polaris.opts.setName, polaris.opts.minInstanceAge
+	if len(tags) == 0 && proto == "" && !polaris.opts.requireTLS && set == "" && age <= 0 {
+		return polaris.filters
+	}
+	filters := make([]instanceFilter, 0, len(tags)+len(polaris.filters)+4)
+	if proto != "" {
+		filters = append(filters, newProtocolFilter(proto))
+	}
+	if polaris.opts.requireTLS {
+		filters = append(filters, newTLSFilter())
+	}
+	if set != "" {
+		filters = append(filters, newSetFilter(set, polaris.opts.setStrict))
+	}
+	if age > 0 {
+		filters = append(filters, newMinAgeFilter(age, time.Now))
+	}
+	for _, tag := range tags {
+		if tag.Key == shardTagKey {
+			filters = append(filters, newShardFilter(polaris.opts.shardMetadataKey(), tag.Value, polaris.opts.shardStrict))
+			continue
+		}
+		filters = append(filters, newTagFilter(tag))
+	}
+	return append(filters, polaris.filters...)
+}
+
+// changeFilters returns the filters resolve runs for the description tags, the Changes of Watcher and of
+// the listeners go through them too. The route labels, the canary and the locality are left to resolve.
+func (polaris *polarisResolver) changeFilters(tags []TargetTag) []instanceFilter {
+	tags, _ = splitRouteLabels(tags)
+	tags, _ = splitCanary(tags)
+	tags, _ = splitLocalityTags(tags, polaris.opts.localityLevels)
+	return polaris.descriptionFilters(tags)
+}
+
+// instanceCache returns the conversion cache of a description.
+func (polaris *polarisResolver) instanceCache(desc string) *instanceCache {
+	if cache, ok := polaris.caches.Load(desc); ok {
+		return cache.(*instanceCache)
+	}
+	cache, _ := polaris.caches.LoadOrStore(desc, newInstanceCache(polaris.opts.instanceConversion()))
+	return cache.(*instanceCache)
+}
+
+// ReportCallResult implements the CallResultResolver interface.
+func (polaris *polarisResolver) ReportCallResult(result CallResult) {
+	polaris.reporter.report(result)
+}
+
+// DroppedCallResults implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) DroppedCallResults() uint64 {
+	return polaris.reporter.droppedResults()
+}
+
+// ClassifyCall implements the CallResultResolver interface.
+func (polaris *polarisResolver) ClassifyCall(err error, ri rpcinfo.RPCInfo) CallStatus {
+	if polaris.opts.callClassifier != nil {
+		return polaris.opts.callClassifier(err, ri)
+	}
+	return DefaultCallClassifier(err, ri)
+}
+
+// ClassifyCallResult implements the CallResultResolver interface.
+func (polaris *polarisResolver) ClassifyCallResult(err error, ri rpcinfo.RPCInfo) model.RetStatus {
+	return polaris.ClassifyCall(err, ri).RawRetStatus()
+}
+
+// Close implements the io.Closer interface.
+// The SDK context is released unless the APIs were given by WithConsumerAPI or WithProviderAPI,
+// it is destroyed once no other resolver or registry shares it.
+func (polaris *polarisResolver) Close() error {
+	polaris.reporter.close()
+	polaris.history.close()
+	polaris.closeListeners()
+	polaris.watcher.close()
+	polaris.apiLock.RLock()
+	release := polaris.releaseSDK
+	polaris.apiLock.RUnlock()
+	if release != nil {
+		polaris.closeOnce.Do(release)
+	}
+	return nil
+}
+
+// LastRevision implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) LastRevision(desc string) (string, bool) {
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return "", false
+	}
+	return polaris.watcher.lastRevision(model.ServiceKey{Namespace: info.Namespace, Service: info.Service})
+}
+
+// SkippedEvents implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) SkippedEvents() uint64 {
+	return polaris.watcher.skippedEvents()
+}
+
 // Diff implements the Resolver interface.
 func (polaris *polarisResolver) Diff(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
 	return discovery.DefaultDiff(cacheKey, prev, next)
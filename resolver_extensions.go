@@ -0,0 +1,233 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"io"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// extendedResolver is a Resolver with the extension interfaces of this package, the lazy and multi-cluster
+// resolvers call the resolvers they wrap through it.
+type extendedResolver interface {
+	Resolver
+	AllInstancesResolver
+	ListenerResolver
+	CallResultResolver
+	RefreshResolver
+	EndpointsUpdater
+	OptionsReporter
+	ResolverDiagnostics
+	io.Closer
+}
+
+var (
+	_ extendedResolver = (*polarisResolver)(nil)
+	_ extendedResolver = (*lazyResolver)(nil)
+	_ extendedResolver = (*multiClusterResolver)(nil)
+)
+
+// extend returns r with the extension interfaces, those r does not implement return
+// ErrExtensionUnsupported or zero values.
+func extend(r Resolver) extendedResolver {
+	if extended, ok := r.(extendedResolver); ok {
+		return extended
+	}
+	return basicResolver{r}
+}
+
+// basicResolver implements the extension interfaces r lacks.
+type basicResolver struct {
+	Resolver
+}
+
+// ResolveAll implements the AllInstancesResolver interface.
+func (b basicResolver) ResolveAll(ctx context.Context, desc string) (discovery.Result, error) {
+	if r, ok := b.Resolver.(AllInstancesResolver); ok {
+		return r.ResolveAll(ctx, desc)
+	}
+	return discovery.Result{}, ErrExtensionUnsupported
+}
+
+// IterateInstances implements the AllInstancesResolver interface.
+func (b basicResolver) IterateInstances(ctx context.Context, desc string, fn func(InstanceInfo) bool) error {
+	if r, ok := b.Resolver.(AllInstancesResolver); ok {
+		return r.IterateInstances(ctx, desc, fn)
+	}
+	return ErrExtensionUnsupported
+}
+
+// Subscribe implements the ListenerResolver interface.
+func (b basicResolver) Subscribe(desc string, listener func(discovery.Change)) (func(), error) {
+	if r, ok := b.Resolver.(ListenerResolver); ok {
+		return r.Subscribe(desc, listener)
+	}
+	return nil, ErrExtensionUnsupported
+}
+
+// SubscribeDeltas implements the ListenerResolver interface.
+func (b basicResolver) SubscribeDeltas(desc string,
+	listener func(added, updated, removed []discovery.Instance)) (func(), error) {
+	if r, ok := b.Resolver.(ListenerResolver); ok {
+		return r.SubscribeDeltas(desc, listener)
+	}
+	return nil, ErrExtensionUnsupported
+}
+
+// ReportCallResult implements the CallResultResolver interface, the result is dropped when r does not report.
+func (b basicResolver) ReportCallResult(result CallResult) {
+	if r, ok := b.Resolver.(CallResultResolver); ok {
+		r.ReportCallResult(result)
+	}
+}
+
+// ClassifyCall implements the CallResultResolver interface.
+func (b basicResolver) ClassifyCall(err error, ri rpcinfo.RPCInfo) CallStatus {
+	if r, ok := b.Resolver.(CallResultResolver); ok {
+		return r.ClassifyCall(err, ri)
+	}
+	return DefaultCallClassifier(err, ri)
+}
+
+// ClassifyCallResult implements the CallResultResolver interface.
+func (b basicResolver) ClassifyCallResult(err error, ri rpcinfo.RPCInfo) model.RetStatus {
+	return b.ClassifyCall(err, ri).RawRetStatus()
+}
+
+// Refresh implements the RefreshResolver interface.
+func (b basicResolver) Refresh(ctx context.Context, desc string) (discovery.Change, error) {
+	if r, ok := b.Resolver.(RefreshResolver); ok {
+		return r.Refresh(ctx, desc)
+	}
+	return discovery.Change{}, ErrExtensionUnsupported
+}
+
+// UpdateEndpoints implements the EndpointsUpdater interface.
+func (b basicResolver) UpdateEndpoints(ctx context.Context, endpoints []string) error {
+	if r, ok := b.Resolver.(EndpointsUpdater); ok {
+		return r.UpdateEndpoints(ctx, endpoints)
+	}
+	return ErrExtensionUnsupported
+}
+
+// EffectiveOptions implements the OptionsReporter interface.
+func (b basicResolver) EffectiveOptions() OptionsSnapshot {
+	if r, ok := b.Resolver.(OptionsReporter); ok {
+		return r.EffectiveOptions()
+	}
+	return OptionsSnapshot{}
+}
+
+// Close implements the io.Closer interface.
+func (b basicResolver) Close() error {
+	if r, ok := b.Resolver.(io.Closer); ok {
+		return r.Close()
+	}
+	return nil
+}
+
+// DroppedListenerChanges implements the ResolverDiagnostics interface.
+func (b basicResolver) DroppedListenerChanges() uint64 {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.DroppedListenerChanges()
+	}
+	return 0
+}
+
+// DroppedCallResults implements the ResolverDiagnostics interface.
+func (b basicResolver) DroppedCallResults() uint64 {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.DroppedCallResults()
+	}
+	return 0
+}
+
+// LastRouteTrace implements the ResolverDiagnostics interface.
+func (b basicResolver) LastRouteTrace(desc string) (RouteTrace, bool) {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.LastRouteTrace(desc)
+	}
+	return RouteTrace{}, false
+}
+
+// StaticFallbacks implements the ResolverDiagnostics interface.
+func (b basicResolver) StaticFallbacks() uint64 {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.StaticFallbacks()
+	}
+	return 0
+}
+
+// ZeroWeightResults implements the ResolverDiagnostics interface.
+func (b basicResolver) ZeroWeightResults() uint64 {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.ZeroWeightResults()
+	}
+	return 0
+}
+
+// ChangeStats implements the ResolverDiagnostics interface.
+func (b basicResolver) ChangeStats() ChangeStats {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.ChangeStats()
+	}
+	return ChangeStats{}
+}
+
+// EvictedServices implements the ResolverDiagnostics interface.
+func (b basicResolver) EvictedServices() uint64 {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.EvictedServices()
+	}
+	return 0
+}
+
+// LastRevision implements the ResolverDiagnostics interface.
+func (b basicResolver) LastRevision(desc string) (string, bool) {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.LastRevision(desc)
+	}
+	return "", false
+}
+
+// SkippedEvents implements the ResolverDiagnostics interface.
+func (b basicResolver) SkippedEvents() uint64 {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.SkippedEvents()
+	}
+	return 0
+}
+
+// WatchDeliveryLag implements the ResolverDiagnostics interface.
+func (b basicResolver) WatchDeliveryLag() LagHistogram {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.WatchDeliveryLag()
+	}
+	return LagHistogram{}
+}
+
+// ResolveHistory implements the ResolverDiagnostics interface.
+func (b basicResolver) ResolveHistory() []ResolveHistoryEntry {
+	if r, ok := b.Resolver.(ResolverDiagnostics); ok {
+		return r.ResolveHistory()
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ package polaris
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -25,6 +26,9 @@ import (
 	"github.com/cloudwego/kitex/pkg/registry"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,7 +37,7 @@ const (
 )
 
 func TestPolarisResolver(t *testing.T) {
-	rg, err := NewPolarisRegistry([]string{"127.0.0.1:8091"})
+	rg, err := NewPolarisRegistry([]string{"127.0.0.1:8091"}, WithAutoMetadata(false))
 	require.Nil(t, err)
 	rs, err := NewPolarisResolver([]string{"127.0.0.1:8091"})
 	require.Nil(t, err)
@@ -51,12 +55,21 @@ func TestPolarisResolver(t *testing.T) {
 	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil)) // the namespace is default
 	result, err := rs.Resolve(context.TODO(), desc)
 	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	hashKey, _ := result.Instances[0].Tag(TagHashKey) // the instance ID, revision and times are assigned by polaris
+	revision, _ := result.Instances[0].Tag(TagRevision)
+	created, _ := result.Instances[0].Tag(TagCreateTime)
+	modified, _ := result.Instances[0].Tag(TagModifyTime)
 	expected := discovery.Result{
 		Cacheable: true,
 		CacheKey:  polarisDefaultNamespace + ":" + serviceName,
 		Instances: []discovery.Instance{
 			discovery.NewInstance(InstanceOne.Addr.Network(), InstanceOne.Addr.String(), InstanceOne.Weight, map[string]string{
-				"namespace": "default",
+				"namespace":   "default",
+				TagHashKey:    hashKey,
+				TagRevision:   revision,
+				TagCreateTime: created,
+				TagModifyTime: modified,
 			}),
 		},
 	}
@@ -100,3 +113,419 @@ func TestEmptyEndpoints(t *testing.T) {
 	_, err := NewPolarisResolver([]string{})
 	require.NotNil(t, err)
 }
+
+// newTestResolver creates a resolver on backend, the descriptions are checked with WithStrictDescriptions
+// unless opts disable it.
+func newTestResolver(backend *polaristest.Backend, opts ...Option) *polarisResolver {
+	o := newOptions(append([]Option{WithStrictDescriptions(true)}, opts...))
+	serviceMetadata := newServiceMetadataCache(o)
+	return &polarisResolver{
+		consumer:        backend,
+		provider:        backend,
+		watcher:         newWatchManager(backend, o, serviceMetadata.invalidate),
+		serviceMetadata: serviceMetadata,
+		reporter:        newCallResultReporter(backend, o),
+		snapshots:       newSnapshotStore(o),
+		opts:            o,
+	}
+}
+
+func TestResolveNoInstanceFromPolaris(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend)
+
+	_, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance))
+	require.Equal(t, polarisDefaultNamespace, noInstance.Namespace)
+	require.Equal(t, serviceName, noInstance.Service)
+	require.Equal(t, 0, noInstance.TotalFromPolaris)
+	require.Empty(t, noInstance.Filters)
+}
+
+func TestResolveNoInstanceAfterFilter(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777},
+	)
+	dropAll := instanceFilter{
+		name: "drop-all",
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			return nil
+		},
+	}
+	rs := newTestResolver(backend)
+	rs.filters = []instanceFilter{dropAll}
+
+	_, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance))
+	require.Equal(t, 2, noInstance.TotalFromPolaris)
+	require.Equal(t, 0, noInstance.AfterFilter)
+	require.Equal(t, []string{"drop-all"}, noInstance.Filters)
+	require.Contains(t, err.Error(), "drop-all")
+}
+
+func TestTargetQualifiedServiceName(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend())
+	cases := []struct {
+		name        string
+		serviceName string
+		tags        map[string]string
+		expected    string
+	}{
+		{"tag only", "user.api", map[string]string{"namespace": "Production"}, "Production:user.api"},
+		{"qualified only", "Production/user.api", nil, "Production:user.api"},
+		{"tag wins", "Test/user.api", map[string]string{"namespace": "Production"}, "Production:user.api"},
+		{"plain name", "user.api", nil, "default:user.api"},
+		{"malformed empty namespace", "/user.api", nil, "default:/user.api"},
+		{"malformed too many separators", "a/b/user.api", nil, "default:a/b/user.api"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(c.serviceName, "", nil, c.tags))
+			require.Equal(t, c.expected, desc)
+		})
+	}
+}
+
+func TestTargetInvalid(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend())
+	cases := []struct {
+		name   string
+		target rpcinfo.EndpointInfo
+	}{
+		{"nil target", nil},
+		{"empty name", rpcinfo.NewEndpointInfo("", "", nil, nil)},
+		{"whitespace name", rpcinfo.NewEndpointInfo("  \t", "", nil, nil)},
+		{"empty qualified name", rpcinfo.NewEndpointInfo("Production/ ", "", nil, nil)},
+		{"empty namespace tag", rpcinfo.NewEndpointInfo("user.api", "", nil, map[string]string{"namespace": ""})},
+		{"namespace with separator", rpcinfo.NewEndpointInfo("user.api", "", nil, map[string]string{"namespace": "a:b"})},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			desc := rs.Target(context.TODO(), c.target)
+			_, err := rs.Resolve(context.TODO(), desc)
+			require.True(t, errors.Is(err, ErrInvalidTarget), err)
+			_, err = rs.Watcher(context.TODO(), desc)
+			require.True(t, errors.Is(err, ErrInvalidTarget), err)
+		})
+	}
+}
+
+func TestWatcherInitialSync(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend, WithInitialSyncTimeout(time.Second))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		backend.AddInstances(&polaristest.Instance{
+			Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+		})
+	}()
+
+	change, err := rs.Watcher(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Len(t, change.Result.Instances, 1)
+	require.Equal(t, "127.0.0.1:6666", change.Result.Instances[0].Address().String())
+	require.Len(t, change.Added, 1)
+}
+
+// requireInitialWatch requires the first Watcher call of desc to return at once with only the Result.
+func requireInitialWatch(t *testing.T, rs *polarisResolver, desc string, instances int) {
+	change, err := rs.Watcher(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, change.Result.Instances, instances)
+	require.Empty(t, change.Added)
+	require.Empty(t, change.Updated)
+	require.Empty(t, change.Removed)
+}
+
+func TestWatcherUpdateOnlyResult(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{
+		Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+	})
+	rs := newTestResolver(backend)
+	requireInitialWatch(t, rs, polarisDefaultNamespace+":"+serviceName, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ins := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+		ins.Weight = 50
+		ins.Metadata = map[string]string{"env": "prod"}
+		backend.UpdateInstance(ins)
+	}()
+
+	change, err := rs.Watcher(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Len(t, change.Updated, 1)
+	require.Len(t, change.Result.Instances, 1)
+	require.Equal(t, 50, change.Result.Instances[0].Weight())
+	env, _ := change.Result.Instances[0].Tag("env")
+	require.Equal(t, "prod", env)
+}
+
+func TestWatcherStaticService(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{
+		Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+	})
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	begin := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	change, err := rs.Watcher(ctx, desc)
+	require.Nil(t, err)
+	require.Len(t, change.Result.Instances, 1)
+	require.True(t, time.Since(begin) < 50*time.Millisecond)
+
+	// the next call of the watcher waits for an event.
+	change, err = rs.Watcher(ctx, desc)
+	require.Nil(t, err)
+	require.Empty(t, change.Result.Instances)
+	require.True(t, time.Since(begin) >= 50*time.Millisecond)
+
+	changes := make(chan discovery.Change, 1)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	requireInitialChange(t, changes, 1)
+}
+
+func TestWatcherInitialResultPerWatcher(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{
+		Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+	})
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	// two watchers of the same description each get the initial Result.
+	var watchers []context.Context
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		change, err := rs.Watcher(ctx, desc)
+		require.Nil(t, err)
+		require.Len(t, change.Result.Instances, 1, i)
+		watchers = append(watchers, ctx)
+	}
+	for i, ctx := range watchers {
+		change, err := rs.Watcher(ctx, desc)
+		require.Nil(t, err)
+		require.Empty(t, change.Result.Instances, i)
+		require.Error(t, ctx.Err(), i)
+	}
+}
+
+func TestWatcherInitialSyncTimeout(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend, WithInitialSyncTimeout(50*time.Millisecond))
+
+	begin := time.Now()
+	change, err := rs.Watcher(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Empty(t, change.Result.Instances)
+	require.Equal(t, polarisDefaultNamespace+":"+serviceName, change.Result.CacheKey)
+	require.True(t, time.Since(begin) >= 50*time.Millisecond)
+}
+
+func TestResolveAll(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777, Unhealthy: true},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 8888, Isolated: true},
+	)
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+
+	all, err := rs.ResolveAll(context.TODO(), desc)
+	require.Nil(t, err)
+	require.False(t, all.Cacheable)
+	require.Len(t, all.Instances, 3)
+	status := make(map[string][2]string)
+	for _, ins := range all.Instances {
+		healthy, _ := ins.Tag(TagHealthy)
+		isolated, _ := ins.Tag(TagIsolated)
+		status[ins.Address().String()] = [2]string{healthy, isolated}
+	}
+	require.Equal(t, [2]string{"true", "false"}, status["127.0.0.1:6666"])
+	require.Equal(t, [2]string{"false", "false"}, status["127.0.0.1:7777"])
+	require.Equal(t, [2]string{"true", "true"}, status["127.0.0.1:8888"])
+}
+
+func TestResolveHooks(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777},
+	)
+	var calls []string
+	var hooked discovery.Result
+	rs := newTestResolver(backend, WithResolveHooks(
+		func(ctx context.Context, desc string) {
+			calls = append(calls, "before "+desc)
+		},
+		func(ctx context.Context, desc string, result discovery.Result, err error) {
+			calls = append(calls, "after "+desc)
+			hooked = result
+		},
+	))
+	rs.filters = []instanceFilter{{
+		name: "first",
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			calls = append(calls, "filter")
+			return instances[:1]
+		},
+	}}
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"before " + desc, "filter", "after " + desc}, calls)
+	require.Equal(t, result, hooked)
+	require.Len(t, hooked.Instances, 1)
+}
+
+func TestResolveHooksPanic(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	rs := newTestResolver(backend, WithResolveHooks(
+		func(ctx context.Context, desc string) { panic("before") },
+		func(ctx context.Context, desc string, result discovery.Result, err error) { panic("after") },
+	))
+
+	result, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+}
+
+func TestTargetTagKeys(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend(),
+		WithTargetTagKeys("namespace", "env", "idc"),
+		WithTargetTagDefaults(map[string]string{"env": "prod"}))
+	cases := []struct {
+		name     string
+		tags     map[string]string
+		expected string
+		env, idc string
+	}{
+		{"all tags", map[string]string{"namespace": "Production", "env": "pre", "idc": "sh"}, "Production:user.api?env=pre&idc=sh", "pre", "sh"},
+		{"default env", map[string]string{"idc": "sz"}, "default:user.api?env=prod&idc=sz", "prod", "sz"},
+		{"no default idc", nil, "default:user.api?env=prod", "prod", ""},
+		{"escaped value", map[string]string{"env": "a&b=c"}, "default:user.api?env=a%26b%3Dc", "a&b=c", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo("user.api", "", nil, c.tags))
+			require.Equal(t, c.expected, desc)
+
+			namespace, service := SplitDescription(desc)
+			require.Equal(t, "user.api", service)
+			if ns, ok := c.tags["namespace"]; ok {
+				require.Equal(t, ns, namespace)
+			} else {
+				require.Equal(t, polarisDefaultNamespace, namespace)
+			}
+			_, tags := cutDescriptionTags(desc)
+			values := make(map[string]string)
+			for _, tag := range tags {
+				values[tag.Key] = tag.Value
+			}
+			require.Equal(t, c.env, values["env"])
+			require.Equal(t, c.idc, values["idc"])
+		})
+	}
+
+	plain := newTestResolver(polaristest.NewBackend())
+	require.Equal(t, "default:user.api",
+		plain.Target(context.TODO(), rpcinfo.NewEndpointInfo("user.api", "", nil, map[string]string{"env": "pre"})))
+}
+
+func TestResolveTargetTags(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{"env": "prod", "idc": "sh"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777,
+			Metadata: map[string]string{"env": "prod", "idc": "sz"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 8888,
+			Metadata: map[string]string{"env": "pre", "idc": "sh"}},
+	)
+	rs := newTestResolver(backend, WithTargetTagKeys("namespace", "env", "idc"))
+
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, map[string]string{"env": "prod", "idc": "sh"}))
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, "127.0.0.1:6666", result.Instances[0].Address().String())
+	require.Equal(t, desc, result.CacheKey)
+
+	desc = rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, map[string]string{"env": "prod"}))
+	result, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 2)
+
+	desc = rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, map[string]string{"env": "test"}))
+	_, err = rs.Resolve(context.TODO(), desc)
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance))
+	require.Equal(t, []string{"env=test"}, noInstance.Filters)
+
+	// descriptions without tags keep resolving every instance.
+	result, err = rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+}
+
+// blockingConsumer blocks GetInstances until release is closed.
+type blockingConsumer struct {
+	*polaristest.Backend
+	release  chan struct{}
+	finished chan struct{}
+}
+
+func (c *blockingConsumer) GetInstances(req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	defer close(c.finished)
+	<-c.release
+	return c.Backend.GetInstances(req)
+}
+
+func TestResolveContextCanceled(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	consumer := &blockingConsumer{Backend: backend, release: make(chan struct{}), finished: make(chan struct{})}
+	rs := newTestResolver(backend)
+	rs.consumer = consumer
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := rs.Resolve(ctx, desc)
+	require.True(t, time.Since(begin) < time.Second)
+	var ctxErr *ResolveContextError
+	require.True(t, errors.As(err, &ctxErr))
+	require.Equal(t, serviceName, ctxErr.Service)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the abandoned SDK call finishes on its own once it is unblocked.
+	close(consumer.release)
+	select {
+	case <-consumer.finished:
+	case <-time.After(time.Second):
+		t.Fatal("SDK call did not finish")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rs.Resolve(canceled, desc)
+	require.True(t, errors.Is(err, context.Canceled))
+}
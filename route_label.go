@@ -0,0 +1,94 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+)
+
+// routeLabelTagPrefix marks the description tags carrying the routing labels of a call.
+const routeLabelTagPrefix = "route-label."
+
+type routeLabelsKey struct{}
+
+// NewRouteLabelMW returns a client middleware computing the routing labels of every call with extract,
+// e.g. a bucket of the user ID of the request, so that the polaris routing rules can match business
+// fields. The labels are the source service metadata of the polaris query. Kitex keeps one balancer per
+// description, so Target appends the labels to the description of the call and every distinct label set
+// is resolved and balanced of its own, keep the labels coarse. A nil extract or a nil result leaves the
+// calls unchanged.
+//
+//	client.WithMiddleware(polaris.NewRouteLabelMW(func(ctx context.Context, method string, req interface{}) map[string]string {
+//		if args, ok := req.(*echo.EchoArgs); ok {
+//			return map[string]string{"uid_bucket": strconv.Itoa(int(args.Req.Uid % 100))}
+//		}
+//		return nil
+//	}))
+func NewRouteLabelMW(extract func(ctx context.Context, method string, req interface{}) map[string]string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if extract == nil {
+			return next
+		}
+		return func(ctx context.Context, request, response interface{}) error {
+			var method string
+			if ri := rpcinfo.GetRPCInfo(ctx); ri != nil && ri.Invocation() != nil {
+				method = ri.Invocation().MethodName()
+			}
+			if labels := extract(ctx, method, request); len(labels) > 0 {
+				ctx = context.WithValue(ctx, routeLabelsKey{}, labels)
+			}
+			return next(ctx, request, response)
+		}
+	}
+}
+
+// routeLabelTags returns the routing labels NewRouteLabelMW put in ctx as description tags sorted by key.
+func routeLabelTags(ctx context.Context) []TargetTag {
+	if ctx == nil {
+		return nil
+	}
+	labels, _ := ctx.Value(routeLabelsKey{}).(map[string]string)
+	if len(labels) == 0 {
+		return nil
+	}
+	tags := make([]TargetTag, 0, len(labels))
+	for key, value := range labels {
+		tags = append(tags, TargetTag{Key: routeLabelTagPrefix + key, Value: value})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return tags
+}
+
+// splitRouteLabels splits the routing labels appended by Target off the description tags.
+func splitRouteLabels(tags []TargetTag) (rest []TargetTag, labels map[string]string) {
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag.Key, routeLabelTagPrefix) {
+			rest = append(rest, tag)
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[strings.TrimPrefix(tag.Key, routeLabelTagPrefix)] = tag.Value
+	}
+	return rest, labels
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingConsumer records the GetInstances requests.
+type recordingConsumer struct {
+	*polaristest.Backend
+	lock     sync.Mutex
+	requests []*api.GetInstancesRequest
+}
+
+func (c *recordingConsumer) GetInstances(req *api.GetInstancesRequest) (*model.InstancesResponse, error) {
+	c.lock.Lock()
+	c.requests = append(c.requests, req)
+	c.lock.Unlock()
+	return c.Backend.GetInstances(req)
+}
+
+type fakeUserRequest struct {
+	UID int
+}
+
+func TestRouteLabelMW(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	consumer := &recordingConsumer{Backend: backend}
+	rs := newTestResolver(backend, WithTargetTagKeys("env"))
+	rs.consumer = consumer
+	mw := NewRouteLabelMW(func(ctx context.Context, method string, req interface{}) map[string]string {
+		r, ok := req.(*fakeUserRequest)
+		if !ok {
+			return nil
+		}
+		return map[string]string{"method": method, "uid_bucket": strconv.Itoa(r.UID % 100)}
+	})
+	// resolve stands in for the Kitex resolve middleware, which calls Target with the context of the call.
+	var desc string
+	resolve := mw(func(ctx context.Context, request, response interface{}) error {
+		to := rpcinfo.GetRPCInfo(ctx).To()
+		desc = rs.Target(ctx, to)
+		result, err := rs.Resolve(ctx, desc)
+		require.Nil(t, err)
+		require.Len(t, result.Instances, 1)
+		return nil
+	})
+	to := rpcinfo.NewEndpointInfo(serviceName, "echo", nil, map[string]string{"env": "prod"})
+	ri := rpcinfo.NewRPCInfo(nil, to, rpcinfo.NewInvocation(serviceName, "echo"), rpcinfo.NewRPCConfig(), rpcinfo.NewRPCStats())
+	ctx := rpcinfo.NewCtxWithRPCInfo(context.Background(), ri)
+
+	// the env tag keeps only the second instance, the labels filter nothing.
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.2",
+		Port: 6666, Metadata: map[string]string{"env": "prod"}})
+	require.Nil(t, resolve(ctx, &fakeUserRequest{UID: 1234}, nil))
+	require.Equal(t, "default:registry-test?env=prod&route-label.method=echo&route-label.uid_bucket=34", desc)
+	require.Len(t, consumer.requests, 1)
+	require.Equal(t, &model.ServiceInfo{Metadata: map[string]string{"method": "echo", "uid_bucket": "34"}},
+		consumer.requests[0].SourceService)
+
+	// requests without labels resolve like before.
+	require.Nil(t, resolve(ctx, "other", nil))
+	require.Equal(t, "default:registry-test?env=prod", desc)
+	require.Nil(t, consumer.requests[1].SourceService)
+}
+
+func TestRouteLabelMWNilExtractor(t *testing.T) {
+	called := false
+	next := func(ctx context.Context, request, response interface{}) error {
+		called = true
+		require.Nil(t, routeLabelTags(ctx))
+		return nil
+	}
+	require.Nil(t, NewRouteLabelMW(nil)(next)(context.Background(), nil, nil))
+	require.True(t, called)
+}
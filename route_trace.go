@@ -0,0 +1,77 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"time"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// routeStagePolaris is the stage of the polaris-go router chain, polaris-go reports no per router counts.
+const routeStagePolaris = "polaris"
+
+// RouteStage is one step that narrowed the instances of a Resolve.
+type RouteStage struct {
+	Name   string
+	Before int
+	After  int
+}
+
+// RouteTrace describes how the instances of the last Resolve of a description were selected,
+// it is only recorded with WithRouteDebug(true).
+type RouteTrace struct {
+	Desc string
+	// RouterChain is the polaris-go service router chain of the consumer.
+	RouterChain []string
+	Stages      []RouteStage
+	Time        time.Time
+}
+
+// newRouteTrace starts the trace of a Resolve with the polaris stage,
+// the instances before routing are queried separately since polaris-go only returns the routed ones.
+func (polaris *polarisResolver) newRouteTrace(desc, namespace, serviceName string, routed int) *RouteTrace {
+	before := -1
+	req := &api.GetAllInstancesRequest{}
+	req.Namespace = namespace
+	req.Service = serviceName
+	if resp, err := polaris.consumerAPI().GetAllInstances(req); err == nil {
+		before = len(resp.GetInstances())
+	}
+	return &RouteTrace{
+		Desc:        desc,
+		RouterChain: polaris.sdkRouterChain(),
+		Stages:      []RouteStage{{Name: routeStagePolaris, Before: before, After: routed}},
+		Time:        time.Now(),
+	}
+}
+
+func (polaris *polarisResolver) recordRouteTrace(trace *RouteTrace) {
+	polaris.routeTraces.Store(trace.Desc, *trace)
+	log.GetBaseLogger().Infof("[Polaris resolver] route trace of %s, router chain %v, stages %+v",
+		trace.Desc, trace.RouterChain, trace.Stages)
+}
+
+// LastRouteTrace implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) LastRouteTrace(desc string) (RouteTrace, bool) {
+	trace, ok := polaris.routeTraces.Load(desc)
+	if !ok {
+		return RouteTrace{}, false
+	}
+	return trace.(RouteTrace), true
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouteTraceBackend() *polaristest.Backend {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{"env": "prod"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777,
+			Metadata: map[string]string{"env": "pre"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 8888,
+			Metadata: map[string]string{"env": "prod"}, Unhealthy: true},
+	)
+	return backend
+}
+
+func TestRouteTrace(t *testing.T) {
+	backend := newRouteTraceBackend()
+	rs := newTestResolver(backend, WithRouteDebug(true), WithTargetTagKeys("namespace", "env"))
+	rs.routerChain = []string{"ruleBasedRouter", "nearbyBasedRouter"}
+	desc := rs.Target(context.TODO(), rpcinfo.NewEndpointInfo(serviceName, "", nil, map[string]string{"env": "prod"}))
+
+	_, ok := rs.LastRouteTrace(desc)
+	require.False(t, ok)
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+
+	trace, ok := rs.LastRouteTrace(desc)
+	require.True(t, ok)
+	require.Equal(t, desc, trace.Desc)
+	require.Equal(t, []string{"ruleBasedRouter", "nearbyBasedRouter"}, trace.RouterChain)
+	require.Equal(t, []RouteStage{
+		{Name: routeStagePolaris, Before: 3, After: 2},
+		{Name: "env=prod", Before: 2, After: 1},
+	}, trace.Stages)
+}
+
+func TestRouteTraceDisabled(t *testing.T) {
+	backend := newRouteTraceBackend()
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	_, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	_, ok := rs.LastRouteTrace(desc)
+	require.False(t, ok)
+	require.Equal(t, 0, backend.Calls(polaristest.OpGetAllInstances))
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"strings"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/polarismesh/polaris-go/pkg/plugin"
+	"github.com/polarismesh/polaris-go/pkg/plugin/common"
+)
+
+// serviceRouterChain returns the router chain of WithRouterChain, an error names the routers that are
+// not service router plugins of polaris-go and the ones listed twice.
+func (o *options) serviceRouterChain() ([]string, error) {
+	var unknown, duplicated []string
+	seen := make(map[string]struct{}, len(o.routerChain))
+	for _, router := range o.routerChain {
+		if !plugin.IsPluginRegistered(common.TypeServiceRouter, router) {
+			unknown = append(unknown, router)
+		} else if _, ok := seen[router]; ok {
+			duplicated = append(duplicated, router)
+		}
+		seen[router] = struct{}{}
+	}
+	if len(unknown) > 0 {
+		return nil, perrors.Errorf("unknown polaris service routers %s in WithRouterChain, like %s or %s",
+			strings.Join(unknown, ", "), config.DefaultServiceRouterRuleBased, config.DefaultServiceRouterNearbyBased)
+	}
+	if len(duplicated) > 0 {
+		return nil, perrors.Errorf("polaris service routers %s listed twice in WithRouterChain", strings.Join(duplicated, ", "))
+	}
+	return append([]string(nil), o.routerChain...), nil
+}
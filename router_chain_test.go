@@ -0,0 +1,52 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"testing"
+
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterChain(t *testing.T) {
+	endpoints := []string{"127.0.0.1:8091"}
+	chain := []string{config.DefaultServiceRouterNearbyBased, config.DefaultServiceRouterRuleBased}
+	conf, err := newPolarisConfiguration(endpoints, newOptions([]Option{WithRouterChain(chain)}))
+	require.Nil(t, err)
+	require.Equal(t, chain, conf.GetConsumer().GetServiceRouter().GetChain())
+
+	// the chain reaches the SDK context, which tells it to RouteTrace.
+	sdkCtx, err := RawSDKContext(endpoints, WithRouterChain(chain))
+	require.Nil(t, err)
+	defer sdkCtx.Destroy()
+	require.Equal(t, chain, sdkCtx.GetConfig().GetConsumer().GetServiceRouter().GetChain())
+
+	// the resolvers with another chain do not share an SDK context.
+	require.NotEqual(t, sdkContextKey(endpoints, newOptions(nil)), sdkContextKey(endpoints, newOptions([]Option{WithRouterChain(chain)})))
+}
+
+func TestRouterChainInvalid(t *testing.T) {
+	endpoints := []string{"127.0.0.1:8091"}
+	_, err := NewPolarisResolver(endpoints, WithRouterChain([]string{"nearbyRouter", config.DefaultServiceRouterRuleBased}))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "unknown polaris service routers nearbyRouter in WithRouterChain")
+
+	_, err = NewPolarisRegistry(endpoints, WithRouterChain([]string{config.DefaultServiceRouterRuleBased, config.DefaultServiceRouterRuleBased}))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "polaris service routers ruleBasedRouter listed twice")
+}
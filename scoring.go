@@ -0,0 +1,100 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"math"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// Parameters of the adaptive instance scoring, see WithAdaptiveInstanceScoring.
+const (
+	scoreUnhealthyFactor = 0.5
+	scoreFloorRatio      = 0.1
+	scoreMinSamples      = 10
+)
+
+// instanceScore computes the effective weight of an instance of weight w:
+//
+//	round(w * health * (1 - failureRatio)), clamped into [max(1, round(w * 0.1)), w]
+//
+// health is 1 for an instance healthy in polaris and 0.5 otherwise. failureRatio is the ratio of the
+// failed calls given to ReportCallResult over the current and the last flush windows, zero below 10
+// calls. ok is false when the circuit breaker of the instance is open, it is then skipped.
+func instanceScore(weight int, healthy bool, breaker model.CircuitBreakerStatus, failureRatio float64,
+	samples int) (score int, ok bool) {
+	if breaker != nil && breaker.GetStatus() == model.Open {
+		return 0, false
+	}
+	if weight <= 0 {
+		return weight, true
+	}
+	factor := 1.0
+	if !healthy {
+		factor = scoreUnhealthyFactor
+	}
+	if samples >= scoreMinSamples {
+		factor *= 1 - failureRatio
+	}
+	score = int(math.Round(float64(weight) * factor))
+	floor := int(math.Round(float64(weight) * scoreFloorRatio))
+	if floor < 1 {
+		floor = 1
+	}
+	if score < floor {
+		score = floor
+	}
+	if score > weight {
+		score = weight
+	}
+	return score, true
+}
+
+// newScoringFilter scores the instances converted from sources in the same order, the Kitex instances
+// of other origins are kept unchanged.
+func (polaris *polarisResolver) newScoringFilter(sources []model.Instance, eps []discovery.Instance) instanceFilter {
+	byInstance := make(map[discovery.Instance]model.Instance, len(eps))
+	for i, ep := range eps {
+		byInstance[ep] = sources[i]
+	}
+	return instanceFilter{
+		name: "adaptive scoring",
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			scored := make([]discovery.Instance, 0, len(instances))
+			for _, ins := range instances {
+				source, ok := byInstance[ins]
+				if !ok {
+					scored = append(scored, ins)
+					continue
+				}
+				ratio, samples := polaris.reporter.failureRatio(source.GetId())
+				score, ok := instanceScore(ins.Weight(), source.IsHealthy(), source.GetCircuitBreakerStatus(), ratio, samples)
+				if !ok {
+					continue
+				}
+				if score != ins.Weight() {
+					ins = &weightedInstance{Instance: ins, weight: score}
+				}
+				scored = append(scored, ins)
+			}
+			return scored
+		},
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceScore(t *testing.T) {
+	open := (&polaristest.Instance{Breaker: model.Open}).GetCircuitBreakerStatus()
+	halfOpen := (&polaristest.Instance{Breaker: model.HalfOpen}).GetCircuitBreakerStatus()
+	for name, c := range map[string]struct {
+		weight   int
+		healthy  bool
+		breaker  model.CircuitBreakerStatus
+		ratio    float64
+		samples  int
+		score    int
+		eligible bool
+	}{
+		"healthy":                 {100, true, nil, 0, 0, 100, true},
+		"unhealthy":               {100, false, nil, 0, 0, 50, true},
+		"open breaker":            {100, true, open, 0, 0, 0, false},
+		"half open breaker":       {100, true, halfOpen, 0, 0, 100, true},
+		"failures":                {100, true, nil, 0.25, 20, 75, true},
+		"too few samples":         {100, true, nil, 0.5, scoreMinSamples - 1, 100, true},
+		"unhealthy with failures": {100, false, nil, 0.5, 20, 25, true},
+		"floor":                   {100, true, nil, 1, 20, 10, true},
+		"floor of light weight":   {3, false, nil, 0.9, 20, 1, true},
+		"zero weight":             {0, true, nil, 0.5, 20, 0, true},
+	} {
+		score, eligible := instanceScore(c.weight, c.healthy, c.breaker, c.ratio, c.samples)
+		require.Equal(t, c.eligible, eligible, name)
+		require.Equal(t, c.score, score, name)
+	}
+}
+
+func TestResolveAdaptiveScoring(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{ID: "ok", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666, Weight: 100},
+		&polaristest.Instance{ID: "open", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6668, Weight: 100, Breaker: model.Open},
+		&polaristest.Instance{ID: "flaky", Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6669, Weight: 100},
+	)
+	rs := newTestResolver(backend, WithAdaptiveInstanceScoring(true), WithCallResultFlushInterval(time.Hour))
+	for i := 0; i < 20; i++ {
+		status := CallSuccess
+		if i%5 == 0 {
+			status = CallFail
+		}
+		rs.ReportCallResult(callResult("flaky", status, 0, time.Millisecond))
+	}
+
+	result, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	weights := make(map[string]int)
+	for _, ins := range result.Instances {
+		weights[ins.Address().String()] = ins.Weight()
+	}
+	require.Equal(t, map[string]int{"127.0.0.1:6666": 100, "127.0.0.1:6669": 80}, weights)
+
+	// the failures age out after two flushes.
+	rs.reporter.flush()
+	rs.reporter.flush()
+	result, err = rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	for _, ins := range result.Instances {
+		if ins.Address().String() == "127.0.0.1:6669" {
+			require.Equal(t, 100, ins.Weight())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"github.com/polarismesh/polaris-go/api"
+)
+
+// sdkAPIs are the polaris-go APIs a resolver or registry runs on.
+type sdkAPIs struct {
+	consumer    api.ConsumerAPI
+	provider    api.ProviderAPI
+	routerChain []string
+	// release releases the SDK context, it is nil for the APIs given by WithConsumerAPI and WithProviderAPI
+	// which are never destroyed.
+	release func()
+}
+
+// newSDKAPIs returns the APIs given by WithConsumerAPI and WithProviderAPI, a missing one is created
+// from the SDK context of the other when it has one. Without them both are created from endpoints.
+func newSDKAPIs(endpoints []string, o *options) (*sdkAPIs, error) {
+	if o.consumerAPI == nil && o.providerAPI == nil {
+		sdkCtx, fanout, release, err := acquireSDKContext(endpoints, o)
+		if err != nil {
+			return nil, err
+		}
+		apis := &sdkAPIs{
+			consumer:    api.NewConsumerAPIByContext(sdkCtx),
+			provider:    api.NewProviderAPIByContext(sdkCtx),
+			routerChain: sdkCtx.GetConfig().GetConsumer().GetServiceRouter().GetChain(),
+			release:     release,
+		}
+		if fanout != nil {
+			// the other users of the context watch the same services.
+			consumer := fanout.consumer(apis.consumer)
+			apis.consumer = consumer
+			apis.release = func() {
+				consumer.close()
+				release()
+			}
+		}
+		return apis, nil
+	}
+	apis := &sdkAPIs{consumer: o.consumerAPI, provider: o.providerAPI}
+	var sdkCtx api.SDKContext
+	if apis.consumer != nil {
+		sdkCtx = apis.consumer.SDKContext()
+	} else {
+		sdkCtx = apis.provider.SDKContext()
+	}
+	if sdkCtx != nil {
+		if apis.consumer == nil {
+			apis.consumer = api.NewConsumerAPIByContext(sdkCtx)
+		}
+		if apis.provider == nil {
+			apis.provider = api.NewProviderAPIByContext(sdkCtx)
+		}
+		apis.routerChain = sdkCtx.GetConfig().GetConsumer().GetServiceRouter().GetChain()
+	}
+	return apis, nil
+}
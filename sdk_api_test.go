@@ -0,0 +1,81 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalAPIsAreNotDestroyed(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	rs, err := NewPolarisResolver(nil, WithConsumerAPI(backend), WithProviderAPI(backend))
+	require.Nil(t, err)
+	result, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.True(t, rs.(OptionsReporter).EffectiveOptions().ExternalSDKAPIs)
+
+	require.Nil(t, rs.(io.Closer).Close())
+	require.Equal(t, 0, backend.Destroyed())
+}
+
+func TestOwnedAPIsAreDestroyedOnce(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend)
+	rs.releaseSDK = rs.consumer.Destroy
+
+	require.Nil(t, rs.Close())
+	require.Nil(t, rs.Close())
+	require.Equal(t, 1, backend.Destroyed())
+}
+
+func TestExternalAPIsConflictingOptions(t *testing.T) {
+	backend := polaristest.NewBackend()
+	_, err := NewPolarisResolver(nil, WithConsumerAPI(backend),
+		WithDisableStatReporter(true), WithRouterChain([]string{"ruleBasedRouter"}))
+	require.True(t, errors.Is(err, ErrConflictingOptions), err)
+	require.Contains(t, err.Error(), "WithConsumerAPI or WithProviderAPI with WithDisableStatReporter")
+	require.Contains(t, err.Error(), "WithConsumerAPI or WithProviderAPI with WithRouterChain")
+
+	_, err = NewPolarisRegistry(nil, WithProviderAPI(backend), WithDisableLocationProvider(true))
+	require.True(t, errors.Is(err, ErrConflictingOptions), err)
+	require.Contains(t, err.Error(), "WithConsumerAPI or WithProviderAPI with WithDisableLocationProvider")
+}
+
+func TestExternalAPIWithoutSDKContext(t *testing.T) {
+	backend := polaristest.NewBackend()
+	_, err := NewPolarisResolver(nil, WithProviderAPI(backend))
+	require.NotNil(t, err)
+	_, err = NewPolarisRegistry(nil, WithConsumerAPI(backend))
+	require.NotNil(t, err)
+
+	rg, err := NewPolarisRegistry(nil, WithProviderAPI(backend))
+	require.Nil(t, err)
+	info := newTestInfo("127.0.0.1:8888", nil)
+	require.Nil(t, rg.Register(info))
+	err = rg.(VerifyingRegistry).VerifyRegistration(context.TODO(), info, 0)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "needs a consumer API")
+	require.Nil(t, rg.Deregister(info))
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/polarismesh/polaris-go/api"
+)
+
+// sdkContexts holds the SDK contexts built from endpoints, shared by the resolvers and registries
+// built with the same endpoints and SDK options.
+var sdkContexts = struct {
+	sync.Mutex
+	shared map[string]*sharedSDKContext
+}{shared: make(map[string]*sharedSDKContext)}
+
+type sharedSDKContext struct {
+	sdkCtx api.SDKContext
+	fanout *watchFanout
+	refs   int
+}
+
+// acquireSDKContext returns the SDK context built from endpoints and the function releasing it.
+// A shared context is destroyed when its last user released it, a dedicated one right away.
+// The watches of the users of a shared context go through its watchFanout, nil for a dedicated one.
+func acquireSDKContext(endpoints []string, o *options) (api.SDKContext, *watchFanout, func(), error) {
+	if o.dedicatedSDKContext {
+		sdkCtx, err := newSDKContext(endpoints, o)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return sdkCtx, nil, sdkCtx.Destroy, nil
+	}
+	key := sdkContextKey(endpoints, o)
+	sdkContexts.Lock()
+	defer sdkContexts.Unlock()
+	shared, ok := sdkContexts.shared[key]
+	if !ok {
+		sdkCtx, err := newSDKContext(endpoints, o)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shared = &sharedSDKContext{sdkCtx: sdkCtx, fanout: newWatchFanout()}
+		sdkContexts.shared[key] = shared
+	}
+	shared.refs++
+	var once sync.Once
+	return shared.sdkCtx, shared.fanout, func() {
+		once.Do(func() { releaseSDKContext(key, shared) })
+	}, nil
+}
+
+func releaseSDKContext(key string, shared *sharedSDKContext) {
+	sdkContexts.Lock()
+	defer sdkContexts.Unlock()
+	if shared.refs--; shared.refs > 0 {
+		return
+	}
+	if sdkContexts.shared[key] == shared {
+		delete(sdkContexts.shared, key)
+	}
+	shared.fanout.close()
+	shared.sdkCtx.Destroy()
+}
+
+// sdkContextKey identifies the SDK context of endpoints and the options configuring it,
+// the order and duplicates of endpoints do not matter.
+func sdkContextKey(endpoints []string, o *options) string {
+	normalized := make([]string, 0, len(endpoints))
+	seen := make(map[string]struct{}, len(endpoints))
+	for _, endpoint := range endpoints {
+		if _, ok := seen[endpoint]; !ok {
+			seen[endpoint] = struct{}{}
+			normalized = append(normalized, endpoint)
+		}
+	}
+	sort.Strings(normalized)
+	return fmt.Sprintf("%s|stat=%t|location=%t|routers=%s", strings.Join(normalized, ","),
+		o.disableStatReporter, o.disableLocationProvider, strings.Join(o.routerChain, ","))
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedSDKContext(t *testing.T) {
+	first, err := NewPolarisResolver([]string{"127.0.0.1:65001", "127.0.0.2:65001"})
+	require.Nil(t, err)
+	second, err := NewPolarisResolver([]string{"127.0.0.2:65001", "127.0.0.1:65001", "127.0.0.2:65001"})
+	require.Nil(t, err)
+	rg, err := NewPolarisRegistry([]string{"127.0.0.1:65001", "127.0.0.2:65001"})
+	require.Nil(t, err)
+	sdkCtx := first.(*polarisResolver).consumer.SDKContext()
+	require.True(t, sdkCtx == second.(*polarisResolver).consumer.SDKContext())
+	require.True(t, sdkCtx == rg.(*polarisRegistry).provider.SDKContext())
+
+	require.Nil(t, first.(io.Closer).Close())
+	require.Nil(t, first.(io.Closer).Close())
+	require.False(t, sdkCtx.IsDestroyed())
+	require.Nil(t, second.(io.Closer).Close())
+	// the registry still holds the context.
+	require.False(t, sdkCtx.IsDestroyed())
+	require.Nil(t, rg.(io.Closer).Close())
+	require.True(t, sdkCtx.IsDestroyed())
+}
+
+func TestSharedSDKContextLastClose(t *testing.T) {
+	first, err := NewPolarisResolver([]string{"127.0.0.1:65002"})
+	require.Nil(t, err)
+	second, err := NewPolarisResolver([]string{"127.0.0.1:65002"})
+	require.Nil(t, err)
+	other, err := NewPolarisResolver([]string{"127.0.0.1:65002"}, WithDisableStatReporter(true))
+	require.Nil(t, err)
+	defer other.(io.Closer).Close()
+	dedicated, err := NewPolarisResolver([]string{"127.0.0.1:65002"}, WithDedicatedSDKContext())
+	require.Nil(t, err)
+	sdkCtx := first.(*polarisResolver).consumer.SDKContext()
+	require.True(t, sdkCtx == second.(*polarisResolver).consumer.SDKContext())
+	require.True(t, sdkCtx != other.(*polarisResolver).consumer.SDKContext())
+	dedicatedCtx := dedicated.(*polarisResolver).consumer.SDKContext()
+	require.True(t, sdkCtx != dedicatedCtx)
+
+	require.Nil(t, dedicated.(io.Closer).Close())
+	require.True(t, dedicatedCtx.IsDestroyed())
+	require.Nil(t, first.(io.Closer).Close())
+	require.False(t, sdkCtx.IsDestroyed())
+	require.Nil(t, second.(io.Closer).Close())
+	require.True(t, sdkCtx.IsDestroyed())
+
+	// a later resolver builds a new context.
+	third, err := NewPolarisResolver([]string{"127.0.0.1:65002"})
+	require.Nil(t, err)
+	require.True(t, sdkCtx != third.(*polarisResolver).consumer.SDKContext())
+	require.Nil(t, third.(io.Closer).Close())
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// sdkLogLevels maps the levels of WithSDKLogLevel to the ones of polaris-go.
+var sdkLogLevels = map[string]int{
+	"trace":   log.TraceLog,
+	"debug":   log.DebugLog,
+	"info":    log.InfoLog,
+	"warn":    log.WarnLog,
+	"warning": log.WarnLog,
+	"error":   log.ErrorLog,
+	"fatal":   log.FatalLog,
+	"none":    log.NoneLog,
+}
+
+// parseSDKLogLevel parses the level of WithSDKLogLevel, ok is false when it is not set.
+func (o *options) parseSDKLogLevel() (level int, ok bool, err error) {
+	if o.sdkLogLevel == "" {
+		return 0, false, nil
+	}
+	level, ok = sdkLogLevels[strings.ToLower(o.sdkLogLevel)]
+	if !ok {
+		return 0, false, perrors.Errorf("unknown SDK log level %q", o.sdkLogLevel)
+	}
+	return level, true, nil
+}
+
+// configureSDKLoggers applies WithSDKLogDir, WithSDKLogLevel and WithSDKLogDiscard to the loggers of
+// polaris-go, which are global to the process, before an SDK context is created.
+func configureSDKLoggers(o *options) error {
+	level, leveled, err := o.parseSDKLogLevel()
+	if err != nil {
+		return err
+	}
+	switch {
+	case o.sdkLogDiscard:
+		if !leveled {
+			level = log.DefaultBaseLogLevel
+		}
+		logger := newSDKLogger(o.structuredLogger, level)
+		log.SetBaseLogger(logger)
+		log.SetStatLogger(logger)
+		log.SetStatReportLogger(logger)
+		log.SetDetectLogger(logger)
+		log.SetNetworkLogger(logger)
+	case o.sdkLogDir != "" && leveled:
+		err = api.ConfigLoggers(o.sdkLogDir, level)
+	case o.sdkLogDir != "":
+		err = api.SetLoggersDir(o.sdkLogDir)
+	case leveled:
+		err = api.SetLoggersLevel(level)
+	}
+	return perrors.WithMessage(err, "configure the polaris SDK loggers")
+}
+
+// sdkLogger is the polaris-go logger of WithSDKLogDiscard, it hands the SDK logs to the StructuredLogger
+// or drops them without one.
+type sdkLogger struct {
+	logger StructuredLogger
+	level  int32 // accessed atomically
+}
+
+func newSDKLogger(logger StructuredLogger, level int) *sdkLogger {
+	return &sdkLogger{logger: logger, level: int32(level)}
+}
+
+// Tracef implements log.Logger.
+func (l *sdkLogger) Tracef(format string, args ...interface{}) { l.log(log.TraceLog, format, args) }
+
+// Debugf implements log.Logger.
+func (l *sdkLogger) Debugf(format string, args ...interface{}) { l.log(log.DebugLog, format, args) }
+
+// Infof implements log.Logger.
+func (l *sdkLogger) Infof(format string, args ...interface{}) { l.log(log.InfoLog, format, args) }
+
+// Warnf implements log.Logger.
+func (l *sdkLogger) Warnf(format string, args ...interface{}) { l.log(log.WarnLog, format, args) }
+
+// Errorf implements log.Logger.
+func (l *sdkLogger) Errorf(format string, args ...interface{}) { l.log(log.ErrorLog, format, args) }
+
+// Fatalf implements log.Logger, it logs an error and never exits.
+func (l *sdkLogger) Fatalf(format string, args ...interface{}) { l.log(log.FatalLog, format, args) }
+
+// IsLevelEnabled implements log.Logger.
+func (l *sdkLogger) IsLevelEnabled(level int) bool {
+	return l.logger != nil && level >= int(atomic.LoadInt32(&l.level))
+}
+
+// SetLogLevel implements log.Logger.
+func (l *sdkLogger) SetLogLevel(level int) error {
+	if err := log.VerifyLogLevel(level); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&l.level, int32(level))
+	return nil
+}
+
+func (l *sdkLogger) log(level int, format string, args []interface{}) {
+	if !l.IsLevelEnabled(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	ctx := context.Background()
+	switch {
+	case level <= log.DebugLog:
+		l.logger.DebugContext(ctx, msg, "source", "polaris-go")
+	case level == log.InfoLog:
+		l.logger.InfoContext(ctx, msg, "source", "polaris-go")
+	case level == log.WarnLog:
+		l.logger.WarnContext(ctx, msg, "source", "polaris-go")
+	default:
+		l.logger.ErrorContext(ctx, msg, "source", "polaris-go")
+	}
+}
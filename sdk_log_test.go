@@ -0,0 +1,108 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+// keepSDKLoggers restores the global loggers of polaris-go at the end of t.
+func keepSDKLoggers(t *testing.T) {
+	base, stat, statReport, detect, network := log.GetBaseLogger(), log.GetStatLogger(), log.GetStatReportLogger(),
+		log.GetDetectLogger(), log.GetNetworkLogger()
+	t.Cleanup(func() {
+		log.SetBaseLogger(base)
+		log.SetStatLogger(stat)
+		log.SetStatReportLogger(statReport)
+		log.SetDetectLogger(detect)
+		log.SetNetworkLogger(network)
+	})
+}
+
+func TestSDKLogLevel(t *testing.T) {
+	keepSDKLoggers(t)
+	for level, want := range map[string]int{"": 0, "TRACE": log.TraceLog, "warning": log.WarnLog, "none": log.NoneLog} {
+		got, ok, err := newOptions([]Option{WithSDKLogLevel(level)}).parseSDKLogLevel()
+		require.Nil(t, err)
+		require.Equal(t, level != "", ok, level)
+		require.Equal(t, want, got, level)
+	}
+	_, err := RawSDKContext([]string{"127.0.0.1:65003"}, WithSDKLogLevel("loud"))
+	require.EqualError(t, err, `unknown SDK log level "loud"`)
+
+	sdkCtx, err := RawSDKContext([]string{"127.0.0.1:65003"}, WithSDKLogLevel("error"))
+	require.Nil(t, err)
+	defer sdkCtx.Destroy()
+	require.False(t, log.GetBaseLogger().IsLevelEnabled(log.WarnLog))
+	require.True(t, log.GetNetworkLogger().IsLevelEnabled(log.ErrorLog))
+}
+
+func TestSDKLogDir(t *testing.T) {
+	keepSDKLoggers(t)
+	dir := filepath.Join(t.TempDir(), "logs")
+	sdkCtx, err := RawSDKContext([]string{"127.0.0.1:65003"}, WithSDKLogDir(dir), WithSDKLogLevel("debug"))
+	require.Nil(t, err)
+	defer sdkCtx.Destroy()
+	require.True(t, log.GetBaseLogger().IsLevelEnabled(log.DebugLog))
+	log.GetBaseLogger().Infof("written under the SDK log dir")
+	_, err = os.Stat(filepath.Join(dir, log.DefaultBaseLogRotationPath))
+	require.Nil(t, err)
+}
+
+func TestSDKLogDiscard(t *testing.T) {
+	keepSDKLoggers(t)
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	dir := t.TempDir()
+	require.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	logger := &structuredLogger{}
+	sdkCtx, err := RawSDKContext([]string{"127.0.0.1:65003"}, WithSDKLogDiscard(), WithStructuredLogger(logger))
+	require.Nil(t, err)
+	log.GetBaseLogger().Debugf("below the default level")
+	log.GetBaseLogger().Warnf("handed to the %s logger", "structured")
+	log.GetNetworkLogger().Fatalf("never exits")
+	sdkCtx.Destroy()
+
+	logger.lock.Lock()
+	var warned, fatal bool
+	for _, record := range logger.records {
+		require.NotEqual(t, "below the default level", record.msg)
+		warned = warned || record.level == "warn" && record.msg == "handed to the structured logger"
+		fatal = fatal || record.level == "error" && record.msg == "never exits"
+		require.Equal(t, "polaris-go", record.fields["source"])
+	}
+	logger.lock.Unlock()
+	require.True(t, warned)
+	require.True(t, fatal)
+	_, err = os.Stat(filepath.Join(dir, log.DefaultLogRotationRootDir))
+	require.True(t, os.IsNotExist(err), "%v", err)
+
+	// without a structured logger the logs are dropped.
+	sdkCtx, err = RawSDKContext([]string{"127.0.0.1:65003"}, WithSDKLogDiscard())
+	require.Nil(t, err)
+	log.GetBaseLogger().Errorf("dropped")
+	sdkCtx.Destroy()
+	_, err = os.Stat(filepath.Join(dir, log.DefaultLogRotationRootDir))
+	require.True(t, os.IsNotExist(err), "%v", err)
+}
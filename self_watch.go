@@ -0,0 +1,184 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	// ownDeregistrationWindow is how long the delete events of an instance deregistered by the registry
+	// itself, by Deregister, SetIsolated or the reconciliation, are not taken for external deregistrations.
+	ownDeregistrationWindow = 30 * time.Second
+	selfWatchWaiterSize     = 64
+)
+
+// watchSelf subscribes once to the service of a registered instance, see WithSelfWatch.
+func (svr *polarisRegistry) watchSelf(namespace, service string) {
+	if !svr.opts.selfWatch || svr.consumerAPI() == nil {
+		return
+	}
+	key := model.ServiceKey{Namespace: namespace, Service: service}
+	svr.selfWatchLock.Lock()
+	defer svr.selfWatchLock.Unlock()
+	if svr.selfWatches == nil {
+		// the hooks and the removal grace of the options are the ones of resolvers.
+		svr.selfWatches = newWatchManager(svr.consumerAPI(), &options{watchTimeout: svr.opts.watchTimeout}, nil)
+		svr.selfWatched = make(map[model.ServiceKey]bool)
+	}
+	if svr.selfWatched[key] {
+		return
+	}
+	_, waiter, _, err := svr.selfWatches.subscribe(key, selfWatchWaiterSize)
+	if err != nil {
+		// the next registration of the service tries again.
+		log.GetBaseLogger().Warnf("[Polaris registry] watch own service %s:%s: %v", namespace, service, err)
+		return
+	}
+	svr.selfWatched[key] = true
+	go svr.runSelfWatch(waiter, svr.selfWatches.done)
+}
+
+// runSelfWatch handles the events of an own service until the registry is closed.
+func (svr *polarisRegistry) runSelfWatch(waiter chan model.SubScribeEvent, done <-chan struct{}) {
+	for {
+		var event model.SubScribeEvent
+		select {
+		case <-done:
+			return
+		case event = <-waiter:
+		}
+		insEvent, _, ok := instanceEvent(event)
+		if !ok || insEvent.DeleteEvent == nil {
+			continue
+		}
+		for _, instance := range insEvent.DeleteEvent.Instances {
+			svr.instanceDeleted(instance)
+		}
+	}
+}
+
+// instanceDeleted registers again a registered instance polaris deleted without the registry deregistering it,
+// like from the polaris console.
+func (svr *polarisRegistry) instanceDeleted(instance model.Instance) {
+	var previous *polarisHeartbeat
+	svr.lock.RLock()
+	for _, ins := range svr.registryIns {
+		if ins.heartbeat.InstanceID == instance.GetId() {
+			previous = ins
+			break
+		}
+	}
+	svr.lock.RUnlock()
+	if previous == nil || svr.ownDeregistration(previous.instanceKey) {
+		return
+	}
+	log.GetBaseLogger().Warnf("[Polaris registry] instance %s was deregistered from polaris by someone else, registering it again",
+		instance.GetId())
+	svr.heartbeatDegraded(previous.heartbeat, true)
+	if deregistered := svr.opts.onExternallyDeregistered; deregistered != nil {
+		runHook("externally deregistered", deregistered)
+	}
+	register := func() error {
+		return svr.registerAgain(previous)
+	}
+	err := register()
+	if err == nil {
+		log.GetBaseLogger().Infof("[Polaris registry] instance %s registered again", instance.GetId())
+		svr.registered()
+		return
+	}
+	if !svr.opts.registerRetry {
+		log.GetBaseLogger().Errorf("[Polaris registry] register externally deregistered instance %s again: %v",
+			instance.GetId(), err)
+		return
+	}
+	svr.startRegisterRetry(previous.instanceKey, err, register, func() error {
+		svr.lock.RLock()
+		current, ok := svr.registryIns[previous.instanceKey]
+		svr.lock.RUnlock()
+		if !ok {
+			return nil
+		}
+		if err := svr.deregisterWithTimeout(createDeregisterParamOf(current.desired)); err != nil {
+			return err
+		}
+		svr.forget(previous.instanceKey, current)
+		return nil
+	})
+	svr.transition(nil)
+}
+
+// registerAgain registers the instance of previous again, unless its registration was replaced meanwhile.
+func (svr *polarisRegistry) registerAgain(previous *polarisHeartbeat) error {
+	svr.updateLock.Lock()
+	defer svr.updateLock.Unlock()
+	svr.lock.RLock()
+	current := svr.registryIns[previous.instanceKey]
+	svr.lock.RUnlock()
+	if current != previous {
+		return nil
+	}
+	param := previous.desired
+	resp, err := svr.providerAPI().Register(param)
+	if err != nil {
+		return notFoundError(err, param.Namespace, param.Service)
+	}
+	svr.startHeartbeat(previous.instanceKey, param, resp, previous.shared)
+	svr.transition(nil)
+	return nil
+}
+
+// recordOwnDeregistration remembers that the registry deregisters the instance of request, see WithSelfWatch.
+func (svr *polarisRegistry) recordOwnDeregistration(request *api.InstanceDeRegisterRequest) {
+	if !svr.opts.selfWatch {
+		return
+	}
+	now := svr.currentTime()
+	instanceKey := GetInstanceKey(request.Namespace, request.Service, request.Host, strconv.Itoa(request.Port))
+	svr.selfWatchLock.Lock()
+	defer svr.selfWatchLock.Unlock()
+	for key, at := range svr.ownDeletes {
+		if now.Sub(at) >= ownDeregistrationWindow {
+			delete(svr.ownDeletes, key)
+		}
+	}
+	if svr.ownDeletes == nil {
+		svr.ownDeletes = make(map[string]time.Time)
+	}
+	svr.ownDeletes[instanceKey] = now
+}
+
+// ownDeregistration tells whether the registry deregistered the instance of instanceKey lately.
+func (svr *polarisRegistry) ownDeregistration(instanceKey string) bool {
+	svr.selfWatchLock.Lock()
+	defer svr.selfWatchLock.Unlock()
+	at, ok := svr.ownDeletes[instanceKey]
+	return ok && svr.currentTime().Sub(at) < ownDeregistrationWindow
+}
+
+func (svr *polarisRegistry) currentTime() time.Time {
+	if svr.now == nil {
+		return time.Now()
+	}
+	return svr.now()
+}
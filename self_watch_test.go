@@ -0,0 +1,103 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newSelfWatchRegistry(backend *polaristest.Backend, opts ...Option) (*polarisRegistry, chan struct{}) {
+	deregistered := make(chan struct{}, 8)
+	opts = append(opts, WithSelfWatch(true), WithOnExternallyDeregistered(func() {
+		deregistered <- struct{}{}
+	}))
+	return newTestRegistry(backend, opts...), deregistered
+}
+
+func TestSelfWatchExternalDeregistration(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg, deregistered := newSelfWatchRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", map[string]string{"env": "prod"})
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	id := backend.Instances(polarisDefaultNamespace, serviceName)[0].ID
+
+	// the instances of others are not ours to restore.
+	backend.AddInstances(&polaristest.Instance{ID: "other", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.2", Port: 6666})
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, "other")
+
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, id)
+	select {
+	case <-deregistered:
+	case <-time.After(time.Second):
+		t.Fatal("external deregistration not detected")
+	}
+	require.Eventually(t, func() bool {
+		return len(backend.Instances(polarisDefaultNamespace, serviceName)) == 1
+	}, time.Second, time.Millisecond)
+	instances := backend.Instances(polarisDefaultNamespace, serviceName)
+	require.Equal(t, id, instances[0].ID)
+	require.Equal(t, "prod", instances[0].Metadata["env"])
+	require.Equal(t, 2, backend.Calls(polaristest.OpRegister))
+	require.Eventually(t, func() bool { return rg.CurrentState() == StateRegistered }, time.Second, time.Millisecond)
+	require.Empty(t, deregistered)
+}
+
+func TestSelfWatchOwnDeregistration(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg, deregistered := newSelfWatchRegistry(backend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+
+	require.Nil(t, rg.SetIsolated(info, true))
+	require.Nil(t, rg.Deregister(info))
+	time.Sleep(30 * time.Millisecond)
+	require.Empty(t, deregistered)
+	require.Empty(t, backend.Instances(polarisDefaultNamespace, serviceName))
+	require.Equal(t, 2, backend.Calls(polaristest.OpRegister))
+}
+
+func TestSelfWatchRegisterRetry(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rg, deregistered := newSelfWatchRegistry(backend,
+		WithRegisterRetry(0, func(attempt int) time.Duration { return 5 * time.Millisecond }))
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	id := backend.Instances(polarisDefaultNamespace, serviceName)[0].ID
+
+	backend.SetFailureRate(polaristest.OpRegister, 1)
+	backend.RemoveInstances(polarisDefaultNamespace, serviceName, id)
+	select {
+	case <-deregistered:
+	case <-time.After(time.Second):
+		t.Fatal("external deregistration not detected")
+	}
+	require.Eventually(t, func() bool { return backend.Calls(polaristest.OpRegister) >= 3 }, time.Second, time.Millisecond)
+	require.Equal(t, StateDegraded, rg.CurrentState())
+
+	backend.SetFailureRate(polaristest.OpRegister, 0)
+	require.Eventually(t, func() bool {
+		return len(backend.Instances(polarisDefaultNamespace, serviceName)) == 1
+	}, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return rg.CurrentState() == StateRegistered }, time.Second, time.Millisecond)
+}
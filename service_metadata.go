@@ -0,0 +1,136 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const defaultServiceMetadataTTL = 30 * time.Second
+
+// ServiceMetadataResolver is the extension interface of the resolvers reading the service level metadata
+// of polaris, the Resolvers of this package implement it:
+//
+//	metadata, err := resolver.(polaris.ServiceMetadataResolver).ServiceMetadata(ctx, desc)
+type ServiceMetadataResolver interface {
+	// ServiceMetadata returns the service level metadata of the service, like owner or tier.
+	ServiceMetadata(ctx context.Context, desc string) (map[string]string, error)
+}
+
+var (
+	_ ServiceMetadataResolver = (*polarisResolver)(nil)
+	_ ServiceMetadataResolver = (*lazyResolver)(nil)
+	_ ServiceMetadataResolver = (*multiClusterResolver)(nil)
+)
+
+type serviceMetadataEntry struct {
+	metadata map[string]string
+	expireAt time.Time
+}
+
+// serviceMetadataCache caches the service level metadata of polaris services for a TTL,
+// entries of watched services are also invalidated on every service change event.
+type serviceMetadataCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	lock    sync.Mutex
+	entries map[model.ServiceKey]serviceMetadataEntry
+}
+
+func newServiceMetadataCache(o *options) *serviceMetadataCache {
+	ttl := o.serviceMetadataTTL
+	if ttl <= 0 {
+		ttl = defaultServiceMetadataTTL
+	}
+	return &serviceMetadataCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[model.ServiceKey]serviceMetadataEntry),
+	}
+}
+
+func (c *serviceMetadataCache) get(key model.ServiceKey) (map[string]string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expireAt) {
+		return nil, false
+	}
+	return entry.metadata, true
+}
+
+func (c *serviceMetadataCache) set(key model.ServiceKey, metadata map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = serviceMetadataEntry{metadata: metadata, expireAt: c.now().Add(c.ttl)}
+}
+
+func (c *serviceMetadataCache) invalidate(key model.ServiceKey) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+}
+
+// ServiceMetadata implements the ServiceMetadataResolver interface.
+func (polaris *polarisResolver) ServiceMetadata(ctx context.Context, desc string) (map[string]string, error) {
+	info, err := polaris.decodeDescription(desc)
+	if err != nil {
+		return nil, err
+	}
+	namespace, serviceName := info.Namespace, info.Service
+	key := model.ServiceKey{Namespace: namespace, Service: serviceName}
+	if metadata, ok := polaris.serviceMetadata.get(key); ok {
+		return copyMetadata(metadata), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req := &api.GetAllInstancesRequest{}
+	req.Namespace = namespace
+	req.Service = serviceName
+	resp, err := polaris.consumerAPI().GetAllInstances(req)
+	if err != nil {
+		return nil, perrors.WithMessagef(err, "get service metadata of %s", desc)
+	}
+	metadata := copyMetadata(resp.Metadata)
+	polaris.serviceMetadata.set(key, metadata)
+	return copyMetadata(metadata), nil
+}
+
+// serviceMetadataOf returns the service metadata of desc from resolver,
+// ErrServiceMetadataUnsupported when it is not a ServiceMetadataResolver.
+func serviceMetadataOf(ctx context.Context, resolver Resolver, desc string) (map[string]string, error) {
+	r, ok := resolver.(ServiceMetadataResolver)
+	if !ok {
+		return nil, ErrServiceMetadataUnsupported
+	}
+	return r.ServiceMetadata(ctx, desc)
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	return copied
+}
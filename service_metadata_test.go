@@ -0,0 +1,97 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceMetadataTTL(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{"owner": "infra", "tier": "1"})
+	rs := newTestResolver(backend, WithServiceMetadataTTL(time.Minute))
+	now := time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC)
+	rs.serviceMetadata.now = func() time.Time { return now }
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	metadata, err := rs.ServiceMetadata(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{"owner": "infra", "tier": "1"}, metadata)
+	metadata["tier"] = "modified"
+
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{"owner": "infra", "tier": "2"})
+	metadata, err = rs.ServiceMetadata(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, "1", metadata["tier"], "cached until the TTL expires")
+	require.Equal(t, 1, backend.Calls(polaristest.OpGetAllInstances))
+
+	now = now.Add(time.Minute)
+	metadata, err = rs.ServiceMetadata(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, "2", metadata["tier"])
+	require.Equal(t, 2, backend.Calls(polaristest.OpGetAllInstances))
+}
+
+func TestServiceMetadataInvalidatedByEvents(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{"tier": "1"})
+	rs := newTestResolver(backend, WithServiceMetadataTTL(time.Hour))
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	received := make(chan struct{}, 1)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { received <- struct{}{} })
+	require.Nil(t, err)
+	defer unsubscribe()
+	metadata, err := rs.ServiceMetadata(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, "1", metadata["tier"])
+
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{"tier": "2"})
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+	metadata, err = rs.ServiceMetadata(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, "2", metadata["tier"])
+}
+
+func TestServiceMetadataResolverExtension(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{"tier": "1"})
+	var resolver Resolver = newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	metadata, err := resolver.(ServiceMetadataResolver).ServiceMetadata(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, "1", metadata["tier"])
+
+	// a Resolver implemented outside of the package needs no ServiceMetadata.
+	var wrapped Resolver = struct{ Resolver }{resolver}
+	_, ok := wrapped.(ServiceMetadataResolver)
+	require.False(t, ok)
+	_, err = serviceMetadataOf(context.TODO(), wrapped, desc)
+	require.ErrorIs(t, err, ErrServiceMetadataUnsupported)
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// trackedService is the per-service state of a resolver, the descriptions of the service used so far.
+type trackedService struct {
+	key   model.ServiceKey
+	descs map[string]struct{}
+}
+
+// serviceTracker orders the services by last use for WithMaxTrackedServices, the zero value is empty.
+type serviceTracker struct {
+	lock     sync.Mutex
+	order    *list.List // of *trackedService, the most recently used first
+	services map[model.ServiceKey]*list.Element
+}
+
+// touch marks desc of key as used and returns the least recently used services over max, busy services
+// are skipped. Nothing is tracked when max is not positive.
+func (t *serviceTracker) touch(key model.ServiceKey, desc string, max int, busy func(key model.ServiceKey) bool) []*trackedService {
+	if max <= 0 {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.order == nil {
+		t.order = list.New()
+		t.services = make(map[model.ServiceKey]*list.Element)
+	}
+	e, ok := t.services[key]
+	if ok {
+		t.order.MoveToFront(e)
+	} else {
+		e = t.order.PushFront(&trackedService{key: key, descs: make(map[string]struct{})})
+		t.services[key] = e
+	}
+	e.Value.(*trackedService).descs[desc] = struct{}{}
+
+	var evicted []*trackedService
+	for e := t.order.Back(); e != nil && t.order.Len()-len(evicted) > max; e = e.Prev() {
+		service := e.Value.(*trackedService)
+		if service.key == key || busy(service.key) {
+			continue
+		}
+		evicted = append(evicted, service)
+	}
+	for _, service := range evicted {
+		t.order.Remove(t.services[service.key])
+		delete(t.services, service.key)
+	}
+	return evicted
+}
+
+// len returns how many services are tracked.
+func (t *serviceTracker) len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.services)
+}
+
+// track records the use of desc and evicts the state of the least recently used services over
+// WithMaxTrackedServices. Services with Subscribe listeners or running watches are kept.
+func (polaris *polarisResolver) track(key model.ServiceKey, desc string) {
+	for _, service := range polaris.tracked.touch(key, desc, polaris.opts.maxTrackedServices, polaris.busy) {
+		polaris.evict(service)
+	}
+}
+
+// busy reports whether a service has Subscribe listeners or running watches.
+func (polaris *polarisResolver) busy(key model.ServiceKey) bool {
+	polaris.listenerLock.Lock()
+	for _, hub := range polaris.hubs {
+		if hub.sw.key == key {
+			polaris.listenerLock.Unlock()
+			return true
+		}
+	}
+	polaris.listenerLock.Unlock()
+	return polaris.watcher.watched(key)
+}
+
+// evict drops the conversion caches, route traces, service metadata and error log state of a service and
+// makes its subscription dormant. polaris-go has no way to cancel a WatchService, the events of a dormant
+// subscription are discarded until the service is used again.
+func (polaris *polarisResolver) evict(service *trackedService) {
+	for desc := range service.descs {
+		polaris.caches.Delete(desc)
+		polaris.routeTraces.Delete(desc)
+		polaris.watchDelivered.forget(desc)
+	}
+	polaris.serviceMetadata.invalidate(service.key)
+	polaris.errorLogs.forget(service.key)
+	polaris.watcher.sleep(service.key)
+	atomic.AddUint64(&polaris.evictedServices, 1)
+	log.GetBaseLogger().Infof("[Polaris resolver] evicted the state of %s, the least recently used service", service.key)
+}
+
+// EvictedServices implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) EvictedServices() uint64 {
+	return atomic.LoadUint64(&polaris.evictedServices)
+}
+
+// watched reports whether the subscription of key has waiters.
+func (m *watchManager) watched(key model.ServiceKey) bool {
+	m.lock.Lock()
+	sw, ok := m.watches[key]
+	m.lock.Unlock()
+	if !ok {
+		return false
+	}
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	return len(sw.waiters) > 0
+}
+
+// sleep makes the subscription of key dormant unless it has waiters: its revisions and draining instances
+// are dropped and its events discarded. The next subscribe wakes it with a new snapshot.
+func (m *watchManager) sleep(key model.ServiceKey) {
+	m.lock.Lock()
+	sw, ok := m.watches[key]
+	m.lock.Unlock()
+	if !ok {
+		return
+	}
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if len(sw.waiters) > 0 {
+		return
+	}
+	sw.dormant = true
+	sw.revisions = nil
+	sw.revision = ""
+	if sw.grace != nil {
+		sw.grace.reset()
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func newTrackedBackend(services ...string) *polaristest.Backend {
+	backend := polaristest.NewBackend()
+	for _, service := range services {
+		backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: service, Host: "127.0.0.1", Port: 6666})
+	}
+	return backend
+}
+
+func TestMaxTrackedServices(t *testing.T) {
+	backend := newTrackedBackend("a", "b", "c")
+	rs := newTestResolver(backend, WithMaxTrackedServices(2))
+	defer rs.Close()
+	descA := polarisDefaultNamespace + ":a"
+	changes := make(chan discovery.Change, 8)
+	unsubscribe, err := rs.Subscribe(descA, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	<-changes
+	unsubscribe()
+	_, err = rs.Resolve(context.Background(), descA)
+	require.Nil(t, err)
+	_, ok := rs.caches.Load(descA)
+	require.True(t, ok)
+
+	for _, service := range []string{"b", "c"} {
+		_, err = rs.Resolve(context.Background(), polarisDefaultNamespace+":"+service)
+		require.Nil(t, err)
+	}
+	require.Equal(t, uint64(1), rs.EvictedServices())
+	require.Equal(t, 2, rs.tracked.len())
+	_, ok = rs.caches.Load(descA)
+	require.False(t, ok)
+	sw := rs.watcher.serviceWatch(model.ServiceKey{Namespace: polarisDefaultNamespace, Service: "a"})
+	sw.lock.Lock()
+	require.True(t, sw.dormant)
+	sw.lock.Unlock()
+	_, ok = rs.LastRevision(descA)
+	require.False(t, ok)
+
+	// the subscription of the evicted service is created again on its next use.
+	watches := backend.Calls(polaristest.OpWatchService)
+	unsubscribe, err = rs.Subscribe(descA, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	require.Equal(t, watches+1, backend.Calls(polaristest.OpWatchService))
+	require.Len(t, (<-changes).Result.Instances, 1)
+	_, ok = rs.LastRevision(descA)
+	require.True(t, ok)
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: "a", Host: "127.0.0.2", Port: 6666})
+	select {
+	case change := <-changes:
+		require.Len(t, change.Added, 1)
+	case <-time.After(time.Second):
+		t.Fatal("no change after the subscription was recreated")
+	}
+	require.Equal(t, uint64(2), rs.EvictedServices())
+}
+
+func TestMaxTrackedServicesKeepsBusy(t *testing.T) {
+	backend := newTrackedBackend("a", "b", "c")
+	rs := newTestResolver(backend, WithMaxTrackedServices(2))
+	defer rs.Close()
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":a", func(discovery.Change) {})
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	for _, service := range []string{"b", "c"} {
+		_, err = rs.Resolve(context.Background(), polarisDefaultNamespace+":"+service)
+		require.Nil(t, err)
+	}
+	require.Equal(t, uint64(1), rs.EvictedServices())
+	_, ok := rs.caches.Load(polarisDefaultNamespace + ":b")
+	require.False(t, ok)
+	_, ok = rs.caches.Load(polarisDefaultNamespace + ":c")
+	require.True(t, ok)
+}
+
+func TestUnboundedTrackedServices(t *testing.T) {
+	rs := newTestResolver(newTrackedBackend("a", "b"))
+	defer rs.Close()
+	for _, service := range []string{"a", "b"} {
+		_, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+service)
+		require.Nil(t, err)
+	}
+	require.Equal(t, 0, rs.tracked.len())
+	require.Equal(t, uint64(0), rs.EvictedServices())
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
+
+const (
+	// MetadataSetName is the instance metadata key of the polaris set, a logical group like "app.sz.1".
+	MetadataSetName = "internal-set-name"
+	// TagSetName is the tag carrying the set name of an instance, for set-aware balancers.
+	TagSetName = "set_name"
+)
+
+// instanceSetName returns the set name of an instance from the metadata keys of WithSetMetadataKeys,
+// then MetadataSetName, then the logic set of the instance.
+func instanceSetName(metadata map[string]string, logicSet string, keys []string) string {
+	for _, key := range keys {
+		if set := metadata[key]; set != "" {
+			return set
+		}
+	}
+	if set := metadata[MetadataSetName]; set != "" {
+		return set
+	}
+	return logicSet
+}
+
+// newSetFilter keeps the instances of the set, unless none matches. The instances without set are kept
+// instead when strict is false, the strict filter keeps nothing.
+func newSetFilter(set string, strict bool) instanceFilter {
+	name := "set(" + set + ")"
+	if strict {
+		name = "set(" + set + ",strict)"
+	}
+	return instanceFilter{
+		name: name,
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			var matched, unset []discovery.Instance
+			for _, ins := range instances {
+				value, ok := ins.Tag(TagSetName)
+				if value == set {
+					matched = append(matched, ins)
+				} else if !ok || value == "" {
+					unset = append(unset, ins)
+				}
+			}
+			if len(matched) > 0 || strict {
+				return matched
+			}
+			return unset
+		},
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newSetBackend() *polaristest.Backend {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{MetadataSetName: "app.sz.1"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.2", Port: 6666,
+			LogicSet: "app.sz.2"},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.3", Port: 6666},
+	)
+	return backend
+}
+
+func TestSetName(t *testing.T) {
+	rs := newTestResolver(newSetBackend())
+	result, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	sets := make(map[string]string)
+	for _, ins := range result.Instances {
+		sets[ins.Address().String()], _ = ins.Tag(TagSetName)
+	}
+	require.Equal(t, map[string]string{"127.0.0.1:6666": "app.sz.1", "127.0.0.2:6666": "app.sz.2", "127.0.0.3:6666": ""}, sets)
+
+	// the keys of WithSetMetadataKeys come first and survive the metadata passthrough.
+	instance := &polaristest.Instance{LogicSet: "app.sz.2", Metadata: map[string]string{"set": "app.sh.1", "other": "x"}}
+	o := newOptions([]Option{WithSetMetadataKeys("set"), WithMetadataTagPrefixPassthrough(nil)})
+	ins := o.instanceConversion().convert(instance)
+	set, _ := ins.Tag(TagSetName)
+	require.Equal(t, "app.sh.1", set)
+	_, ok := ins.Tag("set")
+	require.True(t, ok)
+	_, ok = ins.Tag("other")
+	require.False(t, ok)
+}
+
+func TestSetFilter(t *testing.T) {
+	desc := polarisDefaultNamespace + ":" + serviceName
+	rs := newTestResolver(newSetBackend(), WithSetFilter("app.sz.2"))
+	result, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.2:6666"}, addrs(result.Instances))
+
+	// an empty set falls back to the instances without set.
+	rs = newTestResolver(newSetBackend(), WithSetFilter("app.sz.3"))
+	result, err = rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.3:6666"}, addrs(result.Instances))
+}
+
+func TestStrictSetFilter(t *testing.T) {
+	rs := newTestResolver(newSetBackend(), WithSetFilter("app.sz.3"), WithStrictSetFilter(true))
+	_, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	noInstance, ok := err.(*NoInstanceError)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, 3, noInstance.TotalFromPolaris)
+	require.Equal(t, []string{"set(app.sz.3,strict)"}, noInstance.Filters)
+}
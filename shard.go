@@ -0,0 +1,104 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
+
+const (
+	// DefaultShardMetadataKey is the instance metadata key of the shard of an instance, see CtxWithShard.
+	DefaultShardMetadataKey = "shard"
+	// shardTagKey is the description tag carrying the shard of a call.
+	shardTagKey = "polaris.shard"
+)
+
+type shardKey struct{}
+
+// CtxWithShard returns a context resolving only the instances of shard for the calls made with it, e.g.
+// the shard computed from the key of the request of a partitioned storage. The shard of an instance is
+// its DefaultShardMetadataKey metadata, see WithShardMetadataKey. Kitex keeps one balancer per description,
+// so Target appends the shard to the description of the call. An empty shard resolves every instance.
+func CtxWithShard(ctx context.Context, shard string) context.Context {
+	return context.WithValue(ctx, shardKey{}, shard)
+}
+
+// shard returns the shard of a call set by CtxWithShard.
+func shard(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	value, _ := ctx.Value(shardKey{}).(string)
+	return value
+}
+
+// shardTags returns the shard of a call as a description tag.
+func shardTags(ctx context.Context) []TargetTag {
+	if value := shard(ctx); value != "" {
+		return []TargetTag{{Key: shardTagKey, Value: value}}
+	}
+	return nil
+}
+
+// hasShard reports whether the description tags carry a shard.
+func hasShard(tags []TargetTag) bool {
+	for _, tag := range tags {
+		if tag.Key == shardTagKey {
+			return true
+		}
+	}
+	return false
+}
+
+// shardMetadataKey returns the metadata key set by WithShardMetadataKey or DefaultShardMetadataKey.
+func (o *options) shardMetadataKey() string {
+	if o.shardMetadata != "" {
+		return o.shardMetadata
+	}
+	return DefaultShardMetadataKey
+}
+
+// newShardFilter keeps the instances of the shard. When the shard has no instance every instance is
+// kept, unless strict is set, since a strict shard must never be served by the instances of another one.
+func newShardFilter(key, shard string, strict bool) instanceFilter {
+	name := "shard(" + key + "=" + shard + ")"
+	if strict {
+		name = "shard(" + key + "=" + shard + ",strict)"
+	}
+	return instanceFilter{
+		name: name,
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			matched := filterShard(key, shard, instances)
+			if len(matched) > 0 || strict {
+				return matched
+			}
+			return instances
+		},
+	}
+}
+
+func filterShard(key, shard string, instances []discovery.Instance) []discovery.Instance {
+	var matched []discovery.Instance
+	for _, ins := range instances {
+		if value, ok := ins.Tag(key); ok && value == shard {
+			matched = append(matched, ins)
+		}
+	}
+	return matched
+}
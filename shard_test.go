@@ -0,0 +1,130 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newShardedBackend() *polaristest.Backend {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{DefaultShardMetadataKey: "0"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6667,
+			Metadata: map[string]string{DefaultShardMetadataKey: "0"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777,
+			Metadata: map[string]string{DefaultShardMetadataKey: "1"}},
+	)
+	return backend
+}
+
+func TestShard(t *testing.T) {
+	rs := newTestResolver(newShardedBackend())
+	to := rpcinfo.NewEndpointInfo(serviceName, "echo", nil, nil)
+
+	shard0 := CtxWithShard(context.Background(), "0")
+	desc := rs.Target(shard0, to)
+	require.Equal(t, "default:registry-test?polaris.shard=0", desc)
+	result, err := rs.Resolve(shard0, desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666", "127.0.0.1:6667"}, addrs(result.Instances))
+	require.Equal(t, desc, result.CacheKey)
+
+	shard1 := CtxWithShard(context.Background(), "1")
+	desc = rs.Target(shard1, to)
+	require.Equal(t, "default:registry-test?polaris.shard=1", desc)
+	result, err = rs.Resolve(shard1, desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(result.Instances))
+
+	change, err := rs.Watcher(shard1, desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Result.Instances))
+}
+
+func TestShardCacheKey(t *testing.T) {
+	rs := newTestResolver(newShardedBackend())
+	to := rpcinfo.NewEndpointInfo(serviceName, "echo", nil, nil)
+
+	descs := map[string]struct{}{}
+	for _, ctx := range []context.Context{
+		context.Background(),
+		CtxWithShard(context.Background(), ""),
+		CtxWithShard(context.Background(), "0"),
+		CtxWithShard(context.Background(), "1"),
+		CtxWithShard(context.Background(), "0"),
+	} {
+		descs[rs.Target(ctx, to)] = struct{}{}
+	}
+	require.Equal(t, map[string]struct{}{
+		"default:registry-test":                 {},
+		"default:registry-test?polaris.shard=0": {},
+		"default:registry-test?polaris.shard=1": {},
+	}, descs)
+
+	// the shard is part of the key of the cached descriptions.
+	require.Equal(t, "default:registry-test?polaris.shard=1", rs.Target(CtxWithShard(context.Background(), "1"), to))
+	require.Equal(t, "default:registry-test", rs.Target(context.Background(), to))
+}
+
+func TestShardMissing(t *testing.T) {
+	ctx := CtxWithShard(context.Background(), "2")
+	to := rpcinfo.NewEndpointInfo(serviceName, "echo", nil, nil)
+
+	// every shard serves a missing shard unless the shard is strict.
+	rs := newTestResolver(newShardedBackend())
+	result, err := rs.Resolve(ctx, rs.Target(ctx, to))
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+
+	rs = newTestResolver(newShardedBackend(), WithStrictShard(true),
+		WithStaticFallback(polarisDefaultNamespace+":"+serviceName, []string{"10.0.0.1:8888"}))
+	_, err = rs.Resolve(ctx, rs.Target(ctx, to))
+	var noInstance *NoInstanceError
+	require.True(t, errors.As(err, &noInstance))
+	require.Equal(t, 3, noInstance.TotalFromPolaris)
+	require.Equal(t, []string{"shard(shard=2,strict)"}, noInstance.Filters)
+	require.Zero(t, rs.StaticFallbacks())
+
+	change, err := rs.Watcher(ctx, rs.Target(ctx, to))
+	require.Nil(t, err)
+	require.Empty(t, change.Result.Instances)
+}
+
+func TestShardMetadataKey(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{"partition": "a"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777,
+			Metadata: map[string]string{"partition": "b"}},
+	)
+	// the shard key is copied into the tags whatever the metadata passthrough.
+	rs := newTestResolver(backend, WithShardMetadataKey("partition"), WithStrictShard(true),
+		WithMetadataTagPrefixPassthrough([]string{MetadataConnPrefix}))
+	ctx := CtxWithShard(context.Background(), "b")
+	result, err := rs.Resolve(ctx, rs.Target(ctx, rpcinfo.NewEndpointInfo(serviceName, "echo", nil, nil)))
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:7777"}, addrs(result.Instances))
+}
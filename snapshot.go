@@ -0,0 +1,309 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	// SnapshotVersionJSON is the version of the snapshots of JSONSnapshotCodec.
+	SnapshotVersionJSON = 1
+	// SnapshotVersionGob is the version of the snapshots of GobSnapshotCodec.
+	SnapshotVersionGob = 2
+
+	// snapshotHeader starts the snapshot files, followed by the version and a newline, so that the codec
+	// is known before decoding.
+	snapshotHeader     = "polaris-snapshot "
+	snapshotFileSuffix = ".snapshot"
+	// TagFallback value of the instances served from a snapshot.
+	snapshotFallbackTag = "snapshot"
+)
+
+// Snapshot is the versioned envelope of the instances of a description persisted by WithFallbackSnapshots.
+type Snapshot struct {
+	Version   int                `json:"version"`
+	WrittenAt time.Time          `json:"written_at"`
+	Service   string             `json:"service"` // the description
+	Instances []SnapshotInstance `json:"instances"`
+}
+
+// SnapshotInstance is a polaris instance of a Snapshot.
+type SnapshotInstance struct {
+	ID       string            `json:"id,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	Host     string            `json:"host"`
+	Port     uint32            `json:"port"`
+	Weight   int               `json:"weight"`
+	LogicSet string            `json:"logic_set,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// SnapshotCodec serializes the snapshots of one version, see WithSnapshotCodec.
+type SnapshotCodec interface {
+	Version() int
+	Encode(snapshot *Snapshot) ([]byte, error)
+	Decode(data []byte) (*Snapshot, error)
+}
+
+// JSONSnapshotCodec is the readable SnapshotCodec of SnapshotVersionJSON, the default one.
+type JSONSnapshotCodec struct{}
+
+// Version implements the SnapshotCodec interface.
+func (JSONSnapshotCodec) Version() int { return SnapshotVersionJSON }
+
+// Encode implements the SnapshotCodec interface.
+func (JSONSnapshotCodec) Encode(snapshot *Snapshot) ([]byte, error) {
+	return json.Marshal(snapshot)
+}
+
+// Decode implements the SnapshotCodec interface.
+func (JSONSnapshotCodec) Decode(data []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// GobSnapshotCodec is the compact SnapshotCodec of SnapshotVersionGob, using encoding/gob.
+type GobSnapshotCodec struct{}
+
+// Version implements the SnapshotCodec interface.
+func (GobSnapshotCodec) Version() int { return SnapshotVersionGob }
+
+// Encode implements the SnapshotCodec interface.
+func (GobSnapshotCodec) Encode(snapshot *Snapshot) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements the SnapshotCodec interface.
+func (GobSnapshotCodec) Decode(data []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// encodeSnapshot encodes snapshot with codec behind the version header.
+func encodeSnapshot(codec SnapshotCodec, snapshot *Snapshot) ([]byte, error) {
+	snapshot.Version = codec.Version()
+	data, err := codec.Encode(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	header := snapshotHeader + strconv.Itoa(snapshot.Version) + "\n"
+	return append([]byte(header), data...), nil
+}
+
+// decodeSnapshot decodes the snapshot file of path with the codec of its version, a SnapshotVersionError
+// is returned when there is none.
+func decodeSnapshot(codecs map[int]SnapshotCodec, path string, data []byte) (*Snapshot, error) {
+	line := bytes.IndexByte(data, '\n')
+	if line < 0 || !bytes.HasPrefix(data, []byte(snapshotHeader)) {
+		return nil, perrors.Errorf("snapshot %s has no version header", path)
+	}
+	version, err := strconv.Atoi(string(data[len(snapshotHeader):line]))
+	if err != nil {
+		return nil, perrors.Wrapf(err, "snapshot %s has an invalid version", path)
+	}
+	codec, ok := codecs[version]
+	if !ok {
+		return nil, &SnapshotVersionError{Path: path, Version: version}
+	}
+	snapshot, err := codec.Decode(data[line+1:])
+	if err != nil {
+		return nil, perrors.Wrapf(err, "decode snapshot %s", path)
+	}
+	if snapshot.Version != version {
+		return nil, perrors.Errorf("snapshot %s has the version %d in a version %d file", path, snapshot.Version, version)
+	}
+	return snapshot, nil
+}
+
+// snapshotStore persists the polaris instances of the resolved descriptions, one file per description.
+type snapshotStore struct {
+	dir       string
+	codec     SnapshotCodec
+	codecs    map[int]SnapshotCodec
+	revisions sync.Map // desc -> the polaris revision last written
+}
+
+// newSnapshotStore returns the store of WithFallbackSnapshots, nil when it is not set.
+func newSnapshotStore(o *options) *snapshotStore {
+	if o.snapshotDir == "" {
+		return nil
+	}
+	codecs := map[int]SnapshotCodec{
+		SnapshotVersionJSON: JSONSnapshotCodec{},
+		SnapshotVersionGob:  GobSnapshotCodec{},
+	}
+	codec := o.snapshotCodec
+	if codec == nil {
+		codec = JSONSnapshotCodec{}
+	}
+	codecs[codec.Version()] = codec
+	return &snapshotStore{dir: o.snapshotDir, codec: codec, codecs: codecs}
+}
+
+func (s *snapshotStore) path(desc string) string {
+	return filepath.Join(s.dir, url.QueryEscape(desc)+snapshotFileSuffix)
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary file of the same directory.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// save writes the snapshot of desc when the revision changed since the last one, the file is replaced
+// atomically so that a crash never leaves a partial snapshot.
+func (s *snapshotStore) save(desc, revision string, instances []model.Instance) error {
+	if last, ok := s.revisions.Load(desc); ok && revision != "" && last.(string) == revision {
+		return nil
+	}
+	snapshot := &Snapshot{WrittenAt: time.Now(), Service: desc, Instances: make([]SnapshotInstance, 0, len(instances))}
+	for _, instance := range instances {
+		snapshot.Instances = append(snapshot.Instances, SnapshotInstance{
+			ID:       instance.GetId(),
+			Protocol: instance.GetProtocol(),
+			Host:     instance.GetHost(),
+			Port:     instance.GetPort(),
+			Weight:   instance.GetWeight(),
+			LogicSet: instance.GetLogicSet(),
+			Metadata: instance.GetMetadata(),
+		})
+	}
+	data, err := encodeSnapshot(s.codec, snapshot)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path(desc), data); err != nil {
+		return err
+	}
+	s.revisions.Store(desc, revision)
+	return nil
+}
+
+// load reads the snapshot of desc.
+func (s *snapshotStore) load(desc string) (*Snapshot, error) {
+	path := s.path(desc)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshot(s.codecs, path, data)
+}
+
+// convert converts the instances of a snapshot like the polaris ones, with the TagFallback tag.
+func (snapshot *Snapshot) convert(conv instanceConversion) []discovery.Instance {
+	instances := make([]discovery.Instance, 0, len(snapshot.Instances))
+	for _, ins := range snapshot.Instances {
+		tags := map[string]string{TagFallback: snapshotFallbackTag}
+		if ins.ID != "" {
+			tags[TagHashKey] = ins.ID
+		}
+		if set := instanceSetName(ins.Metadata, ins.LogicSet, conv.setKeys); set != "" {
+			tags[TagSetName] = set
+		}
+		for k, v := range ins.Metadata {
+			if conv.keep != nil && !conv.keep(k) {
+				continue
+			}
+			if _, ok := tags[k]; !ok {
+				tags[k] = v
+			}
+		}
+		weight := polarisWeight(ins.Weight, ins.Metadata, conv.defaultWeight)
+		addr := ins.Host + ":" + strconv.Itoa(int(ins.Port))
+		instances = append(instances, discovery.NewInstance(ins.Protocol, addr, weight, tags))
+	}
+	return instances
+}
+
+// saveSnapshot persists the instances polaris returned for desc, see WithFallbackSnapshots.
+func (polaris *polarisResolver) saveSnapshot(desc, revision string, instances []model.Instance) {
+	if polaris.snapshots == nil {
+		return
+	}
+	if err := polaris.snapshots.save(desc, revision, instances); err != nil {
+		log.GetBaseLogger().Warnf("[Polaris resolver] fail to save the snapshot of %s: %v", desc, err)
+	}
+}
+
+// snapshotFallback returns the non-cacheable result of the snapshot of desc with the filters of its
+// target tags applied, ok is false when there is no usable snapshot.
+func (polaris *polarisResolver) snapshotFallback(ctx context.Context, desc string, tags []TargetTag,
+	cause error) (discovery.Result, bool) {
+	if polaris.snapshots == nil {
+		return discovery.Result{}, false
+	}
+	snapshot, err := polaris.snapshots.load(desc)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.GetBaseLogger().Warnf("[Polaris resolver] ignore the snapshot of %s: %v", desc, err)
+		}
+		return discovery.Result{}, false
+	}
+	tags, _ = splitLocalityTags(tags, polaris.opts.localityLevels)
+	instances, _ := applyFilters(ctx, polaris.descriptionFilters(tags), snapshot.convert(polaris.opts.instanceConversion()), nil)
+	if len(instances) == 0 {
+		return discovery.Result{}, false
+	}
+	log.GetBaseLogger().Warnf("[Polaris resolver] using %d instances of %s from the snapshot written at %s: %v",
+		len(instances), desc, snapshot.WrittenAt.Format(time.RFC3339), cause)
+	return discovery.Result{
+		CacheKey:  desc,
+		Instances: adjustWeights(instances, polaris.opts),
+	}, true
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// installSnapshot copies a fixture of testdata as the snapshot of desc in the store of rs.
+func installSnapshot(t *testing.T, rs *polarisResolver, desc string, data []byte) {
+	require.Nil(t, os.MkdirAll(rs.snapshots.dir, 0o755))
+	require.Nil(t, os.WriteFile(rs.snapshots.path(desc), data, 0o644))
+}
+
+func TestSnapshotFixtures(t *testing.T) {
+	desc := polarisDefaultNamespace + ":" + serviceName
+	for _, fixture := range []string{"snapshot_v1.snapshot", "snapshot_v2.snapshot"} {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture))
+		require.Nil(t, err)
+		backend := polaristest.NewBackend()
+		backend.SetFailureRate(polaristest.OpGetInstances, 1)
+		rs := newTestResolver(backend, WithFallbackSnapshots(t.TempDir()))
+		installSnapshot(t, rs, desc, data)
+
+		snapshot, err := rs.snapshots.load(desc)
+		require.Nil(t, err, fixture)
+		require.Equal(t, desc, snapshot.Service, fixture)
+		require.True(t, time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC).Equal(snapshot.WrittenAt), fixture)
+
+		result, err := rs.Resolve(context.Background(), desc)
+		require.Nil(t, err, fixture)
+		require.False(t, result.Cacheable)
+		require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances), fixture)
+		require.Equal(t, 50, result.Instances[0].Weight())
+		for tag, want := range map[string]string{TagFallback: "snapshot", TagHashKey: "a", "env": "prod"} {
+			value, _ := result.Instances[0].Tag(tag)
+			require.Equal(t, want, value, fixture)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, codec := range []SnapshotCodec{JSONSnapshotCodec{}, GobSnapshotCodec{}} {
+		backend := polaristest.NewBackend()
+		backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName,
+			Host: "127.0.0.1", Port: 6666, Metadata: map[string]string{"env": "prod"}},
+			&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName,
+				Host: "127.0.0.2", Port: 6666, Metadata: map[string]string{"env": "dev"}})
+		rs := newTestResolver(backend, WithFallbackSnapshots(t.TempDir()), WithSnapshotCodec(codec),
+			WithTargetTagKeys("env"))
+		desc := polarisDefaultNamespace + ":" + serviceName + "?env=prod"
+		_, err := rs.Resolve(context.Background(), desc)
+		require.Nil(t, err)
+		snapshot, err := rs.snapshots.load(desc)
+		require.Nil(t, err)
+		require.Equal(t, codec.Version(), snapshot.Version)
+		require.Len(t, snapshot.Instances, 2)
+
+		// the snapshot is filtered by the target tags again when polaris fails.
+		backend.SetFailureRate(polaristest.OpGetInstances, 1)
+		result, err := rs.Resolve(context.Background(), desc)
+		require.Nil(t, err)
+		require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+	}
+}
+
+func TestSnapshotUnknownVersion(t *testing.T) {
+	desc := polarisDefaultNamespace + ":" + serviceName
+	backend := polaristest.NewBackend()
+	backend.SetFailureRate(polaristest.OpGetInstances, 1)
+	rs := newTestResolver(backend, WithFallbackSnapshots(t.TempDir()),
+		WithStaticFallback(desc, []string{"10.0.0.1:8888"}))
+	installSnapshot(t, rs, desc, []byte("polaris-snapshot 9\n{}"))
+
+	_, err := rs.snapshots.load(desc)
+	var versionErr *SnapshotVersionError
+	require.True(t, errors.As(err, &versionErr), "%v", err)
+	require.Equal(t, 9, versionErr.Version)
+
+	// the resolution falls through to the static fallback.
+	result, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1:8888"}, addrs(result.Instances))
+	fallback, _ := result.Instances[0].Tag(TagFallback)
+	require.Equal(t, "true", fallback)
+
+	// without static fallback the polaris error is returned.
+	rs.opts.staticFallbacks = nil
+	_, err = rs.Resolve(context.Background(), desc)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// TagFallback marks the instances of the static fallback list, see WithStaticFallback.
+const TagFallback = "fallback"
+
+// newStaticFallback converts host:port pairs into instances tagged with TagFallback, invalid pairs are dropped.
+func newStaticFallback(desc string, addrs []string) []discovery.Instance {
+	instances := make([]discovery.Instance, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			log.GetBaseLogger().Warnf("[Polaris resolver] drop static fallback %s of %s: %v", addr, desc, err)
+			continue
+		}
+		instances = append(instances, discovery.NewInstance("tcp", addr, defaultWeight, map[string]string{TagFallback: "true"}))
+	}
+	return instances
+}
+
+// staticFallback returns the non-cacheable result of the static fallback list of desc, ok is false
+// when there is none. The description is matched as is, then without its target tags.
+func (polaris *polarisResolver) staticFallback(desc string, info TargetInfo, cause error) (discovery.Result, bool) {
+	instances, ok := polaris.opts.staticFallbacks[desc]
+	if !ok && polaris.opts.shardStrict && hasShard(info.Tags) {
+		// the instances of the service belong to every shard.
+		return discovery.Result{}, false
+	}
+	if !ok {
+		instances, ok = polaris.opts.staticFallbacks[info.Namespace+descriptionSeparator+info.Service]
+	}
+	if !ok || len(instances) == 0 {
+		return discovery.Result{}, false
+	}
+	atomic.AddUint64(&polaris.staticFallbacks, 1)
+	log.GetBaseLogger().Warnf("[Polaris resolver] no instance of %s from polaris, using %d static fallback instances: %v",
+		desc, len(instances), cause)
+	return discovery.Result{
+		CacheKey:  desc,
+		Instances: append([]discovery.Instance(nil), instances...),
+	}, true
+}
+
+// StaticFallbacks implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) StaticFallbacks() uint64 {
+	return atomic.LoadUint64(&polaris.staticFallbacks)
+}
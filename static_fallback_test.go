@@ -0,0 +1,82 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticFallbackActivation(t *testing.T) {
+	desc := polarisDefaultNamespace + ":" + serviceName
+	rs := newTestResolver(polaristest.NewBackend(),
+		WithStaticFallback(desc, []string{"10.0.0.1:8888", "not an address", "10.0.0.2:8888"}))
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.False(t, result.Cacheable)
+	require.Equal(t, desc, result.CacheKey)
+	require.Len(t, result.Instances, 2)
+	for i, addr := range []string{"10.0.0.1:8888", "10.0.0.2:8888"} {
+		require.Equal(t, addr, result.Instances[i].Address().String())
+		require.Equal(t, defaultWeight, result.Instances[i].Weight())
+		fallback, ok := result.Instances[i].Tag(TagFallback)
+		require.True(t, ok)
+		require.Equal(t, "true", fallback)
+	}
+	require.Equal(t, uint64(1), rs.StaticFallbacks())
+
+	// the target tags are ignored when only the plain description has a fallback list.
+	_, err = rs.Resolve(context.TODO(), desc+"?env=prod")
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), rs.StaticFallbacks())
+}
+
+func TestStaticFallbackAfterFilters(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6666, Unhealthy: true})
+	desc := polarisDefaultNamespace + ":" + serviceName
+	rs := newTestResolver(backend, WithHealthyOnly(true), WithStaticFallback(desc, []string{"10.0.0.1:8888"}))
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.1:8888", result.Instances[0].Address().String())
+	require.Equal(t, uint64(1), rs.StaticFallbacks())
+}
+
+func TestStaticFallbackNotActivated(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	desc := polarisDefaultNamespace + ":" + serviceName
+	rs := newTestResolver(backend, WithStaticFallback(desc, []string{"10.0.0.1:8888"}))
+
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.True(t, result.Cacheable)
+	require.Len(t, result.Instances, 1)
+	_, ok := result.Instances[0].Tag(TagFallback)
+	require.False(t, ok)
+
+	// a service without fallback list still fails.
+	_, err = rs.Resolve(context.TODO(), polarisDefaultNamespace+":other")
+	require.NotNil(t, err)
+	require.Equal(t, uint64(0), rs.StaticFallbacks())
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemServiceMode(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: SystemNamespace, Service: "polaris.checker", Host: "127.0.0.1", Port: 8081})
+	consumer := &recordingConsumer{Backend: backend}
+	rs := newTestResolver(backend, WithSystemServiceMode(true))
+	rs.consumer = consumer
+
+	desc := rs.Target(context.Background(), rpcinfo.NewEndpointInfo("polaris.checker", "", nil, nil))
+	require.Equal(t, "Polaris:polaris.checker", desc)
+	result, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	_, err = rs.discover(context.Background(), SystemNamespace, "polaris.checker")
+	require.Nil(t, err)
+	require.Len(t, consumer.requests, 2)
+	for _, req := range consumer.requests {
+		require.Equal(t, SystemNamespace, req.Namespace)
+		require.True(t, req.SkipRouteFilter)
+	}
+
+	// an explicit namespace is kept.
+	rs = newTestResolver(backend, WithSystemServiceMode(true), WithNamespace("Production"))
+	require.Equal(t, "Production:user.api", rs.Target(context.Background(), rpcinfo.NewEndpointInfo("user.api", "", nil, nil)))
+}
+
+func TestNormalServiceMode(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	consumer := &recordingConsumer{Backend: backend}
+	rs := newTestResolver(backend)
+	rs.consumer = consumer
+
+	desc := rs.Target(context.Background(), rpcinfo.NewEndpointInfo(serviceName, "", nil, nil))
+	require.Equal(t, polarisDefaultNamespace+":"+serviceName, desc)
+	_, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	_, err = rs.discover(context.Background(), polarisDefaultNamespace, serviceName)
+	require.Nil(t, err)
+	require.Len(t, consumer.requests, 2)
+	for _, req := range consumer.requests {
+		require.Equal(t, polarisDefaultNamespace, req.Namespace)
+		require.False(t, req.SkipRouteFilter)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+)
+
+// maxCachedTargets bounds the descriptions cached by Target, the targets past it are described on every call,
+// which only happens with unbounded tag values like per request routing labels.
+const maxCachedTargets = 4096
+
+// targetKey identifies the description of a target by the values Target reads from it, not by the
+// EndpointInfo, whose tags may change between calls.
+type targetKey struct {
+	service      string
+	namespace    string // the namespace tag
+	hasNamespace bool
+	tags         string // the other tags, routing labels, canary and shard, length prefixed, empty without any
+}
+
+// targetKeyBuffers pools the buffers the tags of a targetKey are built in.
+var targetKeyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 64)
+		return &buf
+	},
+}
+
+// targetKey returns the cache key of the description of target.
+func (polaris *polarisResolver) targetKey(ctx context.Context, target rpcinfo.EndpointInfo) targetKey {
+	key := targetKey{service: target.ServiceName()}
+	key.namespace, key.hasNamespace = target.Tag(namespaceTagKey)
+	labels := routeLabelTags(ctx)
+	canary, shard := polaris.canary(ctx), shard(ctx)
+	if len(polaris.opts.targetTagKeys) == 0 && len(labels) == 0 && canary == "" && shard == "" {
+		return key
+	}
+	bufp := targetKeyBuffers.Get().(*[]byte)
+	buf := (*bufp)[:0]
+	for _, tagKey := range polaris.opts.targetTagKeys {
+		if tagKey == namespaceTagKey {
+			continue
+		}
+		if value, ok := target.Tag(tagKey); ok {
+			buf = appendKeyPart(buf, value)
+		} else {
+			buf = append(buf, '-')
+		}
+	}
+	for _, label := range labels {
+		buf = appendKeyPart(appendKeyPart(buf, label.Key), label.Value)
+	}
+	buf = appendKeyPart(appendKeyPart(buf, canary), shard)
+	key.tags = string(buf)
+	*bufp = buf
+	targetKeyBuffers.Put(bufp)
+	return key
+}
+
+func appendKeyPart(buf []byte, part string) []byte {
+	buf = strconv.AppendInt(buf, int64(len(part)), 10)
+	buf = append(buf, ':')
+	return append(buf, part...)
+}
+
+// cachedTarget returns the cached description of key.
+func (polaris *polarisResolver) cachedTarget(key targetKey) (string, bool) {
+	desc, ok := polaris.targets.Load(key)
+	if !ok {
+		return "", false
+	}
+	return desc.(string), true
+}
+
+// cacheTarget caches the description of key until maxCachedTargets descriptions are cached.
+func (polaris *polarisResolver) cacheTarget(key targetKey, desc string) {
+	if atomic.LoadInt32(&polaris.cachedTargets) >= maxCachedTargets {
+		return
+	}
+	if _, loaded := polaris.targets.LoadOrStore(key, desc); !loaded {
+		atomic.AddInt32(&polaris.cachedTargets, 1)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetCacheTagChanges(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend(), WithTargetTagKeys(namespaceTagKey, "env"))
+	to := rpcinfo.NewEndpointInfo("user.api", "echo", nil, map[string]string{"env": "prod"})
+	mutable := rpcinfo.AsMutableEndpointInfo(to)
+	ctx := context.Background()
+
+	require.Equal(t, "default:user.api?env=prod", rs.Target(ctx, to))
+	require.Equal(t, "default:user.api?env=prod", rs.Target(ctx, to))
+	require.Nil(t, mutable.SetTag("env", "pre"))
+	require.Equal(t, "default:user.api?env=pre", rs.Target(ctx, to))
+	require.Nil(t, mutable.SetTag(namespaceTagKey, "Production"))
+	require.Equal(t, "Production:user.api?env=pre", rs.Target(ctx, to))
+	// an empty tag differs from a missing one.
+	require.Nil(t, mutable.SetTag("env", ""))
+	require.Equal(t, "Production:user.api?env=", rs.Target(ctx, to))
+	require.Equal(t, "default:user.api", rs.Target(ctx, rpcinfo.NewEndpointInfo("user.api", "echo", nil, nil)))
+
+	// the routing labels and the canary of the context are part of the key.
+	require.Equal(t, "Production:user.api?env=&route-label.lane=blue", rs.Target(context.WithValue(ctx, routeLabelsKey{}, map[string]string{"lane": "blue"}), to))
+	require.Equal(t, "Production:user.api?env=&route-label.lane=green", rs.Target(context.WithValue(ctx, routeLabelsKey{}, map[string]string{"lane": "green"}), to))
+	require.Equal(t, "Production:user.api?env=&polaris.canary=1.2.0", rs.Target(CtxWithCanary(ctx, "1.2.0"), to))
+	require.Equal(t, "Production:user.api?env=", rs.Target(ctx, to))
+}
+
+func TestTargetCacheBounded(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend(), WithTargetTagKeys("env"))
+	for i := 0; i < maxCachedTargets+10; i++ {
+		env := string(rune('a'+i%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i/676%26))
+		require.Equal(t, "default:user.api?env="+env,
+			rs.Target(context.Background(), rpcinfo.NewEndpointInfo("user.api", "", nil, map[string]string{"env": env})))
+	}
+	require.Equal(t, int32(maxCachedTargets), rs.cachedTargets)
+
+	// the invalid targets are never cached.
+	rs = newTestResolver(polaristest.NewBackend())
+	rs.Target(context.Background(), rpcinfo.NewEndpointInfo(" ", "", nil, nil))
+	require.Zero(t, rs.cachedTargets)
+}
+
+// Target used to build the description on every call, on the same machine:
+//
+//	BenchmarkTarget/namespace      260 ns/op    40 B/op    2 allocs/op
+//	BenchmarkTarget/tags           720 ns/op   144 B/op    5 allocs/op
+//
+// with the cache:
+//
+//	BenchmarkTarget/namespace      130 ns/op     0 B/op    0 allocs/op
+//	BenchmarkTarget/tags           350 ns/op    16 B/op    1 allocs/op
+func BenchmarkTarget(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		rs   *polarisResolver
+		tags map[string]string
+	}{
+		{"namespace", newTestResolver(polaristest.NewBackend()), map[string]string{namespaceTagKey: "Production"}},
+		{"tags", newTestResolver(polaristest.NewBackend(), WithTargetTagKeys("env", "idc")),
+			map[string]string{"env": "prod", "idc": "sh"}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			to := rpcinfo.NewEndpointInfo("user.api", "echo", nil, bm.tags)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bm.rs.Target(context.Background(), to)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/polarismesh/polaris-go/pkg/log"
+)
+
+// Service metadata keys of the recommended client timeouts, "timeout.<method>" overrides "timeout.default".
+// The values are durations like "300ms".
+const (
+	MetadataTimeoutPrefix  = "timeout."
+	MetadataTimeoutDefault = MetadataTimeoutPrefix + "default"
+)
+
+// NewMetadataTimeoutMiddleware returns a client middleware applying the RPC timeout recommended by the
+// service metadata of the callee unless the call already has one, set by client.WithRPCTimeout or
+// callopt.WithRPCTimeout. The metadata is read through ServiceMetadataResolver, whose cache is
+// invalidated by the watches, so that changes apply without restart. A resolver without it sets no
+// timeout. The Kitex timeout middleware runs before the user ones, the call is bounded by a deadline of
+// this middleware too.
+//
+//	client.WithMiddleware(polaris.NewMetadataTimeoutMiddleware(resolver))
+func NewMetadataTimeoutMiddleware(resolver Resolver) endpoint.Middleware {
+	var invalid sync.Map // the invalid metadata values already logged
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request, response interface{}) error {
+			ri := rpcinfo.GetRPCInfo(ctx)
+			if ri == nil || ri.To() == nil || ri.Config().RPCTimeout() > 0 ||
+				ri.Config().InteractionMode() == rpcinfo.Streaming {
+				return next(ctx, request, response)
+			}
+			timeout := metadataTimeout(ctx, resolver, ri, &invalid)
+			cfg := rpcinfo.AsMutableRPCConfig(ri.Config())
+			if timeout <= 0 || cfg == nil {
+				return next(ctx, request, response)
+			}
+			// the transport derives its read timeout from the RPC timeout.
+			_ = cfg.SetRPCTimeout(timeout)
+			callCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := next(callCtx, request, response)
+			if err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+				return kerrors.ErrRPCTimeout.WithCause(fmt.Errorf("timeout=%v from the service metadata: %w", timeout, err))
+			}
+			return err
+		}
+	}
+}
+
+// metadataTimeout returns the timeout recommended for the method of the call, zero when there is none.
+// An invalid value is logged once.
+func metadataTimeout(ctx context.Context, resolver Resolver, ri rpcinfo.RPCInfo, invalid *sync.Map) time.Duration {
+	desc := resolver.Target(ctx, ri.To())
+	metadata, err := serviceMetadataOf(ctx, resolver, desc)
+	if err != nil {
+		// the call goes on without timeout, its own error tells more than polaris.
+		return 0
+	}
+	key := MetadataTimeoutPrefix + ri.To().Method()
+	value, ok := metadata[key]
+	if !ok {
+		key = MetadataTimeoutDefault
+		value, ok = metadata[key]
+	}
+	if !ok {
+		return 0
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		if _, logged := invalid.LoadOrStore(desc+"\x00"+key+"\x00"+value, struct{}{}); logged {
+			return 0
+		}
+		log.GetBaseLogger().Warnf("[Polaris resolver] invalid %s metadata %q of %s", key, value, desc)
+		return 0
+	}
+	return timeout
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// timeoutCall runs a call of method through the middleware and returns the RPC timeout and the
+// deadline budget the next endpoint saw.
+func timeoutCall(t *testing.T, rs Resolver, method string, explicit time.Duration) (time.Duration, time.Duration) {
+	cfg := rpcinfo.NewRPCConfig()
+	if explicit > 0 {
+		require.Nil(t, rpcinfo.AsMutableRPCConfig(cfg).SetRPCTimeout(explicit))
+	}
+	to := rpcinfo.NewEndpointInfo(serviceName, method, nil, nil)
+	ri := rpcinfo.NewRPCInfo(nil, to, rpcinfo.NewInvocation(serviceName, method), cfg, rpcinfo.NewRPCStats())
+	var budget time.Duration
+	next := func(ctx context.Context, request, response interface{}) error {
+		if deadline, ok := ctx.Deadline(); ok {
+			budget = time.Until(deadline)
+		}
+		return nil
+	}
+	err := NewMetadataTimeoutMiddleware(rs)(next)(rpcinfo.NewCtxWithRPCInfo(context.Background(), ri), nil, nil)
+	require.Nil(t, err)
+	return ri.Config().RPCTimeout(), budget
+}
+
+func TestMetadataTimeoutMiddleware(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{
+		MetadataTimeoutDefault: "300ms",
+		"timeout.slowMethod":   "1s",
+		"timeout.badMethod":    "soon",
+	})
+	rs := newTestResolver(backend)
+
+	timeout, budget := timeoutCall(t, rs, "echo", 0)
+	require.Equal(t, 300*time.Millisecond, timeout)
+	require.True(t, budget > 0 && budget <= 300*time.Millisecond)
+
+	timeout, _ = timeoutCall(t, rs, "slowMethod", 0)
+	require.Equal(t, time.Second, timeout)
+
+	// the caller timeout wins.
+	timeout, budget = timeoutCall(t, rs, "slowMethod", 50*time.Millisecond)
+	require.Equal(t, 50*time.Millisecond, timeout)
+	require.Zero(t, budget)
+
+	timeout, budget = timeoutCall(t, rs, "badMethod", 0)
+	require.Zero(t, timeout)
+	require.Zero(t, budget)
+
+	timeout, _ = timeoutCall(t, newTestResolver(polaristest.NewBackend()), "echo", 0)
+	require.Zero(t, timeout)
+}
+
+func TestMetadataTimeoutMiddlewareFollowsChanges(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{ID: "ins-1", Namespace: polarisDefaultNamespace, Service: serviceName,
+		Host: "127.0.0.1", Port: 6666})
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{MetadataTimeoutDefault: "300ms"})
+	rs := newTestResolver(backend, WithServiceMetadataTTL(time.Hour))
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":"+serviceName, func(discovery.Change) {})
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	timeout, _ := timeoutCall(t, rs, "echo", 0)
+	require.Equal(t, 300*time.Millisecond, timeout)
+
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{MetadataTimeoutDefault: "2s"})
+	ins := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+	ins.Weight = 50
+	require.Nil(t, backend.UpdateInstance(ins))
+	require.Eventually(t, func() bool {
+		timeout, _ := timeoutCall(t, rs, "echo", 0)
+		return timeout == 2*time.Second
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMetadataTimeoutMiddlewareExpires(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.SetServiceMetadata(polarisDefaultNamespace, serviceName, map[string]string{MetadataTimeoutDefault: "20ms"})
+	rs := newTestResolver(backend)
+	to := rpcinfo.NewEndpointInfo(serviceName, "echo", nil, nil)
+	ri := rpcinfo.NewRPCInfo(nil, to, rpcinfo.NewInvocation(serviceName, "echo"), rpcinfo.NewRPCConfig(), rpcinfo.NewRPCStats())
+	next := func(ctx context.Context, request, response interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := NewMetadataTimeoutMiddleware(rs)(next)(rpcinfo.NewCtxWithRPCInfo(context.Background(), ri), nil, nil)
+	require.True(t, errors.Is(err, kerrors.ErrRPCTimeout))
+	require.True(t, kerrors.IsTimeoutError(err))
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// MetadataTLS is the instance metadata key set to "true" by the registry when the server accepts TLS,
+// see WithTLSCapable and WithRequireTLSInstances.
+const MetadataTLS = "tls"
+
+// tlsFilterName is the name of the filter of WithRequireTLSInstances.
+const tlsFilterName = "tls"
+
+// tlsCapable reports whether the server of info accepts TLS, WithTLSCapable or else the MetadataTLS or
+// the MetadataConnPrefix+"tls" tag of info. ok is false when the capability is unknown.
+func tlsCapable(info *registry.Info, o *options) (capable, ok bool) {
+	if o.tlsCapable != nil {
+		return *o.tlsCapable, true
+	}
+	for _, key := range []string{MetadataTLS, MetadataConnPrefix + "tls"} {
+		if value, found := info.Tags[key]; found {
+			capable, err := strconv.ParseBool(value)
+			return capable && err == nil, err == nil
+		}
+	}
+	return false, false
+}
+
+// newTLSFilter keeps the TLS capable instances, even when none is.
+func newTLSFilter() instanceFilter {
+	return instanceFilter{
+		name: tlsFilterName,
+		filter: func(ctx context.Context, instances []discovery.Instance) []discovery.Instance {
+			matched := make([]discovery.Instance, 0, len(instances))
+			for _, ins := range instances {
+				if value, ok := ins.Tag(MetadataTLS); ok {
+					if capable, _ := strconv.ParseBool(value); capable {
+						matched = append(matched, ins)
+					}
+				}
+			}
+			return matched
+		},
+	}
+}
+
+// warnNoTLSInstance logs once per service that WithRequireTLSInstances filtered out every instance of
+// the service, which is expected while its servers roll TLS out but breaks the calls of this client.
+func (polaris *polarisResolver) warnNoTLSInstance(service model.ServiceKey, total int) {
+	if _, warned := polaris.tlsWarned.LoadOrStore(service, struct{}{}); warned {
+		return
+	}
+	log.GetBaseLogger().Warnf("[Polaris resolver] no instance of %s:%s is TLS capable, %d instances are filtered out by WithRequireTLSInstances",
+		service.Namespace, service.Service, total)
+}
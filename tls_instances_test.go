@@ -0,0 +1,114 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+type warnLogger struct {
+	log.Logger
+	lock     sync.Mutex
+	warnings []string
+}
+
+func (l *warnLogger) Warnf(format string, args ...interface{}) {
+	l.lock.Lock()
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+	l.lock.Unlock()
+}
+
+func (l *warnLogger) containing(s string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	n := 0
+	for _, warning := range l.warnings {
+		if strings.Contains(warning, s) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTLSCapableMetadata(t *testing.T) {
+	metadata := func(tags map[string]string, opts ...Option) map[string]string {
+		param, _, err := createRegisterParam(newTestInfo("127.0.0.1:6666", tags), newOptions(opts))
+		require.Nil(t, err)
+		return param.Metadata
+	}
+	require.NotContains(t, metadata(nil), MetadataTLS)
+	require.Equal(t, "true", metadata(map[string]string{MetadataConnPrefix + "tls": "true"})[MetadataTLS])
+	require.NotContains(t, metadata(map[string]string{MetadataConnPrefix + "tls": "false"}), MetadataTLS)
+	require.Equal(t, "true", metadata(nil, WithTLSCapable(true))[MetadataTLS])
+	require.NotContains(t, metadata(map[string]string{MetadataConnPrefix + "tls": "true"}, WithTLSCapable(false)), MetadataTLS)
+	// the tags of the server still override the synthesized metadata.
+	require.Equal(t, "false", metadata(map[string]string{MetadataTLS: "false"}, WithTLSCapable(true))[MetadataTLS])
+}
+
+// TestRequireTLSInstancesRollout resolves a fleet rolling TLS out: the TLS clients follow the servers
+// enabling it while the other clients keep resolving every server.
+func TestRequireTLSInstancesRollout(t *testing.T) {
+	logger := &warnLogger{Logger: log.GetBaseLogger()}
+	log.SetBaseLogger(logger)
+	t.Cleanup(func() { log.SetBaseLogger(logger.Logger) })
+
+	backend := polaristest.NewBackend()
+	plain, tls := newTestRegistry(backend), newTestRegistry(backend, WithTLSCapable(true))
+	first, second := newTestInfo("127.0.0.1:6666", nil), newTestInfo("127.0.0.1:6667", nil)
+	require.Nil(t, plain.Register(first))
+	require.Nil(t, plain.Register(second))
+	desc := polarisDefaultNamespace + ":" + serviceName
+	rs, tlsRS := newTestResolver(backend), newTestResolver(backend, WithRequireTLSInstances(true))
+
+	// no server accepts TLS yet.
+	for i := 0; i < 2; i++ {
+		_, err := tlsRS.Resolve(context.TODO(), desc)
+		noInstance, ok := err.(*NoInstanceError)
+		require.True(t, ok, "%v", err)
+		require.Equal(t, []string{tlsFilterName}, noInstance.Filters)
+	}
+	require.Equal(t, 1, logger.containing("WithRequireTLSInstances"))
+	result, err := rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 2)
+
+	// the first server restarts with TLS.
+	require.Nil(t, plain.Deregister(first))
+	require.Nil(t, tls.Register(first))
+	result, err = tlsRS.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1:6666"}, addrs(result.Instances))
+	result, err = rs.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"127.0.0.1:6666", "127.0.0.1:6667"}, addrs(result.Instances))
+
+	require.Nil(t, plain.Deregister(second))
+	require.Nil(t, tls.Register(second))
+	result, err = tlsRS.Resolve(context.TODO(), desc)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"127.0.0.1:6666", "127.0.0.1:6667"}, addrs(result.Instances))
+	require.Nil(t, tls.Deregister(first))
+	require.Nil(t, tls.Deregister(second))
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"net"
+	"sync"
+)
+
+// MetadataUDSPath is the metadata key of the unix socket an instance also listens on, the resolver dials it
+// instead of the TCP address when the instance runs on the local host or with WithPreferUDS.
+const MetadataUDSPath = "uds-path"
+
+var (
+	localHostsOnce sync.Once
+	localHostSet   map[string]struct{}
+)
+
+// isLocalHost tells whether host is an address of a local interface, loopback included.
+func isLocalHost(host string) bool {
+	localHostsOnce.Do(func() {
+		localHostSet = map[string]struct{}{"localhost": {}}
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				localHostSet[ipNet.IP.String()] = struct{}{}
+			}
+		}
+	})
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	_, ok := localHostSet[host]
+	return ok
+}
+
+// udsPath returns the unix socket an instance is dialed on, ok is false when it is dialed over TCP.
+func (conv instanceConversion) udsPath(instance Instance) (path string, ok bool) {
+	if conv.local == nil {
+		return "", false
+	}
+	path = instance.GetMetadata()[MetadataUDSPath]
+	if path == "" || !(conv.preferUDS || conv.local(instance.GetHost())) {
+		return "", false
+	}
+	return path, true
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDSLocalInstance(t *testing.T) {
+	rs := newTestResolver(polaristest.NewBackend())
+	conv := rs.opts.instanceConversion()
+
+	ins := conv.convert(&polaristest.Instance{Host: "127.0.0.1", Port: 6666, Protocol: "grpc",
+		Metadata: map[string]string{MetadataUDSPath: "/run/user.sock"}})
+	require.Equal(t, "unix", ins.Address().Network())
+	require.Equal(t, "/run/user.sock", ins.Address().String())
+	proto, ok := instanceProtocol(ins)
+	require.True(t, ok)
+	require.Equal(t, "grpc", proto)
+
+	// without a socket path the local instance is dialed over TCP.
+	ins = conv.convert(&polaristest.Instance{Host: "127.0.0.1", Port: 6666, Protocol: "tcp"})
+	require.Equal(t, "tcp", ins.Address().Network())
+	require.Equal(t, "127.0.0.1:6666", ins.Address().String())
+}
+
+func TestUDSRemoteInstance(t *testing.T) {
+	remote := &polaristest.Instance{Host: "192.0.2.10", Port: 6666, Protocol: "tcp",
+		Metadata: map[string]string{MetadataUDSPath: "/run/user.sock"}}
+	require.False(t, isLocalHost(remote.Host))
+
+	ins := ConvertInstance(remote, WithConvertResolverOptions())
+	require.Equal(t, "tcp", ins.Address().Network())
+	require.Equal(t, "192.0.2.10:6666", ins.Address().String())
+
+	ins = ConvertInstance(remote, WithConvertResolverOptions(WithPreferUDS(true)))
+	require.Equal(t, "unix", ins.Address().Network())
+	require.Equal(t, "/run/user.sock", ins.Address().String())
+
+	// the exported conversion keeps the TCP address.
+	require.Equal(t, "192.0.2.10:6666", ConvertInstance(remote).Address().String())
+}
+
+func TestUDSResolve(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666,
+			Metadata: map[string]string{MetadataUDSPath: "/run/user.sock"}},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "192.0.2.10", Port: 6666,
+			Metadata: map[string]string{MetadataUDSPath: "/run/user.sock"}},
+	)
+	rs := newTestResolver(backend)
+	result, err := rs.Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"/run/user.sock", "192.0.2.10:6666"}, addrs(result.Instances))
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// EndpointsUpdater is the extension interface of the resolvers and registries moving to other polaris
+// servers without being recreated, the Resolver of NewPolarisResolver and the Registry of
+// NewPolarisRegistry implement it:
+//
+//	err := resolver.(polaris.EndpointsUpdater).UpdateEndpoints(ctx, endpoints)
+type EndpointsUpdater interface {
+	// UpdateEndpoints switches to the polaris servers of endpoints without recreating the resolver or the
+	// registry, on failure it keeps running on the old servers. The APIs given by WithConsumerAPI or
+	// WithProviderAPI cannot be replaced.
+	UpdateEndpoints(ctx context.Context, endpoints []string) error
+}
+
+var (
+	_ EndpointsUpdater = (*polarisResolver)(nil)
+	_ EndpointsUpdater = (*polarisRegistry)(nil)
+)
+
+// newEndpointSDKAPIs creates the APIs UpdateEndpoints switches to, replaced in tests.
+var newEndpointSDKAPIs = newSDKAPIs
+
+// endpointsUpdatable reports why the APIs of o cannot be rebuilt from new endpoints.
+func (o *options) endpointsUpdatable() error {
+	if o.consumerAPI != nil || o.providerAPI != nil {
+		return perrors.New("UpdateEndpoints cannot replace the APIs given by WithConsumerAPI or WithProviderAPI")
+	}
+	return nil
+}
+
+// UpdateEndpoints implements the EndpointsUpdater interface.
+// Every watched service is subscribed on the SDK context of endpoints before the resolver moves to it,
+// then the old context is released, the cached instances and the listeners are kept.
+func (polaris *polarisResolver) UpdateEndpoints(ctx context.Context, endpoints []string) error {
+	if err := polaris.opts.endpointsUpdatable(); err != nil {
+		return err
+	}
+	polaris.updateLock.Lock()
+	defer polaris.updateLock.Unlock()
+	if polaris.watcher.closed() {
+		return ErrResolverClosed
+	}
+	apis, err := buildEndpointAPIs(ctx, endpoints, polaris.opts)
+	if err != nil {
+		return err
+	}
+	if err := polaris.watcher.rewatch(ctx, apis.consumer); err != nil {
+		releaseAPIs(apis)
+		return perrors.WithMessage(err, "watch the services on the new endpoints failed")
+	}
+
+	polaris.apiLock.Lock()
+	release := polaris.releaseSDK
+	polaris.consumer = apis.consumer
+	polaris.provider = apis.provider
+	polaris.routerChain = apis.routerChain
+	polaris.releaseSDK = apis.release
+	polaris.endpoints = append([]string(nil), endpoints...)
+	polaris.apiLock.Unlock()
+	polaris.reporter.setConsumer(apis.consumer)
+	if release != nil {
+		release()
+	}
+	log.GetBaseLogger().Infof("[Polaris resolver] switched to endpoints %v", redactEndpoints(endpoints))
+	return nil
+}
+
+// UpdateEndpoints implements the EndpointsUpdater interface.
+// Every registered instance is registered on the SDK context of endpoints and its heartbeats move to
+// it, then the old context is released. Nothing is deregistered from the old servers, which may be the
+// same cluster behind new addresses, the instances left there expire with the heartbeat TTL. On failure
+// the instances registered on endpoints are deregistered again and the registry keeps the old context.
+func (svr *polarisRegistry) UpdateEndpoints(ctx context.Context, endpoints []string) error {
+	if err := svr.opts.endpointsUpdatable(); err != nil {
+		return err
+	}
+	svr.updateLock.Lock()
+	defer svr.updateLock.Unlock()
+	apis, err := buildEndpointAPIs(ctx, endpoints, svr.opts)
+	if err != nil {
+		return err
+	}
+	if apis.provider == nil {
+		releaseAPIs(apis)
+		return perrors.New("the new endpoints have no provider API")
+	}
+
+	svr.lock.RLock()
+	registrations := make([]*polarisHeartbeat, 0, len(svr.registryIns))
+	for _, insHeartbeat := range svr.registryIns {
+		registrations = append(registrations, insHeartbeat)
+	}
+	svr.lock.RUnlock()
+	resps := make([]*model.InstanceRegisterResponse, 0, len(registrations))
+	for _, insHeartbeat := range registrations {
+		if err := ctx.Err(); err != nil {
+			svr.abandonRegistrations(apis, registrations[:len(resps)])
+			return err
+		}
+		param := insHeartbeat.desired
+		resp, err := apis.provider.Register(param)
+		if err = notFoundError(err, param.Namespace, param.Service); err != nil {
+			svr.abandonRegistrations(apis, registrations[:len(resps)])
+			return perrors.WithMessagef(err, "register %s on the new endpoints failed", insHeartbeat.instanceKey)
+		}
+		resps = append(resps, resp)
+	}
+	svr.selfWatchLock.Lock()
+	defer svr.selfWatchLock.Unlock()
+	if svr.selfWatches != nil && apis.consumer != nil {
+		if err := svr.selfWatches.rewatch(ctx, apis.consumer); err != nil {
+			svr.abandonRegistrations(apis, registrations)
+			return perrors.WithMessage(err, "watch the own services on the new endpoints failed")
+		}
+	}
+
+	svr.apiLock.Lock()
+	release := svr.releaseSDK
+	svr.consumer = apis.consumer
+	svr.provider = apis.provider
+	svr.releaseSDK = apis.release
+	svr.endpoints = append([]string(nil), endpoints...)
+	svr.apiLock.Unlock()
+	for i, insHeartbeat := range registrations {
+		svr.lock.RLock()
+		current := svr.registryIns[insHeartbeat.instanceKey]
+		svr.lock.RUnlock()
+		if current == insHeartbeat {
+			// the heartbeats carry the instance ID of the new registration.
+			svr.startHeartbeat(insHeartbeat.instanceKey, insHeartbeat.desired, resps[i], insHeartbeat.shared)
+		}
+	}
+	if release != nil {
+		release()
+	}
+	log.GetBaseLogger().Infof("[Polaris registry] switched to endpoints %v", redactEndpoints(endpoints))
+	return nil
+}
+
+// abandonRegistrations deregisters the instances of registrations from apis and releases them, the
+// registrations stay on the APIs in use.
+func (svr *polarisRegistry) abandonRegistrations(apis *sdkAPIs, registrations []*polarisHeartbeat) {
+	for _, insHeartbeat := range registrations {
+		param := insHeartbeat.desired
+		err := apis.provider.Deregister(&api.InstanceDeRegisterRequest{
+			InstanceDeRegisterRequest: model.InstanceDeRegisterRequest{
+				Service:      param.Service,
+				ServiceToken: param.ServiceToken,
+				Namespace:    param.Namespace,
+				Host:         param.Host,
+				Port:         param.Port,
+				Timeout:      model.ToDurationPtr(registerTimeout),
+			},
+		})
+		if err != nil {
+			log.GetBaseLogger().Warnf("[Polaris registry] deregister %s from the new endpoints: %v",
+				insHeartbeat.instanceKey, err)
+		}
+	}
+	releaseAPIs(apis)
+}
+
+// buildEndpointAPIs creates the APIs of endpoints unless ctx is done.
+func buildEndpointAPIs(ctx context.Context, endpoints []string, o *options) (*sdkAPIs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	apis, err := newEndpointSDKAPIs(endpoints, o)
+	if err != nil {
+		return nil, perrors.WithMessage(err, "create polaris SDK context for the new endpoints failed")
+	}
+	return apis, nil
+}
+
+func releaseAPIs(apis *sdkAPIs) {
+	if apis.release != nil {
+		apis.release()
+	}
+}
+
+// consumerAPI returns the consumer API in use, see UpdateEndpoints.
+func (polaris *polarisResolver) consumerAPI() api.ConsumerAPI {
+	polaris.apiLock.RLock()
+	defer polaris.apiLock.RUnlock()
+	return polaris.consumer
+}
+
+func (polaris *polarisResolver) sdkRouterChain() []string {
+	polaris.apiLock.RLock()
+	defer polaris.apiLock.RUnlock()
+	return polaris.routerChain
+}
+
+func (polaris *polarisResolver) currentEndpoints() []string {
+	polaris.apiLock.RLock()
+	defer polaris.apiLock.RUnlock()
+	return polaris.endpoints
+}
+
+// consumerAPI returns the consumer API in use, see UpdateEndpoints.
+func (svr *polarisRegistry) consumerAPI() api.ConsumerAPI {
+	svr.apiLock.RLock()
+	defer svr.apiLock.RUnlock()
+	return svr.consumer
+}
+
+// providerAPI returns the provider API in use, see UpdateEndpoints.
+func (svr *polarisRegistry) providerAPI() api.ProviderAPI {
+	svr.apiLock.RLock()
+	defer svr.apiLock.RUnlock()
+	return svr.provider
+}
+
+func (svr *polarisRegistry) currentEndpoints() []string {
+	svr.apiLock.RLock()
+	defer svr.apiLock.RUnlock()
+	return svr.endpoints
+}
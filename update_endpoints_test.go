@@ -0,0 +1,197 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// replaceEndpointSDKAPIs makes UpdateEndpoints switch to apis, it counts the releases of apis.
+func replaceEndpointSDKAPIs(t *testing.T, consumer api.ConsumerAPI, provider api.ProviderAPI) *int {
+	released := 0
+	previous := newEndpointSDKAPIs
+	newEndpointSDKAPIs = func(endpoints []string, o *options) (*sdkAPIs, error) {
+		return &sdkAPIs{consumer: consumer, provider: provider, release: func() { released++ }}, nil
+	}
+	t.Cleanup(func() { newEndpointSDKAPIs = previous })
+	return &released
+}
+
+func requireAdded(t *testing.T, changes <-chan discovery.Change, addr string) {
+	select {
+	case change := <-changes:
+		require.Len(t, change.Added, 1)
+		require.Equal(t, addr, change.Added[0].Address().String())
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+}
+
+func TestResolverUpdateEndpoints(t *testing.T) {
+	oldBackend, newBackend := polaristest.NewBackend(), polaristest.NewBackend()
+	oldBackend.AddInstances(newTestListenerInstance(6666))
+	newBackend.AddInstances(newTestListenerInstance(6666))
+	rs := newTestResolver(oldBackend)
+	rs.endpoints = []string{"10.0.0.1:8091"}
+	oldReleased := 0
+	rs.releaseSDK = func() { oldReleased++ }
+	newReleased := replaceEndpointSDKAPIs(t, newBackend, newBackend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	changes := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	requireInitialChange(t, changes, 1)
+
+	require.Nil(t, rs.UpdateEndpoints(context.Background(), []string{"10.0.0.2:8091"}))
+	require.Equal(t, 1, oldReleased)
+	require.Equal(t, 0, *newReleased)
+	require.Equal(t, 1, newBackend.Calls(polaristest.OpWatchService))
+	require.Equal(t, []string{"10.0.0.2:8091"}, rs.EffectiveOptions().Endpoints)
+	require.True(t, rs.consumerAPI() == api.ConsumerAPI(newBackend))
+
+	// the events of the old servers are not consumed anymore, the ones of the new servers are.
+	oldBackend.AddInstances(newTestListenerInstance(7777))
+	newBackend.AddInstances(newTestListenerInstance(8888))
+	requireAdded(t, changes, "127.0.0.1:8888")
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected change %+v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	calls := oldBackend.Calls(polaristest.OpGetInstances)
+	result, err := rs.Resolve(context.Background(), desc)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 2)
+	require.Equal(t, calls, oldBackend.Calls(polaristest.OpGetInstances))
+}
+
+func TestResolverUpdateEndpointsFailure(t *testing.T) {
+	oldBackend, newBackend := polaristest.NewBackend(), polaristest.NewBackend()
+	oldBackend.AddInstances(newTestListenerInstance(6666))
+	newBackend.SetFailureRate(polaristest.OpWatchService, 1)
+	rs := newTestResolver(oldBackend)
+	rs.endpoints = []string{"10.0.0.1:8091"}
+	oldReleased := 0
+	rs.releaseSDK = func() { oldReleased++ }
+	newReleased := replaceEndpointSDKAPIs(t, newBackend, newBackend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	changes := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+	requireInitialChange(t, changes, 1)
+
+	require.NotNil(t, rs.UpdateEndpoints(context.Background(), []string{"10.0.0.2:8091"}))
+	require.Equal(t, 0, oldReleased)
+	require.Equal(t, 1, *newReleased)
+	require.Equal(t, []string{"10.0.0.1:8091"}, rs.EffectiveOptions().Endpoints)
+	require.True(t, rs.consumerAPI() == api.ConsumerAPI(oldBackend))
+	oldBackend.AddInstances(newTestListenerInstance(7777))
+	requireAdded(t, changes, "127.0.0.1:7777")
+
+	require.Nil(t, rs.Close())
+	require.True(t, errors.Is(rs.UpdateEndpoints(context.Background(), []string{"10.0.0.2:8091"}), ErrResolverClosed))
+}
+
+func TestUpdateEndpointsGivenAPIs(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend, WithConsumerAPI(backend))
+	require.NotNil(t, rs.UpdateEndpoints(context.Background(), []string{"10.0.0.2:8091"}))
+	rg := newTestRegistry(backend, WithProviderAPI(backend))
+	require.NotNil(t, rg.UpdateEndpoints(context.Background(), []string{"10.0.0.2:8091"}))
+}
+
+func TestRegistryUpdateEndpoints(t *testing.T) {
+	oldBackend, newBackend := polaristest.NewBackend(), polaristest.NewBackend()
+	rg := newTestRegistry(oldBackend)
+	oldReleased := 0
+	rg.releaseSDK = func() { oldReleased++ }
+	newReleased := replaceEndpointSDKAPIs(t, newBackend, newBackend)
+	info := newTestInfo("127.0.0.1:6666", nil)
+	require.Nil(t, rg.Register(info))
+	defer rg.Deregister(info)
+	require.Eventually(t, func() bool {
+		return oldBackend.Calls(polaristest.OpHeartbeat) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.Nil(t, rg.UpdateEndpoints(context.Background(), []string{"10.0.0.2:8091"}))
+	require.Equal(t, 1, oldReleased)
+	require.Equal(t, 0, *newReleased)
+	require.Len(t, newBackend.Instances(polarisDefaultNamespace, serviceName), 1)
+	require.Equal(t, []string{"10.0.0.2:8091"}, rg.EffectiveOptions().Endpoints)
+
+	oldHeartbeats := oldBackend.Calls(polaristest.OpHeartbeat)
+	require.Eventually(t, func() bool {
+		return newBackend.Calls(polaristest.OpHeartbeat) >= 2
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, oldHeartbeats, oldBackend.Calls(polaristest.OpHeartbeat))
+	instanceID := newBackend.Instances(polarisDefaultNamespace, serviceName)[0].ID
+	for _, heartbeat := range newBackend.Heartbeats() {
+		require.Equal(t, instanceID, heartbeat.InstanceID)
+	}
+}
+
+// failingPortProvider fails the registrations of one port.
+type failingPortProvider struct {
+	*polaristest.Backend
+	port int
+}
+
+func (p *failingPortProvider) Register(req *api.InstanceRegisterRequest) (*model.InstanceRegisterResponse, error) {
+	if req.Port == p.port {
+		return nil, errors.New("register failed")
+	}
+	return p.Backend.Register(req)
+}
+
+func TestRegistryUpdateEndpointsFailure(t *testing.T) {
+	oldBackend, newBackend := polaristest.NewBackend(), polaristest.NewBackend()
+	rg := newTestRegistry(oldBackend)
+	oldReleased := 0
+	rg.releaseSDK = func() { oldReleased++ }
+	newReleased := replaceEndpointSDKAPIs(t, newBackend, &failingPortProvider{Backend: newBackend, port: 7777})
+	first, second := newTestInfo("127.0.0.1:6666", nil), newTestInfo("127.0.0.1:7777", nil)
+	require.Nil(t, rg.Register(first))
+	defer rg.Deregister(first)
+	require.Nil(t, rg.Register(second))
+	defer rg.Deregister(second)
+
+	require.NotNil(t, rg.UpdateEndpoints(context.Background(), []string{"10.0.0.2:8091"}))
+	require.Equal(t, 0, oldReleased)
+	require.Equal(t, 1, *newReleased)
+	require.Empty(t, newBackend.Instances(polarisDefaultNamespace, serviceName))
+	require.Len(t, oldBackend.Instances(polarisDefaultNamespace, serviceName), 2)
+
+	heartbeats := oldBackend.Calls(polaristest.OpHeartbeat)
+	require.Eventually(t, func() bool {
+		return oldBackend.Calls(polaristest.OpHeartbeat) > heartbeats+2
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, 0, newBackend.Calls(polaristest.OpHeartbeat))
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+)
+
+// watchDeliveries remembers the watchers which got the initial Result of a description from Watcher.
+// A watcher is the context it passes to its Watcher calls, the contexts which are done are forgotten.
+type watchDeliveries struct {
+	lock     sync.Mutex
+	watchers map[string]map[context.Context]struct{} // desc -> watchers
+}
+
+// deliver reports whether the watcher of ctx did not get the initial Result of desc yet, it then counts
+// as delivered.
+func (d *watchDeliveries) deliver(ctx context.Context, desc string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	watchers, ok := d.watchers[desc]
+	if !ok {
+		if d.watchers == nil {
+			d.watchers = make(map[string]map[context.Context]struct{})
+		}
+		watchers = make(map[context.Context]struct{})
+		d.watchers[desc] = watchers
+	}
+	if _, ok := watchers[ctx]; ok {
+		return false
+	}
+	for watcher := range watchers {
+		if watcher.Err() != nil {
+			delete(watchers, watcher)
+		}
+	}
+	watchers[ctx] = struct{}{}
+	return true
+}
+
+// forget drops the watchers of desc, their next Watcher calls return the initial Result again.
+func (d *watchDeliveries) forget(desc string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.watchers, desc)
+}
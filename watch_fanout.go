@@ -0,0 +1,156 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync"
+
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// fanoutBufferSize is the buffer of the event channel of every user of a shared service.
+const fanoutBufferSize = 64
+
+// watchFanout shares the event channels of one SDK context between the resolvers and registries using it.
+// polaris-go keeps one event channel per service and SDK context and every WatchService of the service
+// returns it, so that the users of the context would each receive a part of the events. The fanout consumes
+// the channel of a service once and copies every event to a channel of each user watching the service.
+type watchFanout struct {
+	lock     sync.Mutex
+	services map[model.ServiceKey]*fanoutService
+	done     chan struct{}
+	once     sync.Once
+}
+
+// fanoutService is the event channel of a service in the SDK and the channels of its users.
+type fanoutService struct {
+	events      <-chan model.SubScribeEvent
+	subscribers map[*fanoutConsumer]chan model.SubScribeEvent // guarded by the lock of the watchFanout
+}
+
+func newWatchFanout() *watchFanout {
+	return &watchFanout{services: make(map[model.ServiceKey]*fanoutService), done: make(chan struct{})}
+}
+
+// consumer returns the consumer API of a new user of the SDK context of consumer.
+func (f *watchFanout) consumer(consumer api.ConsumerAPI) *fanoutConsumer {
+	return &fanoutConsumer{ConsumerAPI: consumer, fanout: f, done: make(chan struct{})}
+}
+
+// subscribe returns the channel of c receiving the events of the SDK channel of key.
+func (f *watchFanout) subscribe(key model.ServiceKey, events <-chan model.SubScribeEvent,
+	c *fanoutConsumer) <-chan model.SubScribeEvent {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	service, ok := f.services[key]
+	if !ok || service.events != events {
+		service = &fanoutService{events: events, subscribers: make(map[*fanoutConsumer]chan model.SubScribeEvent)}
+		f.services[key] = service
+		go f.run(key, service)
+	}
+	ch, ok := service.subscribers[c]
+	if !ok {
+		ch = make(chan model.SubScribeEvent, fanoutBufferSize)
+		service.subscribers[c] = ch
+	}
+	return ch
+}
+
+// unsubscribe forgets the channels of c, the events are no longer copied to them.
+func (f *watchFanout) unsubscribe(c *fanoutConsumer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, service := range f.services {
+		delete(service.subscribers, c)
+	}
+}
+
+// run copies the events of a service to its users. A user which falls behind delays the others, like
+// the SDK channel itself, until it is unsubscribed. The channels of the users are closed with the one
+// of the SDK so that they subscribe again.
+func (f *watchFanout) run(key model.ServiceKey, service *fanoutService) {
+	for {
+		var event model.SubScribeEvent
+		var ok bool
+		select {
+		case <-f.done:
+			return
+		case event, ok = <-service.events:
+		}
+		if !ok {
+			break
+		}
+		f.lock.Lock()
+		subscribers := make(map[*fanoutConsumer]chan model.SubScribeEvent, len(service.subscribers))
+		for c, ch := range service.subscribers {
+			subscribers[c] = ch
+		}
+		f.lock.Unlock()
+		for c, ch := range subscribers {
+			select {
+			case ch <- event:
+			case <-c.done:
+			case <-f.done:
+				return
+			}
+		}
+	}
+	f.lock.Lock()
+	if f.services[key] == service {
+		delete(f.services, key)
+	}
+	subscribers := service.subscribers
+	service.subscribers = nil
+	f.lock.Unlock()
+	for _, ch := range subscribers {
+		close(ch)
+	}
+}
+
+// close stops copying the events, once the SDK context is destroyed.
+func (f *watchFanout) close() {
+	f.once.Do(func() { close(f.done) })
+}
+
+// fanoutConsumer is the consumer API of one user of a shared SDK context, its WatchService returns
+// channels of the user fed by the watchFanout.
+type fanoutConsumer struct {
+	api.ConsumerAPI
+	fanout *watchFanout
+	done   chan struct{}
+	once   sync.Once
+}
+
+// WatchService implements api.ConsumerAPI.
+func (c *fanoutConsumer) WatchService(req *api.WatchServiceRequest) (*model.WatchServiceResponse, error) {
+	resp, err := c.ConsumerAPI.WatchService(req)
+	if err != nil || resp == nil || resp.EventChannel == nil {
+		return resp, err
+	}
+	watched := *resp
+	watched.EventChannel = c.fanout.subscribe(req.Key, resp.EventChannel, c)
+	return &watched, nil
+}
+
+// close unsubscribes the user, it is called when the user releases the SDK context.
+func (c *fanoutConsumer) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.fanout.unsubscribe(c)
+	})
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// requireInstances waits for a Change leaving want instances, the listeners may drop the older Changes.
+func requireInstances(t *testing.T, changes <-chan discovery.Change, want int) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case change := <-changes:
+			// the Result of an event is the snapshot before it.
+			instances := make(map[string]struct{})
+			for _, ins := range append(change.Result.Instances, change.Added...) {
+				instances[ins.Address().String()] = struct{}{}
+			}
+			if len(instances) == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the instances never reached %d", want)
+		}
+	}
+}
+
+// TestWatchFanoutSharedContext watches one service from two resolvers on the same SDK context, whose
+// WatchService hands both the same event channel, both get every event.
+func TestWatchFanoutSharedContext(t *testing.T) {
+	backend := polaristest.NewBackend()
+	fanout := newWatchFanout()
+	defer fanout.close()
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	var changes []chan discovery.Change
+	for i := 0; i < 2; i++ {
+		consumer := fanout.consumer(backend)
+		defer consumer.close()
+		rs, err := NewPolarisResolver(nil, WithConsumerAPI(consumer), WithProviderAPI(backend))
+		require.Nil(t, err)
+		defer rs.(io.Closer).Close()
+		ch := make(chan discovery.Change, 64)
+		unsubscribe, err := rs.(*polarisResolver).Subscribe(desc, func(change discovery.Change) { ch <- change })
+		require.Nil(t, err)
+		defer unsubscribe()
+		changes = append(changes, ch)
+	}
+	require.Equal(t, 2, backend.Calls(polaristest.OpWatchService))
+
+	for port := uint32(6000); port < 6020; port++ {
+		backend.AddInstances(newTestListenerInstance(port))
+	}
+	for _, ch := range changes {
+		requireInstances(t, ch, 20)
+	}
+}
+
+// TestWatchFanoutClosedUser stops copying the events to a user which released the SDK context, the
+// other users keep getting them.
+func TestWatchFanoutClosedUser(t *testing.T) {
+	backend := polaristest.NewBackend()
+	fanout := newWatchFanout()
+	defer fanout.close()
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	closed := fanout.consumer(backend)
+	req := &api.WatchServiceRequest{}
+	req.Key = model.ServiceKey{Namespace: polarisDefaultNamespace, Service: serviceName}
+	stale, err := closed.WatchService(req)
+	require.Nil(t, err)
+	closed.close()
+
+	consumer := fanout.consumer(backend)
+	defer consumer.close()
+	rs, err := NewPolarisResolver(nil, WithConsumerAPI(consumer), WithProviderAPI(backend))
+	require.Nil(t, err)
+	defer rs.(io.Closer).Close()
+	changes := make(chan discovery.Change, 64)
+	unsubscribe, err := rs.(*polarisResolver).Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	// more events than the channel of the closed user buffers.
+	for i := 1; i <= fanoutBufferSize+8; i++ {
+		backend.AddInstances(newTestListenerInstance(uint32(6000 + i)))
+		requireInstances(t, changes, i)
+	}
+	require.Empty(t, stale.EventChannel)
+}
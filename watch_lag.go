@@ -0,0 +1,156 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"sync"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// WatchDeliveryLagMetric is the name of the histogram returned by ResolverDiagnostics.WatchDeliveryLag.
+const WatchDeliveryLagMetric = "polaris_watch_delivery_lag_seconds"
+
+const defaultWatchLagThreshold = 5 * time.Second
+
+// watchLagBuckets are the upper bounds in seconds of the buckets of the watch delivery lag.
+var watchLagBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// LagHistogram is a snapshot of a histogram in the Prometheus layout, Counts[i] is the number of lags up
+// to Buckets[i] seconds, cumulative, Count and Sum in seconds cover every lag including the ones above
+// the last bucket.
+type LagHistogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+type lagHistogram struct {
+	lock   sync.Mutex
+	counts []uint64 // by bucket of watchLagBuckets, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *lagHistogram) observe(lag time.Duration) {
+	seconds := lag.Seconds()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.counts == nil {
+		h.counts = make([]uint64, len(watchLagBuckets))
+	}
+	for i, bound := range watchLagBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (h *lagHistogram) snapshot() LagHistogram {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := LagHistogram{
+		Buckets: append([]float64(nil), watchLagBuckets...),
+		Counts:  make([]uint64, len(watchLagBuckets)),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+	var cumulative uint64
+	for i := range watchLagBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		s.Counts[i] = cumulative
+	}
+	return s
+}
+
+// timedEvent is an instance event dispatched by the watch manager with the time of its change.
+type timedEvent struct {
+	*model.InstanceEvent
+	origin time.Time
+}
+
+// instanceEvent returns the instance event of a dispatched event and the time of its change, which is
+// zero for the events not dispatched by the watch manager.
+func instanceEvent(event model.SubScribeEvent) (*model.InstanceEvent, time.Time, bool) {
+	switch e := event.(type) {
+	case timedEvent:
+		return e.InstanceEvent, e.origin, true
+	case *model.InstanceEvent:
+		return e, time.Time{}, true
+	}
+	return nil, time.Time{}, false
+}
+
+// eventOrigin returns when polaris changed the instances of an event: the latest modify time of the added
+// and updated instances when the server sent them, or else received, when the SDK handed the event over.
+// The modify times are in seconds of the server clock, one after received is ignored. Deleted instances
+// carry the time of their last update, not of their deletion.
+func eventOrigin(insEvent *model.InstanceEvent, received time.Time) time.Time {
+	var latest time.Time
+	modified := func(instance model.Instance) {
+		_, value := instanceTimes(instance)
+		if value == "" {
+			return
+		}
+		if at, err := time.ParseInLocation(polarisTimeLayout, value, time.Local); err == nil && at.After(latest) {
+			latest = at
+		}
+	}
+	if insEvent.AddEvent != nil {
+		for _, instance := range insEvent.AddEvent.Instances {
+			modified(instance)
+		}
+	}
+	if insEvent.UpdateEvent != nil {
+		for _, update := range insEvent.UpdateEvent.UpdateList {
+			modified(update.After)
+		}
+	}
+	if latest.IsZero() || latest.After(received) {
+		return received
+	}
+	return latest
+}
+
+// observeWatchLag records the lag between the change of an event of desc and the delivery of its Change,
+// the lags above WithWatchLagThreshold are logged.
+func (polaris *polarisResolver) observeWatchLag(desc string, origin time.Time) {
+	if origin.IsZero() {
+		return
+	}
+	lag := polaris.watcher.now().Sub(origin)
+	if lag < 0 {
+		lag = 0
+	}
+	polaris.watchLag.observe(lag)
+	if threshold := orDefaultDuration(polaris.opts.watchLagThreshold, defaultWatchLagThreshold); lag > threshold {
+		log.GetBaseLogger().Warnf("[Polaris resolver] change of %s delivered %v after its polaris event", desc, lag)
+	}
+}
+
+// WatchDeliveryLag implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) WatchDeliveryLag() LagHistogram {
+	return polaris.watchLag.snapshot()
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// steppingClock returns start, then advances by step on every call.
+type steppingClock struct {
+	lock sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+func TestLagHistogram(t *testing.T) {
+	var h lagHistogram
+	require.Equal(t, LagHistogram{Buckets: watchLagBuckets, Counts: make([]uint64, len(watchLagBuckets))}, h.snapshot())
+	for _, lag := range []time.Duration{time.Millisecond, 20 * time.Millisecond, 2 * time.Second, time.Minute} {
+		h.observe(lag)
+	}
+	s := h.snapshot()
+	require.Equal(t, uint64(4), s.Count)
+	require.InDelta(t, 62.021, s.Sum, 1e-9)
+	require.Equal(t, []uint64{1, 1, 2, 2, 2, 2, 2, 2, 3, 3, 3, 3}, s.Counts)
+}
+
+func TestEventOrigin(t *testing.T) {
+	received := time.Date(2021, 11, 1, 8, 0, 10, 0, time.Local)
+	modified := func(at time.Time) *polaristest.Instance {
+		return &polaristest.Instance{Mtime: at.Format(polarisTimeLayout)}
+	}
+
+	event := &model.InstanceEvent{
+		AddEvent: &model.InstanceAddEvent{Instances: []model.Instance{modified(received.Add(-3 * time.Second))}},
+		UpdateEvent: &model.InstanceUpdateEvent{UpdateList: []model.OneInstanceUpdate{
+			{After: modified(received.Add(-2 * time.Second))},
+		}},
+	}
+	require.Equal(t, received.Add(-2*time.Second), eventOrigin(event, received))
+
+	// deleted instances and instances without modify time carry no server time.
+	event = &model.InstanceEvent{
+		AddEvent:    &model.InstanceAddEvent{Instances: []model.Instance{&polaristest.Instance{}}},
+		DeleteEvent: &model.InstanceDeleteEvent{Instances: []model.Instance{modified(received.Add(-time.Hour))}},
+	}
+	require.Equal(t, received, eventOrigin(event, received))
+
+	// a server clock ahead of the local one is ignored.
+	event = &model.InstanceEvent{
+		AddEvent: &model.InstanceAddEvent{Instances: []model.Instance{modified(received.Add(time.Minute))}},
+	}
+	require.Equal(t, received, eventOrigin(event, received))
+}
+
+func TestWatchDeliveryLagSubscribe(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend)
+	now := time.Date(2021, 11, 1, 8, 0, 10, 0, time.Local)
+	rs.watcher.now = func() time.Time { return now }
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	changes := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(desc, func(change discovery.Change) { changes <- change })
+	require.Nil(t, err)
+	defer unsubscribe()
+
+	ins := newTestListenerInstance(6666)
+	ins.Mtime = now.Add(-2 * time.Second).Format(polarisTimeLayout)
+	backend.AddInstances(ins)
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+	s := rs.WatchDeliveryLag()
+	require.Equal(t, uint64(1), s.Count)
+	require.InDelta(t, 2.0, s.Sum, 1e-9)
+	require.Equal(t, uint64(0), s.Counts[7]) // 1s
+	require.Equal(t, uint64(1), s.Counts[8]) // 2.5s
+}
+
+func TestWatchDeliveryLagWatcher(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend, WithWatchLagThreshold(100*time.Millisecond))
+	clock := &steppingClock{now: time.Date(2021, 11, 1, 8, 0, 10, 0, time.Local), step: 300 * time.Millisecond}
+	rs.watcher.now = clock.Now
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	done := make(chan discovery.Change, 1)
+	go func() {
+		change, err := rs.Watcher(context.Background(), desc)
+		require.Nil(t, err)
+		done <- change
+	}()
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpWatchService) > 0
+	}, time.Second, time.Millisecond)
+	// without modify time the lag starts when the SDK delivered the event.
+	backend.AddInstances(newTestListenerInstance(6666))
+	select {
+	case change := <-done:
+		require.Len(t, change.Added, 1)
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+	s := rs.WatchDeliveryLag()
+	require.Equal(t, uint64(1), s.Count)
+	require.InDelta(t, 0.3, s.Sum, 1e-9)
+	require.Equal(t, uint64(0), s.Counts[5]) // 250ms
+	require.Equal(t, uint64(1), s.Counts[6]) // 500ms
+}
@@ -0,0 +1,680 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	perrors "github.com/pkg/errors"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	defaultWatchWorkerPoolSize = 4
+	defaultWatchRetryBase      = 100 * time.Millisecond
+	defaultWatchRetryMax       = 30 * time.Second
+)
+
+// serviceWatch is the shared subscription of one service, events are dispatched to every waiter.
+type serviceWatch struct {
+	key      model.ServiceKey
+	worker   *watchWorker // the worker consuming events, guarded by the lock of the watchManager
+	lock     sync.Mutex
+	attached bool
+	retrying bool
+	waiters  map[chan model.SubScribeEvent]*waiterState
+	onEvent  func(key model.ServiceKey)
+	// onRemoved is called with the instances deleted by an event, see WithOnInstancesRemoved.
+	onRemoved func(instances []model.Instance)
+	// grace keeps the deleted instances for WithRemovalGracePeriod, it is guarded by lock.
+	grace *removalGrace
+	// dormant subscriptions discard their events, see WithMaxTrackedServices. It is guarded by lock.
+	dormant bool
+	// revisions are the instance revisions applied so far by ID, revision is the service revision.
+	revisions map[string]string
+	revision  string
+	skipped   *uint64
+	now       func() time.Time
+	// weights keeps back the weight-only updates, see WithWeightUpdateThreshold. It is nil without it.
+	weights *weightUpdates
+	// deliverLock orders the events dispatched with the updates flushed by syncWeights.
+	deliverLock sync.Mutex
+}
+
+// waiterState records the events missed by a waiter, it is guarded by the lock of the serviceWatch.
+type waiterState struct {
+	missed  bool    // an event was missed since the last takeMissed
+	dropped *uint64 // counts the missed events when set, see countDrops
+}
+
+func (sw *serviceWatch) addWaiter(size int) chan model.SubScribeEvent {
+	ch := make(chan model.SubScribeEvent, size)
+	sw.lock.Lock()
+	sw.waiters[ch] = &waiterState{}
+	sw.lock.Unlock()
+	return ch
+}
+
+// countDrops makes the events missed by the waiter ch counted in dropped.
+func (sw *serviceWatch) countDrops(ch chan model.SubScribeEvent, dropped *uint64) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if state, ok := sw.waiters[ch]; ok {
+		state.dropped = dropped
+	}
+}
+
+// takeMissed reports whether the waiter ch missed events since the last call.
+func (sw *serviceWatch) takeMissed(ch chan model.SubScribeEvent) bool {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	state, ok := sw.waiters[ch]
+	if !ok || !state.missed {
+		return false
+	}
+	state.missed = false
+	return true
+}
+
+// missAll marks every waiter as having missed events, like the ones lost with a closed event channel.
+func (sw *serviceWatch) missAll() {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	for _, state := range sw.waiters {
+		state.missed = true
+	}
+}
+
+func (sw *serviceWatch) removeWaiter(ch chan model.SubScribeEvent) {
+	sw.lock.Lock()
+	delete(sw.waiters, ch)
+	sw.lock.Unlock()
+}
+
+// dispatch hands an event to every waiter, the instance events as timedEvent.
+func (sw *serviceWatch) dispatch(event model.SubScribeEvent) {
+	received := sw.now()
+	sw.lock.Lock()
+	dormant := sw.dormant
+	sw.lock.Unlock()
+	if dormant {
+		return
+	}
+	sw.deliverLock.Lock()
+	defer sw.deliverLock.Unlock()
+	if insEvent, ok := event.(*model.InstanceEvent); ok && !sw.applyRevisions(insEvent) {
+		atomic.AddUint64(sw.skipped, 1)
+		return
+	}
+	if insEvent, ok := event.(*model.InstanceEvent); ok && sw.grace != nil {
+		sw.lock.Lock()
+		drained := sw.grace.drain(sw, insEvent)
+		sw.lock.Unlock()
+		if drained == nil {
+			return
+		}
+		event = drained
+	}
+	if insEvent, ok := event.(*model.InstanceEvent); ok && sw.weights != nil {
+		kept := sw.weights.filter(insEvent)
+		if kept == nil {
+			return
+		}
+		event = kept
+	}
+	if sw.onEvent != nil {
+		sw.onEvent(sw.key)
+	}
+	if insEvent, ok := event.(*model.InstanceEvent); ok && sw.onRemoved != nil &&
+		insEvent.DeleteEvent != nil && len(insEvent.DeleteEvent.Instances) > 0 {
+		sw.onRemoved(insEvent.DeleteEvent.Instances)
+	}
+	if insEvent, ok := event.(*model.InstanceEvent); ok {
+		event = timedEvent{InstanceEvent: insEvent, origin: eventOrigin(insEvent, received)}
+	}
+	sw.broadcast(event)
+}
+
+// broadcast hands event to every waiter, the waiters which are full miss it.
+func (sw *serviceWatch) broadcast(event model.SubScribeEvent) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	for ch, state := range sw.waiters {
+		select {
+		case ch <- event:
+		default:
+			state.missed = true
+			if state.dropped != nil {
+				atomic.AddUint64(state.dropped, 1)
+			}
+		}
+	}
+}
+
+// wake resumes a dormant subscription from snapshot.
+func (sw *serviceWatch) wake(snapshot *model.InstancesResponse) {
+	sw.lock.Lock()
+	dormant := sw.dormant
+	sw.dormant = false
+	sw.lock.Unlock()
+	if dormant {
+		sw.resetRevisions(snapshot)
+		if sw.weights != nil {
+			sw.deliverLock.Lock()
+			sw.weights.pending = make(map[string]model.OneInstanceUpdate)
+			sw.deliverLock.Unlock()
+		}
+	}
+}
+
+// resetRevisions records the instance revisions of the snapshot the subscription started from.
+func (sw *serviceWatch) resetRevisions(snapshot *model.InstancesResponse) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	sw.revisions = make(map[string]string, len(snapshot.GetInstances()))
+	for _, instance := range snapshot.GetInstances() {
+		sw.revisions[instance.GetId()] = instance.GetRevision()
+	}
+	sw.revision = snapshot.GetRevision()
+	if sw.grace != nil {
+		sw.grace.reset()
+	}
+}
+
+// applyRevisions applies the instance revisions of an event and reports whether it changed any,
+// an idempotent re-push of instances already applied with the same revision changes nothing.
+// polaris-go events carry no service revision, the new one is a digest of the instance revisions.
+func (sw *serviceWatch) applyRevisions(insEvent *model.InstanceEvent) bool {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if sw.revisions == nil {
+		return true
+	}
+	changed := false
+	set := func(instance model.Instance) {
+		if revision, ok := sw.revisions[instance.GetId()]; !ok || revision != instance.GetRevision() {
+			sw.revisions[instance.GetId()] = instance.GetRevision()
+			changed = true
+		}
+	}
+	if insEvent.AddEvent != nil {
+		for _, instance := range insEvent.AddEvent.Instances {
+			set(instance)
+		}
+	}
+	if insEvent.UpdateEvent != nil {
+		for _, update := range insEvent.UpdateEvent.UpdateList {
+			set(update.After)
+		}
+	}
+	if insEvent.DeleteEvent != nil {
+		for _, instance := range insEvent.DeleteEvent.Instances {
+			if _, ok := sw.revisions[instance.GetId()]; ok {
+				delete(sw.revisions, instance.GetId())
+				changed = true
+			}
+		}
+	}
+	if changed {
+		sw.revision = revisionDigest(sw.revisions)
+	}
+	return changed
+}
+
+// lastRevision returns the service revision, ok is false before the subscription started.
+func (sw *serviceWatch) lastRevision() (string, bool) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	return sw.revision, sw.revisions != nil
+}
+
+// revisionDigest hashes the instance revisions in ID order.
+func revisionDigest(revisions map[string]string) string {
+	ids := make([]string, 0, len(revisions))
+	for id := range revisions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	h := fnv.New64a()
+	for _, id := range ids {
+		h.Write([]byte(id))
+		h.Write([]byte{'='})
+		h.Write([]byte(revisions[id]))
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// watchAttachment hands the event channel of a service to a worker.
+type watchAttachment struct {
+	sw     *serviceWatch
+	events <-chan model.SubScribeEvent
+}
+
+// watchWorker consumes the event channels of many services in one goroutine. The attachments are
+// queued without bound, so that handing one never waits for the dispatch of an event.
+type watchWorker struct {
+	lock    sync.Mutex
+	queued  []watchAttachment // guarded by lock, applied in order
+	notify  chan struct{}     // signals queued attachments, buffered by one
+	watches []*serviceWatch
+	// closed is called with the services whose event channel was closed, see watchManager.detach.
+	closed func(sw *serviceWatch)
+}
+
+func newWatchWorker(closed func(sw *serviceWatch)) *watchWorker {
+	return &watchWorker{notify: make(chan struct{}, 1), closed: closed}
+}
+
+// enqueue hands an attachment to the worker, it never blocks.
+func (w *watchWorker) enqueue(attachment watchAttachment) {
+	w.lock.Lock()
+	w.queued = append(w.queued, attachment)
+	w.lock.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watchWorker) run(done <-chan struct{}) {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(w.notify)},
+	}
+	const fixedCases = 2
+	for {
+		chosen, value, ok := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			return
+		case 1:
+			w.lock.Lock()
+			queued := w.queued
+			w.queued = nil
+			w.lock.Unlock()
+			for _, attachment := range queued {
+				if i := w.index(attachment.sw); i >= 0 {
+					// the service was subscribed again by rewatch, consume its new event channel.
+					cases[i+fixedCases].Chan = reflect.ValueOf(attachment.events)
+					continue
+				}
+				w.watches = append(w.watches, attachment.sw)
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(attachment.events)})
+			}
+		default:
+			i := chosen - fixedCases
+			if !ok {
+				// the channel is closed, stop selecting on it and let the service be subscribed again.
+				sw := w.watches[i]
+				w.watches = append(w.watches[:i], w.watches[i+1:]...)
+				cases = append(cases[:chosen], cases[chosen+1:]...)
+				if w.closed != nil {
+					w.closed(sw)
+				}
+				continue
+			}
+			w.watches[i].dispatch(value.Interface().(model.SubScribeEvent))
+		}
+	}
+}
+
+func (w *watchWorker) index(sw *serviceWatch) int {
+	for i, watch := range w.watches {
+		if watch == sw {
+			return i
+		}
+	}
+	return -1
+}
+
+// watchManager shares one subscription per service between all watchers and multiplexes
+// the event channels of all services over a bounded pool of worker goroutines.
+type watchManager struct {
+	skipped   uint64 // accessed atomically, keep it first for 64-bit alignment
+	consumer  api.ConsumerAPI
+	poolSize  int
+	lock      sync.Mutex
+	watches   map[model.ServiceKey]*serviceWatch
+	workers   []*watchWorker
+	next      int
+	done      chan struct{}
+	closeOnce sync.Once
+	onEvent   func(key model.ServiceKey)
+	onRemoved func(instances []model.Instance)
+	newGrace  func() *removalGrace
+	timeout   time.Duration
+	retryBase time.Duration
+	retryMax  time.Duration
+	now       func() time.Time // time.Now, replaced in tests
+	// weightThreshold enables the weightUpdates of every service watch, see WithWeightUpdateThreshold.
+	weightThreshold float64
+}
+
+// newWatchManager creates a watchManager, onEvent is called with the key of every event before it is dispatched.
+func newWatchManager(consumer api.ConsumerAPI, o *options, onEvent func(key model.ServiceKey)) *watchManager {
+	poolSize := o.watchWorkerPoolSize
+	if poolSize <= 0 {
+		poolSize = defaultWatchWorkerPoolSize
+	}
+	m := &watchManager{
+		consumer:  consumer,
+		poolSize:  poolSize,
+		watches:   make(map[model.ServiceKey]*serviceWatch),
+		done:      make(chan struct{}),
+		onEvent:   onEvent,
+		onRemoved: o.instancesRemovedHook(),
+		newGrace:  o.newRemovalGrace,
+		timeout:   o.watchTimeout,
+		retryBase: defaultWatchRetryBase,
+		retryMax:  defaultWatchRetryMax,
+		now:       time.Now,
+		// weightThreshold enables the weightUpdates of every service watch.
+		weightThreshold: o.weightUpdateThreshold,
+	}
+	if m.weightThreshold > 0 {
+		go m.syncWeights(orDefaultDuration(o.weightSyncInterval, defaultWeightSyncInterval))
+	}
+	return m
+}
+
+// subscribe registers a waiter buffering size events of key and returns the current instances of the service.
+// The waiter is registered before the snapshot is taken so that no event after the snapshot is missed.
+func (m *watchManager) subscribe(key model.ServiceKey, size int) (*serviceWatch, chan model.SubScribeEvent, *model.InstancesResponse, error) {
+	if m.closed() {
+		return nil, nil, nil, ErrResolverClosed
+	}
+	sw := m.serviceWatch(key)
+	waiter := sw.addWaiter(size)
+	watchRsp, from, err := m.watchService(sw)
+	if err != nil {
+		sw.removeWaiter(waiter)
+		return nil, nil, nil, err
+	}
+	if !m.attach(sw, watchRsp, from) {
+		m.retry(sw, nil)
+	}
+	sw.wake(watchRsp.GetAllInstancesResp)
+	return sw, waiter, watchRsp.GetAllInstancesResp, nil
+}
+
+type watchServiceResult struct {
+	resp     *model.WatchServiceResponse
+	consumer api.ConsumerAPI
+	err      error
+}
+
+// watchService creates the subscription of a service within the WithWatchTimeout budget. On failure
+// the subscription keeps being retried in the background, a timed out call is awaited by the retry.
+// The consumer API the subscription was created with is returned too, see attach.
+func (m *watchManager) watchService(sw *serviceWatch) (*model.WatchServiceResponse, api.ConsumerAPI, error) {
+	if m.timeout <= 0 {
+		r := m.callWatchService(sw.key)
+		if r.err != nil {
+			m.retry(sw, nil)
+		}
+		return r.resp, r.consumer, r.err
+	}
+	pending := make(chan watchServiceResult, 1)
+	go func() {
+		pending <- m.callWatchService(sw.key)
+	}()
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-pending:
+		if r.err != nil {
+			m.retry(sw, nil)
+		}
+		return r.resp, r.consumer, r.err
+	case <-timer.C:
+		m.retry(sw, pending)
+		return nil, nil, &WatchTimeoutError{Namespace: sw.key.Namespace, Service: sw.key.Service, Timeout: m.timeout}
+	}
+}
+
+func (m *watchManager) callWatchService(key model.ServiceKey) watchServiceResult {
+	m.lock.Lock()
+	consumer := m.consumer
+	m.lock.Unlock()
+	watchReq := api.WatchServiceRequest{}
+	watchReq.Key = key
+	resp, err := consumer.WatchService(&watchReq)
+	return watchServiceResult{resp: resp, consumer: consumer, err: err}
+}
+
+// retry starts the background creation of the subscription of a service unless it already runs or
+// the service is attached. It first waits for the pending call, then retries with exponential backoff.
+func (m *watchManager) retry(sw *serviceWatch, pending <-chan watchServiceResult) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if sw.attached || sw.retrying {
+		return
+	}
+	sw.retrying = true
+	go func() {
+		defer func() {
+			m.lock.Lock()
+			sw.retrying = false
+			m.lock.Unlock()
+		}()
+		if pending != nil {
+			select {
+			case <-m.done:
+				return
+			case r := <-pending:
+				if r.err == nil && m.attach(sw, r.resp, r.consumer) {
+					return
+				}
+			}
+		}
+		backoff := m.retryBase
+		for {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-m.done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if m.isAttached(sw) {
+				return
+			}
+			r := m.callWatchService(sw.key)
+			if r.err == nil && m.attach(sw, r.resp, r.consumer) {
+				return
+			}
+			if r.err == nil {
+				continue
+			}
+			log.GetBaseLogger().Warnf("[Polaris resolver] retry WatchService of %s: %v", sw.key, r.err)
+			if backoff *= 2; backoff > m.retryMax {
+				backoff = m.retryMax
+			}
+		}
+	}()
+}
+
+func (m *watchManager) isAttached(sw *serviceWatch) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return sw.attached
+}
+
+func (m *watchManager) serviceWatch(key model.ServiceKey) *serviceWatch {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sw, ok := m.watches[key]
+	if !ok {
+		sw = &serviceWatch{key: key, waiters: make(map[chan model.SubScribeEvent]*waiterState), onEvent: m.onEvent,
+			onRemoved: m.onRemoved, grace: m.newGrace(), skipped: &m.skipped, now: m.now}
+		if m.weightThreshold > 0 {
+			sw.weights = newWeightUpdates(m.weightThreshold)
+		}
+		m.watches[key] = sw
+	}
+	return sw
+}
+
+// attach hands the event channel of a service to a worker the first time it is seen,
+// the revisions of its snapshot are the base the events are compared with. It returns false when
+// the subscription was created with the consumer API replaced by rewatch since, it must be created again.
+func (m *watchManager) attach(sw *serviceWatch, resp *model.WatchServiceResponse, from api.ConsumerAPI) bool {
+	if resp == nil || resp.EventChannel == nil {
+		return true
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if sw.attached || m.closed() {
+		return true
+	}
+	if from != m.consumer {
+		return false
+	}
+	sw.attached = true
+	sw.resetRevisions(resp.GetAllInstancesResp)
+	var worker *watchWorker
+	if len(m.workers) < m.poolSize {
+		worker = newWatchWorker(m.detach)
+		m.workers = append(m.workers, worker)
+		go worker.run(m.done)
+	} else {
+		worker = m.workers[m.next%len(m.workers)]
+		m.next++
+	}
+	sw.worker = worker
+	worker.enqueue(watchAttachment{sw: sw, events: resp.EventChannel})
+	return true
+}
+
+// detach forgets the closed event channel of a service and creates its subscription again in the
+// background, the next events are compared with the snapshot of the new subscription and the waiters
+// are told they missed events.
+func (m *watchManager) detach(sw *serviceWatch) {
+	m.lock.Lock()
+	sw.attached = false
+	sw.worker = nil
+	m.lock.Unlock()
+	sw.lock.Lock()
+	sw.revisions = nil
+	sw.revision = ""
+	sw.lock.Unlock()
+	// the events until the new subscription are lost.
+	sw.missAll()
+	m.retry(sw, nil)
+}
+
+// rewatch subscribes every attached service again with consumer, then switches the workers to the new
+// event channels and makes consumer the API of the next subscriptions. Nothing is switched when a
+// subscription fails, the ones already created are dropped with the SDK context of consumer.
+// The revisions applied so far are kept, so the snapshot pushed again by the new subscriptions is skipped.
+// The error of ctx is returned when it is done before the switch.
+func (m *watchManager) rewatch(ctx context.Context, consumer api.ConsumerAPI) error {
+	resps := make(map[*serviceWatch]*model.WatchServiceResponse)
+	for {
+		m.lock.Lock()
+		if m.closed() {
+			m.lock.Unlock()
+			return ErrResolverClosed
+		}
+		if err := ctx.Err(); err != nil {
+			m.lock.Unlock()
+			return err
+		}
+		var pending []*serviceWatch
+		for _, sw := range m.watches {
+			if _, ok := resps[sw]; sw.attached && !ok {
+				pending = append(pending, sw)
+			}
+		}
+		if len(pending) == 0 {
+			m.consumer = consumer
+			for sw, resp := range resps {
+				if resp == nil || resp.EventChannel == nil || !sw.attached {
+					// a service detached since is subscribed again by its retry.
+					continue
+				}
+				sw.worker.enqueue(watchAttachment{sw: sw, events: resp.EventChannel})
+			}
+			m.lock.Unlock()
+			return nil
+		}
+		m.lock.Unlock()
+		for _, sw := range pending {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			watchReq := api.WatchServiceRequest{}
+			watchReq.Key = sw.key
+			resp, err := consumer.WatchService(&watchReq)
+			if err != nil {
+				return perrors.WithMessagef(err, "watch %s/%s", sw.key.Namespace, sw.key.Service)
+			}
+			resps[sw] = resp
+		}
+	}
+}
+
+// resetRevisions makes the subscription of key compare the next events with snapshot, if it is attached.
+func (m *watchManager) resetRevisions(key model.ServiceKey, snapshot *model.InstancesResponse) {
+	m.lock.Lock()
+	sw, ok := m.watches[key]
+	attached := ok && sw.attached
+	m.lock.Unlock()
+	if attached {
+		sw.resetRevisions(snapshot)
+	}
+}
+
+// lastRevision returns the last applied revision of key, see serviceWatch.applyRevisions.
+func (m *watchManager) lastRevision(key model.ServiceKey) (string, bool) {
+	m.lock.Lock()
+	sw, ok := m.watches[key]
+	m.lock.Unlock()
+	if !ok {
+		return "", false
+	}
+	return sw.lastRevision()
+}
+
+// skippedEvents returns how many events changed no instance revision and were dropped.
+func (m *watchManager) skippedEvents() uint64 {
+	return atomic.LoadUint64(&m.skipped)
+}
+
+func (m *watchManager) closed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops all workers, the shared subscriptions stay registered in the SDK.
+func (m *watchManager) close() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/polarismesh/polaris-go/api"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchManagerBoundedGoroutines(t *testing.T) {
+	backend := polaristest.NewBackend()
+	manager := newWatchManager(backend, newOptions([]Option{WithWatchWorkerPool(2)}), nil)
+	defer close(manager.done)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 200; i++ {
+		sw, waiter, _, err := manager.subscribe(model.ServiceKey{Namespace: polarisDefaultNamespace, Service: "svc-" + strconv.Itoa(i)}, 1)
+		require.Nil(t, err)
+		sw.removeWaiter(waiter)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine()-before, 2)
+	require.Len(t, manager.workers, 2)
+
+	// events of the last attached service still flow through the pool.
+	key := model.ServiceKey{Namespace: polarisDefaultNamespace, Service: "svc-199"}
+	sw, waiter, _, err := manager.subscribe(key, 1)
+	require.Nil(t, err)
+	defer sw.removeWaiter(waiter)
+	backend.AddInstances(&polaristest.Instance{Namespace: key.Namespace, Service: key.Service, Host: "127.0.0.1", Port: 6666})
+	select {
+	case <-waiter:
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+// TestWatchManagerSubscribeFromHook subscribes a service from the dispatch of an event of the single worker,
+// like a hook resolving another service does.
+func TestWatchManagerSubscribeFromHook(t *testing.T) {
+	backend := polaristest.NewBackend()
+	first := model.ServiceKey{Namespace: polarisDefaultNamespace, Service: "svc-1"}
+	second := model.ServiceKey{Namespace: polarisDefaultNamespace, Service: "svc-2"}
+	var manager *watchManager
+	subscribed := make(chan error, 1)
+	manager = newWatchManager(backend, newOptions([]Option{WithWatchWorkerPool(1)}), func(key model.ServiceKey) {
+		if key == first {
+			_, _, _, err := manager.subscribe(second, 1)
+			subscribed <- err
+		}
+	})
+	defer close(manager.done)
+
+	sw, waiter, _, err := manager.subscribe(first, 1)
+	require.Nil(t, err)
+	defer sw.removeWaiter(waiter)
+	backend.AddInstances(&polaristest.Instance{Namespace: first.Namespace, Service: first.Service, Host: "127.0.0.1", Port: 6666})
+	select {
+	case err := <-subscribed:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("subscribe from the hook deadlocked")
+	}
+	select {
+	case <-waiter:
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+// closingWatchConsumer hands out event channels the test closes, like a torn down subscription.
+type closingWatchConsumer struct {
+	*polaristest.Backend
+	lock   sync.Mutex
+	events chan model.SubScribeEvent
+}
+
+func (c *closingWatchConsumer) WatchService(req *api.WatchServiceRequest) (*model.WatchServiceResponse, error) {
+	resp, err := c.Backend.WatchService(req)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events = make(chan model.SubScribeEvent, 1)
+	resp.EventChannel = c.events
+	return resp, nil
+}
+
+func (c *closingWatchConsumer) channel() chan model.SubScribeEvent {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.events
+}
+
+// TestWatchManagerResubscribesClosedChannel closes the event channel of a service, the service is
+// subscribed again and the waiter gets the events of the new channel.
+func TestWatchManagerResubscribesClosedChannel(t *testing.T) {
+	consumer := &closingWatchConsumer{Backend: polaristest.NewBackend()}
+	manager := newWatchManager(consumer, newOptions([]Option{WithWatchWorkerPool(1)}), nil)
+	defer close(manager.done)
+	key := model.ServiceKey{Namespace: polarisDefaultNamespace, Service: serviceName}
+
+	sw, waiter, _, err := manager.subscribe(key, 1)
+	require.Nil(t, err)
+	defer sw.removeWaiter(waiter)
+	close(consumer.channel())
+	require.Eventually(t, func() bool {
+		manager.lock.Lock()
+		defer manager.lock.Unlock()
+		return consumer.Calls(polaristest.OpWatchService) == 2 && sw.attached
+	}, time.Second, time.Millisecond)
+	require.True(t, sw.takeMissed(waiter))
+
+	instance := &polaristest.Instance{ID: "6666", Namespace: key.Namespace, Service: key.Service, Host: "127.0.0.1", Port: 6666}
+	event := &model.InstanceEvent{AddEvent: &model.InstanceAddEvent{Instances: []model.Instance{instance}}}
+	consumer.channel() <- event
+	select {
+	case got := <-waiter:
+		insEvent, _, ok := instanceEvent(got)
+		require.True(t, ok)
+		require.Equal(t, event, insEvent)
+	case <-time.After(time.Second):
+		t.Fatal("event of the new channel not delivered")
+	}
+}
+
+func TestWatcherSharedSubscription(t *testing.T) {
+	backend := polaristest.NewBackend()
+	rs := newTestResolver(backend)
+	desc := polarisDefaultNamespace + ":" + serviceName
+
+	changes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			change, err := rs.Watcher(context.TODO(), desc)
+			require.Nil(t, err)
+			changes <- len(change.Added)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return backend.Calls(polaristest.OpWatchService) == 2
+	}, time.Second, time.Millisecond)
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case added := <-changes:
+			require.Equal(t, 1, added)
+		case <-time.After(time.Second):
+			t.Fatal("watcher did not return")
+		}
+	}
+}
+
+// flakyWatchConsumer blocks the first WatchService call until release is closed and fails the next ones
+// until failures reaches zero.
+type flakyWatchConsumer struct {
+	*polaristest.Backend
+	release  chan struct{}
+	failures int32
+	calls    int32
+}
+
+func (c *flakyWatchConsumer) WatchService(req *api.WatchServiceRequest) (*model.WatchServiceResponse, error) {
+	if atomic.AddInt32(&c.calls, 1) == 1 && c.release != nil {
+		<-c.release
+		return nil, errors.New("control plane unreachable")
+	}
+	if atomic.AddInt32(&c.failures, -1) >= 0 {
+		return nil, errors.New("control plane unreachable")
+	}
+	return c.Backend.WatchService(req)
+}
+
+func TestWatcherTimeout(t *testing.T) {
+	backend := polaristest.NewBackend()
+	consumer := &flakyWatchConsumer{Backend: backend, release: make(chan struct{}), failures: 2}
+	rs := newTestResolver(backend, WithWatchTimeout(50*time.Millisecond))
+	rs.consumer = consumer
+	rs.watcher = newWatchManager(consumer, rs.opts, nil)
+	rs.watcher.retryBase = time.Millisecond
+	defer rs.watcher.close()
+	key := model.ServiceKey{Namespace: polarisDefaultNamespace, Service: serviceName}
+
+	begin := time.Now()
+	_, err := rs.Watcher(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	var timeout *WatchTimeoutError
+	require.True(t, errors.As(err, &timeout))
+	require.Equal(t, 50*time.Millisecond, timeout.Timeout)
+	require.Less(t, int64(time.Since(begin)), int64(time.Second))
+
+	// the retry waits for the blocked call, then backs off over the failures.
+	close(consumer.release)
+	require.Eventually(t, func() bool {
+		return rs.watcher.isAttached(rs.watcher.serviceWatch(key))
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, int32(4), atomic.LoadInt32(&consumer.calls))
+}
+
+func TestWatchManagerRetryStopsOnClose(t *testing.T) {
+	backend := polaristest.NewBackend()
+	consumer := &flakyWatchConsumer{Backend: backend, failures: 1 << 30}
+	manager := newWatchManager(consumer, newOptions(nil), nil)
+	manager.retryBase = time.Millisecond
+	manager.retryMax = time.Millisecond
+
+	_, _, _, err := manager.subscribe(model.ServiceKey{Namespace: polarisDefaultNamespace, Service: serviceName}, 1)
+	require.NotNil(t, err)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&consumer.calls) > 3 }, time.Second, time.Millisecond)
+	manager.close()
+	time.Sleep(10 * time.Millisecond)
+	calls := atomic.LoadInt32(&consumer.calls)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, calls, atomic.LoadInt32(&consumer.calls))
+}
+
+func TestWatcherSkipsDuplicateRevisions(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666})
+	rs := newTestResolver(backend)
+	defer rs.Close()
+	desc := polarisDefaultNamespace + ":" + serviceName
+	_, ok := rs.LastRevision(desc)
+	require.False(t, ok)
+	requireInitialWatch(t, rs, desc, 1)
+
+	changes := make(chan discovery.Change, 1)
+	go func() {
+		change, err := rs.Watcher(context.TODO(), desc)
+		require.Nil(t, err)
+		changes <- change
+	}()
+	require.Eventually(t, func() bool {
+		_, ok := rs.LastRevision(desc)
+		return ok
+	}, time.Second, time.Millisecond)
+	first, _ := rs.LastRevision(desc)
+
+	// idempotent re-pushes of the instance already applied.
+	existing := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+	backend.Publish(polarisDefaultNamespace, serviceName,
+		&model.InstanceEvent{AddEvent: &model.InstanceAddEvent{Instances: []model.Instance{existing}}})
+	backend.Publish(polarisDefaultNamespace, serviceName, &model.InstanceEvent{UpdateEvent: &model.InstanceUpdateEvent{
+		UpdateList: []model.OneInstanceUpdate{{Before: existing, After: existing}},
+	}})
+	require.Eventually(t, func() bool { return rs.SkippedEvents() == 2 }, time.Second, time.Millisecond)
+	select {
+	case <-changes:
+		t.Fatal("duplicate revision emitted a change")
+	case <-time.After(20 * time.Millisecond):
+	}
+	revision, _ := rs.LastRevision(desc)
+	require.Equal(t, first, revision)
+
+	updated := *existing
+	updated.Weight = 50
+	require.Nil(t, backend.UpdateInstance(&updated))
+	select {
+	case change := <-changes:
+		require.Len(t, change.Updated, 1)
+		require.Equal(t, 50, change.Updated[0].Weight())
+	case <-time.After(time.Second):
+		t.Fatal("watcher did not return")
+	}
+	revision, _ = rs.LastRevision(desc)
+	require.NotEqual(t, first, revision)
+	require.Equal(t, uint64(2), rs.SkippedEvents())
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"math"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/registry"
+)
+
+// TagDrained marks the instances registered with a zero registry.Info weight, the resolver keeps
+// their zero weight instead of the default one so that they get no traffic, e.g. while pre-warming.
+const TagDrained = "drained"
+
+// registrationWeight returns the registered weight of info: the given one when positive, zero for
+// drained instances and the default weight of WithDefaultWeight when negative. Kitex servers replace
+// an unset weight with discovery.DefaultWeight, so a zero weight is always explicit.
+func (o *options) registrationWeight(info *registry.Info) int {
+	if info.Weight < 0 {
+		return o.instanceWeight()
+	}
+	return info.Weight
+}
+
+// instanceWeight returns the default weight of WithDefaultWeight.
+func (o *options) instanceWeight() int {
+	if o.defaultInstanceWeight > 0 {
+		return o.defaultInstanceWeight
+	}
+	return defaultWeight
+}
+
+// polarisWeight returns the weight of a polaris instance with metadata, the default one replaces a missing
+// weight unless the instance is drained.
+func polarisWeight(weight int, metadata map[string]string, def int) int {
+	if isDrainedWeight(weight, metadata) {
+		return 0
+	}
+	if weight <= 0 {
+		if def <= 0 {
+			return defaultWeight
+		}
+		return def
+	}
+	return weight
+}
+
+// isDrainedWeight returns whether a polaris weight with metadata is the zero weight of a drained instance.
+func isDrainedWeight(weight int, metadata map[string]string) bool {
+	return weight == 0 && metadata[TagDrained] == "true"
+}
+
+// isDrained returns whether a converted instance is drained, see TagDrained.
+func isDrained(ins discovery.Instance) bool {
+	drained, _ := ins.Tag(TagDrained)
+	return drained == "true" && ins.Weight() == 0
+}
+
+// weightedInstance overrides the weight of a converted instance.
+type weightedInstance struct {
+	discovery.Instance
+	weight int
+}
+
+// Weight implements the discovery.Instance interface.
+func (i *weightedInstance) Weight() int {
+	return i.weight
+}
+
+// adjustWeights clamps the weights into the range of WithWeightClamp and then rescales them
+// proportionally to the sum of WithWeightNormalization, instances keep a weight of at least 1 except the
+// drained ones, which keep their zero weight.
+func adjustWeights(instances []discovery.Instance, o *options) []discovery.Instance {
+	if (!o.weightClamp && o.weightTargetSum <= 0) || len(instances) == 0 {
+		return instances
+	}
+	weights := make([]int, len(instances))
+	total := 0
+	for i, ins := range instances {
+		if isDrained(ins) {
+			continue
+		}
+		weight := ins.Weight()
+		if o.weightClamp {
+			if weight < o.weightMin {
+				weight = o.weightMin
+			}
+			if weight > o.weightMax {
+				weight = o.weightMax
+			}
+		}
+		weights[i] = weight
+		total += weight
+	}
+	if o.weightTargetSum > 0 {
+		for i := range weights {
+			if isDrained(instances[i]) {
+				continue
+			}
+			if total <= 0 {
+				// no weight to preserve, share the target equally.
+				weights[i] = o.weightTargetSum / len(weights)
+			} else {
+				weights[i] = int(math.Round(float64(weights[i]) * float64(o.weightTargetSum) / float64(total)))
+			}
+			if weights[i] < 1 {
+				weights[i] = 1
+			}
+		}
+	}
+	adjusted := make([]discovery.Instance, len(instances))
+	for i, ins := range instances {
+		if ins.Weight() == weights[i] {
+			adjusted[i] = ins
+			continue
+		}
+		if wi, ok := ins.(*weightedInstance); ok {
+			ins = wi.Instance
+		}
+		adjusted[i] = &weightedInstance{Instance: ins, weight: weights[i]}
+	}
+	return adjusted
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+func newWeightedInstances(weights ...int) []discovery.Instance {
+	instances := make([]discovery.Instance, 0, len(weights))
+	for i, weight := range weights {
+		instances = append(instances, discovery.NewInstance("tcp", "127.0.0.1:"+strconv.Itoa(6000+i), weight, nil))
+	}
+	return instances
+}
+
+func instanceWeights(instances []discovery.Instance) []int {
+	weights := make([]int, 0, len(instances))
+	for _, ins := range instances {
+		weights = append(weights, ins.Weight())
+	}
+	return weights
+}
+
+func TestWeightClamp(t *testing.T) {
+	o := newOptions([]Option{WithWeightClamp(5, 100)})
+	adjusted := adjustWeights(newWeightedInstances(1, 50, 65535), o)
+	require.Equal(t, []int{5, 50, 100}, instanceWeights(adjusted))
+	require.Equal(t, "127.0.0.1:6002", adjusted[2].Address().String())
+
+	instances := newWeightedInstances(10, 20)
+	require.Equal(t, instances, adjustWeights(instances, newOptions(nil)))
+}
+
+func TestWeightNormalizationProportional(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const targetSum = 1000
+	o := newOptions([]Option{WithWeightNormalization(targetSum)})
+	for round := 0; round < 200; round++ {
+		weights := make([]int, 1+r.Intn(20))
+		total := 0
+		for i := range weights {
+			weights[i] = 1 + r.Intn(65535)
+			total += weights[i]
+		}
+		adjusted := instanceWeights(adjustWeights(newWeightedInstances(weights...), o))
+
+		sum := 0
+		for i, weight := range adjusted {
+			require.GreaterOrEqual(t, weight, 1)
+			expected := float64(weights[i]) * targetSum / float64(total)
+			require.True(t, math.Abs(float64(weight)-expected) <= 1, "weight %d expected %f", weight, expected)
+			sum += weight
+		}
+		require.InDelta(t, targetSum, sum, float64(len(weights)))
+	}
+}
+
+func TestWeightNormalizationZeroTotal(t *testing.T) {
+	o := newOptions([]Option{WithWeightClamp(0, 100), WithWeightNormalization(90)})
+	require.Equal(t, []int{30, 30, 30}, instanceWeights(adjustWeights(newWeightedInstances(0, 0, 0), o)))
+}
+
+func TestResolveWeightClamp(t *testing.T) {
+	backend := polaristest.NewBackend()
+	backend.AddInstances(
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 6666, Weight: 65535},
+		&polaristest.Instance{Namespace: polarisDefaultNamespace, Service: serviceName, Host: "127.0.0.1", Port: 7777, Weight: 100},
+	)
+	rs := newTestResolver(backend, WithWeightClamp(1, 1000))
+
+	result, err := rs.Resolve(context.TODO(), polarisDefaultNamespace+":"+serviceName)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []int{1000, 100}, instanceWeights(result.Instances))
+}
+
+func TestRegistrationWeight(t *testing.T) {
+	for _, c := range []struct {
+		weight, registered int
+		drained            bool
+	}{
+		{weight: -1, registered: 30},
+		{weight: 0, registered: 0, drained: true},
+		{weight: 50, registered: 50},
+	} {
+		backend := polaristest.NewBackend()
+		rg := newTestRegistry(backend, WithDefaultWeight(30))
+		info := newTestInfo("127.0.0.1:6666", nil)
+		info.Weight = c.weight
+		require.Nil(t, rg.Register(info))
+		instances := backend.Instances(polarisDefaultNamespace, serviceName)
+		require.Len(t, instances, 1)
+		require.Equal(t, c.registered, instances[0].Weight, c.weight)
+		_, drained := instances[0].Metadata[TagDrained]
+		require.Equal(t, c.drained, drained, c.weight)
+
+		// the lone drained instance keeps its zero weight, the zero weight policy leaves it out.
+		result, err := newTestResolver(backend).Resolve(context.Background(), polarisDefaultNamespace+":"+serviceName)
+		require.Nil(t, err)
+		require.Equal(t, []int{c.registered}, instanceWeights(result.Instances), c.weight)
+		require.Nil(t, rg.Deregister(info))
+	}
+}
+
+func TestConversionWeight(t *testing.T) {
+	conv := newOptions([]Option{WithDefaultWeight(30), WithMetadataTagPrefixPassthrough(nil)}).instanceConversion()
+	for _, c := range []struct {
+		instance *polaristest.Instance
+		weight   int
+		drained  bool
+	}{
+		{instance: &polaristest.Instance{Weight: -1}, weight: 30},
+		{instance: &polaristest.Instance{}, weight: 30},
+		{instance: &polaristest.Instance{Metadata: map[string]string{TagDrained: "true"}}, weight: 0, drained: true},
+		// a drained tag does not zero a positive weight.
+		{instance: &polaristest.Instance{Weight: 50, Metadata: map[string]string{TagDrained: "true"}}, weight: 50},
+		{instance: &polaristest.Instance{Weight: 50}, weight: 50},
+	} {
+		c.instance.Host, c.instance.Port = "127.0.0.1", 6666
+		ins := conv.convert(c.instance)
+		require.Equal(t, c.weight, ins.Weight(), "%+v", c.instance)
+		require.Equal(t, c.drained, isDrained(ins), "%+v", c.instance)
+	}
+}
+
+func TestDrainedWeightAdjustment(t *testing.T) {
+	instances := []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:6000", 0, map[string]string{TagDrained: "true"}),
+		discovery.NewInstance("tcp", "127.0.0.1:6001", 10, nil),
+	}
+	o := newOptions([]Option{WithWeightClamp(5, 100), WithWeightNormalization(1000)})
+	require.Equal(t, []int{0, 1000}, instanceWeights(adjustWeights(instances, o)))
+	require.Equal(t, 0, newDrainingInstance(&polaristest.Instance{Metadata: map[string]string{TagDrained: "true"}}, 50).GetWeight())
+}
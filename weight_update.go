@@ -0,0 +1,167 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const defaultWeightSyncInterval = 30 * time.Second
+
+// weightUpdates keeps back the weight-only updates of a service within WithWeightUpdateThreshold.
+type weightUpdates struct {
+	threshold float64 // in percent of the propagated weight
+	// pending are the updates kept back by instance ID, Before is the instance last propagated.
+	pending map[string]model.OneInstanceUpdate
+}
+
+func newWeightUpdates(threshold float64) *weightUpdates {
+	return &weightUpdates{threshold: threshold, pending: make(map[string]model.OneInstanceUpdate)}
+}
+
+// filter returns insEvent without the weight-only updates within the threshold, which are kept pending,
+// or nil when nothing is left to propagate. An update propagating an instance carries the instance last
+// propagated as Before. insEvent is not modified.
+func (w *weightUpdates) filter(insEvent *model.InstanceEvent) *model.InstanceEvent {
+	if insEvent.AddEvent != nil {
+		for _, instance := range insEvent.AddEvent.Instances {
+			delete(w.pending, instance.GetId())
+		}
+	}
+	if insEvent.DeleteEvent != nil {
+		for _, instance := range insEvent.DeleteEvent.Instances {
+			delete(w.pending, instance.GetId())
+		}
+	}
+	if insEvent.UpdateEvent == nil {
+		return insEvent
+	}
+	updates := make([]model.OneInstanceUpdate, 0, len(insEvent.UpdateEvent.UpdateList))
+	for _, update := range insEvent.UpdateEvent.UpdateList {
+		id := update.After.GetId()
+		if pending, ok := w.pending[id]; ok {
+			update.Before = pending.Before
+		}
+		if weightOnly(update.Before, update.After) && !w.significant(update.Before.GetWeight(), update.After.GetWeight()) {
+			w.pending[id] = update
+			continue
+		}
+		delete(w.pending, id)
+		updates = append(updates, update)
+	}
+	kept := &model.InstanceEvent{AddEvent: insEvent.AddEvent, DeleteEvent: insEvent.DeleteEvent}
+	if len(updates) > 0 {
+		kept.UpdateEvent = &model.InstanceUpdateEvent{UpdateList: updates}
+	}
+	if kept.AddEvent == nil && kept.UpdateEvent == nil && kept.DeleteEvent == nil {
+		return nil
+	}
+	return kept
+}
+
+// significant reports whether a weight changed by more than the threshold, a weight from or to zero
+// always is.
+func (w *weightUpdates) significant(before, after int) bool {
+	if before == after {
+		return false
+	}
+	if before <= 0 || after <= 0 {
+		return true
+	}
+	diff := after - before
+	if diff < 0 {
+		diff = -diff
+	}
+	return float64(diff)*100 > w.threshold*float64(before)
+}
+
+// flush returns the pending updates as one event, nil without any.
+func (w *weightUpdates) flush() *model.InstanceEvent {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	updates := make([]model.OneInstanceUpdate, 0, len(w.pending))
+	for id, update := range w.pending {
+		updates = append(updates, update)
+		delete(w.pending, id)
+	}
+	return &model.InstanceEvent{UpdateEvent: &model.InstanceUpdateEvent{UpdateList: updates}}
+}
+
+// weightOnly reports whether two versions of an instance differ in nothing Kitex sees but the weight.
+func weightOnly(before, after model.Instance) bool {
+	if before == nil || after == nil {
+		return false
+	}
+	return before.GetHost() == after.GetHost() &&
+		before.GetPort() == after.GetPort() &&
+		before.GetProtocol() == after.GetProtocol() &&
+		before.GetVersion() == after.GetVersion() &&
+		before.GetPriority() == after.GetPriority() &&
+		before.GetLogicSet() == after.GetLogicSet() &&
+		before.IsHealthy() == after.IsHealthy() &&
+		before.IsIsolated() == after.IsIsolated() &&
+		before.GetRegion() == after.GetRegion() &&
+		before.GetZone() == after.GetZone() &&
+		before.GetIDC() == after.GetIDC() &&
+		before.GetCampus() == after.GetCampus() &&
+		reflect.DeepEqual(before.GetMetadata(), after.GetMetadata())
+}
+
+// flushWeights broadcasts the weight-only updates kept back, see WithWeightSyncInterval.
+func (sw *serviceWatch) flushWeights() {
+	sw.deliverLock.Lock()
+	defer sw.deliverLock.Unlock()
+	sw.lock.Lock()
+	dormant := sw.dormant
+	sw.lock.Unlock()
+	if dormant {
+		return
+	}
+	if event := sw.weights.flush(); event != nil {
+		if sw.onEvent != nil {
+			sw.onEvent(sw.key)
+		}
+		sw.broadcast(timedEvent{InstanceEvent: event, origin: sw.now()})
+	}
+}
+
+// syncWeights flushes the weight-only updates of every service kept back by WithWeightUpdateThreshold
+// every interval until the watch manager is closed.
+func (m *watchManager) syncWeights(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		m.lock.Lock()
+		watches := make([]*serviceWatch, 0, len(m.watches))
+		for _, sw := range m.watches {
+			watches = append(watches, sw)
+		}
+		m.lock.Unlock()
+		for _, sw := range watches {
+			sw.flushWeights()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+// subscribeWeighted subscribes to a service of one instance of weight 100 and returns its Changes.
+func subscribeWeighted(t *testing.T, opts ...Option) (*polaristest.Backend, <-chan discovery.Change) {
+	backend := polaristest.NewBackend()
+	ins := newTestListenerInstance(6666)
+	ins.Weight = 100
+	backend.AddInstances(ins)
+	rs := newTestResolver(backend, opts...)
+	t.Cleanup(func() { rs.Close() })
+	changes := make(chan discovery.Change, 4)
+	unsubscribe, err := rs.Subscribe(polarisDefaultNamespace+":"+serviceName, func(change discovery.Change) {
+		changes <- change
+	})
+	require.Nil(t, err)
+	t.Cleanup(unsubscribe)
+	requireInitialChange(t, changes, 1)
+	return backend, changes
+}
+
+func updateWeight(t *testing.T, backend *polaristest.Backend, weight int) {
+	ins := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+	ins.Weight = weight
+	require.Nil(t, backend.UpdateInstance(ins))
+}
+
+func requireWeightChange(t *testing.T, changes <-chan discovery.Change, weight int) discovery.Change {
+	select {
+	case change := <-changes:
+		require.Len(t, change.Updated, 1)
+		require.Equal(t, weight, change.Updated[0].Weight())
+		return change
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+	return discovery.Change{}
+}
+
+func requireNoChange(t *testing.T, changes <-chan discovery.Change) {
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected change %+v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWeightUpdateSuppressedBelowThreshold(t *testing.T) {
+	backend, changes := subscribeWeighted(t, WithWeightUpdateThreshold(10))
+	updateWeight(t, backend, 105)
+	requireNoChange(t, changes)
+	// the threshold is relative to the weight propagated last, not to the previous update.
+	updateWeight(t, backend, 108)
+	requireNoChange(t, changes)
+}
+
+func TestWeightUpdatePropagatedAboveThreshold(t *testing.T) {
+	backend, changes := subscribeWeighted(t, WithWeightUpdateThreshold(10))
+	updateWeight(t, backend, 105)
+	requireNoChange(t, changes)
+	updateWeight(t, backend, 115)
+	change := requireWeightChange(t, changes, 115)
+	require.Equal(t, 115, change.Result.Instances[0].Weight())
+
+	// the next update is compared with 115.
+	updateWeight(t, backend, 90)
+	requireWeightChange(t, changes, 90)
+	updateWeight(t, backend, 0)
+	requireWeightChange(t, changes, defaultWeight)
+}
+
+func TestWeightUpdateOtherChangesPropagate(t *testing.T) {
+	backend, changes := subscribeWeighted(t, WithWeightUpdateThreshold(10))
+	updateWeight(t, backend, 105)
+	requireNoChange(t, changes)
+
+	ins := backend.Instances(polarisDefaultNamespace, serviceName)[0]
+	ins.Metadata = map[string]string{"env": "prod"}
+	require.Nil(t, backend.UpdateInstance(ins))
+	change := requireWeightChange(t, changes, 105)
+	env, _ := change.Updated[0].Tag("env")
+	require.Equal(t, "prod", env)
+
+	backend.AddInstances(newTestListenerInstance(7777))
+	select {
+	case change := <-changes:
+		require.Equal(t, []string{"127.0.0.1:7777"}, addrs(change.Added))
+	case <-time.After(time.Second):
+		t.Fatal("change not delivered")
+	}
+}
+
+func TestWeightUpdatePeriodicSync(t *testing.T) {
+	backend, changes := subscribeWeighted(t, WithWeightUpdateThreshold(10),
+		WithWeightSyncInterval(200*time.Millisecond))
+	updateWeight(t, backend, 105)
+	change := requireWeightChange(t, changes, 105)
+	require.Equal(t, 105, change.Result.Instances[0].Weight())
+	// nothing is pending after the sync.
+	requireNoChange(t, changes)
+	requireNoChange(t, changes)
+	requireNoChange(t, changes)
+}
+
+func TestWeightUpdateDisabled(t *testing.T) {
+	backend, changes := subscribeWeighted(t)
+	updateWeight(t, backend, 101)
+	requireWeightChange(t, changes, 101)
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/polarismesh/polaris-go/pkg/log"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+// ZeroWeightPolicy decides what Resolve does when every instance of a Result has a zero weight, like
+// after a fleet restarted at once with their dynamic weights still warming up.
+type ZeroWeightPolicy int
+
+const (
+	// ZeroWeightEqual gives every instance the default weight of WithDefaultWeight, the default policy.
+	ZeroWeightEqual ZeroWeightPolicy = iota
+	// ZeroWeightError fails Resolve with a ZeroWeightResultError.
+	ZeroWeightError
+	// ZeroWeightPassthrough returns the zero weights as they are.
+	ZeroWeightPassthrough
+)
+
+// String returns the name of the policy.
+func (p ZeroWeightPolicy) String() string {
+	switch p {
+	case ZeroWeightEqual:
+		return "equal"
+	case ZeroWeightError:
+		return "error"
+	case ZeroWeightPassthrough:
+		return "passthrough"
+	}
+	return "ZeroWeightPolicy(" + strconv.Itoa(int(p)) + ")"
+}
+
+// zeroWeights returns the instances of eps, the conversions of instances, whose polaris weight is zero
+// and was replaced by the default weight of WithDefaultWeight. The drained instances are left out, they
+// keep their zero weight, see TagDrained.
+func zeroWeights(instances []model.Instance, eps []discovery.Instance) map[discovery.Instance]struct{} {
+	zero := make(map[discovery.Instance]struct{})
+	for i, ins := range instances {
+		if ins.GetWeight() == 0 && !isDrainedWeight(ins.GetWeight(), ins.GetMetadata()) {
+			zero[eps[i]] = struct{}{}
+		}
+	}
+	return zero
+}
+
+// unweighted returns the converted instance whose weight ins overrides, like the scores of
+// WithAdaptiveScoring.
+func unweighted(ins discovery.Instance) discovery.Instance {
+	if wi, ok := ins.(*weightedInstance); ok {
+		return wi.Instance
+	}
+	return ins
+}
+
+// allZeroWeights returns the number of the instances which are not drained when they all are in zero,
+// zero otherwise.
+func allZeroWeights(instances []discovery.Instance, zero map[discovery.Instance]struct{}) int {
+	count := 0
+	for _, ins := range instances {
+		if isDrained(ins) {
+			continue
+		}
+		if _, ok := zero[unweighted(ins)]; !ok {
+			return 0
+		}
+		count++
+	}
+	return count
+}
+
+// applyZeroWeightPolicy handles the Results whose instances all have a zero polaris weight, which are
+// counted and logged, the other Results are returned untouched. zero are the instances of a zero polaris
+// weight, see zeroWeights, the drained instances are left out of the policy.
+func (polaris *polarisResolver) applyZeroWeightPolicy(ctx context.Context, desc string, service model.ServiceKey,
+	instances []discovery.Instance, zero map[discovery.Instance]struct{}) ([]discovery.Instance, error) {
+	count := allZeroWeights(instances, zero)
+	if count == 0 {
+		return instances, nil
+	}
+	atomic.AddUint64(&polaris.zeroWeightResults, 1)
+	policy := polaris.opts.zeroWeightPolicy
+	if ok, suppressed := polaris.errorLogs.allow(errorLogZeroWeight, service, polaris.opts.errorLogInterval); ok {
+		if logger := polaris.opts.structuredLogger; logger != nil {
+			logger.WarnContext(ctx, "polaris resolve found only zero weights", polaris.logFields(ctx,
+				"namespace", service.Namespace, "service", service.Service, "description", desc,
+				"instance_count", count, "policy", policy.String(), "suppressed", suppressed)...)
+		} else {
+			log.GetBaseLogger().Warnf("[Polaris resolver] the %d instances of %s all have a zero weight, policy %s%s",
+				count, desc, policy, suppressedSuffix(suppressed))
+		}
+	}
+	weight := polaris.opts.instanceWeight()
+	switch policy {
+	case ZeroWeightError:
+		return nil, &ZeroWeightResultError{Namespace: service.Namespace, Service: service.Service, Instances: count}
+	case ZeroWeightPassthrough:
+		weight = 0
+	}
+	weighted := make([]discovery.Instance, len(instances))
+	for i, ins := range instances {
+		if _, ok := zero[unweighted(ins)]; !ok {
+			weighted[i] = ins
+			continue
+		}
+		weighted[i] = &weightedInstance{Instance: unweighted(ins), weight: weight}
+	}
+	return weighted, nil
+}
+
+// ZeroWeightResults implements the ResolverDiagnostics interface.
+func (polaris *polarisResolver) ZeroWeightResults() uint64 {
+	return atomic.LoadUint64(&polaris.zeroWeightResults)
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2021 CloudWeGo Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kitex-contrib/registry-polaris/polaristest"
+	"github.com/stretchr/testify/require"
+)
+
+const zeroWeightDesc = polarisDefaultNamespace + ":" + serviceName
+
+func newZeroWeightBackend(weights ...int) *polaristest.Backend {
+	backend := polaristest.NewBackend()
+	for i, weight := range weights {
+		instance := newTestListenerInstance(uint32(6000 + i))
+		instance.Weight = weight
+		backend.AddInstances(instance)
+	}
+	return backend
+}
+
+// addDrainedInstance adds a drained instance of a zero weight to backend, see TagDrained.
+func addDrainedInstance(backend *polaristest.Backend, port uint32) {
+	instance := newTestListenerInstance(port)
+	instance.Metadata = map[string]string{TagDrained: "true"}
+	backend.AddInstances(instance)
+}
+
+func TestZeroWeightPolicy(t *testing.T) {
+	cases := []struct {
+		policy  ZeroWeightPolicy
+		weights []int
+	}{
+		{policy: ZeroWeightEqual, weights: []int{defaultWeight, defaultWeight}},
+		{policy: ZeroWeightPassthrough, weights: []int{0, 0}},
+	}
+	for _, c := range cases {
+		rs := newTestResolver(newZeroWeightBackend(0, 0), WithZeroWeightPolicy(c.policy))
+		result, err := rs.Resolve(context.Background(), zeroWeightDesc)
+		require.Nil(t, err, c.policy)
+		require.Equal(t, c.weights, instanceWeights(result.Instances), c.policy)
+		require.Equal(t, uint64(1), rs.ZeroWeightResults(), c.policy)
+	}
+
+	rs := newTestResolver(newZeroWeightBackend(0, 0), WithZeroWeightPolicy(ZeroWeightError))
+	_, err := rs.Resolve(context.Background(), zeroWeightDesc)
+	var zeroErr *ZeroWeightResultError
+	require.True(t, errors.As(err, &zeroErr), err)
+	require.Equal(t, ZeroWeightResultError{Namespace: polarisDefaultNamespace, Service: serviceName, Instances: 2}, *zeroErr)
+	require.Equal(t, uint64(1), rs.ZeroWeightResults())
+}
+
+func TestZeroWeightPolicyMixedWeights(t *testing.T) {
+	for _, policy := range []ZeroWeightPolicy{ZeroWeightEqual, ZeroWeightError, ZeroWeightPassthrough} {
+		rs := newTestResolver(newZeroWeightBackend(0, 20), WithZeroWeightPolicy(policy))
+		result, err := rs.Resolve(context.Background(), zeroWeightDesc)
+		require.Nil(t, err, policy)
+		// the zero weight is replaced by the default one like without the policy.
+		require.ElementsMatch(t, []int{defaultWeight, 20}, instanceWeights(result.Instances), policy)
+		require.Zero(t, rs.ZeroWeightResults(), policy)
+	}
+}
+
+func TestZeroWeightPolicyDrained(t *testing.T) {
+	cases := []struct {
+		policy  ZeroWeightPolicy
+		weights []int
+	}{
+		{policy: ZeroWeightEqual, weights: []int{0, defaultWeight}},
+		{policy: ZeroWeightPassthrough, weights: []int{0, 0}},
+	}
+	for _, c := range cases {
+		backend := newZeroWeightBackend(0)
+		addDrainedInstance(backend, 7000)
+		rs := newTestResolver(backend, WithZeroWeightPolicy(c.policy))
+		result, err := rs.Resolve(context.Background(), zeroWeightDesc)
+		require.Nil(t, err, c.policy)
+		require.ElementsMatch(t, c.weights, instanceWeights(result.Instances), c.policy)
+		require.Equal(t, uint64(1), rs.ZeroWeightResults(), c.policy)
+	}
+
+	backend := newZeroWeightBackend(0)
+	addDrainedInstance(backend, 7000)
+	rs := newTestResolver(backend, WithZeroWeightPolicy(ZeroWeightError))
+	_, err := rs.Resolve(context.Background(), zeroWeightDesc)
+	var zeroErr *ZeroWeightResultError
+	require.True(t, errors.As(err, &zeroErr), err)
+	// the drained instance is not counted.
+	require.Equal(t, 1, zeroErr.Instances)
+
+	// the drained instances alone are not handled by the policy, they keep their zero weight.
+	for _, policy := range []ZeroWeightPolicy{ZeroWeightEqual, ZeroWeightError, ZeroWeightPassthrough} {
+		backend := polaristest.NewBackend()
+		addDrainedInstance(backend, 7000)
+		addDrainedInstance(backend, 7001)
+		rs := newTestResolver(backend, WithZeroWeightPolicy(policy))
+		result, err := rs.Resolve(context.Background(), zeroWeightDesc)
+		require.Nil(t, err, policy)
+		require.Equal(t, []int{0, 0}, instanceWeights(result.Instances), policy)
+		require.Zero(t, rs.ZeroWeightResults(), policy)
+	}
+}
+
+func TestZeroWeightPolicyLog(t *testing.T) {
+	logger := &structuredLogger{}
+	rs := newTestResolver(newZeroWeightBackend(0), WithStructuredLogger(logger))
+	for i := 0; i < 3; i++ {
+		_, err := rs.Resolve(context.Background(), zeroWeightDesc)
+		require.Nil(t, err)
+	}
+	require.Equal(t, uint64(3), rs.ZeroWeightResults())
+	var warnings []structuredRecord
+	for _, record := range logger.records {
+		if record.msg == "polaris resolve found only zero weights" {
+			warnings = append(warnings, record)
+		}
+	}
+	// the repeated warnings are rate limited like the resolve failures.
+	require.Len(t, warnings, 1)
+	require.Equal(t, "warn", warnings[0].level)
+	require.Equal(t, "equal", warnings[0].fields["policy"])
+}